@@ -10,15 +10,92 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func Run(wd string, port int, keepOriginalUploadFileName bool, spaMode bool, logger *logrus.Entry) error {
+// Listeners são as portas opcionais que servem os arquivos de wd por outros protocolos.
+type Listeners struct {
+	S3   S3Config
+	SFTP SFTPConfig
+}
+
+// S3Config habilita a API compatível com o S3 em uma porta própria.
+type S3Config struct {
+	// Port 0 desabilita a API
+	Port int
+	// Keys são as credenciais 'ACCESS_KEY:SECRET_KEY', sem elas o acesso é anônimo
+	Keys []string
+}
+
+// SFTPConfig habilita o servidor SFTP. Os usuários são os mesmos do '--auth'.
+type SFTPConfig struct {
+	// Port 0 desabilita o servidor
+	Port int
+	// HostKeyFile é criado com uma nova chave quando não existe
+	HostKeyFile string
+	// AuthorizedKeysFile é opcional, no formato do authorized_keys do OpenSSH
+	AuthorizedKeysFile string
+	// Anonymous permite o acesso sem autenticação quando não há usuários nem chaves
+	Anonymous bool
+}
+
+// listener é um servidor executado em paralelo com o servidor HTTP.
+type listener struct {
+	serve func() error
+	close func() error
+}
+
+func Run(wd string, port int, keepOriginalUploadFileName bool, spaMode bool, logger *logrus.Entry, opts ...handler.Option) error {
+	return RunWithSites(wd, port, keepOriginalUploadFileName, spaMode, nil, nil, Listeners{}, logger, opts...)
+}
+
+// RunWithSites serve wd, com os mounts, como site padrão e cada um dos sites
+// para os seus hosts. Os listeners servem os arquivos de wd também pela API
+// do S3, onde os buckets são os diretórios do primeiro nível, e por SFTP.
+func RunWithSites(wd string, port int, keepOriginalUploadFileName bool, spaMode bool, mounts []Mount, sites []Site, listeners Listeners, logger *logrus.Entry, opts ...handler.Option) error {
 	logger.Info("** Go Upload Server **")
 	logger.Infof("Working directory: %s", wd)
 
-	h := handler.NewServer(wd, keepOriginalUploadFileName, spaMode, logger.WithField("server", "handler"))
+	root, err := newServer(wd, keepOriginalUploadFileName, spaMode, logger.WithField("server", "handler"), opts)
+	if err != nil {
+		logger.Errorf("Server error: %s", err)
+		return err
+	}
+	h, err := newHandler(root, keepOriginalUploadFileName, spaMode, mounts, sites, logger, opts)
+	if err != nil {
+		logger.Errorf("Sites error: %s", err)
+		return err
+	}
+	defer h.Close()
 
 	srv := &http.Server{
-		Addr:    ":" + strconv.Itoa(port),
-		Handler: h,
+		Addr:        ":" + strconv.Itoa(port),
+		Handler:     h,
+		ConnContext: handler.ConnContext,
+	}
+	servers := []listener{{srv.ListenAndServe, srv.Close}}
+
+	// the other protocols share the root Server, so all of them see the same
+	// files even in memory
+	if listeners.S3.Port != 0 {
+		api, err := handler.NewS3API(root, listeners.S3.Keys, logger.WithField("server", "s3"))
+		if err != nil {
+			logger.Errorf("S3 API error: %s", err)
+			return err
+		}
+		defer api.Close()
+		s3srv := &http.Server{
+			Addr:        ":" + strconv.Itoa(listeners.S3.Port),
+			Handler:     api,
+			ConnContext: handler.ConnContext,
+		}
+		servers = append(servers, listener{s3srv.ListenAndServe, s3srv.Close})
+	}
+	if cfg := listeners.SFTP; cfg.Port != 0 {
+		sftpSrv, err := handler.NewSFTPServer(root, cfg.HostKeyFile, cfg.AuthorizedKeysFile, cfg.Anonymous, logger.WithField("server", "sftp"))
+		if err != nil {
+			logger.Errorf("SFTP error: %s", err)
+			return err
+		}
+		addr := ":" + strconv.Itoa(cfg.Port)
+		servers = append(servers, listener{func() error { return sftpSrv.ListenAndServe(addr) }, sftpSrv.Close})
 	}
 
 	addrs, err := net.InterfaceAddrs()
@@ -32,15 +109,66 @@ func Run(wd string, port int, keepOriginalUploadFileName bool, spaMode bool, log
 		if ipnet, ok := a.(*net.IPNet); ok {
 			if ipnet.IP.To4() != nil {
 				logger.Infof("Listening on: http://%s%s", ipnet.IP, srv.Addr)
+				if listeners.S3.Port != 0 {
+					logger.Infof("S3 API on: http://%s:%d", ipnet.IP, listeners.S3.Port)
+				}
+				if listeners.SFTP.Port != 0 {
+					logger.Infof("SFTP on: sftp://%s:%d", ipnet.IP, listeners.SFTP.Port)
+				}
 			}
 		}
 	}
 
-	err = srv.ListenAndServe()
-	if err != nil {
+	// the first listener to fail stops the server
+	errs := make(chan error, len(servers))
+	for _, l := range servers {
+		go func(l listener) {
+			errs <- l.serve()
+		}(l)
+	}
+	err = <-errs
+	for _, l := range servers {
+		l.close()
+	}
+	if err != nil && err != http.ErrServerClosed && err != handler.ErrSFTPClosed {
 		logger.Errorf("Server error: %s", err)
 		return err
 	}
 
 	return nil
 }
+
+// newHandler monta o site padrão sobre root e os sites virtuais.
+func newHandler(root *handler.Server, keepOriginalUploadFileName bool, spaMode bool, mounts []Mount, sites []Site, logger *logrus.Entry, opts []handler.Option) (handler.Handler, error) {
+	defaultSite, err := newMountedSite(root, keepOriginalUploadFileName, spaMode, mounts, logger.WithField("server", "handler"), opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(sites) == 0 {
+		return defaultSite, nil
+	}
+
+	vh := handler.NewVirtualHosts(defaultSite)
+	for _, site := range sites {
+		siteLogger := logger.WithField("server", "handler").WithField("site", site.Hosts[0])
+		siteOpts := append(append([]handler.Option{}, opts...), site.options()...)
+		siteRoot, err := newServer(site.Root, site.KeepUploadFilename, site.SPA, siteLogger, siteOpts)
+		if err != nil {
+			vh.Close()
+			return nil, err
+		}
+		s, err := newMountedSite(siteRoot, site.KeepUploadFilename, site.SPA, site.Mounts, siteLogger, siteOpts)
+		if err != nil {
+			vh.Close()
+			return nil, err
+		}
+		for _, host := range site.Hosts {
+			if err := vh.Add(host, s); err != nil {
+				vh.Close()
+				return nil, err
+			}
+		}
+		logger.Infof("Site %v: %s", site.Hosts, site.Root)
+	}
+	return vh, nil
+}
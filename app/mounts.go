@@ -0,0 +1,130 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/guilhermerodrigues680/gouploadserver/handler"
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrMountFlag = errors.New("Invalid mount")
+
+const (
+	mountModeReadOnly  = "ro"
+	mountModeReadWrite = "rw"
+	mountModeSPA       = "spa"
+)
+
+// Mount é um diretório servido sob um prefixo das URLs, informado no formato
+// '/prefix=/path[:ro|rw|spa]'. Sem o modo, o ponto de montagem usa as opções globais.
+type Mount struct {
+	Prefix string
+	Root   string
+	Mode   string
+}
+
+func ParseMount(value string) (Mount, error) {
+	i := strings.Index(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return Mount{}, fmt.Errorf("%w: %q, expected '/prefix=/path[:ro|rw|spa]'", ErrMountFlag, value)
+	}
+	m := Mount{Prefix: value[:i], Root: value[i+1:]}
+
+	// the mode is optional and the path itself may contain ':' (ex: 'C:\builds')
+	if j := strings.LastIndex(m.Root, ":"); j > 0 {
+		switch mode := m.Root[j+1:]; mode {
+		case mountModeReadOnly, mountModeReadWrite, mountModeSPA:
+			m.Root, m.Mode = m.Root[:j], mode
+		}
+	}
+	return m, nil
+}
+
+// UnmarshalJSON lê o ponto de montagem no mesmo formato da flag '--mount'.
+func (m *Mount) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	mount, err := ParseMount(value)
+	if err != nil {
+		return err
+	}
+	*m = mount
+	return nil
+}
+
+// ParseMounts lê os valores da flag '--mount'.
+func ParseMounts(values []string) ([]Mount, error) {
+	var mounts []Mount
+	for _, value := range values {
+		m, err := ParseMount(value)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// options retorna as opções do ponto de montagem, aplicadas depois das opções do site.
+func (m *Mount) options() []handler.Option {
+	switch m.Mode {
+	case mountModeReadOnly:
+		return []handler.Option{handler.WithUploads(false)}
+	case mountModeReadWrite:
+		return []handler.Option{handler.WithUploads(true)}
+	}
+	return nil
+}
+
+// newMountedSite monta cada um dos mounts sobre o Server s da raiz do site.
+// Sem pontos de montagem o próprio Server é retornado.
+func newMountedSite(s *handler.Server, keepOriginalUploadFileName bool, spaMode bool, mounts []Mount, logger *logrus.Entry, opts []handler.Option) (handler.Handler, error) {
+	if len(mounts) == 0 {
+		return s, nil
+	}
+
+	ms := handler.NewMounts(s)
+	for _, m := range mounts {
+		mountOpts := append(append([]handler.Option{}, opts...), m.options()...)
+		// 'ro' and 'rw' mounts are file browsers even when the site is a SPA
+		mountSPA := m.Mode == mountModeSPA || (m.Mode == "" && spaMode)
+		mounted, err := newServer(m.Root, keepOriginalUploadFileName, mountSPA, logger.WithField("mount", m.Prefix), mountOpts)
+		if err != nil {
+			ms.Close()
+			return nil, err
+		}
+		if err := ms.Add(m.Prefix, mounted); err != nil {
+			mounted.Close()
+			ms.Close()
+			return nil, err
+		}
+		logger.Infof("Mount %s: %s", m.Prefix, m.Root)
+	}
+	return ms, nil
+}
+
+// newServer cria o Server de root, que pode ser um diretório ou a URI de um
+// Storage (ex: 's3://bucket/site' ou 'memory:').
+func newServer(root string, keepOriginalUploadFileName bool, spaMode bool, logger *logrus.Entry, opts []handler.Option) (*handler.Server, error) {
+	if !storage.IsURI(root) {
+		return handler.NewServer(root, keepOriginalUploadFileName, spaMode, logger, opts...), nil
+	}
+
+	st, err := storage.New(root)
+	if err != nil {
+		return nil, err
+	}
+	if local, ok := st.(*storage.Local); ok {
+		return handler.NewServer(local.Root(), keepOriginalUploadFileName, spaMode, logger, opts...), nil
+	}
+	// the handler names the files under '/', the content lives in the storage
+	storageOpts := append(append([]handler.Option{}, opts...), handler.WithStorage(st))
+	return handler.NewServer("/", keepOriginalUploadFileName, spaMode, logger, storageOpts...), nil
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/guilhermerodrigues680/gouploadserver/handler"
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
+)
+
+var ErrSitesFile = errors.New("Sites file error")
+
+// Site é um site virtual escolhido pelo header Host. Os sites recebem as
+// mesmas opções globais do servidor, especializadas pelos campos abaixo.
+type Site struct {
+	// Hosts são os padrões de host do site (ex: 'example.com' ou '*.example.com')
+	Hosts []string `json:"hosts"`
+	// Root é o diretório servido, relativo ao arquivo de configuração, ou a URI
+	// de um Storage (ex: 's3://bucket/site')
+	Root string `json:"root"`
+	SPA  bool   `json:"spa"`
+	// Upload habilita o envio de arquivos, o padrão é true
+	Upload             *bool `json:"upload"`
+	KeepUploadFilename bool  `json:"keepUploadFilename"`
+	// Auth são credenciais 'user:password' exigidas pelo site
+	Auth []string `json:"auth"`
+	// CacheRules têm prioridade sobre as regras globais de Cache-Control
+	CacheRules []string `json:"cacheRules"`
+	// Mounts são diretórios do site no formato '/prefix=/path[:ro|rw|spa]'
+	Mounts []Mount `json:"mounts"`
+}
+
+// LoadSites lê o arquivo JSON com a lista de sites virtuais:
+// '{"sites": [{"hosts": ["docs.example.com"], "root": "./docs"}]}'.
+func LoadSites(file string) ([]Site, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSitesFile, err)
+	}
+
+	var config struct {
+		Sites []Site `json:"sites"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrSitesFile, file, err)
+	}
+
+	for i := range config.Sites {
+		site := &config.Sites[i]
+		if len(site.Hosts) == 0 || site.Root == "" {
+			return nil, fmt.Errorf("%w: site %d must have hosts and root", ErrSitesFile, i)
+		}
+		if !filepath.IsAbs(site.Root) && !storage.IsURI(site.Root) {
+			site.Root = filepath.Join(filepath.Dir(file), site.Root)
+		}
+		for j := range site.Mounts {
+			if root := site.Mounts[j].Root; !filepath.IsAbs(root) && !storage.IsURI(root) {
+				site.Mounts[j].Root = filepath.Join(filepath.Dir(file), root)
+			}
+		}
+	}
+	return config.Sites, nil
+}
+
+// options retorna as opções do site, aplicadas depois das opções globais.
+func (site *Site) options() []handler.Option {
+	upload := true
+	if site.Upload != nil {
+		upload = *site.Upload
+	}
+	return []handler.Option{
+		handler.WithUploads(upload),
+		handler.WithBasicAuth(site.Auth),
+		handler.WithCacheRules(site.CacheRules),
+	}
+}
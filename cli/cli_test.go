@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/handler"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestServer(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	s := handler.NewServer(dir, true, false, logrus.NewEntry(logger))
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return ts.URL, dir
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func run(t *testing.T, args ...string) (int, string) {
+	var out bytes.Buffer
+	code := Run(context.Background(), args[0], args[1:], &out, &out)
+	return code, out.String()
+}
+
+func TestSync(t *testing.T) {
+	url, root := newTestServer(t)
+	local := t.TempDir()
+	writeFiles(t, local, map[string]string{
+		"index.html":      "<h1>home</h1>",
+		"img/logo.svg":    "<svg/>",
+		"img/icons/a.ico": "ico",
+	})
+
+	code, out := run(t, "sync", "--dry-run", local, url+"/site")
+	if code != 0 || !strings.Contains(out, "upload  img/logo.svg") || !strings.Contains(out, "dry run: 3 to upload") {
+		t.Fatalf("dry run returned %d:\n%s", code, out)
+	}
+	if _, err := os.Stat(filepath.Join(root, "site")); !os.IsNotExist(err) {
+		t.Fatal("dry run must not change the server")
+	}
+
+	if code, out := run(t, "sync", local, url+"/site"); code != 0 || !strings.Contains(out, "3 uploaded") {
+		t.Fatalf("sync returned %d:\n%s", code, out)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, "site", "img", "icons", "a.ico"))
+	if err != nil || string(data) != "ico" {
+		t.Fatalf("sync saved %q, %v", data, err)
+	}
+	if code, out := run(t, "sync", local, url+"/site/"); code != 0 || !strings.Contains(out, "0 uploaded (0 B), 0 deleted, 0 directories created, 3 up to date") {
+		t.Fatalf("second sync returned %d:\n%s", code, out)
+	}
+
+	// only the changed file is sent, and --delete removes what is gone locally
+	future := time.Now().Add(time.Hour)
+	writeFiles(t, local, map[string]string{"index.html": "<h1>new</h1>"})
+	os.Chtimes(filepath.Join(local, "index.html"), future, future)
+	os.RemoveAll(filepath.Join(local, "img", "icons"))
+	if code, out := run(t, "sync", "--delete", local, url+"/site"); code != 0 || !strings.Contains(out, "1 uploaded") || !strings.Contains(out, "2 deleted") {
+		t.Fatalf("sync with changes returned %d:\n%s", code, out)
+	}
+	if _, err := os.Stat(filepath.Join(root, "site", "img", "icons")); !os.IsNotExist(err) {
+		t.Fatalf("--delete kept the directory: %v", err)
+	}
+
+	// the checksum ignores the modification time
+	os.Chtimes(filepath.Join(local, "img", "logo.svg"), future, future)
+	if code, out := run(t, "sync", "--checksum", local, url+"/site"); code != 0 || !strings.Contains(out, "0 uploaded") {
+		t.Fatalf("sync --checksum returned %d:\n%s", code, out)
+	}
+}
+
+func TestSyncRenamingServer(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"site/old.txt": "old"})
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	s := handler.NewServer(root, false, false, logrus.NewEntry(logger))
+	ts := httptest.NewServer(s)
+	defer s.Close()
+	defer ts.Close()
+
+	local := t.TempDir()
+	writeFiles(t, local, map[string]string{"index.html": "<h1>home</h1>"})
+
+	// the sync stops before deleting or uploading anything
+	code, out := run(t, "sync", "--delete", local, ts.URL+"/site")
+	if code == 0 || !strings.Contains(out, "--keep-upload-filename") {
+		t.Fatalf("sync to a renaming server returned %d:\n%s", code, out)
+	}
+	entries, _ := ioutil.ReadDir(filepath.Join(root, "site"))
+	if len(entries) != 1 || entries[0].Name() != "old.txt" {
+		t.Fatalf("the remote directory was changed: %v", entries)
+	}
+
+	// a new remote directory is checked once created
+	code, out = run(t, "sync", local, ts.URL+"/new")
+	entries, _ = ioutil.ReadDir(filepath.Join(root, "new"))
+	if code == 0 || len(entries) != 0 {
+		t.Fatalf("sync to a new directory returned %d with %d files:\n%s", code, len(entries), out)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	url, root := newTestServer(t)
+	local := t.TempDir()
+	writeFiles(t, local, map[string]string{"a.txt": "alpha", "b.txt": "beta"})
+	writeFiles(t, root, map[string]string{"docs/old/x.txt": "x"})
+
+	if code, out := run(t, "upload", filepath.Join(local, "a.txt"), filepath.Join(local, "b.txt"), "--to", url+"/docs/"); code != 0 || !strings.Contains(out, "-> /docs/b.txt") {
+		t.Fatalf("upload returned %d:\n%s", code, out)
+	}
+	if code, out := run(t, "ls", url+"/docs"); code != 0 || out != "a.txt\nb.txt\nold/\n" {
+		t.Fatalf("ls returned %d:\n%s", code, out)
+	}
+
+	dest := t.TempDir()
+	if code, out := run(t, "download", "--output", dest, url+"/docs/a.txt"); code != 0 {
+		t.Fatalf("download returned %d:\n%s", code, out)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dest, "a.txt")); string(data) != "alpha" {
+		t.Fatalf("download saved %q", data)
+	}
+	ioutil.WriteFile(filepath.Join(dest, "b.txt"), []byte("be"), 0644)
+	if code, out := run(t, "download", "--continue", "--output", filepath.Join(dest, "b.txt"), url+"/docs/b.txt"); code != 0 {
+		t.Fatalf("download --continue returned %d:\n%s", code, out)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dest, "b.txt")); string(data) != "beta" {
+		t.Fatalf("download --continue saved %q", data)
+	}
+
+	if code, out := run(t, "rm", url+"/docs/old/"); code != 1 || !strings.Contains(out, "409") {
+		t.Fatalf("rm of a non-empty directory returned %d:\n%s", code, out)
+	}
+	if code, out := run(t, "rm", "-r", url+"/docs/old/", url+"/docs/a.txt"); code != 0 {
+		t.Fatalf("rm -r returned %d:\n%s", code, out)
+	}
+	if code, out := run(t, "ls", url+"/docs/"); out != "b.txt\n" {
+		t.Fatalf("ls after rm returned %d:\n%s", code, out)
+	}
+
+	if code, _ := run(t, "upload", filepath.Join(local, "a.txt")); code != 2 {
+		t.Fatalf("upload without --to returned %d", code)
+	}
+	if code, _ := run(t, "ls", "ftp://host/"); code != 2 {
+		t.Fatalf("ls of a non-http URL returned %d", code)
+	}
+}
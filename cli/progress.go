@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/client"
+)
+
+const (
+	progressBarWidth    = 30
+	progressRedrawDelay = 100 * time.Millisecond
+)
+
+// progressBar exibe em uma única linha o progresso somado de todas as
+// transferências de um comando, redesenhada no máximo a cada 100ms.
+type progressBar struct {
+	mu      sync.Mutex
+	w       io.Writer
+	enabled bool
+
+	total     int64
+	done      int64
+	files     int
+	filesDone int
+
+	start time.Time
+	drawn time.Time
+	// lineWidth é o tamanho da última linha desenhada, apagada antes das mensagens
+	lineWidth int
+}
+
+func newProgressBar(w io.Writer, enabled bool) *progressBar {
+	return &progressBar{w: w, enabled: enabled, start: time.Now()}
+}
+
+// isTerminal indica se w é um terminal, onde a linha pode ser redesenhada.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// addFile soma uma transferência de size bytes ao total, 0 quando o tamanho é desconhecido.
+func (p *progressBar) addFile(size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total += size
+	p.files++
+}
+
+func (p *progressBar) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	p.draw(false)
+}
+
+// track retorna o client.Progress de uma transferência. Como o progresso volta
+// quando uma parte é reenviada, somente a diferença é somada.
+func (p *progressBar) track() client.Progress {
+	var last int64
+	return func(sent int64, total int64) {
+		p.add(sent - last)
+		last = sent
+	}
+}
+
+func (p *progressBar) fileDone() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.filesDone++
+	p.draw(true)
+}
+
+// printf escreve uma mensagem em w sem misturá-la com a barra.
+func (p *progressBar) printf(w io.Writer, format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Fprintf(w, format, args...)
+	p.draw(true)
+}
+
+// finish desenha a barra completa e termina a linha.
+func (p *progressBar) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.enabled || p.files == 0 {
+		return
+	}
+	p.draw(true)
+	fmt.Fprintln(p.w)
+	p.lineWidth = 0
+}
+
+func (p *progressBar) clear() {
+	if p.lineWidth > 0 {
+		fmt.Fprintf(p.w, "\r%s\r", strings.Repeat(" ", p.lineWidth))
+		p.lineWidth = 0
+	}
+}
+
+func (p *progressBar) draw(force bool) {
+	if !p.enabled || (!force && time.Since(p.drawn) < progressRedrawDelay) {
+		return
+	}
+	p.drawn = time.Now()
+
+	var line strings.Builder
+	if p.total > 0 {
+		done := p.done
+		if done > p.total {
+			done = p.total
+		}
+		filled := int(done * progressBarWidth / p.total)
+		bar := strings.Repeat("=", filled)
+		if filled < progressBarWidth {
+			bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+		}
+		fmt.Fprintf(&line, "[%s] %3d%%  %s/%s", bar, done*100/p.total, formatBytes(p.done), formatBytes(p.total))
+	} else {
+		fmt.Fprintf(&line, "%s", formatBytes(p.done))
+	}
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		fmt.Fprintf(&line, "  %s/s", formatBytes(int64(float64(p.done)/elapsed)))
+	}
+	fmt.Fprintf(&line, "  %d/%d files", p.filesDone, p.files)
+
+	s := line.String()
+	pad := ""
+	if len(s) < p.lineWidth {
+		pad = strings.Repeat(" ", p.lineWidth-len(s))
+	}
+	fmt.Fprintf(p.w, "\r%s%s", s, pad)
+	p.lineWidth = len(s)
+}
@@ -0,0 +1,313 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/client"
+)
+
+// syncPlan são as alterações que deixam o diretório remoto igual ao local.
+type syncPlan struct {
+	deletes []string
+	mkdirs  []string
+	uploads []string
+	// upToDate é a quantidade de arquivos que não precisam ser enviados
+	upToDate int
+}
+
+// runSync envia para o diretório remoto somente os arquivos locais novos ou
+// alterados, comparados pelo tamanho e data de modificação ou pelo sha256.
+// Com --delete os arquivos remotos que não existem localmente são removidos.
+func runSync(ctx context.Context, env *env, args []string) error {
+	var opts options
+	fs := env.flagSet(&opts, true)
+	checksum := fs.Bool("checksum", false, "Compare files by sha256 instead of size and modification time")
+	del := fs.Bool("delete", false, "Remove remote files that do not exist in the local directory")
+	dryRun := fs.Bool("dry-run", false, "Only show what would be transferred")
+	fs.BoolVar(&opts.resumable, "resumable", false, "Send files in parts that continue after network errors")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("%w: expected a local directory and a URL", ErrUsage)
+	}
+	localDir := positional[0]
+	if fi, err := os.Stat(localDir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("%w: %s is not a local directory", ErrUsage, localDir)
+	}
+	c, remoteDir, err := remote(positional[1], opts)
+	if err != nil {
+		return err
+	}
+	remoteDir = strings.TrimSuffix(remoteDir, "/") + "/"
+
+	local, err := localTree(localDir)
+	if err != nil {
+		return err
+	}
+	remoteFiles, err := remoteTree(ctx, c, remoteDir)
+	remoteExists := true
+	if isStatus(err, http.StatusNotFound) {
+		// the remote directory is created with the first mkdir
+		remoteFiles, err = map[string]client.Entry{}, nil
+		local[""] = nil
+		remoteExists = false
+	}
+	if err != nil {
+		return err
+	}
+
+	plan, err := planSync(ctx, c, remoteDir, localDir, local, remoteFiles, *checksum, *del, opts.parallel)
+	if err != nil {
+		return err
+	}
+
+	var uploadBytes int64
+	for _, rel := range plan.uploads {
+		uploadBytes += local[rel].Size()
+	}
+	if *dryRun {
+		for _, rel := range plan.deletes {
+			env.printf("delete  %s\n", path.Join(remoteDir, rel))
+		}
+		for _, rel := range plan.mkdirs {
+			env.printf("mkdir   %s\n", path.Join(remoteDir, rel)+"/")
+		}
+		for _, rel := range plan.uploads {
+			env.printf("upload  %s (%s)\n", rel, formatBytes(local[rel].Size()))
+		}
+		env.printf("dry run: %d to upload (%s), %d to delete, %d directories to create, %d up to date\n",
+			len(plan.uploads), formatBytes(uploadBytes), len(plan.deletes), len(plan.mkdirs), plan.upToDate)
+		return nil
+	}
+
+	// a server that renames the uploads fails before any change, a missing
+	// remote directory has nothing to delete and is checked once created
+	if len(plan.uploads) > 0 && remoteExists {
+		if err := checkKeepFilename(ctx, c, remoteDir); err != nil {
+			return err
+		}
+	}
+	for _, rel := range plan.deletes {
+		if err := c.Delete(ctx, path.Join(remoteDir, rel)); err != nil {
+			return fmt.Errorf("delete %s: %w", rel, err)
+		}
+		env.printf("deleted  %s\n", path.Join(remoteDir, rel))
+	}
+	for _, rel := range plan.mkdirs {
+		if err := c.Mkdir(ctx, path.Join(remoteDir, rel)+"/"); err != nil {
+			return fmt.Errorf("mkdir %s: %w", rel, err)
+		}
+		env.printf("created  %s\n", path.Join(remoteDir, rel)+"/")
+	}
+	if len(plan.uploads) > 0 && !remoteExists {
+		if err := checkKeepFilename(ctx, c, remoteDir); err != nil {
+			return err
+		}
+	}
+
+	bar := env.newProgress(opts)
+	for _, rel := range plan.uploads {
+		bar.addFile(local[rel].Size())
+	}
+	failed := parallel(ctx, opts.parallel, len(plan.uploads), func(i int) error {
+		rel := plan.uploads[i]
+		dir := path.Join(remoteDir, path.Dir(rel)) + "/"
+		saved, err := c.UploadFile(ctx, filepath.Join(localDir, filepath.FromSlash(rel)), dir, bar.track())
+		bar.fileDone()
+		if err == nil && saved != path.Join(remoteDir, rel) {
+			// a renamed copy would be uploaded again on every sync
+			err = fmt.Errorf("the server saved it as %s, start the server with --keep-upload-filename", saved)
+		}
+		if err != nil {
+			env.errorf("upload %s: %s\n", rel, err)
+			return err
+		}
+		env.printf("uploaded %s\n", rel)
+		return nil
+	})
+	bar.finish()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed", failed, len(plan.uploads))
+	}
+	env.printf("%d uploaded (%s), %d deleted, %d directories created, %d up to date\n",
+		len(plan.uploads), formatBytes(uploadBytes), len(plan.deletes), len(plan.mkdirs), plan.upToDate)
+	return nil
+}
+
+// checkKeepFilename envia um pequeno arquivo de teste para dir e o remove. Sem
+// o --keep-upload-filename o servidor salva os arquivos com outro nome e as
+// cópias renomeadas seriam enviadas de novo a cada sync.
+func checkKeepFilename(ctx context.Context, c *client.Client, dir string) error {
+	name := fmt.Sprintf("gouploadserver-sync-check-%d.txt", time.Now().UnixNano())
+	content := []byte("gouploadserver sync check\n")
+	saved, err := c.Upload(ctx, dir, name, bytes.NewReader(content), int64(len(content)), nil)
+	if err != nil {
+		return fmt.Errorf("upload check: %w", err)
+	}
+	if err := c.Delete(ctx, saved); err != nil {
+		return fmt.Errorf("upload check: delete %s: %w", saved, err)
+	}
+	if saved != path.Join(dir, name) {
+		return fmt.Errorf("the server renames the uploads (%s was saved as %s), start the server with --keep-upload-filename", name, saved)
+	}
+	return nil
+}
+
+// localTree lista recursivamente o diretório local. As chaves são os caminhos
+// relativos com '/', terminados em '/' nos diretórios. Links simbólicos para
+// arquivos são seguidos, os demais são ignorados.
+func localTree(dir string) (map[string]os.FileInfo, error) {
+	tree := make(map[string]os.FileInfo)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(p)
+			if err != nil || !target.Mode().IsRegular() {
+				return nil
+			}
+			fi = target
+		}
+		switch {
+		case fi.IsDir():
+			tree[rel+"/"] = fi
+		case fi.Mode().IsRegular():
+			tree[rel] = fi
+		}
+		return nil
+	})
+	return tree, err
+}
+
+// planSync compara as árvores local e remota. Uma chave "" em local indica que
+// o próprio diretório remoto precisa ser criado.
+func planSync(ctx context.Context, c *client.Client, remoteDir string, localDir string, local map[string]os.FileInfo, remoteFiles map[string]client.Entry, checksum bool, del bool, workers int) (*syncPlan, error) {
+	plan := &syncPlan{}
+
+	if del {
+		for rel := range remoteFiles {
+			if _, ok := local[rel]; !ok {
+				plan.deletes = append(plan.deletes, rel)
+			}
+		}
+		sortForDelete(plan.deletes)
+	}
+
+	var candidates []string
+	for rel, fi := range local {
+		switch {
+		case rel == "":
+			plan.mkdirs = append(plan.mkdirs, "")
+		case fi.IsDir():
+			if _, ok := remoteFiles[rel]; !ok {
+				if _, isFile := remoteFiles[strings.TrimSuffix(rel, "/")]; isFile && !del {
+					return nil, fmt.Errorf("remote %s is a file, use --delete to replace it", rel)
+				}
+				plan.mkdirs = append(plan.mkdirs, strings.TrimSuffix(rel, "/"))
+			}
+		default:
+			if _, isDir := remoteFiles[rel+"/"]; isDir && !del {
+				return nil, fmt.Errorf("remote %s/ is a directory, use --delete to replace it", rel)
+			}
+			candidates = append(candidates, rel)
+		}
+	}
+	// parents sort before their children
+	sort.Strings(plan.mkdirs)
+	sort.Strings(candidates)
+
+	changed := make([]bool, len(candidates))
+	var mu sync.Mutex
+	var firstErr error
+	parallel(ctx, workers, len(candidates), func(i int) error {
+		rel := candidates[i]
+		diff, err := differs(ctx, c, path.Join(remoteDir, rel), filepath.Join(localDir, filepath.FromSlash(rel)), local[rel], remoteFiles[rel], checksum)
+		if err != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("compare %s: %w", rel, err)
+			}
+			mu.Unlock()
+			return err
+		}
+		changed[i] = diff
+		return nil
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, rel := range candidates {
+		if changed[i] {
+			plan.uploads = append(plan.uploads, rel)
+		} else {
+			plan.upToDate++
+		}
+	}
+	return plan, nil
+}
+
+// differs indica se o arquivo local precisa ser enviado. O arquivo remoto
+// recebe a data do upload, então ele está atualizado quando não é mais antigo
+// que o local.
+func differs(ctx context.Context, c *client.Client, remotePath string, localPath string, local os.FileInfo, remote client.Entry, checksum bool) (bool, error) {
+	if remote.Name == "" || remote.IsDir || remote.Size != local.Size() {
+		return true, nil
+	}
+	if !checksum {
+		return local.ModTime().After(remote.ModTime), nil
+	}
+
+	remoteSum, err := c.Checksum(ctx, remotePath, "sha256")
+	if err != nil {
+		return false, err
+	}
+	localSum, err := fileSHA256(localPath)
+	if err != nil {
+		return false, err
+	}
+	return remoteSum != localSum, nil
+}
+
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func isStatus(err error, code int) bool {
+	var statusErr *client.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == code
+}
@@ -0,0 +1,403 @@
+// Package client acessa um gouploadserver pela API HTTP: envio de arquivos com
+// progresso e retomada, download com intervalos, listagem e remoção.
+//
+//	c, err := client.New("http://localhost:8000", client.WithBasicAuth("user", "password"))
+//	if err != nil {
+//		return err
+//	}
+//	saved, err := c.UploadFile(ctx, "report.pdf", "/docs/", nil)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBaseURL       = errors.New("Invalid server URL")
+	ErrRemoteChanged = errors.New("Remote file changed during the download")
+)
+
+// StatusError é a resposta de erro do servidor.
+type StatusError struct {
+	StatusCode int
+	// Message é o corpo da resposta, quando é texto
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client acessa um servidor. É seguro para uso concorrente.
+type Client struct {
+	base *url.URL
+	http *http.Client
+
+	user     string
+	password string
+	header   http.Header
+
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// resumable envia os uploads em partes, veja WithResumableUploads
+	resumable bool
+	chunkSize int64
+}
+
+// New cria o Client do servidor em baseURL (ex: 'http://localhost:8000').
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBaseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrBaseURL, baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	c := &Client{
+		base:       u,
+		http:       http.DefaultClient,
+		header:     make(http.Header),
+		retries:    defaultRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		chunkSize:  defaultChunkSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Entry é um arquivo ou diretório da listagem.
+type Entry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// dirListing é a listagem em JSON enviada pelo servidor.
+type dirListing struct {
+	Entries []Entry `json:"entries"`
+	Next    string  `json:"next"`
+}
+
+// List retorna as entradas do diretório remoto dir, seguindo todas as páginas.
+func (c *Client) List(ctx context.Context, dir string) ([]Entry, error) {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	next := c.url(dir, "")
+
+	var entries []Entry
+	for next != "" {
+		target := next
+		resp, err := c.do(ctx, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodGet, target, nil)
+			if err == nil {
+				req.Header.Set("Accept", "application/json")
+			}
+			return req, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var listing dirListing
+		err = json.NewDecoder(resp.Body).Decode(&listing)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", dir, err)
+		}
+		entries = append(entries, listing.Entries...)
+
+		next = ""
+		if listing.Next != "" {
+			u, err := url.Parse(listing.Next)
+			if err != nil {
+				return nil, fmt.Errorf("list %s: %w", dir, err)
+			}
+			next = c.url(u.Path, u.RawQuery)
+		}
+	}
+	return entries, nil
+}
+
+// Delete remove o arquivo ou o diretório vazio remotePath.
+func (c *Client) Delete(ctx context.Context, remotePath string) error {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodDelete, c.url(remotePath, ""), nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Mkdir cria o diretório remoto dir, o diretório pai precisa existir.
+func (c *Client) Mkdir(ctx context.Context, dir string) error {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest("MKCOL", c.url(dir, ""), nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Checksum retorna o hash hexadecimal do arquivo remotePath calculado pelo
+// servidor, algorithm é 'sha256' ou 'md5'.
+func (c *Client) Checksum(ctx context.Context, remotePath string, algorithm string) (string, error) {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, c.url(remotePath, "checksum="+url.QueryEscape(algorithm)), nil)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	sum, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(sum)), nil
+}
+
+// Range é um trecho de um arquivo, Length <= 0 vai até o fim do arquivo.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// Download escreve em w o arquivo remotePath, ou somente o trecho rng quando
+// não é nil, e retorna a quantidade de bytes escritos. Uma conexão perdida no
+// meio do arquivo é retomada do último byte escrito, desde que o arquivo
+// remoto não tenha sido alterado (ErrRemoteChanged).
+func (c *Client) Download(ctx context.Context, remotePath string, w io.Writer, rng *Range) (int64, error) {
+	var want Range
+	if rng != nil {
+		want = *rng
+	}
+
+	var written int64
+	var lastModified string
+	for attempt := 0; ; attempt++ {
+		start := want.Offset + written
+		resp, err := c.do(ctx, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodGet, c.url(remotePath, ""), nil)
+			if err != nil {
+				return nil, err
+			}
+			// compressed responses have no ranges
+			req.Header.Set("Accept-Encoding", "identity")
+			if start > 0 || want.Length > 0 {
+				spec := "bytes=" + strconv.FormatInt(start, 10) + "-"
+				if want.Length > 0 {
+					spec += strconv.FormatInt(want.Offset+want.Length-1, 10)
+				}
+				req.Header.Set("Range", spec)
+				if lastModified != "" {
+					req.Header.Set("If-Range", lastModified)
+				}
+			}
+			return req, nil
+		})
+		if err != nil {
+			return written, err
+		}
+
+		var body io.Reader = resp.Body
+		if resp.StatusCode == http.StatusOK {
+			// the server sent the whole file: it ignores ranges or the file changed
+			if written > 0 {
+				resp.Body.Close()
+				return written, fmt.Errorf("%w: %s", ErrRemoteChanged, remotePath)
+			}
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, want.Offset); err != nil {
+				resp.Body.Close()
+				return written, err
+			}
+			if want.Length > 0 {
+				body = io.LimitReader(resp.Body, want.Length)
+			}
+		}
+		if lastModified == "" {
+			lastModified = resp.Header.Get("Last-Modified")
+		}
+		resumable := resp.Header.Get("Accept-Ranges") == "bytes" && lastModified != ""
+
+		dst := &writer{w: w}
+		n, err := io.Copy(dst, body)
+		resp.Body.Close()
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		// only a lost connection is resumed, w may not accept more data
+		if dst.err != nil || !resumable || ctx.Err() != nil || attempt >= c.retries {
+			return written, err
+		}
+		if err := c.sleep(ctx, attempt, 0); err != nil {
+			return written, err
+		}
+	}
+}
+
+// writer guarda o erro de escrita, para diferenciá-lo dos erros de leitura do io.Copy.
+type writer struct {
+	w   io.Writer
+	err error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.err = err
+	return n, err
+}
+
+// url monta a URL de p, um caminho do servidor (ex: '/docs/report.pdf').
+func (c *Client) url(p string, rawQuery string) string {
+	u := *c.base
+	u.Path = c.base.Path + "/" + strings.TrimPrefix(p, "/")
+	u.RawPath = ""
+	u.RawQuery = rawQuery
+	return u.String()
+}
+
+// resolve converte a URL relativa location enviada pelo servidor em uma URL absoluta.
+func (c *Client) resolve(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	if u.IsAbs() {
+		return u.String(), nil
+	}
+	return c.url(u.Path, u.RawQuery), nil
+}
+
+// send envia a requisição uma única vez, as respostas de erro viram *StatusError.
+func (c *Client) send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	if c.user != "" || c.password != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	statusErr := &StatusError{StatusCode: resp.StatusCode}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		statusErr.Message = strings.TrimSpace(string(body))
+	}
+	return resp, statusErr
+}
+
+// do envia a requisição criada por newRequest, repetindo após as falhas de
+// rede e as respostas temporárias (ex: 503) com backoff exponencial.
+func (c *Client) do(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.send(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil || attempt >= c.retries || !retryable(err) {
+			return nil, err
+		}
+		var retryAfter time.Duration
+		if resp != nil {
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		if err := c.sleep(ctx, attempt, retryAfter); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable indica se a requisição que falhou com err pode ser repetida.
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// sleep aguarda o backoff da tentativa, que dobra a cada tentativa até
+// maxBackoff, ou o Retry-After do servidor quando é maior.
+func (c *Client) sleep(ctx context.Context, attempt int, retryAfter time.Duration) error {
+	d := c.minBackoff << uint(attempt)
+	if d > c.maxBackoff || d <= 0 {
+		d = c.maxBackoff
+	}
+	// jitter spreads the retries of concurrent clients
+	if d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	if retryAfter > d {
+		d = retryAfter
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/handler"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestServer serve um diretório temporário com 'docs/guide.md', wrap
+// permite simular falhas na frente do handler.Server.
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler, opts ...handler.Option) (*httptest.Server, string) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "docs", "guide.md"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	s := handler.NewServer(dir, false, false, logrus.NewEntry(logger), opts...)
+	var h http.Handler = s
+	if wrap != nil {
+		h = wrap(h)
+	}
+	ts := httptest.NewServer(h)
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return ts, dir
+}
+
+func newTestClient(t *testing.T, url string, opts ...Option) *Client {
+	opts = append([]Option{WithRetry(3, time.Millisecond, 10*time.Millisecond)}, opts...)
+	c, err := New(url, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	ts, dir := newTestServer(t, nil)
+	c := newTestClient(t, ts.URL)
+	ctx := context.Background()
+
+	content := "hello resumable world"
+	var last int64
+	saved, err := c.Upload(ctx, "/docs/", "report.txt", strings.NewReader(content), int64(len(content)), func(sent, total int64) {
+		if total != int64(len(content)) {
+			t.Errorf("progress total %d", total)
+		}
+		last = sent
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(saved, "/docs/report-") || !strings.HasSuffix(saved, ".txt") {
+		t.Fatalf("Upload saved %q", saved)
+	}
+	if last != int64(len(content)) {
+		t.Fatalf("last progress %d", last)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(saved)))
+	if err != nil || string(data) != content {
+		t.Fatalf("upload saved %q, %v", data, err)
+	}
+
+	entries, err := c.List(ctx, "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "guide.md" || entries[0].Size != 10 {
+		t.Fatalf("List returned %+v", entries)
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.Download(ctx, "/docs/guide.md", &buf, nil); err != nil || buf.String() != "0123456789" {
+		t.Fatalf("Download returned %q, %v", buf.String(), err)
+	}
+	buf.Reset()
+	if _, err := c.Download(ctx, "/docs/guide.md", &buf, &Range{Offset: 3, Length: 4}); err != nil || buf.String() != "3456" {
+		t.Fatalf("Download of a range returned %q, %v", buf.String(), err)
+	}
+
+	sum, err := c.Checksum(ctx, "/docs/guide.md", "sha256")
+	if err != nil || sum != "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882" {
+		t.Fatalf("Checksum returned %q, %v", sum, err)
+	}
+	if err := c.Mkdir(ctx, "/docs/new/"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "docs", "new")); err != nil || !fi.IsDir() {
+		t.Fatalf("Mkdir did not create the directory: %v", err)
+	}
+
+	if err := c.Delete(ctx, saved); err != nil {
+		t.Fatal(err)
+	}
+	var statusErr *StatusError
+	if err := c.Delete(ctx, saved); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("deleting a missing file returned %v", err)
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	ts, _ := newTestServer(t, nil, handler.WithBasicAuth([]string{"user:password"}))
+	ctx := context.Background()
+
+	var statusErr *StatusError
+	if _, err := newTestClient(t, ts.URL).List(ctx, "/"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("List without credentials returned %v", err)
+	}
+	if _, err := newTestClient(t, ts.URL, WithBasicAuth("user", "password")).List(ctx, "/"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	var failures int32 = 2
+	ts, _ := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	ctx := context.Background()
+
+	var statusErr *StatusError
+	if _, err := newTestClient(t, ts.URL, WithRetry(0, 0, 0)).List(ctx, "/"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("List without retries returned %v", err)
+	}
+	if _, err := newTestClient(t, ts.URL).List(ctx, "/"); err != nil {
+		t.Fatalf("List with retries returned %v", err)
+	}
+}
+
+// TestClientResume corta as conexões no meio dos corpos: o upload e o download
+// continuam do último byte recebido.
+func TestClientResume(t *testing.T) {
+	var patches, gets int32
+	ts, dir := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodPatch && atomic.AddInt32(&patches, 1) == 2:
+				// the server receives half of the part before the connection drops
+				r.Body = ioutil.NopCloser(io.MultiReader(io.LimitReader(r.Body, 2), errReader{}))
+				next.ServeHTTP(httptest.NewRecorder(), r)
+				panic(http.ErrAbortHandler)
+			case r.Method == http.MethodGet && r.URL.Path == "/docs/guide.md" && atomic.AddInt32(&gets, 1) == 1:
+				rec := httptest.NewRecorder()
+				next.ServeHTTP(rec, r)
+				for k, v := range rec.Header() {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(rec.Code)
+				w.Write(rec.Body.Bytes()[:4])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := newTestClient(t, ts.URL, WithResumableUploads(), WithChunkSize(4))
+	ctx := context.Background()
+
+	content := "abcdefghijkl"
+	saved, err := c.Upload(ctx, "/docs", "resumed.txt", strings.NewReader(content), int64(len(content)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(saved)))
+	if err != nil || string(data) != content {
+		t.Fatalf("resumed upload saved %q, %v", data, err)
+	}
+	// 0-4, 4-6 cut, 6-10 and 10-12
+	if patches != 4 {
+		t.Fatalf("upload sent %d parts, want 4", patches)
+	}
+
+	var buf bytes.Buffer
+	n, err := c.Download(ctx, "/docs/guide.md", &buf, nil)
+	if err != nil || n != 10 || buf.String() != "0123456789" {
+		t.Fatalf("resumed download returned %q, %d, %v", buf.String(), n, err)
+	}
+	if gets != 2 {
+		t.Fatalf("download sent %d requests, want 2", gets)
+	}
+}
+
+func TestClientUploadError(t *testing.T) {
+	var fail int32 = 1
+	ts, dir := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPatch && atomic.LoadInt32(&fail) == 1 {
+				http.Error(w, "maintenance", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := newTestClient(t, ts.URL, WithResumableUploads(), WithRetry(1, time.Millisecond, time.Millisecond))
+	ctx := context.Background()
+
+	content := "later"
+	_, err := c.Upload(ctx, "/", "later.txt", strings.NewReader(content), int64(len(content)), nil)
+	var uploadErr *UploadError
+	if !errors.As(err, &uploadErr) {
+		t.Fatalf("Upload returned %v, want an *UploadError", err)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	saved, err := c.Resume(ctx, uploadErr.URL, strings.NewReader(content), int64(len(content)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(saved))); string(data) != content {
+		t.Fatalf("Resume saved %q", data)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetries    = 3
+	defaultMinBackoff = 250 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+	// defaultChunkSize é o tamanho das partes dos uploads, o máximo reenviado
+	// após uma falha de rede
+	defaultChunkSize = 8 << 20
+)
+
+// Option configura funcionalidades opcionais do Client.
+type Option func(*Client)
+
+// WithBasicAuth envia as credenciais do '--auth' do servidor em todas as requisições.
+func WithBasicAuth(user string, password string) Option {
+	return func(c *Client) {
+		c.user = user
+		c.password = password
+	}
+}
+
+// WithHeader envia o header em todas as requisições (ex: o token de um proxy
+// de autenticação na frente do servidor).
+func WithHeader(key string, value string) Option {
+	return func(c *Client) {
+		c.header.Add(key, value)
+	}
+}
+
+// WithHTTPClient usa hc nas requisições no lugar do http.DefaultClient
+// (ex: para configurar timeouts, proxies e certificados).
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithRetry configura as novas tentativas após falhas de rede e respostas
+// temporárias (408, 429 e 5xx). A espera começa em minBackoff e dobra a cada
+// tentativa até maxBackoff. retries 0 desabilita as novas tentativas.
+func WithRetry(retries int, minBackoff time.Duration, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithResumableUploads envia os uploads em partes que continuam do último byte
+// recebido após uma falha, o servidor deve estar com os uploads habilitados.
+func WithResumableUploads() Option {
+	return func(c *Client) {
+		c.resumable = true
+	}
+}
+
+// WithChunkSize configura o tamanho das partes enviadas pelo Upload com
+// WithResumableUploads.
+func WithChunkSize(size int64) Option {
+	return func(c *Client) {
+		if size > 0 {
+			c.chunkSize = size
+		}
+	}
+}
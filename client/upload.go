@@ -0,0 +1,281 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Progress recebe a quantidade de bytes enviados e o total do arquivo. Após
+// uma falha, sent volta para o último byte recebido pelo servidor.
+type Progress func(sent int64, total int64)
+
+// UploadError é a falha de um upload que pode ser continuado com Resume.
+type UploadError struct {
+	// URL identifica o upload no servidor
+	URL string
+	Err error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("upload %s: %s", e.URL, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// UploadFile envia o arquivo localPath para o diretório remoto dir, veja Upload.
+func (c *Client) UploadFile(ctx context.Context, localPath string, dir string, progress Progress) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("upload %s: not a regular file", localPath)
+	}
+	return c.Upload(ctx, dir, filepath.Base(localPath), f, fi.Size(), progress)
+}
+
+// Upload envia os size bytes de r como o arquivo name do diretório remoto dir
+// e retorna o caminho do arquivo salvo, que recebe um sufixo aleatório quando
+// o servidor não mantém os nomes originais (ex: '/docs/report-123.pdf').
+//
+// O arquivo é enviado no campo 'file' de um formulário multipart, como no
+// navegador de arquivos, e após uma falha é enviado novamente desde o início.
+// Com WithResumableUploads o arquivo é enviado em partes: após uma falha o envio
+// continua do último byte recebido pelo servidor e, quando as tentativas
+// acabam, o erro é um *UploadError, que permite continuar mais tarde com Resume.
+func (c *Client) Upload(ctx context.Context, dir string, name string, r io.ReaderAt, size int64, progress Progress) (string, error) {
+	if !c.resumable {
+		return c.uploadForm(ctx, dir, name, r, size, progress)
+	}
+
+	target := path.Join("/", dir, name)
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, c.url(target, "resumable"), nil)
+		if err == nil {
+			req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+		}
+		return req, err
+	})
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") == "" {
+		return "", fmt.Errorf("upload %s: the server does not support resumable uploads", target)
+	}
+	uploadURL, err := c.resolve(resp.Header.Get("Location"))
+	if err != nil {
+		return "", err
+	}
+	return c.resume(ctx, uploadURL, r, size, 0, progress)
+}
+
+// uploadForm envia o arquivo em um formulário multipart para o diretório dir.
+func (c *Client) uploadForm(ctx context.Context, dir string, name string, r io.ReaderAt, size int64, progress Progress) (string, error) {
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	if _, err := mw.CreateFormFile("file", name); err != nil {
+		return "", err
+	}
+	// the file content goes between the part header and the closing boundary
+	headerLen := form.Len()
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	// the server saves the file in the directory of the URL, so it ends with '/'
+	target := strings.TrimSuffix(path.Join("/", dir), "/") + "/"
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		var file io.Reader = io.NewSectionReader(r, 0, size)
+		if progress != nil {
+			progress(0, size)
+			file = &progressReader{r: file, total: size, progress: progress}
+		}
+		body := io.MultiReader(bytes.NewReader(form.Bytes()[:headerLen]), file, bytes.NewReader(form.Bytes()[headerLen:]))
+		req, err := http.NewRequest(http.MethodPost, c.url(target, ""), body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(form.Len()) + size
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Files []string `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("upload %s: %w", path.Join(target, name), err)
+	}
+	if len(result.Files) != 1 {
+		return "", fmt.Errorf("upload %s: the server saved %d files", path.Join(target, name), len(result.Files))
+	}
+	return result.Files[0], nil
+}
+
+// Resume continua o upload uploadURL de um *UploadError, r deve ter o mesmo
+// conteúdo enviado no Upload.
+func (c *Client) Resume(ctx context.Context, uploadURL string, r io.ReaderAt, size int64, progress Progress) (string, error) {
+	return c.resume(ctx, uploadURL, r, size, -1, progress)
+}
+
+// AbortUpload cancela o upload uploadURL de um *UploadError.
+func (c *Client) AbortUpload(ctx context.Context, uploadURL string) error {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodDelete, uploadURL, nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// resume envia as partes a partir de offset, ou do offset informado pelo
+// servidor quando offset é negativo.
+func (c *Client) resume(ctx context.Context, uploadURL string, r io.ReaderAt, size int64, offset int64, progress Progress) (string, error) {
+	failures := 0
+	for {
+		var err error
+		if offset < 0 {
+			offset, err = c.uploadOffset(ctx, uploadURL)
+			if err != nil {
+				return "", &UploadError{URL: uploadURL, Err: err}
+			}
+		}
+		if progress != nil {
+			progress(offset, size)
+		}
+
+		var saved string
+		saved, offset, err = c.sendPart(ctx, uploadURL, r, size, offset, progress)
+		if err == nil {
+			if saved != "" {
+				return saved, nil
+			}
+			failures = 0
+			continue
+		}
+
+		// a conflict is the previous request of a lost connection still running
+		if ctx.Err() != nil || failures >= c.retries || !(retryable(err) || isStatus(err, http.StatusConflict)) {
+			return "", &UploadError{URL: uploadURL, Err: err}
+		}
+		if err := c.sleep(ctx, failures, 0); err != nil {
+			return "", &UploadError{URL: uploadURL, Err: err}
+		}
+		failures++
+		offset = -1
+	}
+}
+
+// sendPart envia a parte que começa em offset e retorna o novo offset, ou o
+// caminho do arquivo salvo quando o upload completa.
+func (c *Client) sendPart(ctx context.Context, uploadURL string, r io.ReaderAt, size int64, offset int64, progress Progress) (string, int64, error) {
+	n := size - offset
+	if n > c.chunkSize {
+		n = c.chunkSize
+	}
+	var body io.Reader = io.NewSectionReader(r, offset, n)
+	if progress != nil {
+		body = &progressReader{r: body, sent: offset, total: size, progress: progress}
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, uploadURL, body)
+	if err != nil {
+		return "", offset, err
+	}
+	req.ContentLength = n
+	if n == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		// the server has a different offset, e.g. the response of the last part was lost
+		if isStatus(err, http.StatusConflict) {
+			if o, perr := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64); perr == nil {
+				return "", o, nil
+			}
+		}
+		return "", offset, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusCreated {
+		u, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			return "", offset, err
+		}
+		return u.Path, size, nil
+	}
+	o, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || o <= offset || o > size {
+		return "", offset, fmt.Errorf("upload %s: invalid Upload-Offset %q", uploadURL, resp.Header.Get("Upload-Offset"))
+	}
+	return "", o, nil
+}
+
+// uploadOffset consulta quantos bytes do upload o servidor recebeu.
+func (c *Client) uploadOffset(ctx context.Context, uploadURL string) (int64, error) {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, uploadURL, nil)
+	})
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("upload %s: invalid Upload-Offset %q", uploadURL, resp.Header.Get("Upload-Offset"))
+	}
+	return offset, nil
+}
+
+func isStatus(err error, code int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == code
+}
+
+// progressReader informa o progresso a cada leitura do corpo da requisição.
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress Progress
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.sent += int64(n)
+		pr.progress(pr.sent, pr.total)
+	}
+	return n, err
+}
@@ -1,7 +1,31 @@
 package main
 
-import "fmt"
+/*
+	go-post-compile [options]
+	ex: go run ./cmd/go-post-compile
+
+	Remove a cópia do diretório e os arquivos gerados pelo go-pre-compile
+	depois do 'go build -tags pack'.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/guilhermerodrigues680/gouploadserver/pack"
+)
+
+var packDirFlag = flag.String("pack-dir", "pack", "Directory of the pack package")
 
 func main() {
-	fmt.Println("go-post-compile")
+	flag.Parse()
+
+	for _, name := range []string{pack.SiteDir, pack.ConfigFile, pack.GeneratedFile} {
+		if err := os.RemoveAll(filepath.Join(*packDirFlag, name)); err != nil {
+			fmt.Fprintf(os.Stderr, "go-post-compile: %s\n", err)
+			os.Exit(1)
+		}
+	}
 }
@@ -1,7 +1,318 @@
 package main
 
-import "fmt"
+/*
+	go-pre-compile [options] <dir>
+	ex: go run ./cmd/go-pre-compile --spa --precompress test/spa/dist
+
+	Copia <dir> para pack/site e gera os arquivos que o embutem no executável
+	compilado com 'go build -tags pack'. O go-post-compile remove a cópia e o
+	cmd/pack executa os três passos.
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/guilhermerodrigues680/gouploadserver/handler"
+	"github.com/guilhermerodrigues680/gouploadserver/pack"
+)
+
+// precompressMinSize é o tamanho mínimo dos arquivos comprimidos pelo --precompress.
+const precompressMinSize = 1024
+
+var packDirFlag = flag.String("pack-dir", "pack", "Directory of the pack package")
+var spaFlag = flag.Bool("spa", false, "Return to all files not found /index.html")
+var precompressFlag = flag.Bool("precompress", false, "Create .br and .gz variants of compressible files and serve them")
+var precompressedFlag = flag.Bool("precompressed", false, "Serve the .br and .gz files already present in <dir>")
+var cachePolicyFlag = flag.Bool("cache-policy", true, "Send Cache-Control headers: immutable for hashed assets and no-cache for HTML")
+var ruleFilesFlag = flag.Bool("rule-files", false, "Apply Netlify-style _redirects and _headers files from the root of <dir>")
+var indexFilesFlag = flag.String("index-files", "", "Comma separated index files served instead of the directory listing")
+var cleanURLsFlag = flag.Bool("clean-urls", false, "Serve /about with about.html when /about does not exist")
+var hiddenFlag = flag.Bool("hidden", false, "Embed hidden files (dotfiles), .git directories are always skipped")
+var cacheRuleFlag listFlag
+
+func init() {
+	flag.Var(&cacheRuleFlag, "cache-rule", "Cache-Control rule 'pattern=value' (repeatable)")
+}
+
+// listFlag é uma flag que pode ser informada várias vezes.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
 
 func main() {
-	fmt.Println("go-pre-compile")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: go-pre-compile [options] <dir>")
+		flag.PrintDefaults()
+	}
+	// the flags may also come after <dir>, as in 'pack <dir> --spa'
+	var args []string
+	for rest := os.Args[1:]; ; rest = flag.Args()[1:] {
+		flag.CommandLine.Parse(rest)
+		if flag.NArg() == 0 {
+			break
+		}
+		args = append(args, flag.Arg(0))
+	}
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(args[0], *packDirFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "go-pre-compile: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(srcDir string, packDir string) error {
+	if fi, err := os.Stat(srcDir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", srcDir)
+	}
+	if err := clean(packDir); err != nil {
+		return err
+	}
+
+	siteDir := filepath.Join(packDir, pack.SiteDir)
+	patterns, files, modified, err := copyTree(srcDir, siteDir, *hiddenFlag)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%s has no files to embed", srcDir)
+	}
+
+	precompressed := *precompressedFlag
+	if *precompressFlag {
+		created, err := precompress(siteDir, files)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("go-pre-compile: %d compressed variants created\n", created)
+		precompressed = true
+	}
+
+	cfg := pack.Config{
+		SPA:           *spaFlag,
+		Precompressed: precompressed,
+		CachePolicy:   *cachePolicyFlag,
+		CacheRules:    cacheRuleFlag,
+		RuleFiles:     *ruleFilesFlag,
+		IndexFiles:    splitList(*indexFilesFlag),
+		CleanURLs:     *cleanURLsFlag,
+		BuiltAt:       modified.UTC().Truncate(time.Second),
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(packDir, pack.ConfigFile), data, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(packDir, pack.GeneratedFile), generate(patterns), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("go-pre-compile: %d files of %s copied to %s\n", len(files), srcDir, siteDir)
+	return nil
+}
+
+// clean remove a cópia e os arquivos gerados por uma execução anterior.
+func clean(packDir string) error {
+	for _, name := range []string{pack.SiteDir, pack.ConfigFile, pack.GeneratedFile} {
+		if err := os.RemoveAll(filepath.Join(packDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copia os arquivos regulares de src para dst, seguindo os links
+// simbólicos. Retorna os padrões do //go:embed e os arquivos copiados, com
+// caminhos relativos separados por '/', e a data de modificação mais recente
+// entre eles, assim o mesmo diretório gera sempre a mesma Config.
+func copyTree(src string, dst string, hidden bool) ([]string, []string, time.Time, error) {
+	// the embed directive skips names starting with '.' or '_' inside a
+	// directory, they are embedded only when listed by name
+	patterns := []string{pack.SiteDir}
+	var files []string
+	var modified time.Time
+	err := filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		name := fi.Name()
+		if name == ".git" || (!hidden && strings.HasPrefix(name, ".")) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(p)
+			if err != nil || !target.Mode().IsRegular() {
+				fmt.Fprintf(os.Stderr, "go-pre-compile: skipping %s\n", p)
+				return nil
+			}
+			fi = target
+		}
+		if fi.IsDir() || !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		if err := copyFile(p, filepath.Join(dst, filepath.FromSlash(rel))); err != nil {
+			return err
+		}
+		files = append(files, rel)
+		if fi.ModTime().After(modified) {
+			modified = fi.ModTime()
+		}
+		if hasIgnoredElem(rel) {
+			patterns = append(patterns, path.Join(pack.SiteDir, rel))
+		}
+		return nil
+	})
+	return patterns, files, modified, err
+}
+
+// hasIgnoredElem indica se algum elemento do caminho é ignorado pelo //go:embed de um diretório.
+func hasIgnoredElem(rel string) bool {
+	for _, elem := range strings.Split(rel, "/") {
+		if strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+func copyFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// precompress cria os arquivos '.br' e '.gz' dos arquivos compressíveis que
+// ainda não os têm, mantendo somente as variantes menores que o original.
+func precompress(siteDir string, files []string) (int, error) {
+	existing := make(map[string]bool, len(files))
+	for _, rel := range files {
+		existing[rel] = true
+	}
+
+	created := 0
+	for _, rel := range files {
+		ext := path.Ext(rel)
+		if ext == ".br" || ext == ".gz" || !handler.IsCompressible(mime.TypeByExtension(ext)) {
+			continue
+		}
+		name := filepath.Join(siteDir, filepath.FromSlash(rel))
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return created, err
+		}
+		if len(data) < precompressMinSize {
+			continue
+		}
+		for _, variant := range []string{".br", ".gz"} {
+			if existing[rel+variant] {
+				continue
+			}
+			compressed, err := compress(data, variant)
+			if err != nil {
+				return created, err
+			}
+			if len(compressed) >= len(data) {
+				continue
+			}
+			if err := ioutil.WriteFile(name+variant, compressed, 0644); err != nil {
+				return created, err
+			}
+			created++
+		}
+	}
+	return created, nil
+}
+
+func compress(data []byte, variant string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if variant == ".br" {
+		w = brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	} else {
+		w, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// generate retorna o código do pack.GeneratedFile.
+func generate(patterns []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("// Code generated by go-pre-compile. DO NOT EDIT.\n\n")
+	b.WriteString("//go:build pack\n// +build pack\n\n")
+	b.WriteString("package pack\n\n")
+	b.WriteString("import \"embed\"\n\n")
+	for _, p := range patterns {
+		fmt.Fprintf(&b, "//go:embed %s\n", strconv.Quote(p))
+	}
+	b.WriteString("var files embed.FS\n\n")
+	fmt.Fprintf(&b, "//go:embed %s\n", strconv.Quote(pack.ConfigFile))
+	b.WriteString("var packConfig []byte\n\n")
+	b.WriteString("func init() {\n\tcontent = files\n\tconfig = packConfig\n}\n")
+	return b.Bytes()
+}
+
+// splitList separa uma flag com valores separados por virgula, ignorando itens vazios.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
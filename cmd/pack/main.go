@@ -0,0 +1,91 @@
+package main
+
+/*
+	pack [options] <dir> -o <bin>
+	ex: go run ./cmd/pack test/spa/dist --spa --precompress -o bin/site
+
+	Compila um executável com <dir> embutido: executa o go-pre-compile com as
+	demais opções, o 'go build -tags pack' e o go-post-compile, que remove a
+	cópia mesmo quando a compilação falha. Deve ser executado na raiz do módulo.
+*/
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+)
+
+func main() {
+	output, packDir, args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pack: %s\n", err)
+		fmt.Fprintln(os.Stderr, "Usage: pack [go-pre-compile options] <dir> -o <bin>")
+		os.Exit(2)
+	}
+
+	// Ctrl-C also reaches the go commands, the cleanup still has to run
+	signal.Ignore(os.Interrupt)
+
+	if err := run(output, packDir, args); err != nil {
+		fmt.Fprintf(os.Stderr, "pack: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs separa o -o e o --pack-dir, em qualquer posição, das opções e do
+// diretório repassados ao go-pre-compile.
+func parseArgs(args []string) (output string, packDir string, rest []string, err error) {
+	packDir = "pack"
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.TrimLeft(args[i], "-"), "", false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+		if !strings.HasPrefix(args[i], "-") || (name != "o" && name != "pack-dir") {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return "", "", nil, fmt.Errorf("flag needs an argument: %s", args[i])
+			}
+			i++
+			value = args[i]
+		}
+		if name == "o" {
+			output = value
+		} else {
+			packDir = value
+		}
+	}
+	if output == "" {
+		return "", "", nil, fmt.Errorf("missing -o <bin>")
+	}
+	return output, packDir, rest, nil
+}
+
+func run(output string, packDir string, args []string) (err error) {
+	defer func() {
+		if cerr := goCommand("run", "./cmd/go-post-compile", "--pack-dir", packDir); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	preCompile := append([]string{"run", "./cmd/go-pre-compile", "--pack-dir", packDir}, args...)
+	if err := goCommand(preCompile...); err != nil {
+		return err
+	}
+	return goCommand("build", "-tags", "pack", "-o", output, ".")
+}
+
+func goCommand(args ...string) error {
+	cmd := exec.Command("go", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go %s: %w", args[0], err)
+	}
+	return nil
+}
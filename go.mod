@@ -4,6 +4,13 @@ module github.com/guilhermerodrigues680/gouploadserver
 go 1.16
 
 require (
+	github.com/alecthomas/chroma v0.10.0
+	github.com/andybalholm/brotli v1.0.4
+	github.com/fsnotify/fsnotify v1.5.1
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/pkg/sftp v1.13.5
 	github.com/sirupsen/logrus v1.8.1
+	github.com/yuin/goldmark v1.4.13
+	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 )
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Políticas para links simbólicos.
+const (
+	// SymlinksFollow segue os links simbólicos, mesmo os que apontam para fora da raiz
+	SymlinksFollow = "follow"
+	// SymlinksFollowWithinRoot segue somente os links cujo destino está dentro da raiz
+	SymlinksFollowWithinRoot = "follow-within-root"
+	// SymlinksDeny recusa qualquer caminho que passe por um link simbólico
+	SymlinksDeny = "deny"
+)
+
+// Políticas para arquivos ocultos, os que começam com '.' (ex: '.git', '.env').
+const (
+	HiddenShow = "show"
+	// HiddenHide omite os arquivos ocultos das listagens e buscas, mas permite o acesso direto
+	HiddenHide = "hide"
+	// HiddenDeny omite os arquivos ocultos e recusa o acesso e o upload
+	HiddenDeny = "deny"
+)
+
+// accessPolicy decide quais caminhos do staticDirPath podem ser acessados,
+// listados e usados como destino de uploads.
+type accessPolicy struct {
+	symlinks string
+	hidden   string
+	// exclude são globs (ex: '.git/**', '*.key') tratados como inexistentes
+	exclude []*globPattern
+}
+
+func defaultAccessPolicy() accessPolicy {
+	return accessPolicy{symlinks: SymlinksFollow, hidden: HiddenShow}
+}
+
+// permissive indica que todos os caminhos são permitidos, assim as listagens
+// não precisam verificar cada entrada.
+func (p *accessPolicy) permissive() bool {
+	return p.symlinks == SymlinksFollow && p.hidden == HiddenShow && len(p.exclude) == 0
+}
+
+func (p *accessPolicy) excluded(rel string) bool {
+	for _, g := range p.exclude {
+		if g.Match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHiddenPath verifica se algum segmento do caminho relativo começa com '.'.
+func isHiddenPath(rel string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(name, ".") && name != "." && name != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// relToRoot retorna filePath relativo ao staticDirPath, separado por '/'.
+func (s *Server) relToRoot(filePath string) (string, error) {
+	rel, err := filepath.Rel(s.staticDirPath, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is outside the served directory", ErrAccessDenied, filePath)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// checkAccess verifica se filePath pode ser enviado ou receber uploads.
+// Caminhos que não existem são permitidos, o erro fica com quem tentar abri-los.
+func (s *Server) checkAccess(filePath string) error {
+	rel, err := s.relToRoot(filePath)
+	if err != nil {
+		return err
+	}
+	if s.access.excluded(rel) {
+		return fmt.Errorf("%w: %s is excluded", ErrAccessDenied, rel)
+	}
+	if s.access.hidden == HiddenDeny && isHiddenPath(rel) {
+		return fmt.Errorf("%w: %s is hidden", ErrAccessDenied, rel)
+	}
+
+	switch s.access.symlinks {
+	case SymlinksDeny:
+		// the root itself may be a symlink, only the components below it are checked
+		current := s.staticDirPath
+		for _, name := range strings.Split(rel, "/") {
+			if name == "." {
+				continue
+			}
+			current = filepath.Join(current, name)
+			fi, err := os.Lstat(current)
+			if err != nil {
+				return nil
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("%w: %s is a symlink", ErrAccessDenied, rel)
+			}
+		}
+	case SymlinksFollowWithinRoot:
+		if !s.withinRoot(filePath) {
+			return fmt.Errorf("%w: %s points outside the served directory", ErrAccessDenied, rel)
+		}
+	}
+	return nil
+}
+
+// withinRoot verifica se o destino real de filePath, depois de resolver os links
+// simbólicos, está dentro do staticDirPath.
+func (s *Server) withinRoot(filePath string) bool {
+	real, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		// missing paths are reported by the caller, dangling links are not followed
+		_, lerr := os.Lstat(filePath)
+		return os.IsNotExist(lerr)
+	}
+	root, err := filepath.EvalSymlinks(s.staticDirPath)
+	if err != nil {
+		return false
+	}
+	return real == root || strings.HasPrefix(real, root+string(filepath.Separator))
+}
+
+// listable verifica se a entrada name de dirPath aparece nas listagens e buscas.
+func (s *Server) listable(dirPath string, name string, mode os.FileMode) bool {
+	entryPath := filepath.Join(dirPath, name)
+	rel, err := s.relToRoot(entryPath)
+	if err != nil || s.access.excluded(rel) {
+		return false
+	}
+	if s.access.hidden != HiddenShow && isHiddenPath(rel) {
+		return false
+	}
+	if mode&os.ModeSymlink != 0 {
+		switch s.access.symlinks {
+		case SymlinksDeny:
+			return false
+		case SymlinksFollowWithinRoot:
+			return s.withinRoot(entryPath)
+		}
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newAccessTree cria 'root' com links para dentro e para fora da raiz e
+// arquivos ocultos, e 'outside' com o conteúdo que não pode vazar.
+func newAccessTree(t *testing.T) string {
+	base := writeTestFiles(t, map[string]string{
+		"outside/secret.txt":   "top secret content",
+		"root/public.txt":      "public",
+		"root/docs/guide.txt":  "guide",
+		"root/.env":            "TOKEN=1",
+		"root/.git/config":     "[core]",
+		"root/server.key":      "key",
+		"root/secret/file.txt": "secret",
+	})
+
+	links := map[string]string{
+		"root/escape.txt": path.Join(base, "outside/secret.txt"),
+		"root/escapedir":  path.Join(base, "outside"),
+		"root/relative":   "../outside",
+		"root/inside.txt": "public.txt",
+		"root/insidedir":  "docs",
+		"root/dangling":   path.Join(base, "missing"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, path.Join(base, name)); err != nil {
+			t.Skipf("symlinks are not supported: %s", err)
+		}
+	}
+
+	return base
+}
+
+func accessGet(s *Server, url string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	// set the raw path, NewRequest would clean the '..' segments
+	req.URL.Path = url
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAccessPolicySymlinks(t *testing.T) {
+	base := newAccessTree(t)
+	root := path.Join(base, "root")
+
+	tests := []struct {
+		url    string
+		follow int
+		within int
+		deny   int
+	}{
+		{"/public.txt", http.StatusOK, http.StatusOK, http.StatusOK},
+		{"/../outside/secret.txt", http.StatusNotFound, http.StatusNotFound, http.StatusNotFound},
+		{"/escape.txt", http.StatusOK, http.StatusNotFound, http.StatusNotFound},
+		{"/escapedir/secret.txt", http.StatusOK, http.StatusNotFound, http.StatusNotFound},
+		{"/relative/secret.txt", http.StatusOK, http.StatusNotFound, http.StatusNotFound},
+		{"/escapedir/", http.StatusOK, http.StatusNotFound, http.StatusNotFound},
+		{"/inside.txt", http.StatusOK, http.StatusOK, http.StatusNotFound},
+		{"/insidedir/guide.txt", http.StatusOK, http.StatusOK, http.StatusNotFound},
+		{"/dangling", http.StatusNotFound, http.StatusNotFound, http.StatusNotFound},
+	}
+
+	for _, policy := range []string{SymlinksFollow, SymlinksFollowWithinRoot, SymlinksDeny} {
+		s := NewServer(root, false, false, logrus.WithField("test", true), WithAccessPolicy(policy, HiddenShow, nil))
+		for _, tt := range tests {
+			want := map[string]int{SymlinksFollow: tt.follow, SymlinksFollowWithinRoot: tt.within, SymlinksDeny: tt.deny}[policy]
+			rr := accessGet(s, tt.url)
+			if rr.Code != want {
+				t.Fatalf("%s %s: handler returned wrong status code: got %v want %v", policy, tt.url, rr.Code, want)
+			}
+			if policy != SymlinksFollow && strings.Contains(rr.Body.String(), "top secret content") {
+				t.Fatalf("%s %s: leaked the file outside the root", policy, tt.url)
+			}
+		}
+
+		listing := accessGet(s, "/").Body.String()
+		for name, listed := range map[string]bool{
+			"public.txt": true,
+			"escape.txt": policy == SymlinksFollow,
+			"escapedir":  policy == SymlinksFollow,
+			"inside.txt": policy != SymlinksDeny,
+			"insidedir":  policy != SymlinksDeny,
+		} {
+			if got := strings.Contains(listing, `href="`+name); got != listed {
+				t.Fatalf("%s: listing contains %s: got %v want %v", policy, name, got, listed)
+			}
+		}
+	}
+}
+
+func TestAccessPolicyHiddenAndExclude(t *testing.T) {
+	base := newAccessTree(t)
+	root := path.Join(base, "root")
+
+	tests := []struct {
+		url  string
+		show int
+		hide int
+		deny int
+	}{
+		{"/public.txt", http.StatusOK, http.StatusOK, http.StatusOK},
+		{"/.env", http.StatusOK, http.StatusOK, http.StatusNotFound},
+		{"/.git/config", http.StatusNotFound, http.StatusNotFound, http.StatusNotFound},
+		{"/.git/", http.StatusNotFound, http.StatusNotFound, http.StatusNotFound},
+		{"/server.key", http.StatusNotFound, http.StatusNotFound, http.StatusNotFound},
+	}
+
+	for _, policy := range []string{HiddenShow, HiddenHide, HiddenDeny} {
+		s := NewServer(root, false, false, logrus.WithField("test", true),
+			WithAccessPolicy(SymlinksFollowWithinRoot, policy, []string{".git/**", "*.key"}))
+		for _, tt := range tests {
+			want := map[string]int{HiddenShow: tt.show, HiddenHide: tt.hide, HiddenDeny: tt.deny}[policy]
+			if rr := accessGet(s, tt.url); rr.Code != want {
+				t.Fatalf("%s %s: handler returned wrong status code: got %v want %v", policy, tt.url, rr.Code, want)
+			}
+		}
+
+		listing := accessGet(s, "/").Body.String()
+		if got := strings.Contains(listing, `href=".env`); got != (policy == HiddenShow) {
+			t.Fatalf("%s: listing contains .env: got %v", policy, got)
+		}
+		for _, name := range []string{".git/", "server.key"} {
+			if strings.Contains(listing, `href="`+name) {
+				t.Fatalf("%s: listing contains the excluded %s", policy, name)
+			}
+		}
+	}
+}
+
+func TestAccessPolicyExcludeDirectories(t *testing.T) {
+	base := newAccessTree(t)
+
+	// patterns without '/' also exclude everything inside the matching directories
+	s := NewServer(path.Join(base, "root"), false, false, logrus.WithField("test", true),
+		WithAccessPolicy(SymlinksFollowWithinRoot, HiddenShow, []string{".git", "secret"}))
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"/.git/config", http.StatusNotFound},
+		{"/.git/", http.StatusNotFound},
+		{"/secret/file.txt", http.StatusNotFound},
+		{"/secret/", http.StatusNotFound},
+		{"/docs/guide.txt", http.StatusOK},
+		{"/.env", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rr := accessGet(s, tt.url); rr.Code != tt.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.url, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestAccessPolicyUpload(t *testing.T) {
+	base := newAccessTree(t)
+	root := path.Join(base, "root")
+	s := NewServer(root, true, false, logrus.WithField("test", true),
+		WithAccessPolicy(SymlinksFollowWithinRoot, HiddenDeny, []string{"*.key"}))
+
+	upload := func(dir string, fname string) int {
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		fw, err := w.CreateFormFile("file", fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte("uploaded"))
+		w.Close()
+
+		req, err := http.NewRequest(http.MethodPost, "/", &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.URL.Path = dir
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	tests := []struct {
+		dir    string
+		fname  string
+		status int
+	}{
+		{"/docs/", "new.txt", http.StatusOK},
+		{"/insidedir/", "new2.txt", http.StatusOK},
+		{"/escapedir/", "new.txt", http.StatusNotFound},
+		{"/relative/", "new.txt", http.StatusNotFound},
+		{"/../", "confined.txt", http.StatusOK},
+		{"/", ".env", http.StatusForbidden},
+		{"/.git/", "new.txt", http.StatusNotFound},
+		{"/", "new.key", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if status := upload(tt.dir, tt.fname); status != tt.status {
+			t.Fatalf("%s%s: upload returned wrong status code: got %v want %v", tt.dir, tt.fname, status, tt.status)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(path.Join(base, "outside"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("upload escaped the root: %d files in outside", len(entries))
+	}
+	if _, err := os.Stat(path.Join(root, "confined.txt")); err != nil {
+		t.Fatalf("upload to '/../' was not confined to the root: %s", err)
+	}
+}
+
+func TestAccessPolicySearch(t *testing.T) {
+	base := newAccessTree(t)
+	s := NewServer(path.Join(base, "root"), false, false, logrus.WithField("test", true),
+		WithSearch(true, false),
+		WithAccessPolicy(SymlinksFollowWithinRoot, HiddenHide, []string{"*.key"}))
+
+	for _, query := range []string{"?q=*", "?q=*&content=secret"} {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, searchPath+query+"&depth=5", nil)
+		s.ServeHTTP(rr, req)
+
+		body := rr.Body.String()
+		for _, name := range []string{"escape.txt", ".env", ".git", "server.key", "top secret"} {
+			if strings.Contains(body, `"`+name) {
+				t.Fatalf("%s: search results contain %s: %s", query, name, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	aclAllow = "allow"
+	aclDeny  = "deny"
+
+	// aclReloadInterval é o intervalo mínimo entre as verificações do arquivo de regras
+	aclReloadInterval = time.Second
+)
+
+// aclMethodGroups são os grupos de métodos aceitos nas regras.
+var aclMethodGroups = map[string][]string{
+	"read":  {http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND"},
+	"write": {http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, "MKCOL", "MOVE", "COPY"},
+}
+
+// aclRule é uma linha 'allow|deny cidrs [methods] [path]' de uma lista de acesso.
+type aclRule struct {
+	line  string
+	allow bool
+	// nets, methods e path vazios casam com qualquer valor
+	nets    ipNets
+	methods map[string]bool
+	path    *globPattern
+}
+
+// parseACLRule lê uma regra como 'allow 10.0.0.0/8,192.168.0.0/16 write /uploads/**'.
+// As redes aceitam 'all', os métodos aceitam '*', 'read', 'write' e nomes
+// separados por vírgula, e o caminho é um glob no estilo do '--exclude'.
+func parseACLRule(line string) (aclRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 4 {
+		return aclRule{}, fmt.Errorf("%w: %q, expected 'allow|deny cidrs [methods] [path]'", ErrAccessList, line)
+	}
+
+	rule := aclRule{line: strings.Join(fields, " ")}
+	switch strings.ToLower(fields[0]) {
+	case aclAllow:
+		rule.allow = true
+	case aclDeny:
+	default:
+		return aclRule{}, fmt.Errorf("%w: %q, the action must be allow or deny", ErrAccessList, line)
+	}
+
+	if fields[1] != "all" && fields[1] != "*" {
+		for _, value := range strings.Split(fields[1], ",") {
+			ipnet, err := parseIPNet(value)
+			if err != nil {
+				return aclRule{}, fmt.Errorf("%w: %q: %s", ErrAccessList, line, err)
+			}
+			rule.nets = append(rule.nets, ipnet)
+		}
+	}
+
+	if len(fields) > 2 && fields[2] != "*" {
+		rule.methods = make(map[string]bool)
+		for _, method := range strings.Split(fields[2], ",") {
+			if group, ok := aclMethodGroups[strings.ToLower(method)]; ok {
+				for _, m := range group {
+					rule.methods[m] = true
+				}
+			} else if method != "" {
+				rule.methods[strings.ToUpper(method)] = true
+			}
+		}
+	}
+
+	if len(fields) > 3 && fields[3] != "*" {
+		g, err := compileGlob(fields[3])
+		if err != nil {
+			return aclRule{}, fmt.Errorf("%w: %q: %s", ErrAccessList, line, err)
+		}
+		rule.path = g
+	}
+	return rule, nil
+}
+
+func (rule *aclRule) matches(ip net.IP, method string, urlPath string) bool {
+	if rule.nets != nil && (ip == nil || !rule.nets.contains(ip)) {
+		return false
+	}
+	if rule.methods != nil && !rule.methods[method] {
+		return false
+	}
+	return rule.path == nil || rule.path.Match(urlPath)
+}
+
+// parseACLFile lê um arquivo de regras, uma por linha, ignorando linhas vazias
+// e comentários com '#'. Uma linha inválida invalida o arquivo inteiro.
+func parseACLFile(f io.Reader, name string) ([]aclRule, error) {
+	var rules []aclRule
+	sc := bufio.NewScanner(f)
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseACLRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, lineNum, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, sc.Err()
+}
+
+// accessList decide pelo IP do cliente, método e caminho se uma requisição é
+// aceita. As regras de rules e depois as do arquivo são avaliadas em ordem, a
+// primeira que casa decide, e sem nenhuma a requisição é aceita. O arquivo é
+// relido quando muda, e um arquivo inválido mantém as regras anteriores. Sem
+// nenhuma leitura válida do arquivo todas as requisições são recusadas.
+type accessList struct {
+	rules  []aclRule
+	file   string
+	logger *logrus.Entry
+
+	mu        sync.RWMutex
+	fileRules []aclRule
+	loaded    bool
+	modTime   time.Time
+	size      int64
+	checked   time.Time
+}
+
+func newAccessList(rules []aclRule, file string, logger *logrus.Entry) *accessList {
+	a := &accessList{rules: rules, file: file, logger: logger}
+	if file != "" {
+		a.reload(time.Now())
+	}
+	return a
+}
+
+// reload relê o arquivo quando a data de modificação ou o tamanho mudaram.
+func (a *accessList) reload(now time.Time) {
+	a.mu.RLock()
+	recent := now.Sub(a.checked) < aclReloadInterval
+	a.mu.RUnlock()
+	if a.file == "" || recent {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.checked) < aclReloadInterval {
+		return
+	}
+	a.checked = now
+
+	fi, err := os.Stat(a.file)
+	if err != nil {
+		if a.loaded {
+			a.logger.Warnf("Access list %s: %s, keeping the previous rules", a.file, err)
+			a.modTime, a.size = time.Time{}, 0
+		} else {
+			a.logger.Errorf("Access list %s: %s, denying all requests", a.file, err)
+		}
+		return
+	}
+	if fi.ModTime().Equal(a.modTime) && fi.Size() == a.size {
+		return
+	}
+	a.modTime, a.size = fi.ModTime(), fi.Size()
+
+	f, err := os.Open(a.file)
+	if err != nil {
+		a.logger.Errorf("Access list %s: %s", a.file, err)
+		return
+	}
+	defer f.Close()
+	rules, err := parseACLFile(f, a.file)
+	if err != nil {
+		if a.loaded {
+			a.logger.Errorf("%s, keeping the previous rules", err)
+		} else {
+			a.logger.Errorf("%s, denying all requests", err)
+		}
+		return
+	}
+	a.fileRules, a.loaded = rules, true
+	a.logger.Infof("Loaded %d rules from %s", len(rules), a.file)
+}
+
+// check retorna se a requisição é aceita e a regra que decidiu, vazia quando
+// nenhuma regra casou.
+func (a *accessList) check(ip string, method string, urlPath string) (string, bool) {
+	a.reload(time.Now())
+	parsed := net.ParseIP(ip)
+	for i := range a.rules {
+		if a.rules[i].matches(parsed, method, urlPath) {
+			return a.rules[i].line, a.rules[i].allow
+		}
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.file != "" && !a.loaded {
+		return "invalid " + a.file, false
+	}
+	for i := range a.fileRules {
+		if a.fileRules[i].matches(parsed, method, urlPath) {
+			return a.fileRules[i].line, a.fileRules[i].allow
+		}
+	}
+	return "", true
+}
+
+// withAccessList responde 403 às requisições recusadas pela lista de acesso.
+// Os assets dos templates são sempre servidos, para a página de erro.
+func (s *Server) withAccessList(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, assetsPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		rule, ok := s.accessList.check(ip, r.Method, r.URL.Path)
+		if !ok {
+			s.logger.Warnf("Access list: %s %s from %s denied by %q", r.Method, r.URL.Path, ip, rule)
+			s.sendForbidden(w, r, ip)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
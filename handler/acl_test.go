@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseACLRule(t *testing.T) {
+	for _, line := range []string{"permit all", "allow", "allow 10.0.0.0/33", "deny all * /a /b", "allow 10.0.0.1,bad"} {
+		if _, err := parseACLRule(line); !errors.Is(err, ErrAccessList) {
+			t.Errorf("parseACLRule(%q) returned %v", line, err)
+		}
+	}
+
+	rule, err := parseACLRule("allow 10.0.0.0/8,192.0.2.7 read,delete /docs/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip     string
+		method string
+		path   string
+		want   bool
+	}{
+		{"10.1.2.3", http.MethodGet, "/docs/report.pdf", true},
+		{"192.0.2.7", http.MethodDelete, "/docs/", true},
+		{"192.0.2.8", http.MethodGet, "/docs/report.pdf", false},
+		{"10.1.2.3", http.MethodPost, "/docs/report.pdf", false},
+		{"10.1.2.3", http.MethodGet, "/other/report.pdf", false},
+		{"", http.MethodGet, "/docs/report.pdf", false},
+	}
+	for _, test := range tests {
+		if got := rule.matches(parseHopIP(test.ip), test.method, test.path); got != test.want {
+			t.Errorf("matches(%s %s %s) = %v", test.ip, test.method, test.path, got)
+		}
+	}
+}
+
+func TestAccessList(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	aclFile := filepath.Join(t.TempDir(), "acl.txt")
+	writeACL := func(content string, mtime time.Time) {
+		if err := ioutil.WriteFile(aclFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(aclFile, mtime, mtime)
+	}
+	writeACL("# uploads only from the internal network\nallow 10.0.0.0/8 write\ndeny all write\n", time.Now().Add(-time.Hour))
+
+	s := NewServer(dir, true, false, logrus.WithField("test", true),
+		WithTrustedProxies([]string{"192.0.2.1"}),
+		WithAccessList([]string{"deny 198.51.100.0/24 * /file.txt"}, aclFile),
+	)
+	defer s.Close()
+
+	send := func(method, target, ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(""))
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("X-Forwarded-For", ip)
+		r.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := send(http.MethodGet, "/file.txt", "203.0.113.1"); w.Code != http.StatusOK {
+		t.Fatalf("download from anywhere returned %d", w.Code)
+	}
+	w := send(http.MethodPost, "/", "203.0.113.1")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "203.0.113.1") || !strings.Contains(w.Body.String(), "POST") {
+		t.Fatalf("external upload returned %d %s", w.Code, w.Body.String())
+	}
+	if w := send(http.MethodPost, "/", "10.1.2.3"); w.Code == http.StatusForbidden {
+		t.Fatal("internal upload was denied")
+	}
+	// the inline rules are checked first
+	if w := send(http.MethodGet, "/file.txt", "198.51.100.9"); w.Code != http.StatusForbidden {
+		t.Fatalf("inline deny returned %d", w.Code)
+	}
+	if w := send(http.MethodGet, assetsPath+"style.css", "198.51.100.9"); w.Code != http.StatusOK {
+		t.Fatalf("assets of the forbidden page returned %d", w.Code)
+	}
+
+	// the changed file is reloaded, an invalid one keeps the previous rules
+	writeACL("deny all\n", time.Now().Add(-time.Minute))
+	s.accessList.checked = time.Time{}
+	if w := send(http.MethodGet, "/file.txt", "203.0.113.1"); w.Code != http.StatusForbidden {
+		t.Fatalf("the reloaded rules returned %d", w.Code)
+	}
+	writeACL("allow everyone\n", time.Now())
+	s.accessList.checked = time.Time{}
+	if w := send(http.MethodGet, "/file.txt", "203.0.113.1"); w.Code != http.StatusForbidden {
+		t.Fatalf("an invalid file replaced the rules, returned %d", w.Code)
+	}
+
+	// a file that never loaded denies everything
+	missing := NewServer(dir, true, false, logrus.WithField("test", true), WithAccessList(nil, filepath.Join(dir, "missing.txt")))
+	defer missing.Close()
+	w = httptest.NewRecorder()
+	missing.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	if w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "<html") {
+		t.Fatalf("missing file returned %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const basicAuthRealm = "gouploadserver"
+
+type userContextKey struct{}
+
+// basicAuth protege o Server com HTTP Basic Authentication.
+type basicAuth struct {
+	// users mapeia o usuário para o sha256 da senha, assim a comparação tem
+	// tempo constante independente do tamanho da senha informada
+	users map[string][sha256.Size]byte
+}
+
+// parseCredential lê uma credencial no formato 'user:password'.
+func parseCredential(credential string) (string, string, error) {
+	i := strings.Index(credential, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("%w: expected 'user:password'", ErrAuthCredential)
+	}
+	return credential[:i], credential[i+1:], nil
+}
+
+func (a *basicAuth) add(user string, password string) {
+	if a.users == nil {
+		a.users = make(map[string][sha256.Size]byte)
+	}
+	a.users[user] = sha256.Sum256([]byte(password))
+}
+
+// authenticate retorna o usuário autenticado pelo header Authorization.
+func (a *basicAuth) authenticate(r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok || !a.check(user, password) {
+		return "", false
+	}
+	return user, true
+}
+
+// check verifica a senha do usuário, também usada pelo login do SFTP.
+func (a *basicAuth) check(user string, password string) bool {
+	expected, found := a.users[user]
+	sum := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(sum[:], expected[:]) == 1 && found
+}
+
+// withAuth exige as credenciais antes de next. O usuário autenticado fica
+// disponível nos templates como '.User'.
+func (s *Server) withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := s.auth.authenticate(r)
+		if s.rateLimit != nil {
+			// requests without credentials are the browser asking for them
+			if _, _, sent := r.BasicAuth(); sent && !ok {
+				s.rateLimit.authFailed(clientIP(r), time.Now())
+			} else if ok {
+				s.rateLimit.authSucceeded(clientIP(r))
+			}
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+basicAuthRealm+`", charset="UTF-8"`)
+			s.sendError(w, r, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	})
+}
+
+// requestUser retorna o usuário autenticado na requisição, ou "" sem autenticação.
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userContextKey{}).(string)
+	return user
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	cacheControlImmutable = "public, max-age=31536000, immutable"
+	cacheControlNoCache   = "no-cache"
+)
+
+// contentHashRegexp encontra hashes de conteúdo no nome dos arquivos gerados por
+// bundlers, como 'chunk-vendors.5f4616df.js' (webpack) ou 'index-BxY3z_9a.js' (vite).
+var contentHashRegexp = regexp.MustCompile(`[.-]([0-9a-fA-F]{8,64}|[A-Za-z0-9_]{8})\.[A-Za-z0-9]+(\.map)?$`)
+
+// versionedWordRegexp casa com palavras seguidas de um número, como 'Version2'.
+var versionedWordRegexp = regexp.MustCompile(`^[A-Z]?[a-z]+[0-9_]+$`)
+
+// cacheRule associa um padrão (glob ou regex) a um valor de Cache-Control.
+type cacheRule struct {
+	glob  *globPattern
+	re    *regexp.Regexp
+	value string
+}
+
+func (c *cacheRule) match(urlPath string) bool {
+	if c.re != nil {
+		return c.re.MatchString(urlPath)
+	}
+	return c.glob.Match(urlPath)
+}
+
+// cachePolicy decide o header Cache-Control de cada arquivo. As regras
+// configuradas são avaliadas em ordem e a primeira que casar vence; sem regras,
+// arquivos com hash no nome são imutáveis e HTML nunca é cacheado sem revalidação.
+type cachePolicy struct {
+	enabled bool
+	rules   []cacheRule
+}
+
+// parseCacheRule interpreta 'pattern=value'. Padrões iniciados por '~' são
+// expressões regulares aplicadas ao caminho da URL, os demais são globs.
+func parseCacheRule(rule string) (cacheRule, error) {
+	kv := strings.SplitN(rule, "=", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return cacheRule{}, fmt.Errorf("%w: expected 'pattern=value', got %q", ErrCacheRule, rule)
+	}
+
+	pattern, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	if strings.HasPrefix(pattern, "~") {
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return cacheRule{}, fmt.Errorf("%w: %s", ErrCacheRule, err)
+		}
+		return cacheRule{re: re, value: value}, nil
+	}
+
+	g, err := compileGlob(pattern)
+	if err != nil {
+		return cacheRule{}, fmt.Errorf("%w: %s", ErrCacheRule, err)
+	}
+	return cacheRule{glob: g, value: value}, nil
+}
+
+func hasContentHash(name string) bool {
+	m := contentHashRegexp.FindStringSubmatch(path.Base(name))
+	if m == nil {
+		return false
+	}
+
+	// hashes mix letters and digits, which rules out words like 'deadbeef' or
+	// 'vendors' and dates or timestamps like '20211231'
+	hash := m[1]
+	if !strings.ContainsAny(hash, "0123456789") || strings.Trim(hash, "0123456789") == "" {
+		return false
+	}
+	if strings.Trim(hash, "0123456789abcdefABCDEF") == "" {
+		return true
+	}
+	// base64url hashes mix lower and upper case letters, unlike a word
+	// followed by a number like 'Version2'
+	return strings.ToLower(hash) != hash && strings.ToUpper(hash) != hash && !versionedWordRegexp.MatchString(hash)
+}
+
+// value retorna o Cache-Control para o arquivo ou "" para não enviar o header.
+func (p *cachePolicy) value(urlPath string, ctype string) string {
+	if !p.enabled {
+		return ""
+	}
+
+	for i := range p.rules {
+		if p.rules[i].match(urlPath) {
+			return p.rules[i].value
+		}
+	}
+
+	if isHTMLContentType(ctype) {
+		return cacheControlNoCache
+	}
+	if hasContentHash(urlPath) {
+		return cacheControlImmutable
+	}
+	return ""
+}
+
+// setCacheControl aplica a política ao arquivo, sem sobrescrever um Cache-Control
+// já definido (ex: pelo arquivo '_headers').
+func (s *Server) setCacheControl(w http.ResponseWriter, filePath string, ctype string) {
+	if w.Header().Get("Cache-Control") != "" {
+		return
+	}
+
+	urlPath := "/"
+	if rel, err := filepath.Rel(s.staticDirPath, filePath); err == nil {
+		urlPath = path.Join("/", filepath.ToSlash(rel))
+	}
+
+	if v := s.cachePolicy.value(urlPath, ctype); v != "" {
+		w.Header().Set("Cache-Control", v)
+	}
+}
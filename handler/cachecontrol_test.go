@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHasContentHash(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"chunk-vendors.5f4616df.js", true},
+		{"NotFound.d6242b7f.js.map", true},
+		{"logo.82b9c7a5.png", true},
+		{"index-BxY3z_9a.js", true},
+		{"chunk-vendors.js", false},
+		{"index.html", false},
+		{"my-component.deadbeef.js", false},
+		{"handbook.abcdefgh.pdf", false},
+		{"report-20211231.pdf", false},
+		{"app-Version2.js", false},
+		{"foto-1234567890.jpg", false},
+	}
+
+	for _, tt := range tests {
+		if got := hasContentHash(tt.name); got != tt.want {
+			t.Fatalf("hasContentHash(%q) = %v want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	s := NewServer("../test/spa/dist/", false, true, logrus.WithField("test", true),
+		WithCachePolicy(true, []string{"*.ico=public, max-age=86400", "~^/css/=public, max-age=60", "invalid"}))
+
+	tests := []struct {
+		url          string
+		cacheControl string
+	}{
+		{"/js/chunk-vendors.5f4616df.js", cacheControlImmutable},
+		{"/index.html", cacheControlNoCache},
+		{"/random-url-12345", cacheControlNoCache},
+		{"/favicon.ico", "public, max-age=86400"},
+		{"/css/app.5154eb61.css", "public, max-age=60"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+
+		if cacheControl := rr.Header().Get("Cache-Control"); cacheControl != tt.cacheControl {
+			t.Fatalf("%s: handler returned wrong header Cache-Control: got %v want %v", tt.url, cacheControl, tt.cacheControl)
+		}
+	}
+}
+
+func TestCachePolicyDirList(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/handler/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	s := NewServer("../", false, false, logrus.WithField("test", true), WithCachePolicy(true, nil))
+
+	s.ServeHTTP(rr, req)
+	if cacheControl := rr.Header().Get("Cache-Control"); cacheControl != cacheControlNoCache {
+		t.Fatalf("handler returned wrong header Cache-Control: got %v want %v", cacheControl, cacheControlNoCache)
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// checksumAlgorithms são os algoritmos aceitos em '?checksum='.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"md5":    md5.New,
+}
+
+func wantsChecksum(r *http.Request) bool {
+	_, ok := r.URL.Query()["checksum"]
+	return ok
+}
+
+// sendChecksumToClient envia em texto o hash hexadecimal do arquivo
+// ('?checksum=sha256' ou '?checksum=md5'), permitindo comparar arquivos sem
+// baixá-los (ex: 'gouploadserver sync --checksum').
+func (s *Server) sendChecksumToClient(w http.ResponseWriter, r *http.Request, filepath string) error {
+	algorithm := r.URL.Query().Get("checksum")
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("%w: unsupported algorithm %q, use sha256 or md5", ErrChecksum, algorithm)
+	}
+
+	f, err := s.open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, err = io.WriteString(w, hex.EncodeToString(h.Sum(nil)))
+	return err
+}
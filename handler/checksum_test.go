@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	s, _ := newResumableTestServer(t)
+
+	rr := serveTest(s, http.MethodGet, "/docs/guide.md?checksum=sha256", "", nil)
+	if want := "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"; rr.Code != http.StatusOK || rr.Body.String() != want {
+		t.Fatalf("sha256 returned %d %q, want %q", rr.Code, rr.Body, want)
+	}
+	if rr := serveTest(s, http.MethodGet, "/docs/guide.md?checksum=md5", "", nil); rr.Body.String() != "781e5e245d69b566979b86e28d23f2c7" {
+		t.Fatalf("md5 returned %q", rr.Body)
+	}
+	if rr := serveTest(s, http.MethodGet, "/docs/guide.md?checksum=crc32", "", nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("an unsupported algorithm returned %d", rr.Code)
+	}
+}
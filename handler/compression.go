@@ -0,0 +1,319 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// supportedEncodings em ordem de preferência quando o cliente aceita ambos com o mesmo peso.
+var supportedEncodings = []string{encodingBrotli, encodingGzip}
+
+// precompressedExt mapeia o Content-Encoding para a extensão do arquivo irmão
+// pré-comprimido (ex: 'app.js.br' e 'app.js.gz').
+var precompressedExt = map[string]string{
+	encodingBrotli: ".br",
+	encodingGzip:   ".gz",
+}
+
+// compression configura o envio de conteúdo comprimido.
+type compression struct {
+	// precompressed serve o arquivo irmão '.br' ou '.gz' quando existir
+	precompressed bool
+	// dynamic comprime em streaming respostas com MIME types compressíveis
+	dynamic bool
+	// minSize é o tamanho mínimo, em bytes, para a compressão dinâmica
+	minSize int64
+	cache   *compressedCache
+}
+
+func (c *compression) enabled() bool {
+	return c.precompressed || c.dynamic
+}
+
+// negotiateEncoding escolhe, entre as codificações disponíveis, a de maior peso
+// (q-value) no header Accept-Encoding. Retorna "" para enviar sem compressão.
+func negotiateEncoding(acceptEncoding string, available []string) string {
+	if acceptEncoding == "" || len(available) == 0 {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range available {
+		q, ok := weights[enc]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// IsCompressible indica se vale a pena comprimir o MIME type. Imagens, vídeos e
+// arquivos já comprimidos (zip, woff2, ...) não ficam menores.
+func IsCompressible(ctype string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(ctype, ";", 2)[0]))
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/x-javascript", "application/json",
+		"application/manifest+json", "application/ld+json", "application/xml",
+		"application/xhtml+xml", "application/rss+xml", "application/atom+xml",
+		"application/wasm", "application/x-font-ttf", "font/ttf", "font/otf",
+		"image/svg+xml", "image/x-icon", "image/vnd.microsoft.icon":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+func newCompressWriter(w io.Writer, encoding string) io.WriteCloser {
+	if encoding == encodingBrotli {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	}
+	gz, _ := gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	return gz
+}
+
+// findPrecompressed procura os arquivos irmãos pré-comprimidos de filepath.
+// Os irmãos mais antigos que o arquivo estão desatualizados e são ignorados.
+func (s *Server) findPrecompressed(filepath string, fileinfo os.FileInfo) map[string]os.FileInfo {
+	found := make(map[string]os.FileInfo)
+	for enc, ext := range precompressedExt {
+		fi, err := s.stat(filepath + ext)
+		if err == nil && fi.Mode().IsRegular() && !fi.ModTime().Before(fileinfo.ModTime()) {
+			found[enc] = fi
+		}
+	}
+	return found
+}
+
+// sendPrecompressedFile envia o arquivo irmão '.br'/'.gz' quando o cliente aceita
+// a codificação. Retorna false se nenhum arquivo pôde ser usado.
+func (s *Server) sendPrecompressedFile(w http.ResponseWriter, r *http.Request, filepath string, fileinfo os.FileInfo, ctype string, buf []byte) (bool, error) {
+	found := s.findPrecompressed(filepath, fileinfo)
+	if len(found) == 0 {
+		return false, nil
+	}
+
+	var available []string
+	for _, enc := range supportedEncodings {
+		if _, ok := found[enc]; ok {
+			available = append(available, enc)
+		}
+	}
+
+	enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), available)
+	if enc == "" {
+		return false, nil
+	}
+
+	f, err := s.open(filepath + precompressedExt[enc])
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Encoding", enc)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Last-Modified", fileinfo.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.FormatInt(found[enc].Size(), 10))
+	w.WriteHeader(http.StatusOK)
+
+	return true, readFileAndWriteToW(w, s.throttleDownload(r, f), buf)
+}
+
+// sendCompressedFile comprime o arquivo em streaming para o cliente. A saída
+// comprimida também é guardada no cache, indexada pelo mtime e tamanho do arquivo,
+// para que as próximas requisições não precisem comprimir novamente.
+func (s *Server) sendCompressedFile(w http.ResponseWriter, r *http.Request, filepath string, f io.Reader, fileinfo os.FileInfo, ctype string, enc string, buf []byte) error {
+	key := compressedCacheKey{filepath, fileinfo.ModTime(), fileinfo.Size(), enc}
+	if data, ok := s.compression.cache.get(key); ok {
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Encoding", enc)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		return readFileAndWriteToW(w, s.throttleDownload(r, bytes.NewReader(data)), buf)
+	}
+
+	// the compressed size is unknown, so the response is sent chunked
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Encoding", enc)
+	w.WriteHeader(http.StatusOK)
+
+	var out io.Writer = w
+	var cached *limitedBuffer
+	if s.compression.cache.accepts(fileinfo.Size()) {
+		cached = &limitedBuffer{limit: s.compression.cache.maxEntryBytes()}
+		out = io.MultiWriter(w, cached)
+	}
+
+	// the limit is applied to the uncompressed bytes, which can only be slower
+	cw := newCompressWriter(out, enc)
+	if err := readFileAndWriteToW(cw, s.throttleDownload(r, f), buf); err != nil {
+		cw.Close()
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+
+	if cached != nil && !cached.overflow {
+		s.compression.cache.add(key, cached.Bytes())
+	}
+	return nil
+}
+
+// writeBody envia um conteúdo gerado em memória (ex: listagem de diretórios ou
+// HTML reescrito), comprimindo quando permitido.
+func (s *Server) writeBody(w http.ResponseWriter, r *http.Request, status int, ctype string, body []byte) error {
+	w.Header().Set("Content-Type", ctype)
+
+	if s.compression.dynamic && IsCompressible(ctype) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := ""
+		if int64(len(body)) >= s.compression.minSize {
+			enc = negotiateEncoding(r.Header.Get("Accept-Encoding"), supportedEncodings)
+		}
+		if enc != "" {
+			var compressed bytes.Buffer
+			cw := newCompressWriter(&compressed, enc)
+			if _, err := cw.Write(body); err != nil {
+				return err
+			}
+			if err := cw.Close(); err != nil {
+				return err
+			}
+			body = compressed.Bytes()
+			w.Header().Set("Content-Encoding", enc)
+		}
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
+// limitedBuffer acumula até limit bytes e descarta o restante.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.overflow || int64(b.Len()+len(p)) > b.limit {
+		b.overflow = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+type compressedCacheKey struct {
+	path     string
+	modTime  time.Time
+	size     int64
+	encoding string
+}
+
+type compressedCacheEntry struct {
+	key  compressedCacheKey
+	data []byte
+}
+
+// compressedCache é um cache LRU em memória das saídas comprimidas, limitado
+// pelo total de bytes armazenados.
+type compressedCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	curBytes int64
+	ll       *list.List
+	items    map[compressedCacheKey]*list.Element
+}
+
+func newCompressedCache(maxBytes int64) *compressedCache {
+	return &compressedCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[compressedCacheKey]*list.Element),
+	}
+}
+
+// maxEntryBytes evita que um único arquivo ocupe o cache inteiro.
+func (c *compressedCache) maxEntryBytes() int64 {
+	return c.maxBytes / 4
+}
+
+func (c *compressedCache) accepts(size int64) bool {
+	return c != nil && c.maxBytes > 0 && size <= c.maxEntryBytes()
+}
+
+func (c *compressedCache) get(key compressedCacheKey) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*compressedCacheEntry).data, true
+}
+
+func (c *compressedCache) add(key compressedCacheKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.items[key]; ok {
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&compressedCacheEntry{key, data})
+	c.curBytes += int64(len(data))
+
+	for c.curBytes > c.maxBytes {
+		oldest := c.ll.Back()
+		entry := oldest.Value.(*compressedCacheEntry)
+		c.ll.Remove(oldest)
+		delete(c.items, entry.key)
+		c.curBytes -= int64(len(entry.data))
+	}
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/sirupsen/logrus"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		available      []string
+		want           string
+	}{
+		{"", supportedEncodings, ""},
+		{"gzip, deflate, br", supportedEncodings, encodingBrotli},
+		{"gzip", supportedEncodings, encodingGzip},
+		{"br;q=0.5, gzip", supportedEncodings, encodingGzip},
+		{"br;q=0, gzip;q=0", supportedEncodings, ""},
+		{"*", supportedEncodings, encodingBrotli},
+		{"br", []string{encodingGzip}, ""},
+		{"identity", supportedEncodings, ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding, tt.available); got != tt.want {
+			t.Fatalf("negotiateEncoding(%q, %v) = %q want %q", tt.acceptEncoding, tt.available, got, tt.want)
+		}
+	}
+}
+
+func newCompressionTestDir(t *testing.T) string {
+	js := strings.Repeat("console.log('gouploadserver');\n", 200)
+	dir := writeTestFiles(t, map[string]string{
+		"app.js":    js,
+		"app.js.gz": "precompressed-gzip",
+		"main.js":   js,
+	})
+	// the files are written in any order, the sibling must not be older
+	modified := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path.Join(dir, "app.js"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestPrecompressedFile(t *testing.T) {
+	dir := newCompressionTestDir(t)
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithCompression(true, false, 0, 0))
+
+	req, err := http.NewRequest(http.MethodGet, "/app.js", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip, br")
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	if encoding := rr.Header().Get("Content-Encoding"); encoding != encodingGzip {
+		t.Fatalf("handler returned wrong header Content-Encoding: got %v want %v", encoding, encodingGzip)
+	}
+	if vary := rr.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Fatalf("handler returned wrong header Vary: got %v want %v", vary, "Accept-Encoding")
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/javascript") &&
+		!strings.HasPrefix(rr.Header().Get("Content-Type"), "text/javascript") {
+		t.Fatalf("handler returned wrong header Content-Type: got %v", rr.Header().Get("Content-Type"))
+	}
+	if body := rr.Body.String(); body != "precompressed-gzip" {
+		t.Fatalf("handler returned wrong body: got %v want %v", body, "precompressed-gzip")
+	}
+
+	// clients without gzip support receive the original file
+	req.Header.Set("Accept-Encoding", "br")
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if encoding := rr.Header().Get("Content-Encoding"); encoding != "" {
+		t.Fatalf("handler returned wrong header Content-Encoding: got %v want none", encoding)
+	}
+}
+
+func TestPrecompressedFileFallback(t *testing.T) {
+	dir := newCompressionTestDir(t)
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithCompression(true, true, 0, 0))
+
+	req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if rr.Body.String() != "precompressed-gzip" || rr.Header().Get("Accept-Ranges") != "bytes" || rr.Header().Get("Last-Modified") == "" {
+		t.Fatalf("precompressed response %q with headers %v", rr.Body.String(), rr.Header())
+	}
+
+	// ranges are served from the original file, never compressed
+	req.Header.Set("Range", "bytes=0-6")
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPartialContent || rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != "console" {
+		t.Fatalf("range response %d %q encoded as %q", rr.Code, rr.Body.String(), rr.Header().Get("Content-Encoding"))
+	}
+
+	// a sibling older than the source is stale
+	stale := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path.Join(dir, "app.js.gz"), stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Del("Range")
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if rr.Body.String() == "precompressed-gzip" {
+		t.Fatal("a stale precompressed file must not be served")
+	}
+	if rr.Header().Get("Content-Encoding") != encodingGzip {
+		t.Fatalf("the stale sibling must fall back to the dynamic compression, got %q", rr.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestDynamicCompression(t *testing.T) {
+	dir := newCompressionTestDir(t)
+
+	original, err := ioutil.ReadFile(path.Join(dir, "main.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithCompression(false, true, 1024, 1024*1024))
+
+	for i, enc := range []string{encodingBrotli, encodingBrotli, encodingGzip} {
+		req, err := http.NewRequest(http.MethodGet, "/main.js", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", enc)
+
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+
+		if encoding := rr.Header().Get("Content-Encoding"); encoding != enc {
+			t.Fatalf("handler returned wrong header Content-Encoding: got %v want %v", encoding, enc)
+		}
+		// the second brotli request is served from the cache with a known length
+		if i == 1 && rr.Header().Get("Content-Length") == "" {
+			t.Fatalf("handler did not serve the cached compressed file")
+		}
+
+		var decoded []byte
+		if enc == encodingBrotli {
+			decoded, err = ioutil.ReadAll(brotli.NewReader(rr.Body))
+		} else {
+			var zr *gzip.Reader
+			zr, err = gzip.NewReader(rr.Body)
+			if err == nil {
+				decoded, err = ioutil.ReadAll(zr)
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, original) {
+			t.Fatalf("handler returned wrong body: got decoded size %v want %v", len(decoded), len(original))
+		}
+	}
+}
+
+func TestDynamicCompressionDirList(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/handler/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rr := httptest.NewRecorder()
+	s := NewServer("../", false, false, logrus.WithField("test", true), WithCompression(false, true, 1024, 0))
+
+	s.ServeHTTP(rr, req)
+	if encoding := rr.Header().Get("Content-Encoding"); encoding != encodingGzip {
+		t.Fatalf("handler returned wrong header Content-Encoding: got %v want %v", encoding, encodingGzip)
+	}
+}
@@ -3,8 +3,37 @@ package handler
 import "errors"
 
 var (
-	ErrFileIsNotRegular = errors.New("File is not regular")
-	ErrFileIsNotDir     = errors.New("File is not dir")
-	ErrCreateTemplate   = errors.New("Create template error")
-	ErrExecuteTemplate  = errors.New("Execute template error")
+	ErrFileIsNotRegular        = errors.New("File is not regular")
+	ErrFileIsNotDir            = errors.New("File is not dir")
+	ErrCreateTemplate          = errors.New("Create template error")
+	ErrExecuteTemplate         = errors.New("Execute template error")
+	ErrSpaEnvFile              = errors.New("SPA env file error")
+	ErrCacheRule               = errors.New("Invalid cache rule")
+	ErrListOptions             = errors.New("Invalid list options")
+	ErrSearchQuery             = errors.New("Invalid search query")
+	ErrThumbnail               = errors.New("Thumbnail error")
+	ErrAuthCredential          = errors.New("Invalid auth credential")
+	ErrUnauthorized            = errors.New("Unauthorized")
+	ErrVirtualHost             = errors.New("Invalid virtual host")
+	ErrMountPoint              = errors.New("Invalid mount point")
+	ErrAccessDenied            = errors.New("Access denied")
+	ErrUploadFileName          = errors.New("Invalid upload file name")
+	ErrS3Key                   = errors.New("Invalid S3 access key")
+	ErrSFTPHostKey             = errors.New("SFTP host key error")
+	ErrSFTPAuthorizedKeys      = errors.New("SFTP authorized keys error")
+	ErrSFTPNoAuth              = errors.New("SFTP requires --auth, authorized keys or anonymous access")
+	ErrSFTPClosed              = errors.New("SFTP server closed")
+	ErrRange                   = errors.New("Requested range not satisfiable")
+	ErrDirNotEmpty             = errors.New("Directory not empty")
+	ErrResumableUpload         = errors.New("Invalid resumable upload")
+	ErrUploadOffset            = errors.New("Upload offset mismatch")
+	ErrTooManyResumableUploads = errors.New("Too many resumable uploads")
+	ErrChecksum                = errors.New("Invalid checksum algorithm")
+	ErrUploadIdle              = errors.New("Upload idle timeout")
+	ErrBandwidthLimit          = errors.New("Invalid bandwidth limit")
+	ErrTooManyTransfers        = errors.New("Too many concurrent transfers")
+	ErrTooManyRequests         = errors.New("Too many requests")
+	ErrTrustedProxy            = errors.New("Invalid trusted proxy")
+	ErrAccessList              = errors.New("Invalid access list rule")
+	ErrForbidden               = errors.New("Forbidden")
 )
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// globPattern é um padrão glob no estilo do .gitignore. '*' não atravessa
+// diretórios, '**' atravessa e '?' casa com um único caractere. Padrões sem '/'
+// casam com o nome de qualquer segmento do caminho, assim 'secret' casa com
+// '/secret' e com tudo dentro de '/docs/secret/'.
+type globPattern struct {
+	pattern  string
+	re       *regexp.Regexp
+	baseOnly bool
+}
+
+func compileGlob(pattern string) (*globPattern, error) {
+	g := &globPattern{pattern: pattern, baseOnly: !strings.Contains(pattern, "/")}
+
+	p := strings.TrimPrefix(pattern, "/")
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch c {
+		case '*':
+			if i+1 < len(p) && p[i+1] == '*' {
+				i++
+				if i+1 < len(p) && p[i+1] == '/' {
+					// '**/' also matches zero directories
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			j := strings.IndexByte(p[i:], ']')
+			if j < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := p[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += j
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	g.re = re
+	return g, nil
+}
+
+// Match verifica se o caminho, relativo a raiz servida, casa com o padrão.
+// Um diretório também casa com padrões que terminam em '/**' (ex: '.git/**').
+func (g *globPattern) Match(urlPath string) bool {
+	p := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if g.baseOnly {
+		for _, name := range strings.Split(p, "/") {
+			if g.re.MatchString(name) {
+				return true
+			}
+		}
+		return false
+	}
+	return g.re.MatchString(p) || g.re.MatchString(p+"/")
+}
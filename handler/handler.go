@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
 	"mime"
@@ -12,23 +14,103 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 )
 
+// Handler é um site atendido pelo servidor HTTP: um Server ou uma composição de
+// Servers, como Mounts e VirtualHosts.
+type Handler interface {
+	http.Handler
+	Close() error
+}
+
 type Server struct {
 	r                          *httprouter.Router
 	logger                     *logrus.Entry
 	staticDirPath              string
 	keepOriginalUploadFileName bool
 	spaMode                    bool
+	uploadEnabled              bool
+
+	// storage guarda os arquivos servidos, por padrão o próprio staticDirPath
+	storage storage.Storage
+
+	// mountPath é o prefixo das URLs quando o Server é um ponto de montagem
+	mountPath string
+	// mountedDirs são exibidos como diretórios virtuais na listagem da raiz
+	mountedDirs []mountedDir
+
+	access accessPolicy
+
+	// auth é nil quando o acesso não exige autenticação
+	auth *basicAuth
+
+	// internal routes live under internalPathPrefix, outside the served tree
+	internal *httprouter.Router
+
+	liveReloadEnabled bool
+	liveReload        *liveReloadHub
+
+	spaEnv spaEnv
+
+	ruleFilesEnabled bool
+	rules            *siteRules
+
+	compression compression
+	cachePolicy cachePolicy
+	templates   templateSet
+
+	listPageSize int
+
+	searchEnabled bool
+	searchIndexed bool
+	searchIndex   *searchIndex
+
+	thumbnails *thumbnailer
+
+	// indexFiles são enviados no lugar da listagem quando existem no diretório
+	indexFiles   []string
+	cleanURLs    bool
+	notFoundPage string
+
+	// uploadListeners são chamados com o caminho de cada arquivo recebido
+	uploadListeners []func(fullPath string)
+
+	// resumable guarda os uploads em partes, nil com os uploads desabilitados
+	resumable          *resumableUploads
+	resumableMaxLength int64
+
+	// uploads acompanha os uploads em andamento, compartilhado com os pontos de montagem
+	uploads               *uploadTracker
+	uploadIdleTimeout     time.Duration
+	uploadProgressEnabled bool
+
+	// bandwidth e transfers são compartilhados por todos os Servers criados
+	// com as mesmas opções, nil sem limites
+	bandwidth *bandwidthLimiter
+	transfers *transferSlots
+	rateLimit *rateLimiter
+
+	trustedProxies trustedProxies
+	forceHTTPS     bool
+	accessList     *accessList
 }
 
-func NewServer(staticDirPath string, keepOriginalUploadFileName bool, spaMode bool, logger *logrus.Entry) *Server {
+// internalPathPrefix é o prefixo das rotas próprias do servidor (ex: live reload).
+// Como o httprouter não permite rotas ao lado do catch-all '/*filepath', essas
+// requisições são despachadas para um router separado em ServeHTTP.
+const internalPathPrefix = "/_gouploadserver/"
+
+// defaultListPageSize é a quantidade de entradas por página na listagem de diretórios.
+const defaultListPageSize = 1000
+
+func NewServer(staticDirPath string, keepOriginalUploadFileName bool, spaMode bool, logger *logrus.Entry, opts ...Option) *Server {
 	router := httprouter.New()
 	s := Server{
 		r:                          router,
@@ -36,34 +118,138 @@ func NewServer(staticDirPath string, keepOriginalUploadFileName bool, spaMode bo
 		staticDirPath:              staticDirPath,
 		keepOriginalUploadFileName: keepOriginalUploadFileName,
 		spaMode:                    spaMode,
+		uploadEnabled:              true,
+		access:                     defaultAccessPolicy(),
+		internal:                   httprouter.New(),
+		listPageSize:               defaultListPageSize,
+		resumableMaxLength:         defaultResumableMaxLength,
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	if s.storage == nil {
+		s.storage = storage.NewLocal(staticDirPath)
+	}
+	if !s.localStorage() {
+		// object storages have no symlinks and no file system to watch or walk
+		s.access.symlinks = SymlinksFollow
+		if s.liveReloadEnabled {
+			logger.Warn("Live reload is only available for local directories, disabling it")
+			s.liveReloadEnabled = false
+		}
+		if s.searchEnabled {
+			logger.Warn("Search is only available for local directories, disabling it")
+			s.searchEnabled = false
+		}
 	}
 
 	if s.spaMode {
 		router.GET("/*filepath", s.spaFileHandler)
 	} else {
 		router.GET("/*filepath", s.fileHandler)
-		router.POST("/*dirpath", s.uploadHandler)
+		if s.uploadEnabled {
+			s.resumable = newResumableUploads(resumableUploadTTL)
+			router.POST("/*dirpath", s.uploadHandler)
+			router.PATCH("/*filepath", s.resumableUploadHandler)
+			router.DELETE("/*filepath", s.deleteHandler)
+			router.Handle("MKCOL", "/*filepath", s.mkdirHandler)
+		}
+	}
+
+	if s.liveReloadEnabled {
+		s.liveReload = newLiveReloadHub(staticDirPath, s.liveReloadVisible, logger.WithField("server", "livereload"))
+		s.internal.GET(liveReloadPath, s.liveReloadHandler)
+	}
+
+	if s.ruleFilesEnabled {
+		s.rules = newSiteRules(s.storage, logger.WithField("server", "rules"))
+	}
+
+	s.internal.GET(assetsPath+"*name", s.assetsHandler)
+
+	s.uploads = newUploadTracker(s.uploadIdleTimeout, logger.WithField("server", "uploads"))
+	// the uploads reveal clients and targets, so they are only listed on request
+	if s.uploadProgressEnabled && s.uploadEnabled && !s.spaMode {
+		s.internal.GET(uploadsPath, s.uploadsHandler)
+	}
+
+	// search exposes the whole tree, so it is only available in file browser mode
+	if s.searchEnabled && !s.spaMode {
+		s.internal.GET(searchPath, s.searchHandler)
+		if s.searchIndexed {
+			s.searchIndex = newSearchIndex(staticDirPath, logger.WithField("server", "search-index"))
+			s.uploadListeners = append(s.uploadListeners, s.searchIndex.add)
+		}
 	}
 
 	return &s
 }
 
 func (f *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	mw := NewLoggingInterceptorOnServer(f.r, f.logger.WithField("server", "interceptor-on-server"))
-	mw.ServeHTTP(w, r)
+	var next http.Handler = f.r
+	if strings.HasPrefix(r.URL.Path, internalPathPrefix) {
+		next = f.internal
+	} else if f.rules != nil {
+		next = f.withRules(next)
+	}
+	if f.auth != nil {
+		next = f.withAuth(next)
+	}
+	if f.rateLimit != nil {
+		next = f.withRateLimit(next)
+	}
+	if f.accessList != nil {
+		next = f.withAccessList(next)
+	}
+	if f.forceHTTPS {
+		next = f.withForceHTTPS(next)
+	}
+	mw := NewLoggingInterceptorOnServer(next, f.logger.WithField("server", "interceptor-on-server"))
+	mw.ServeHTTP(w, f.withClient(r))
+}
+
+// Close libera os recursos em segundo plano do Server (ex: observador do live reload).
+func (s *Server) Close() error {
+	if s.liveReload != nil {
+		s.liveReload.Close()
+	}
+	if s.resumable != nil {
+		s.resumable.Close()
+	}
+	s.uploads.Close()
+	return nil
 }
 
 func (s *Server) fileHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	fileUrlPath := p.ByName("filepath")
-	filePath := path.Join(s.staticDirPath, ".", fileUrlPath)
+	filePath := s.localPath(fileUrlPath)
 	s.logger.Trace(filePath)
 
-	fileinfo, err := os.Stat(filePath)
+	if s.resumable != nil && hasParam(r.URL.Query(), "upload") {
+		s.resumableUploadStatus(w, r)
+		return
+	}
+
+	if err := s.checkAccess(filePath); err != nil {
+		s.logger.Info(err)
+		s.sendNotFound(w, r, os.ErrNotExist)
+		return
+	}
+
+	fileinfo, err := s.stat(filePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			if htmlPath := s.findCleanURLFile(fileUrlPath); htmlPath != "" {
+				if err := s.sendFileToClient(w, r, htmlPath); err != nil {
+					s.sendError(w, r, http.StatusInternalServerError, err)
+				}
+				return
+			}
+			s.sendNotFound(w, r, err)
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			s.sendError(w, r, http.StatusInternalServerError, err)
 		}
 		return
 	}
@@ -76,17 +262,54 @@ func (s *Server) fileHandler(w http.ResponseWriter, r *http.Request, p httproute
 			return
 		}
 
-		err := sendDirFileListToClient(w, filePath)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		// clients asking for JSON want the entries, not the index page
+		if indexPath := s.findIndexFile(filePath); indexPath != "" && !acceptsJSON(r) {
+			if err := s.sendFileToClient(w, r, indexPath); err != nil {
+				s.sendError(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		err := s.sendDirFileListToClient(w, r, filePath)
+		if errors.Is(err, ErrListOptions) {
+			s.sendError(w, r, http.StatusBadRequest, err)
+		} else if err != nil {
+			s.sendError(w, r, http.StatusInternalServerError, err)
 		}
 	case mode.IsRegular():
-		err := sendFileToClient(w, filePath)
+		if s.thumbnails != nil && wantsThumbnail(r) {
+			err := s.sendThumbnailToClient(w, r, filePath)
+			if errors.Is(err, ErrThumbnail) {
+				s.sendError(w, r, http.StatusBadRequest, err)
+			} else if err != nil {
+				s.sendError(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		if wantsChecksum(r) {
+			err := s.sendChecksumToClient(w, r, filePath)
+			if errors.Is(err, ErrChecksum) {
+				s.sendError(w, r, http.StatusBadRequest, err)
+			} else if err != nil {
+				s.sendError(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		if wantsPreview(r) {
+			if err := s.sendPreviewToClient(w, r, filePath); err != nil {
+				s.sendError(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		err := s.sendFileToClient(w, r, filePath)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			s.sendError(w, r, http.StatusInternalServerError, err)
 		}
 	default:
-		http.Error(w, fmt.Sprintf("Error: Unrecognized mode %s", mode), http.StatusInternalServerError)
+		s.sendError(w, r, http.StatusInternalServerError, fmt.Errorf("Error: Unrecognized mode %s", mode))
 	}
 }
 
@@ -103,16 +326,23 @@ func (s *Server) spaFileHandler(w http.ResponseWriter, r *http.Request, p httpro
 	indexPath := path.Join(s.staticDirPath, "./index.html")
 
 	fileUrlPath := p.ByName("filepath")
-	filePath := path.Join(s.staticDirPath, ".", fileUrlPath)
+	filePath := s.localPath(fileUrlPath)
 
 	// root requests receive the 'index.html' file
-	if fileUrlPath == "/" || fileUrlPath == "" {
+	if s.relPath(fileUrlPath) == "/" {
 		filePath = indexPath
 	}
 
 	s.logger.Trace(filePath)
 
-	err := sendFileToClient(w, filePath)
+	err := s.checkAccess(filePath)
+	if err != nil {
+		s.logger.Info(err)
+		// denied paths are handled like missing files, the SPA renders its own 404
+		err = os.ErrNotExist
+	} else {
+		err = s.sendFileToClient(w, r, filePath)
+	}
 	if err == nil {
 		// FIXME - cliente broken pipe
 		// OK! file successfully sent to the client
@@ -122,13 +352,13 @@ func (s *Server) spaFileHandler(w http.ResponseWriter, r *http.Request, p httpro
 	if !errors.Is(err, os.ErrNotExist) {
 		// unknown error returns an internal server error
 		s.logger.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.sendError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	// could not find the file path, fallback to 'index.html'
 	s.logger.Infof("%s Not Found. Responding to the request with the index.html", filePath)
-	err = sendFileToClient(w, indexPath)
+	err = s.sendFileToClient(w, r, indexPath)
 	if err == nil {
 		// OK! file successfully sent to the client
 		return
@@ -137,19 +367,44 @@ func (s *Server) spaFileHandler(w http.ResponseWriter, r *http.Request, p httpro
 	if !errors.Is(err, os.ErrNotExist) {
 		// unknown error returns an internal server error
 		s.logger.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.sendError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	// 'index.html' not found returns a 404 status
-	http.Error(w, err.Error(), http.StatusNotFound)
+	s.sendError(w, r, http.StatusNotFound, err)
 }
 
 func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	dirUrlPath := p.ByName("dirpath")
-	dirPath := path.Join(s.staticDirPath, ".", path.Dir(dirUrlPath))
+	if hasParam(r.URL.Query(), "resumable") {
+		s.createResumableUpload(w, r, dirUrlPath)
+		return
+	}
+
+	dirPath := s.localPath(path.Dir(dirUrlPath))
 	s.logger.Trace(dirPath)
 
+	if err := s.checkAccess(dirPath); err != nil {
+		s.logger.Errorf("Upload error: %s", err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(os.ErrNotExist.Error()))
+		return
+	}
+	// storages with implicit directories would create any path posted to
+	if fi, err := s.stat(dirPath); err != nil || !fi.IsDir() {
+		s.logger.Errorf("Upload error: %s is not a directory", dirPath)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(os.ErrNotExist.Error()))
+		return
+	}
+
+	release, ok := s.acquireTransfer(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
 	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
 		s.logger.Errorf("Parse Media Type error: %s", err)
@@ -159,8 +414,10 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request, p httprou
 	}
 	s.logger.Trace(mediaType, params)
 
+	var uploads []string
 	boundary := params["boundary"]
-	reader := multipart.NewReader(r.Body, boundary)
+	body := &countingReader{r: r.Body}
+	reader := multipart.NewReader(body, boundary)
 	for {
 		part, err := reader.NextPart()
 		if err != nil {
@@ -186,10 +443,32 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request, p httprou
 		fname := part.FileName()
 		s.logger.Infof("multipart/form-data Content-Type: %s, Filename: %s", contentType, fname)
 
+		if !validUploadFileName(fname) {
+			s.logger.Errorf("%s: %q", ErrUploadFileName, fname)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%s: %q", ErrUploadFileName, fname)
+			return
+		}
+		if err := s.checkAccess(path.Join(dirPath, fname)); err != nil {
+			s.logger.Errorf("Upload error: %s", err)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		// the size of a part is unknown, the rest of the body is the closest estimate
+		size := int64(-1)
+		if r.ContentLength > 0 {
+			size = r.ContentLength - body.n
+		}
 		buf := make([]byte, 4096) // make a buffer to keep chunks that are read
-		fileSent, err := readerToFile(part, dirPath, fname, s.keepOriginalUploadFileName, buf)
+		fileSent, err := s.readerToFile(part, dirPath, fname, s.keepOriginalUploadFileName, buf, requestSource(r, "http", size))
 		if err != nil {
-			if errors.Is(err, io.ErrUnexpectedEOF) {
+			if errors.Is(err, ErrUploadIdle) {
+				s.logger.Errorf("Reader To File error: %s", err)
+				w.WriteHeader(http.StatusRequestTimeout)
+				w.Write([]byte(err.Error()))
+			} else if errors.Is(err, io.ErrUnexpectedEOF) {
 				s.logger.Errorf("Reader To File error, Client closed the connection: %s", err)
 				w.WriteHeader(http.StatusBadRequest)
 				w.Write([]byte(err.Error()))
@@ -201,23 +480,141 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request, p httprou
 			return
 		}
 		s.logger.Infof("File sent: %s", fileSent)
+		uploads = append(uploads, path.Base(fileSent))
+		s.notifyUpload(fileSent)
+	}
+
+	// clients asking for JSON receive the URLs of the saved files
+	if acceptsJSON(r) {
+		files := make([]string, len(uploads))
+		for i, name := range uploads {
+			files[i] = path.Join(path.Dir(dirUrlPath), name)
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(struct {
+			Files []string `json:"files"`
+		}{files})
+		return
+	}
+
+	// browsers without JavaScript post the form directly and receive a result page
+	if acceptsHTML(r) {
+		data := s.newPageData(strings.TrimSuffix(path.Dir(dirUrlPath), "/") + "/")
+		data.Uploads = uploads
+		if err := s.renderPage(w, r, http.StatusOK, templateUpload, data); err != nil {
+			s.logger.Errorf("Render upload page: %s", err)
+		}
 	}
 }
 
-// helpers
+// validUploadFileName indica se fname é somente um nome de arquivo, sem diretórios.
+func validUploadFileName(fname string) bool {
+	return fname != "" && fname != "." && fname != ".." && !strings.ContainsAny(fname, `/\`)
+}
+
+// deleteHandler remove um arquivo ou um diretório vazio. Com '?upload=<id>'
+// cancela um upload em partes.
+func (s *Server) deleteHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if hasParam(r.URL.Query(), "upload") {
+		s.abortResumableUpload(w, r)
+		return
+	}
+
+	fileUrlPath := p.ByName("filepath")
+	filePath := s.localPath(fileUrlPath)
+	if s.relPath(fileUrlPath) == "/" {
+		s.sendError(w, r, http.StatusForbidden, ErrAccessDenied)
+		return
+	}
+	if err := s.checkAccess(filePath); err != nil {
+		s.logger.Errorf("Delete error: %s", err)
+		s.sendError(w, r, http.StatusNotFound, os.ErrNotExist)
+		return
+	}
+
+	fileinfo, err := s.stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		s.sendError(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		s.sendError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if fileinfo.IsDir() {
+		dir, err := s.openDir(filePath)
+		if err != nil {
+			s.sendError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		entries, _ := dir.ReadDir(1)
+		dir.Close()
+		if len(entries) > 0 {
+			s.sendError(w, r, http.StatusConflict, ErrDirNotEmpty)
+			return
+		}
+	}
 
-func getContentType(path string, buf []byte) (string, error) {
-	f, err := os.Open(path)
+	name, err := s.relToRoot(filePath)
+	if err == nil {
+		err = s.storage.Remove(name)
+	}
 	if err != nil {
-		return "", err
+		s.logger.Errorf("Delete error: %s", err)
+		s.sendError(w, r, http.StatusInternalServerError, err)
+		return
 	}
-	defer f.Close()
+	s.logger.Infof("File deleted: %s", filePath)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	ctype := mime.TypeByExtension(filepath.Ext(path))
+// mkdirHandler cria um diretório com o método MKCOL do WebDAV. Como no WebDAV,
+// o diretório pai precisa existir (409) e um caminho existente recebe 405.
+func (s *Server) mkdirHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	dirPath := s.localPath(p.ByName("filepath"))
+	if err := s.checkAccess(dirPath); err != nil {
+		s.logger.Errorf("Mkdir error: %s", err)
+		s.sendError(w, r, http.StatusForbidden, err)
+		return
+	}
+	if _, err := s.stat(dirPath); err == nil {
+		s.sendError(w, r, http.StatusMethodNotAllowed, os.ErrExist)
+		return
+	}
+	if fi, err := s.stat(path.Dir(dirPath)); err != nil || !fi.IsDir() {
+		s.sendError(w, r, http.StatusConflict, fmt.Errorf("%w: %s", ErrFileIsNotDir, path.Dir(p.ByName("filepath"))))
+		return
+	}
+
+	name, err := s.relToRoot(dirPath)
+	if err == nil {
+		err = s.storage.MkdirAll(name)
+	}
+	if err != nil {
+		s.logger.Errorf("Mkdir error: %s", err)
+		s.sendError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	s.logger.Infof("Directory created: %s", dirPath)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// notifyUpload avisa os interessados (ex: índice de busca) que um arquivo foi recebido.
+func (s *Server) notifyUpload(fullPath string) {
+	for _, listener := range s.uploadListeners {
+		listener(fullPath)
+	}
+}
+
+// helpers
+
+// getContentType identifica o tipo pela extensão de name ou pelo conteúdo de f,
+// que volta para o início do arquivo.
+func getContentType(name string, f io.ReadSeeker, buf []byte) (string, error) {
+	ctype := mime.TypeByExtension(filepath.Ext(name))
 	if ctype == "" {
 		n, _ := io.ReadFull(f, buf) // read a chunk to decide between utf-8 text and binary
 		ctype = http.DetectContentType(buf[:n])
-		_, err = f.Seek(0, io.SeekStart) // rewind to output whole file
+		_, err := f.Seek(0, io.SeekStart) // rewind to output whole file
 		if err != nil {
 			// seeker can't seek
 			return "", err
@@ -240,8 +637,8 @@ func formatBytes(b int64) string {
 	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
 }
 
-func sendFileToClient(w http.ResponseWriter, filepath string) error {
-	fileinfo, err := os.Stat(filepath)
+func (s *Server) sendFileToClient(w http.ResponseWriter, r *http.Request, filepath string) error {
+	fileinfo, err := s.stat(filepath)
 	if err != nil {
 		return err
 	}
@@ -250,18 +647,87 @@ func sendFileToClient(w http.ResponseWriter, filepath string) error {
 		return ErrFileIsNotRegular
 	}
 
+	release, ok := s.acquireTransfer(w, r)
+	if !ok {
+		return nil
+	}
+	defer release()
+
+	f, err := s.open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
 	// FIXME comparar desempenho bufio
 	buf := make([]byte, 4096) // make a buffer to keep chunks that are read
-	ctype, err := getContentType(filepath, buf)
+	ctype, err := getContentType(filepath, f, buf)
 	if err != nil {
 		return fmt.Errorf("Get Content-Type error: %w", err)
 	}
 
+	s.setCacheControl(w, filepath, ctype)
+
+	if isHTMLContentType(ctype) && s.rewritesHTML(filepath) {
+		html, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		html, err = s.rewriteHTML(filepath, html)
+		if err != nil {
+			return err
+		}
+		return s.writeBody(w, r, http.StatusOK, ctype, html)
+	}
+
+	// the ranges are always served from the uncompressed file
+	if s.compression.enabled() {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if s.compression.enabled() && r.Header.Get("Range") == "" {
+		if s.compression.precompressed {
+			sent, err := s.sendPrecompressedFile(w, r, filepath, fileinfo, ctype, buf)
+			if sent || err != nil {
+				return err
+			}
+		}
+
+		if s.compression.dynamic && IsCompressible(ctype) && fileinfo.Size() >= s.compression.minSize {
+			enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), supportedEncodings)
+			if enc != "" {
+				return s.sendCompressedFile(w, r, filepath, f, fileinfo, ctype, enc, buf)
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", ctype)
-	w.Header().Set("Content-Length", strconv.FormatInt(fileinfo.Size(), 10))
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Last-Modified", fileinfo.ModTime().UTC().Format(http.TimeFormat))
 
-	err = readFileAndWriteToW(w, filepath, buf)
+	br, partial, err := requestedRange(r, fileinfo)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fileinfo.Size()))
+		s.sendError(w, r, http.StatusRequestedRangeNotSatisfiable, err)
+		return nil
+	}
+
+	var body io.Reader = f
+	status := http.StatusOK
+	length := fileinfo.Size()
+	if partial {
+		if _, err := f.Seek(br.start, io.SeekStart); err != nil {
+			return err
+		}
+		body = io.LimitReader(f, br.length)
+		status = http.StatusPartialContent
+		length = br.length
+		w.Header().Set("Content-Range", br.contentRange(fileinfo.Size()))
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+
+	err = readFileAndWriteToW(w, s.throttleDownload(r, body), buf)
 	if err != nil {
 		return fmt.Errorf("Read File And Write To W Error: %w", err)
 	}
@@ -269,8 +735,34 @@ func sendFileToClient(w http.ResponseWriter, filepath string) error {
 	return nil
 }
 
-func sendDirFileListToClient(w http.ResponseWriter, dirpath string) error {
-	fileinfo, err := os.Stat(dirpath)
+// rewritesHTML indica se o arquivo HTML precisa ser alterado antes do envio,
+// nesse caso ele é lido por completo em memória ao invés de ser enviado em chunks.
+func (s *Server) rewritesHTML(filepath string) bool {
+	return s.liveReload != nil || s.isSpaIndex(filepath)
+}
+
+func (s *Server) rewriteHTML(filepath string, html []byte) ([]byte, error) {
+	if s.isSpaIndex(filepath) {
+		var err error
+		html, err = s.spaEnv.inject(html)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.liveReload != nil {
+		html = injectLiveReloadScript(html, "")
+	}
+
+	return html, nil
+}
+
+func (s *Server) isSpaIndex(filepath string) bool {
+	return s.spaMode && s.spaEnv.enabled() && filepath == path.Join(s.staticDirPath, "./index.html")
+}
+
+func (s *Server) sendDirFileListToClient(w http.ResponseWriter, r *http.Request, dirpath string) error {
+	fileinfo, err := s.stat(dirpath)
 	if err != nil {
 		return err
 	}
@@ -279,41 +771,64 @@ func sendDirFileListToClient(w http.ResponseWriter, dirpath string) error {
 		return ErrFileIsNotDir
 	}
 
-	dirfileList, err := ioutil.ReadDir(dirpath)
+	opts, err := parseListOptions(r.URL.Query(), s.listPageSize)
 	if err != nil {
 		return err
 	}
 
-	// Sort by name
-	sort.Slice(dirfileList, func(i, j int) bool {
-		return strings.ToLower(dirfileList[i].Name()) < strings.ToLower(dirfileList[j].Name())
-	})
-
-	t, err := template.New("files").Funcs(template.FuncMap{
-		"formatBytes": formatBytes,
-	}).Parse(TemplateListFiles)
-	if err != nil {
-		return fmt.Errorf("%w %s", ErrCreateTemplate, err)
+	var mounted []os.DirEntry
+	if s.relPath(r.URL.Path) == "/" {
+		mounted = s.mountedDirEntries()
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
+	var visible func(os.DirEntry) bool
+	if !s.access.permissive() {
+		visible = func(e os.DirEntry) bool {
+			return s.listable(dirpath, e.Name(), e.Type())
+		}
+	}
 
-	err = t.Execute(w, dirfileList)
+	dir, err := s.openDir(dirpath)
+	if err != nil {
+		return err
+	}
+	page, total, hasMore, err := readDirPage(dir, opts, mounted, visible)
+	dir.Close()
 	if err != nil {
-		return fmt.Errorf("%w %s", ErrExecuteTemplate, err)
+		return err
 	}
 
-	return nil
-}
+	if acceptsJSON(r) {
+		listing := newDirListing(r.URL.Path, page, total)
+		listing.Next = newListingInfo(r.URL.Path, opts, total, page, hasMore).NextURL
+		body, err := json.Marshal(listing)
+		if err != nil {
+			return err
+		}
+		s.setCacheControl(w, dirpath, "application/json")
+		return s.writeBody(w, r, http.StatusOK, "application/json", body)
+	}
 
-func readFileAndWriteToW(w io.Writer, path string, buf []byte) error {
-	file, err := os.Open(path)
+	data := s.newPageData(r.URL.Path)
+	data.Entries = make([]Entry, 0, len(page))
+	for _, it := range page {
+		e := newEntry(it.info)
+		if s.thumbnails != nil && !e.IsDir && isThumbnailable(e.Name) {
+			e.ThumbURL = e.URL + "?thumb=" + strconv.Itoa(defaultThumbSize)
+		}
+		data.Entries = append(data.Entries, e)
+	}
+	data.Listing = newListingInfo(r.URL.Path, opts, total, page, hasMore)
+	data.Readme, err = s.readmeHTML(dirpath)
 	if err != nil {
-		return err
+		s.logger.Errorf("Render README: %s", err)
 	}
-	defer file.Close()
 
+	s.setCacheControl(w, dirpath, "text/html; charset=utf-8")
+	return s.renderPage(w, r, http.StatusOK, templateList, data)
+}
+
+func readFileAndWriteToW(w io.Writer, file io.Reader, buf []byte) error {
 	for {
 		// read a chunk
 		n, err := file.Read(buf)
@@ -334,45 +849,70 @@ func readFileAndWriteToW(w io.Writer, path string, buf []byte) error {
 	return nil
 }
 
-func readerToFile(r io.Reader, dir string, fname string, keepOriginalFileName bool, buf []byte) (string, error) {
+// readerToFile grava o upload em um arquivo com sufixo aleatório (ex: 'foto-123.jpg')
+// e o renomeia para fname quando keepOriginalFileName é true. Retorna o caminho
+// do arquivo gravado dentro do staticDirPath. Com src o upload aparece no
+// acompanhamento dos uploads, sem ele (ex: arquivos temporários já recebidos) não.
+func (s *Server) readerToFile(r io.Reader, dir string, fname string, keepOriginalFileName bool, buf []byte, src *uploadSource) (fileSent string, err error) {
 	// FIXME file permissions originais
 
+	dirName, err := s.relToRoot(dir)
+	if err != nil {
+		return "", err
+	}
+	if src != nil {
+		u := s.uploads.start(src, s.mountPath+path.Join("/", dirName, fname))
+		defer func() { u.finish(err) }()
+		r = u.reader(s.throttle(src.ctx, r, directionUpload, src.client, src.user))
+	}
+
 	ext := path.Ext(fname)
 	name := fname[0 : len(fname)-len(ext)]
-	tempFilePattern := name + "-*" + ext
-	tempFile, err := ioutil.TempFile(dir, tempFilePattern)
+	tempName, tempFile, err := s.createUnique(dirName, name, ext)
 	if err != nil {
 		return "", err
 	}
-	defer tempFile.Close()
-
-	for {
-		// read a chunk
-		n, err := r.Read(buf)
-		if err != nil && err != io.EOF {
-			os.Remove(tempFile.Name())
-			return "", err
-		}
-
-		if n == 0 {
-			break
-		}
 
-		// write a chunk
-		if _, err := tempFile.Write(buf[:n]); err != nil {
-			os.Remove(tempFile.Name())
-			return "", err
-		}
+	if err := readFileAndWriteToW(tempFile, r, buf); err != nil {
+		tempFile.Close()
+		s.storage.Remove(tempName)
+		return "", err
+	}
+	if err := tempFile.Close(); err != nil {
+		s.storage.Remove(tempName)
+		return "", err
 	}
 
 	if keepOriginalFileName {
-		finalFileName := path.Join(dir, fname)
-		err = os.Rename(tempFile.Name(), path.Join(dir, fname))
+		err = s.storage.Rename(tempName, path.Join(dirName, fname))
 		if err != nil {
+			s.storage.Remove(tempName)
 			return "", err
 		}
-		return finalFileName, nil
+		return path.Join(dir, fname), nil
 	}
 
-	return tempFile.Name(), nil
+	return path.Join(dir, path.Base(tempName)), nil
+}
+
+// createUnique cria um arquivo 'name-<aleatório>ext' ainda não usado em
+// dirName, como o ioutil.TempFile. O sufixo vem do crypto/rand e a criação é
+// exclusiva, assim uploads simultâneos nunca recebem o mesmo arquivo.
+func (s *Server) createUnique(dirName string, name string, ext string) (string, io.WriteCloser, error) {
+	b := make([]byte, 4)
+	for i := 0; i < 10000; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return "", nil, err
+		}
+		candidate := path.Join(dirName, name+"-"+strconv.FormatUint(uint64(binary.BigEndian.Uint32(b)), 10)+ext)
+		f, err := s.storage.CreateExclusive(candidate)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		return candidate, f, nil
+	}
+	return "", nil, fmt.Errorf("upload %s%s: no unused file name in %s", name, ext, dirName)
 }
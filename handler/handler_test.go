@@ -9,11 +9,37 @@ import (
 	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/sirupsen/logrus"
 )
 
+// writeTestFiles cria um diretório temporário com files, que mapeia o caminho
+// relativo, separado por '/', para o conteúdo. Caminhos terminados em '/' criam
+// diretórios vazios. O diretório é removido ao final do teste.
+func writeTestFiles(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(p, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
 func TestFileHandlerStatusFound(t *testing.T) {
 	// https://blog.questionable.services/article/testing-http-handlers-go/
 	req, err := http.NewRequest(http.MethodGet, "/handler", nil)
@@ -69,9 +95,13 @@ func TestFileHandlerStream(t *testing.T) {
 	}
 
 	var bufferR bytes.Buffer
-	name := path.Join(s.staticDirPath, ".") + filepath
+	f, err := os.Open(path.Join(s.staticDirPath, ".") + filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
 	buf := make([]byte, 4096)
-	readFileAndWriteToW(&bufferR, name, buf)
+	readFileAndWriteToW(&bufferR, f, buf)
 
 	bufferF, err := ioutil.ReadAll(rr.Body)
 	if err != nil {
@@ -103,9 +133,13 @@ func TestSpaFileHandlerStream(t *testing.T) {
 	}
 
 	var bufferR bytes.Buffer
-	name := path.Join(s.staticDirPath, "index.html")
+	f, err := os.Open(path.Join(s.staticDirPath, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
 	buf := make([]byte, 4096)
-	readFileAndWriteToW(&bufferR, name, buf)
+	readFileAndWriteToW(&bufferR, f, buf)
 
 	bufferF, err := ioutil.ReadAll(rr.Body)
 	if err != nil {
@@ -134,12 +168,17 @@ func TestSpaFileHandlerIndexNotFound(t *testing.T) {
 }
 
 func TestUploadHandlerStream(t *testing.T) {
-	s := NewServer("..", false, false, logrus.WithField("test", true))
+	// the uploads are written to a temporary copy of the test directory
+	dir := t.TempDir()
+	if err := os.Mkdir(path.Join(dir, "test"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(dir, false, false, logrus.WithField("test", true))
 	filepath := "/test/mimetype/yolinux-mime-test.gif"
 
 	var b bytes.Buffer
 	w := multipart.NewWriter(&b)
-	f, err := os.Open(path.Join(s.staticDirPath, ".") + filepath)
+	f, err := os.Open(".." + filepath)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -175,13 +214,17 @@ func TestUploadHandlerStream(t *testing.T) {
 }
 
 func BenchmarkUploadHandlerStream(b *testing.B) {
-	s := NewServer("..", false, false, logrus.WithField("test", true))
+	dir := b.TempDir()
+	if err := os.Mkdir(path.Join(dir, "test"), 0755); err != nil {
+		b.Fatal(err)
+	}
+	s := NewServer(dir, false, false, logrus.WithField("test", true))
 	filepath := "/test/mimetype/yolinux-mime-test.gif"
 
 	for n := 0; n < b.N; n++ {
 		var buffer bytes.Buffer
 		w := multipart.NewWriter(&buffer)
-		f, err := os.Open(path.Join(s.staticDirPath, ".") + filepath)
+		f, err := os.Open(".." + filepath)
 		if err != nil {
 			b.Fatal(err)
 		}
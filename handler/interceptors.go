@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"net"
 	"net/http"
 	"time"
 
@@ -24,11 +23,15 @@ func NewLoggingInterceptorOnServer(next http.Handler, logger *logrus.Entry) *Log
 
 func (l *LoggingInterceptorOnServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	remoteIp, _, _ := net.SplitHostPort(r.RemoteAddr)
-	forwardedFor := r.Header.Get("X-Forwarded-For")
+	// the client resolved through the trusted proxies, empty when it is the peer itself
+	client := requestClient(r)
+	forwardedFor := ""
+	if client.ip != client.peer {
+		forwardedFor = client.ip
+	}
 	lrw := newLoggingResponseWriter(w)
 	l.next.ServeHTTP(lrw, r)
-	l.logger.Infof("%s - %s '%s %s' %d %s", forwardedFor, remoteIp, r.Method, r.RequestURI, lrw.StatusCode, time.Since(start))
+	l.logger.Infof("%s - %s '%s %s' %d %s", forwardedFor, client.peer, r.Method, r.RequestURI, lrw.StatusCode, time.Since(start))
 }
 
 // loggingResponseWriter é um ResponseWriter para fazer o log do código HTTP enviado ao cliente
@@ -48,6 +51,14 @@ func (lw *loggingResponseWriter) WriteHeader(code int) {
 	lw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush repassa o flush para o ResponseWriter original, necessário para respostas
+// em streaming como Server-Sent Events.
+func (lw *loggingResponseWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // // LoggingInterceptorOnFunc é uma objeto capaz de interceptar 'httprouter.Handle'
 // type LoggingInterceptorOnFunc struct {
 // 	logger *logrus.Entry
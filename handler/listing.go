@@ -0,0 +1,413 @@
+package handler
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
+)
+
+const (
+	sortByName  = "name"
+	sortBySize  = "size"
+	sortByMtime = "mtime"
+	sortByType  = "type"
+
+	// listReadDirBatch é a quantidade de entradas lidas por chamada a Dir.ReadDir
+	listReadDirBatch = 1024
+	// maxListLimit é o maior '?limit=' aceito, ou a página padrão quando é maior
+	maxListLimit = 10000
+)
+
+var listSortColumns = []struct {
+	sortBy string
+	label  string
+}{
+	{sortByName, "file"},
+	{sortBySize, "size"},
+	{sortByMtime, "modified"},
+	{sortByType, "type"},
+}
+
+// listOptions são os parâmetros da listagem recebidos na query string:
+// '?sort=name|size|mtime|type&order=asc|desc&filter=texto&limit=100&cursor=...'
+type listOptions struct {
+	sortBy string
+	desc   bool
+	filter string
+	limit  int
+	cursor *listCursor
+	// limitSet indica que limit veio da query string e deve ser mantido nos links
+	limitSet bool
+}
+
+// listCursor identifica a última entrada da página anterior. Como a listagem é
+// ordenada, a próxima página contém as entradas que vêm depois dela na ordem.
+type listCursor struct {
+	Num  int64  `json:"v,omitempty"`
+	Str  string `json:"s,omitempty"`
+	Name string `json:"n"`
+}
+
+// ListingInfo descreve a paginação, ordenação e filtro da listagem em 'list.html'.
+type ListingInfo struct {
+	Sort    string
+	Order   string
+	Filter  string
+	Limit   int
+	Total   int
+	Columns []SortColumn
+	// FirstURL volta para a primeira página, vazio quando já está nela
+	FirstURL string
+	// NextURL avança para a próxima página, vazio quando não há mais entradas
+	NextURL string
+}
+
+// SortColumn é um cabeçalho clicável da listagem.
+type SortColumn struct {
+	Label  string
+	URL    string
+	Active bool
+	Desc   bool
+}
+
+func parseListOptions(query url.Values, defaultLimit int) (listOptions, error) {
+	opts := listOptions{sortBy: sortByName, limit: defaultLimit, filter: query.Get("filter")}
+
+	switch sortBy := query.Get("sort"); sortBy {
+	case "":
+	case sortByName, sortBySize, sortByMtime, sortByType:
+		opts.sortBy = sortBy
+	default:
+		return opts, fmt.Errorf("%w: unknown sort %q", ErrListOptions, sortBy)
+	}
+
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.desc = true
+	default:
+		return opts, fmt.Errorf("%w: unknown order %q", ErrListOptions, order)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("%w: invalid limit %q", ErrListOptions, limit)
+		}
+		// a client can not ask for the whole directory, only the server sets no limit
+		max := maxListLimit
+		if defaultLimit > max {
+			max = defaultLimit
+		}
+		if n > max {
+			n = max
+		}
+		opts.limit = n
+		opts.limitSet = true
+	}
+
+	if c := query.Get("cursor"); c != "" {
+		data, err := base64.RawURLEncoding.DecodeString(c)
+		if err != nil {
+			return opts, fmt.Errorf("%w: invalid cursor", ErrListOptions)
+		}
+		opts.cursor = &listCursor{}
+		if err := json.Unmarshal(data, opts.cursor); err != nil {
+			return opts, fmt.Errorf("%w: invalid cursor", ErrListOptions)
+		}
+	}
+
+	return opts, nil
+}
+
+func (c *listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// listItem guarda a chave de ordenação de uma entrada do diretório.
+type listItem struct {
+	entry os.DirEntry
+	info  os.FileInfo
+	key   listCursor
+}
+
+// newListItem monta a chave de ordenação. os.DirEntry.Info() só é chamado
+// quando a ordenação depende do tamanho ou da data de modificação.
+func newListItem(e os.DirEntry, sortBy string) (*listItem, error) {
+	it := &listItem{entry: e, key: listCursor{Name: e.Name()}}
+	switch sortBy {
+	case sortByName:
+		it.key.Str = strings.ToLower(e.Name())
+	case sortByType:
+		if !e.IsDir() {
+			it.key.Str = strings.ToLower(path.Ext(e.Name()))
+		}
+	case sortBySize, sortByMtime:
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		it.info = info
+		if sortBy == sortBySize {
+			it.key.Num = info.Size()
+		} else {
+			it.key.Num = info.ModTime().UnixNano()
+		}
+	}
+	return it, nil
+}
+
+// compareKeys ordena pela chave primária e usa o nome, único no diretório, como desempate.
+func compareKeys(a, b *listCursor) int {
+	switch {
+	case a.Num != b.Num:
+		if a.Num < b.Num {
+			return -1
+		}
+		return 1
+	case a.Str != b.Str:
+		if a.Str < b.Str {
+			return -1
+		}
+		return 1
+	case a.Name != b.Name:
+		if a.Name < b.Name {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// pageHeap é um max-heap (na ordem da listagem) com as entradas selecionadas
+// para a página. A maior entrada é descartada quando o heap passa do limite,
+// assim a memória usada fica proporcional ao tamanho da página e não ao diretório.
+type pageHeap struct {
+	items []*listItem
+	desc  bool
+}
+
+func (h *pageHeap) less(a, b *listItem) bool {
+	c := compareKeys(&a.key, &b.key)
+	if h.desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+func (h *pageHeap) Len() int { return len(h.items) }
+
+// Less inverts the listing order, the root of the heap is the last entry of the page
+func (h *pageHeap) Less(i, j int) bool { return h.less(h.items[j], h.items[i]) }
+
+func (h *pageHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *pageHeap) Push(x interface{}) { h.items = append(h.items, x.(*listItem)) }
+
+func (h *pageHeap) Pop() interface{} {
+	it := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return it
+}
+
+func (h *pageHeap) top() *listItem { return h.items[0] }
+
+func (h *pageHeap) afterCursor(it *listItem, c *listCursor) bool {
+	return c == nil || h.less(&listItem{key: *c}, it)
+}
+
+// matchListFilter compara o nome com o filtro, sem diferenciar maiúsculas.
+// Filtros com '*', '?' ou '[' são globs, os demais são buscas por substring.
+func matchListFilter(name string, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	name, filter = strings.ToLower(name), strings.ToLower(filter)
+	if strings.ContainsAny(filter, "*?[") {
+		ok, err := path.Match(filter, name)
+		return err == nil && ok
+	}
+	return strings.Contains(name, filter)
+}
+
+// readDirPage lê o diretório em lotes com dir.ReadDir(n) e seleciona a página
+// pedida. As entradas virtual (ex: pontos de montagem) são listadas junto com as
+// do diretório e escondem as entradas reais com o mesmo nome, e as entradas reais
+// recusadas por visible (ex: arquivos ocultos) são omitidas. Retorna as entradas
+// da página, o total de entradas que passaram pelo filtro e se existem entradas
+// depois da página.
+func readDirPage(dir storage.Dir, opts listOptions, virtual []os.DirEntry, visible func(os.DirEntry) bool) ([]*listItem, int, bool, error) {
+	h := &pageHeap{desc: opts.desc}
+	total, after := 0, 0
+	add := func(e os.DirEntry) {
+		if !matchListFilter(e.Name(), opts.filter) {
+			return
+		}
+		total++
+
+		it, err := newListItem(e, opts.sortBy)
+		if err != nil {
+			// the entry was removed while listing
+			return
+		}
+		if !h.afterCursor(it, opts.cursor) {
+			return
+		}
+		after++
+
+		if opts.limit > 0 && h.Len() == opts.limit {
+			if !h.less(it, h.top()) {
+				return
+			}
+			heap.Pop(h)
+		}
+		heap.Push(h, it)
+	}
+
+	shadowed := make(map[string]bool, len(virtual))
+	for _, e := range virtual {
+		shadowed[e.Name()] = true
+		add(e)
+	}
+
+	for {
+		batch, err := dir.ReadDir(listReadDirBatch)
+		for _, e := range batch {
+			if !shadowed[e.Name()] && (visible == nil || visible(e)) {
+				add(e)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, 0, false, err
+		}
+	}
+
+	page := make([]*listItem, h.Len())
+	for i := len(page) - 1; i >= 0; i-- {
+		page[i] = heap.Pop(h).(*listItem)
+	}
+
+	for _, it := range page {
+		if it.info == nil {
+			info, err := it.entry.Info()
+			if err != nil {
+				return nil, 0, false, err
+			}
+			it.info = info
+		}
+	}
+
+	return page, total, after > len(page), nil
+}
+
+// listingURL monta a URL da listagem preservando os parâmetros atuais.
+func listingURL(urlPath string, opts listOptions, cursor string) string {
+	q := url.Values{}
+	if opts.sortBy != sortByName {
+		q.Set("sort", opts.sortBy)
+	}
+	if opts.desc {
+		q.Set("order", "desc")
+	}
+	if opts.filter != "" {
+		q.Set("filter", opts.filter)
+	}
+	if opts.limitSet {
+		q.Set("limit", strconv.Itoa(opts.limit))
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+
+	u := url.URL{Path: urlPath, RawQuery: q.Encode()}
+	return u.String()
+}
+
+func newListingInfo(urlPath string, opts listOptions, total int, page []*listItem, hasMore bool) ListingInfo {
+	info := ListingInfo{
+		Sort:   opts.sortBy,
+		Order:  "asc",
+		Filter: opts.filter,
+		Limit:  opts.limit,
+		Total:  total,
+	}
+	if opts.desc {
+		info.Order = "desc"
+	}
+
+	for _, col := range listSortColumns {
+		colOpts := opts
+		colOpts.sortBy = col.sortBy
+		// clicking the active column toggles the order
+		colOpts.desc = col.sortBy == opts.sortBy && !opts.desc
+		info.Columns = append(info.Columns, SortColumn{
+			Label:  col.label,
+			URL:    listingURL(urlPath, colOpts, ""),
+			Active: col.sortBy == opts.sortBy,
+			Desc:   col.sortBy == opts.sortBy && opts.desc,
+		})
+	}
+
+	if opts.cursor != nil {
+		info.FirstURL = listingURL(urlPath, opts, "")
+	}
+	if hasMore && len(page) > 0 {
+		info.NextURL = listingURL(urlPath, opts, page[len(page)-1].key.encode())
+	}
+
+	return info
+}
+
+// dirListing é a listagem enviada em JSON para os clientes que aceitam
+// 'application/json' (ex: o pacote client).
+type dirListing struct {
+	Path    string            `json:"path"`
+	Entries []dirListingEntry `json:"entries"`
+	Total   int               `json:"total"`
+	// Next é a URL da próxima página, vazia na última
+	Next string `json:"next,omitempty"`
+}
+
+type dirListingEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+func newDirListing(urlPath string, page []*listItem, total int) dirListing {
+	listing := dirListing{
+		Path:    urlPath,
+		Entries: make([]dirListingEntry, 0, len(page)),
+		Total:   total,
+	}
+	for _, it := range page {
+		listing.Entries = append(listing.Entries, dirListingEntry{
+			Name:    it.info.Name(),
+			IsDir:   it.info.IsDir(),
+			Size:    it.info.Size(),
+			ModTime: it.info.ModTime(),
+		})
+	}
+	return listing
+}
+
+func acceptsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
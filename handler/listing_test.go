@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newListingTestDir(t *testing.T, n int) string {
+	files := map[string]string{
+		"Docs/":     "",
+		"readme.md": "# readme",
+	}
+	for i := 0; i < n; i++ {
+		// sizes are not in name order: 0, 7, 14, 0, 7, ...
+		files[fmt.Sprintf("file-%03d.txt", i)] = strings.Repeat("x", (i%3)*7)
+	}
+	return writeTestFiles(t, files)
+}
+
+func pageNames(page []*listItem) []string {
+	names := make([]string, 0, len(page))
+	for _, it := range page {
+		names = append(names, it.info.Name())
+	}
+	return names
+}
+
+func readLocalDirPage(t *testing.T, dir string, opts listOptions, virtual []os.DirEntry, visible func(os.DirEntry) bool) ([]*listItem, int, bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return readDirPage(f, opts, virtual, visible)
+}
+
+func TestReadDirPagePagination(t *testing.T) {
+	dir := newListingTestDir(t, 25)
+
+	full, total, hasMore, err := readLocalDirPage(t, dir, listOptions{sortBy: sortBySize, desc: true}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 27 || len(full) != 27 || hasMore {
+		t.Fatalf("readDirPage returned total %v, %v entries, hasMore %v: want 27, 27, false", total, len(full), hasMore)
+	}
+	for i := 1; i < len(full); i++ {
+		if full[i-1].info.Size() < full[i].info.Size() {
+			t.Fatalf("readDirPage did not sort by size desc: %v", pageNames(full))
+		}
+	}
+
+	// walking the pages with the cursor must return the same order as the full listing
+	var walked []string
+	opts := listOptions{sortBy: sortBySize, desc: true, limit: 10}
+	for {
+		page, _, hasMore, err := readLocalDirPage(t, dir, opts, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		walked = append(walked, pageNames(page)...)
+		if !hasMore {
+			break
+		}
+		last := page[len(page)-1].key
+		opts.cursor = &last
+	}
+
+	if strings.Join(walked, ",") != strings.Join(pageNames(full), ",") {
+		t.Fatalf("paginated listing differs from the full listing:\ngot  %v\nwant %v", walked, pageNames(full))
+	}
+}
+
+func TestReadDirPageFilter(t *testing.T) {
+	dir := newListingTestDir(t, 12)
+
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{"file-01", "file-010.txt,file-011.txt"},
+		{"*.MD", "readme.md"},
+		{"docs", "Docs"},
+		{"file-00[12].txt", "file-001.txt,file-002.txt"},
+	}
+
+	for _, tt := range tests {
+		page, _, _, err := readLocalDirPage(t, dir, listOptions{sortBy: sortByName, filter: tt.filter}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(pageNames(page), ","); got != tt.want {
+			t.Fatalf("filter %q: got %v want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestDirListPagination(t *testing.T) {
+	dir := newListingTestDir(t, 5)
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithListPageSize(3))
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	// first page: Docs, file-000.txt, file-001.txt
+	if strings.Contains(body, "file-002.txt") || !strings.Contains(body, "file-001.txt") {
+		t.Fatalf("handler returned more entries than the page size")
+	}
+
+	i := strings.Index(body, "/?cursor=")
+	if i < 0 {
+		t.Fatalf("handler did not return the next page link")
+	}
+	next := body[i : strings.Index(body[i:], `"`)+i]
+	next = strings.Replace(next, "&amp;", "&", -1)
+
+	u, err := url.Parse(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	body = rr.Body.String()
+	if !strings.Contains(body, "file-002.txt") || strings.Contains(body, "file-001.txt") {
+		t.Fatalf("handler returned the wrong second page")
+	}
+
+	req, err = http.NewRequest(http.MethodGet, "/?sort=color", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestDirListJSON(t *testing.T) {
+	dir := newListingTestDir(t, 5)
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithListPageSize(3))
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	if ctype := rr.Header().Get("Content-Type"); ctype != "application/json" {
+		t.Fatalf("handler returned wrong Content-Type: got %v want application/json", ctype)
+	}
+	var listing dirListing
+	if err := json.Unmarshal(rr.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Entries) != 3 || listing.Total != 7 || !strings.HasPrefix(listing.Next, "/?cursor=") {
+		t.Fatalf("handler returned the wrong first page: %+v", listing)
+	}
+	if e := listing.Entries[0]; e.Name != "Docs" || !e.IsDir {
+		t.Fatalf("handler returned wrong first entry: %+v", e)
+	}
+}
+
+func TestParseListOptionsLimit(t *testing.T) {
+	tests := []struct {
+		query        string
+		defaultLimit int
+		want         int
+		wantErr      bool
+	}{
+		{"limit=50", 1000, 50, false},
+		{"limit=0", 1000, 0, true},
+		{"limit=-1", 1000, 0, true},
+		{"limit=1000000", 1000, maxListLimit, false},
+		{"limit=1000000", 0, maxListLimit, false},
+		{"limit=1000000", 50000, 50000, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		opts, err := parseListOptions(query, tt.defaultLimit)
+		if tt.wantErr {
+			if !errors.Is(err, ErrListOptions) {
+				t.Fatalf("%q returned %v, want ErrListOptions", tt.query, err)
+			}
+			continue
+		}
+		if err != nil || opts.limit != tt.want {
+			t.Fatalf("%q with page size %d returned limit %d, %v, want %d", tt.query, tt.defaultLimit, opts.limit, err, tt.want)
+		}
+	}
+}
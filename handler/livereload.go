@@ -0,0 +1,366 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	liveReloadPath          = internalPathPrefix + "livereload"
+	liveReloadDebounce      = 100 * time.Millisecond
+	liveReloadPollInterval  = time.Second
+	liveReloadKeepAlive     = 30 * time.Second
+	liveReloadSubscriberBuf = 8
+)
+
+// liveReloadScript é injetado nas respostas HTML quando o live reload está habilitado.
+// Páginas comuns recarregam em qualquer alteração, já a listagem de diretórios
+// (data-scope="dir") recarrega somente quando o diretório exibido foi alterado.
+const liveReloadScript = `<script data-gouploadserver-livereload>
+(function () {
+  var scope = document.currentScript.getAttribute("data-scope") || "page";
+  var es = new EventSource("` + liveReloadPath + `");
+  es.addEventListener("change", function (evt) {
+    if (scope === "dir") {
+      var data = JSON.parse(evt.data);
+      var dir = data.path.substring(0, data.path.lastIndexOf("/") + 1);
+      if (dir !== decodeURIComponent(location.pathname)) return;
+    }
+    location.reload();
+  });
+})();
+</script>
+`
+
+// liveReloadHub observa o diretório servido e distribui os eventos de alteração
+// para os clientes conectados via Server-Sent Events (SSE).
+type liveReloadHub struct {
+	root string
+	// visible indica se as alterações de um caminho podem ser enviadas aos
+	// clientes, os diretórios que não são visíveis nem são observados
+	visible func(filePath string) bool
+	logger  *logrus.Entry
+
+	mu          sync.Mutex
+	subscribers map[chan string]struct{}
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newLiveReloadHub(root string, visible func(filePath string) bool, logger *logrus.Entry) *liveReloadHub {
+	h := &liveReloadHub{
+		root:        root,
+		visible:     visible,
+		logger:      logger,
+		subscribers: make(map[chan string]struct{}),
+		done:        make(chan struct{}),
+	}
+
+	events := make(chan string)
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		// fsnotify is not recursive, every directory must be watched
+		if err = h.addRecursive(watcher, h.root); err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		// inotify indisponível (ex: limite de watches atingido), usa polling
+		h.logger.Warnf("Live reload: fsnotify unavailable (%s), falling back to polling", err)
+		h.wg.Add(1)
+		go h.poll(events)
+	} else {
+		h.wg.Add(1)
+		go h.watch(watcher, events)
+	}
+
+	h.wg.Add(1)
+	go h.dispatch(events)
+
+	return h
+}
+
+// Close interrompe a observação do diretório e desconecta os clientes.
+func (h *liveReloadHub) Close() {
+	close(h.done)
+	h.wg.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.subscribers {
+		close(c)
+		delete(h.subscribers, c)
+	}
+}
+
+func (h *liveReloadHub) subscribe() chan string {
+	c := make(chan string, liveReloadSubscriberBuf)
+	h.mu.Lock()
+	h.subscribers[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+func (h *liveReloadHub) unsubscribe(c chan string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[c]; ok {
+		delete(h.subscribers, c)
+		close(c)
+	}
+}
+
+// dispatch agrupa rajadas de eventos (ex: editor salvando vários arquivos) e
+// envia o caminho alterado, relativo a raiz, para todos os inscritos.
+func (h *liveReloadHub) dispatch(events <-chan string) {
+	defer h.wg.Done()
+
+	pending := make(map[string]struct{})
+	timer := time.NewTimer(liveReloadDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			timer.Stop()
+			return
+		case p := <-events:
+			if len(pending) == 0 {
+				timer.Reset(liveReloadDebounce)
+			}
+			pending[p] = struct{}{}
+		case <-timer.C:
+			h.mu.Lock()
+			for p := range pending {
+				for c := range h.subscribers {
+					select {
+					case c <- p:
+					default:
+						// slow client, drop the event
+					}
+				}
+				delete(pending, p)
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+func (h *liveReloadHub) urlPath(name string) string {
+	rel, err := filepath.Rel(h.root, name)
+	if err != nil {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+func (h *liveReloadHub) watch(watcher *fsnotify.Watcher, events chan<- string) {
+	defer h.wg.Done()
+	defer watcher.Close()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case evt, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// hidden and excluded paths must not reach the clients
+			if !h.visible(evt.Name) {
+				continue
+			}
+			if evt.Op&fsnotify.Create == fsnotify.Create {
+				if fi, err := os.Stat(evt.Name); err == nil && fi.IsDir() {
+					if err := h.addRecursive(watcher, evt.Name); err != nil {
+						// a directory without a watch would never reload the pages
+						h.logger.Warnf("Live reload: %s, falling back to polling", err)
+						h.wg.Add(1)
+						go h.poll(events)
+						return
+					}
+				}
+			}
+			select {
+			case events <- h.urlPath(evt.Name):
+			case <-h.done:
+				return
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			h.logger.Errorf("Live reload watcher error: %s", err)
+		}
+	}
+}
+
+// addRecursive observa dir e os seus subdiretórios. Retorna o erro do primeiro
+// diretório que não pôde ser observado (ex: limite de watches atingido).
+func (h *liveReloadHub) addRecursive(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// removed while walking
+			return nil
+		}
+		if p != dir && !h.visible(p) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if err := watcher.Add(p); err != nil {
+				return fmt.Errorf("unable to watch %s: %w", p, err)
+			}
+		}
+		return nil
+	})
+}
+
+type pollEntry struct {
+	modTime time.Time
+	size    int64
+}
+
+func (h *liveReloadHub) snapshot() map[string]pollEntry {
+	entries := make(map[string]pollEntry)
+	filepath.Walk(h.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != h.root && !h.visible(p) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entries[p] = pollEntry{info.ModTime(), info.Size()}
+		return nil
+	})
+	return entries
+}
+
+func (h *liveReloadHub) poll(events chan<- string) {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(liveReloadPollInterval)
+	defer ticker.Stop()
+
+	prev := h.snapshot()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+
+		cur := h.snapshot()
+		var changed []string
+		for p, e := range cur {
+			if old, ok := prev[p]; !ok || old != e {
+				changed = append(changed, p)
+			}
+		}
+		for p := range prev {
+			if _, ok := cur[p]; !ok {
+				changed = append(changed, p)
+			}
+		}
+		prev = cur
+
+		for _, p := range changed {
+			select {
+			case events <- h.urlPath(p):
+			case <-h.done:
+				return
+			}
+		}
+	}
+}
+
+// liveReloadVisible indica se as alterações em filePath podem ser enviadas aos
+// clientes do live reload, as mesmas regras das listagens.
+func (s *Server) liveReloadVisible(filePath string) bool {
+	if s.checkAccess(filePath) != nil {
+		return false
+	}
+	return s.listable(filepath.Dir(filePath), filepath.Base(filePath), 0)
+}
+
+// liveReloadHandler mantém uma conexão SSE aberta e envia um evento "change"
+// para cada arquivo alterado.
+func (s *Server) liveReloadHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := s.liveReload.subscribe()
+	defer s.liveReload.unsubscribe(c)
+
+	keepAlive := time.NewTicker(liveReloadKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case p, ok := <-c:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(struct {
+				Path string `json:"path"`
+			}{p})
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
+
+// injectLiveReloadScript insere o script do live reload antes do fechamento do
+// </body>, ou ao final do documento caso a tag não exista.
+func injectLiveReloadScript(html []byte, scope string) []byte {
+	script := []byte(liveReloadScript)
+	if scope != "" {
+		script = bytes.Replace(script, []byte("data-gouploadserver-livereload"),
+			[]byte(`data-gouploadserver-livereload data-scope="`+scope+`"`), 1)
+	}
+
+	i := bytes.LastIndex(bytes.ToLower(html), []byte("</body>"))
+	if i < 0 {
+		return append(html, script...)
+	}
+
+	out := make([]byte, 0, len(html)+len(script))
+	out = append(out, html[:i]...)
+	out = append(out, script...)
+	out = append(out, html[i:]...)
+	return out
+}
+
+func isHTMLContentType(ctype string) bool {
+	return strings.HasPrefix(ctype, "text/html")
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLiveReloadInjectsScript(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/index.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	s := NewServer("../test/plain-html", false, false, logrus.WithField("test", true), WithLiveReload(true))
+	defer s.Close()
+
+	s.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	body := rr.Body.String()
+	i := strings.Index(body, liveReloadPath)
+	if i < 0 || i > strings.LastIndex(body, "</body>") {
+		t.Fatalf("handler did not inject the live reload script before </body>")
+	}
+
+	if length := rr.Header().Get("Content-Length"); length != "" && length != strconv.Itoa(len(body)) {
+		t.Fatalf("handler returned wrong header Content-Length: got %v want %v", length, len(body))
+	}
+}
+
+func TestLiveReloadDisabledDoesNotInject(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/index.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	s := NewServer("../test/plain-html", false, false, logrus.WithField("test", true))
+
+	s.ServeHTTP(rr, req)
+	if strings.Contains(rr.Body.String(), liveReloadPath) {
+		t.Fatalf("handler injected the live reload script with live reload disabled")
+	}
+}
+
+func TestLiveReloadEventStream(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithLiveReload(true),
+		WithAccessPolicy(SymlinksFollowWithinRoot, HiddenHide, []string{"*.key"}))
+	defer s.Close()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + liveReloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("handler returned wrong header Content-Type: got %v want %v", contentType, "text/event-stream")
+	}
+
+	// give the subscriber time to register before touching the directory
+	time.Sleep(50 * time.Millisecond)
+	// hidden and excluded files are written first, their events would arrive before new.txt
+	for _, name := range []string{".env", "server.key"} {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte("secret"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(3 * liveReloadDebounce)
+	if err := ioutil.WriteFile(path.Join(dir, "new.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(res.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("event stream closed before a change event was received")
+			}
+			if strings.HasPrefix(line, "data: ") && strings.Contains(line, `"/new.txt"`) {
+				return
+			}
+			if strings.HasPrefix(line, "data: ") {
+				t.Fatalf("event stream sent a change of a hidden file: %s", line)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the change event")
+		}
+	}
+}
+
+func TestLiveReloadAddRecursiveError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(path.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Skipf("fsnotify is not supported: %s", err)
+	}
+	// a closed watcher fails every Add, like an exhausted inotify watch limit
+	watcher.Close()
+
+	h := &liveReloadHub{root: dir, visible: func(string) bool { return true }, logger: logrus.WithField("test", true)}
+	if err := h.addRecursive(watcher, dir); err == nil {
+		t.Fatal("addRecursive ignored the failed watch")
+	}
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// Mounts serve vários diretórios sob um único servidor. Cada ponto de montagem
+// ('/builds') é atendido por um Server com a sua própria raiz e opções, e
+// aparece como um diretório virtual na listagem da raiz. As demais requisições,
+// incluindo as rotas internas, são atendidas pelo Server da raiz, por isso o
+// live reload e a busca ficam desabilitados nos pontos de montagem.
+type Mounts struct {
+	root   *Server
+	mounts map[string]*Server
+}
+
+// mountedDir é um ponto de montagem exibido na listagem da raiz.
+type mountedDir struct {
+	name   string
+	server *Server
+}
+
+func NewMounts(root *Server) *Mounts {
+	return &Mounts{
+		root:   root,
+		mounts: make(map[string]*Server),
+	}
+}
+
+// Add monta s em prefix. O prefixo é um único segmento do caminho (ex: '/builds')
+// e as URLs abaixo dele são resolvidas a partir da raiz de s.
+func (m *Mounts) Add(prefix string, s *Server) error {
+	name := strings.Trim(prefix, "/")
+	switch {
+	case name == "" || strings.Contains(name, "/") || name == "." || name == "..":
+		return fmt.Errorf("%w: %q, expected a single path segment like '/builds'", ErrMountPoint, prefix)
+	case "/"+name+"/" == internalPathPrefix:
+		return fmt.Errorf("%w: %q is reserved", ErrMountPoint, prefix)
+	}
+	if _, ok := m.mounts[name]; ok {
+		return fmt.Errorf("%w: duplicate mount %q", ErrMountPoint, prefix)
+	}
+	if fi, err := s.storage.Stat(""); err != nil || !fi.IsDir() {
+		return fmt.Errorf("%w: %q: %s is not a directory", ErrMountPoint, prefix, s.staticDirPath)
+	}
+
+	s.mountPath = "/" + name
+	// the uploads of every mount are listed by the root
+	s.uploads.Close()
+	s.uploads = m.root.uploads
+	// the internal routes of the root only know its own tree
+	if s.liveReload != nil {
+		s.logger.Warnf("Live reload is not available for the mount %s, disabling it", s.mountPath)
+		s.liveReload.Close()
+		s.liveReload = nil
+	}
+	if s.searchEnabled {
+		s.logger.Warnf("Search is not available for the mount %s, disabling it", s.mountPath)
+		s.searchEnabled = false
+		s.searchIndex = nil
+	}
+	m.mounts[name] = s
+	m.root.mountedDirs = append(m.root.mountedDirs, mountedDir{name, s})
+	return nil
+}
+
+func (m *Mounts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, internalPathPrefix) {
+		name := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+		if s, ok := m.mounts[name]; ok {
+			s.ServeHTTP(w, r)
+			return
+		}
+	}
+	m.root.ServeHTTP(w, r)
+}
+
+// Close libera os recursos da raiz e de todos os pontos de montagem.
+func (m *Mounts) Close() error {
+	for _, s := range m.mounts {
+		s.Close()
+	}
+	return m.root.Close()
+}
+
+// relPath retorna o caminho de urlPath relativo à raiz do Server, sempre
+// começando com '/' e sem segmentos '..', assim não é possível sair da raiz.
+func (s *Server) relPath(urlPath string) string {
+	if s.mountPath != "" && (urlPath == s.mountPath || strings.HasPrefix(urlPath, s.mountPath+"/")) {
+		urlPath = urlPath[len(s.mountPath):]
+	}
+	return path.Clean("/" + urlPath)
+}
+
+// localPath resolve urlPath para o caminho do arquivo dentro do staticDirPath.
+func (s *Server) localPath(urlPath string) string {
+	return path.Join(s.staticDirPath, s.relPath(urlPath))
+}
+
+// mountedDirEntries retorna os pontos de montagem como entradas de diretório
+// para a listagem da raiz. Montagens cujo diretório não existe mais são omitidas.
+func (s *Server) mountedDirEntries() []os.DirEntry {
+	var entries []os.DirEntry
+	for _, m := range s.mountedDirs {
+		fi, err := m.server.storage.Stat("")
+		if err != nil || !fi.IsDir() {
+			s.logger.Errorf("Mount /%s: %s is not available", m.name, m.server.staticDirPath)
+			continue
+		}
+		entries = append(entries, mountedDirEntry{mountedFileInfo{fi, m.name}})
+	}
+	return entries
+}
+
+// mountedFileInfo é o os.FileInfo da raiz de um ponto de montagem com o nome do prefixo.
+type mountedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi mountedFileInfo) Name() string { return fi.name }
+
+type mountedDirEntry struct {
+	info mountedFileInfo
+}
+
+func (e mountedDirEntry) Name() string               { return e.info.name }
+func (e mountedDirEntry) IsDir() bool                { return true }
+func (e mountedDirEntry) Type() os.FileMode          { return os.ModeDir }
+func (e mountedDirEntry) Info() (os.FileInfo, error) { return e.info, nil }
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMounts(t *testing.T) {
+	base := writeTestFiles(t, map[string]string{
+		"root/name.txt":       "root",
+		"root/builds/old.txt": "shadowed",
+		"builds/name.txt":     "builds",
+		"uploads/name.txt":    "uploads",
+		"secret.txt":          "secret",
+	})
+
+	logger := logrus.WithField("test", true)
+	ms := NewMounts(NewServer(path.Join(base, "root"), true, false, logger))
+	defer ms.Close()
+	if err := ms.Add("/builds", NewServer(path.Join(base, "builds"), true, false, logger, WithUploads(false))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Add("/uploads/", NewServer(path.Join(base, "uploads"), true, false, logger)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{"/", "/a/b", "/..", "/_gouploadserver", "/builds"} {
+		err := ms.Add(prefix, NewServer(base, false, false, logger))
+		if !errors.Is(err, ErrMountPoint) {
+			t.Fatalf("%q: expected ErrMountPoint, got %v", prefix, err)
+		}
+	}
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/name.txt", http.StatusOK, "root"},
+		{"/builds/name.txt", http.StatusOK, "builds"},
+		{"/uploads/name.txt", http.StatusOK, "uploads"},
+		{"/builds/old.txt", http.StatusNotFound, ""},
+		{"/builds", http.StatusFound, ""},
+		{"/../secret.txt", http.StatusNotFound, ""},
+		{"/builds/../secret.txt", http.StatusNotFound, ""},
+		{"/builds/../../secret.txt", http.StatusNotFound, ""},
+		{"/uploads/../builds/name.txt", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// set the raw path, NewRequest would clean the '..' segments
+		req.URL.Path = tt.url
+		rr := httptest.NewRecorder()
+		ms.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tt.status {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", tt.url, status, tt.status)
+		}
+		if tt.body != "" && rr.Body.String() != tt.body {
+			t.Fatalf("%s: handler returned wrong body: got %v want %v", tt.url, rr.Body.String(), tt.body)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	ms.ServeHTTP(rr, req)
+	for _, link := range []string{`href="builds/"`, `href="uploads/"`, `href="name.txt?preview"`} {
+		if !strings.Contains(rr.Body.String(), link) {
+			t.Fatalf("root listing does not contain %s", link)
+		}
+	}
+
+	upload := func(url string) int {
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		fw, err := w.CreateFormFile("file", "new.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte("new"))
+		w.Close()
+
+		req, err := http.NewRequest(http.MethodPost, "/", &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.URL.Path = url
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		rr := httptest.NewRecorder()
+		ms.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if status := upload("/uploads/../../"); status != http.StatusOK {
+		t.Fatalf("upload returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if _, err := os.Stat(path.Join(base, "uploads/new.txt")); err != nil {
+		t.Fatalf("upload was not confined to the mount root: %s", err)
+	}
+	if status := upload("/builds/"); status != http.StatusMethodNotAllowed {
+		t.Fatalf("upload to a read only mount returned %v, want %v", status, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestMountsInternalRoutes(t *testing.T) {
+	base := writeTestFiles(t, map[string]string{
+		"root/name.txt":   "root",
+		"builds/name.txt": "builds",
+	})
+	logger := logrus.WithField("test", true)
+	ms := NewMounts(NewServer(path.Join(base, "root"), false, false, logger, WithLiveReload(true), WithSearch(true, false)))
+	defer ms.Close()
+	if err := ms.Add("/builds", NewServer(path.Join(base, "builds"), false, false, logger, WithLiveReload(true), WithSearch(true, false))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the internal routes are served by the root, so the mount pages must not use them
+	rr := httptest.NewRecorder()
+	ms.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/builds/", nil))
+	if body := rr.Body.String(); strings.Contains(body, liveReloadPath) || strings.Contains(body, searchPath) {
+		t.Fatalf("the mount listing refers to the internal routes of the root:\n%s", body)
+	}
+	rr = httptest.NewRecorder()
+	ms.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := rr.Body.String(); !strings.Contains(body, liveReloadPath) || !strings.Contains(body, searchPath) {
+		t.Fatal("the root listing must keep the live reload and the search")
+	}
+}
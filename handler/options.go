@@ -0,0 +1,374 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
+)
+
+// Option configura funcionalidades opcionais do Server.
+// As opções são aplicadas em ordem por NewServer antes do registro das rotas.
+type Option func(*Server)
+
+// WithLiveReload habilita o modo live reload, que observa o staticDirPath e
+// notifica os navegadores conectados quando algum arquivo é alterado.
+func WithLiveReload(enabled bool) Option {
+	return func(s *Server) {
+		s.liveReloadEnabled = enabled
+	}
+}
+
+// WithSpaEnv injeta o objeto window.__ENV__ no 'index.html' servido no modo SPA.
+// O objeto é montado a partir das variáveis de ambiente que começam com um dos
+// prefixes (ex: VUE_APP_) e do arquivo JSON envFile, quando informado.
+// Com placeholders habilitado, ocorrências de '%%NAME%%' (texto do HTML) e de
+// '%%json:NAME%%' (dentro de scripts) no 'index.html' também são substituídas
+// pelos mesmos valores.
+func WithSpaEnv(prefixes []string, envFile string, placeholders bool) Option {
+	return func(s *Server) {
+		s.spaEnv = spaEnv{
+			prefixes:     prefixes,
+			file:         envFile,
+			placeholders: placeholders,
+		}
+	}
+}
+
+// WithRuleFiles habilita a leitura dos arquivos '_redirects' e '_headers' da raiz
+// do staticDirPath, no mesmo formato usado pelo Netlify. Os arquivos são
+// recarregados automaticamente quando alterados.
+func WithRuleFiles(enabled bool) Option {
+	return func(s *Server) {
+		s.ruleFilesEnabled = enabled
+	}
+}
+
+// WithCompression configura o envio de conteúdo comprimido (brotli e gzip).
+// Com precompressed, os arquivos irmãos '.br' e '.gz' são enviados quando o
+// Accept-Encoding permite. Com dynamic, os MIME types compressíveis maiores que
+// minSize bytes são comprimidos em streaming, e as saídas ficam em um cache de
+// até cacheBytes bytes indexado pelo mtime dos arquivos.
+func WithCompression(precompressed bool, dynamic bool, minSize int64, cacheBytes int64) Option {
+	return func(s *Server) {
+		s.compression = compression{
+			precompressed: precompressed,
+			dynamic:       dynamic,
+			minSize:       minSize,
+		}
+		if dynamic && cacheBytes > 0 {
+			s.compression.cache = newCompressedCache(cacheBytes)
+		}
+	}
+}
+
+// WithCachePolicy habilita o envio do header Cache-Control. Cada regra tem o
+// formato 'pattern=value', onde pattern é um glob (ex: '*.woff2', 'img/**') ou
+// uma regex iniciada por '~'. Sem regra correspondente, arquivos com hash no
+// nome recebem 'immutable' e arquivos HTML recebem 'no-cache'. Regras inválidas
+// são ignoradas e registradas no log.
+func WithCachePolicy(enabled bool, rules []string) Option {
+	return func(s *Server) {
+		s.cachePolicy = cachePolicy{enabled: enabled}
+		WithCacheRules(rules)(s)
+	}
+}
+
+// WithCacheRules adiciona regras de Cache-Control com prioridade sobre as já
+// configuradas, usado para especializar as regras globais em um site.
+func WithCacheRules(rules []string) Option {
+	return func(s *Server) {
+		var parsed []cacheRule
+		for _, rule := range rules {
+			r, err := parseCacheRule(rule)
+			if err != nil {
+				s.logger.Error(err)
+				continue
+			}
+			parsed = append(parsed, r)
+		}
+		s.cachePolicy.rules = append(parsed, s.cachePolicy.rules...)
+	}
+}
+
+// WithUploads habilita ou desabilita o envio de arquivos no navegador de arquivos.
+func WithUploads(enabled bool) Option {
+	return func(s *Server) {
+		s.uploadEnabled = enabled
+	}
+}
+
+// WithUploadIdleTimeout aborta os uploads que ficam sem receber dados por
+// timeout. Zero desabilita o tempo limite.
+func WithUploadIdleTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.uploadIdleTimeout = timeout
+	}
+}
+
+// WithUploadProgress habilita a lista dos uploads em andamento em
+// '/_gouploadserver/uploads', somente com os uploads habilitados e fora do modo SPA.
+func WithUploadProgress(enabled bool) Option {
+	return func(s *Server) {
+		s.uploadProgressEnabled = enabled
+	}
+}
+
+// WithMaxResumableUploadSize limita o 'Upload-Length' dos uploads retomáveis
+// a size bytes.
+func WithMaxResumableUploadSize(size int64) Option {
+	return func(s *Server) {
+		if size > 0 {
+			s.resumableMaxLength = size
+		}
+	}
+}
+
+// WithBandwidthLimits limita a banda dos downloads e uploads com regras
+// 'direction[/scope]=rate' (ex: 'download=10MiB', 'upload/ip=1MB', 'download/user=512KiB').
+// O escopo global é o padrão e os escopos ip e user limitam cada cliente
+// separadamente. Os limites são compartilhados por todos os Servers que recebem
+// esta Option. Regras inválidas são ignoradas e registradas no log.
+func WithBandwidthLimits(limits []string) Option {
+	var once sync.Once
+	var limiter *bandwidthLimiter
+	return func(s *Server) {
+		once.Do(func() {
+			var rules []bandwidthRule
+			for _, limit := range limits {
+				rule, err := parseBandwidthRule(limit)
+				if err != nil {
+					s.logger.Error(err)
+					continue
+				}
+				rules = append(rules, rule)
+			}
+			if len(rules) > 0 {
+				limiter = newBandwidthLimiter(rules)
+			}
+		})
+		s.bandwidth = limiter
+	}
+}
+
+// WithTransferLimit limita a perClient os downloads e uploads simultâneos de
+// cada IP. As transferências excedentes esperam por uma vaga por até
+// queueTimeout e depois recebem 429 com 'Retry-After'. Zero em perClient
+// desabilita o limite e zero em queueTimeout responde 429 sem esperar.
+func WithTransferLimit(perClient int, queueTimeout time.Duration) Option {
+	var slots *transferSlots
+	if perClient > 0 {
+		slots = newTransferSlots(perClient, queueTimeout)
+	}
+	return func(s *Server) {
+		s.transfers = slots
+	}
+}
+
+// WithTrustedProxies aceita os headers de encaminhamento (Forwarded,
+// X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host e X-Real-IP) das
+// conexões vindas das redes cidrs (ex: '10.0.0.0/8' ou um único IP). O IP, o
+// esquema e o host reais do cliente são usados nos logs, nos limites por
+// cliente e nos redirects. Valores inválidos são ignorados e registrados no log.
+func WithTrustedProxies(cidrs []string) Option {
+	return func(s *Server) {
+		s.trustedProxies = nil
+		for _, cidr := range cidrs {
+			ipnet, err := parseTrustedProxy(cidr)
+			if err != nil {
+				s.logger.Error(err)
+				continue
+			}
+			s.trustedProxies = append(s.trustedProxies, ipnet)
+		}
+	}
+}
+
+// WithForceHTTPS redireciona para https as requisições feitas por http. Atrás
+// de um proxy reverso o esquema vem do 'X-Forwarded-Proto' ou do 'Forwarded',
+// que exigem o proxy em WithTrustedProxies.
+func WithForceHTTPS(enabled bool) Option {
+	return func(s *Server) {
+		s.forceHTTPS = enabled
+	}
+}
+
+// WithAccessList aceita ou recusa as requisições pelo IP real do cliente,
+// método e caminho, com regras 'allow|deny cidrs [methods] [path]' (ex:
+// 'allow 10.0.0.0/8 write /uploads/**' seguida de 'deny all write'). As regras
+// de rules e depois as do arquivo file são avaliadas em ordem e a primeira que
+// casa decide. O arquivo é relido quando alterado. Regras inválidas em rules
+// são registradas no log e tratadas como 'deny all', e um arquivo que nunca
+// pôde ser lido recusa todas as requisições. As requisições recusadas recebem
+// 403 com a página 'forbidden.html'. A lista também vale para a API do S3 e
+// para o SFTP, que usa o endereço da conexão.
+func WithAccessList(rules []string, file string) Option {
+	var once sync.Once
+	var list *accessList
+	return func(s *Server) {
+		once.Do(func() {
+			if len(rules) == 0 && file == "" {
+				return
+			}
+			var parsed []aclRule
+			for _, line := range rules {
+				rule, err := parseACLRule(line)
+				if err != nil {
+					s.logger.Error(err)
+					rule = aclRule{line: "deny all (" + line + ")"}
+				}
+				parsed = append(parsed, rule)
+			}
+			list = newAccessList(parsed, file, s.logger.WithField("server", "access-list"))
+		})
+		s.accessList = list
+	}
+}
+
+// WithRateLimit limita cada IP a requests requisições por window e bloqueia
+// por lockout os IPs com authFailures falhas de autenticação seguidas. O
+// bloqueio dobra a cada nova série de falhas, até 24 horas. Zero em requests
+// ou em authFailures desabilita o respectivo limite. O estado é compartilhado
+// por todos os Servers que recebem esta Option.
+func WithRateLimit(requests int, window time.Duration, authFailures int, lockout time.Duration) Option {
+	var once sync.Once
+	var limiter *rateLimiter
+	return func(s *Server) {
+		once.Do(func() {
+			if requests > 0 && window <= 0 {
+				s.logger.Errorf("Invalid rate limit window %s, requests are not limited", window)
+				requests = 0
+			}
+			if requests > 0 || authFailures > 0 {
+				limiter = newRateLimiter(requests, window, authFailures, lockout, s.logger.WithField("server", "rate-limit"))
+			}
+		})
+		s.rateLimit = limiter
+	}
+}
+
+// WithBasicAuth exige HTTP Basic Authentication com uma das credentials no
+// formato 'user:password'. Credenciais inválidas são ignoradas e registradas no log.
+func WithBasicAuth(credentials []string) Option {
+	return func(s *Server) {
+		for _, credential := range credentials {
+			user, password, err := parseCredential(credential)
+			if err != nil {
+				s.logger.Error(err)
+				continue
+			}
+			if s.auth == nil {
+				s.auth = &basicAuth{}
+			}
+			s.auth.add(user, password)
+		}
+	}
+}
+
+// WithTemplates usa os arquivos de dir ('layout.html', 'list.html', 'error.html',
+// 'upload.html' e 'assets/*') no lugar dos templates padrão. Arquivos ausentes
+// continuam usando o padrão embutido. Com reload os templates são relidos a
+// cada requisição, útil durante o desenvolvimento de um tema.
+func WithTemplates(dir string, reload bool) Option {
+	return func(s *Server) {
+		s.templates.dir = dir
+		s.templates.reload = reload
+	}
+}
+
+// WithListPageSize define a quantidade padrão de entradas por página na listagem
+// de diretórios. Com 0 todas as entradas são exibidas em uma única página.
+func WithListPageSize(n int) Option {
+	return func(s *Server) {
+		s.listPageSize = n
+	}
+}
+
+// WithThumbnails habilita as miniaturas de imagens JPEG, PNG e GIF em
+// '?thumb=256' e a visualização em grade da listagem. As miniaturas são
+// guardadas em cacheDir e no máximo workers imagens são decodificadas ao mesmo tempo.
+func WithThumbnails(enabled bool, cacheDir string, workers int) Option {
+	return func(s *Server) {
+		if enabled {
+			s.thumbnails = newThumbnailer(cacheDir, workers)
+		}
+	}
+}
+
+// WithIndexFiles envia o primeiro arquivo de names (ex: 'index.html', 'index.htm')
+// existente no diretório no lugar da listagem, permitindo servir sites estáticos.
+func WithIndexFiles(names []string) Option {
+	return func(s *Server) {
+		s.indexFiles = names
+	}
+}
+
+// WithCleanURLs resolve URLs sem extensão para o arquivo '.html' correspondente
+// (ex: '/about' envia 'about.html') quando o caminho não existe.
+func WithCleanURLs(enabled bool) Option {
+	return func(s *Server) {
+		s.cleanURLs = enabled
+	}
+}
+
+// WithNotFoundPage usa o arquivo name, relativo ao staticDirPath, como
+// resposta 404 quando ele existir (ex: '404.html').
+func WithNotFoundPage(name string) Option {
+	return func(s *Server) {
+		s.notFoundPage = name
+	}
+}
+
+// WithSearch habilita a busca recursiva por nome e conteúdo em
+// '/_gouploadserver/search' (somente fora do modo SPA). Com indexed, um índice
+// em memória dos caminhos é mantido para acelerar as buscas repetidas.
+func WithSearch(enabled bool, indexed bool) Option {
+	return func(s *Server) {
+		s.searchEnabled = enabled
+		s.searchIndexed = indexed
+	}
+}
+
+// WithAccessPolicy define como os links simbólicos (SymlinksFollow,
+// SymlinksFollowWithinRoot, SymlinksDeny) e os arquivos ocultos (HiddenShow,
+// HiddenHide, HiddenDeny) são tratados, e os globs exclude (ex: '.git/**',
+// '*.key') que nunca são servidos, listados ou aceitos como destino de upload.
+// Políticas desconhecidas são registradas no log e tratadas como deny.
+func WithAccessPolicy(symlinks string, hidden string, exclude []string) Option {
+	return func(s *Server) {
+		switch symlinks {
+		case SymlinksFollow, SymlinksFollowWithinRoot, SymlinksDeny:
+			s.access.symlinks = symlinks
+		default:
+			s.logger.Errorf("Unknown symlinks policy %q, using %q", symlinks, SymlinksDeny)
+			s.access.symlinks = SymlinksDeny
+		}
+
+		switch hidden {
+		case HiddenShow, HiddenHide, HiddenDeny:
+			s.access.hidden = hidden
+		default:
+			s.logger.Errorf("Unknown hidden files policy %q, using %q", hidden, HiddenDeny)
+			s.access.hidden = HiddenDeny
+		}
+
+		for _, pattern := range exclude {
+			g, err := compileGlob(pattern)
+			if err != nil {
+				s.logger.Errorf("Exclude pattern %q: %s", pattern, err)
+				continue
+			}
+			s.access.exclude = append(s.access.exclude, g)
+		}
+	}
+}
+
+// WithStorage troca o disco local pelo Storage st (ex: memória ou S3). O
+// staticDirPath continua identificando os arquivos nos logs e nas regras, mas
+// o conteúdo é lido e gravado em st. A busca e o live reload dependem do disco
+// local e são desabilitados para os demais armazenamentos.
+func WithStorage(st storage.Storage) Option {
+	return func(s *Server) {
+		s.storage = st
+	}
+}
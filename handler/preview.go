@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+)
+
+const (
+	previewMarkdown = "markdown"
+	previewCode     = "code"
+	previewImage    = "image"
+	previewAudio    = "audio"
+	previewVideo    = "video"
+	previewPDF      = "pdf"
+	previewCSV      = "csv"
+	previewJSON     = "json"
+	// previewNone é usado para arquivos binários ou grandes demais, a página
+	// exibe somente o link para download
+	previewNone = "none"
+
+	// maxPreviewBytes limita os arquivos lidos em memória para gerar a pré-visualização
+	maxPreviewBytes = 2 << 20
+	// maxPreviewCSVRows limita as linhas exibidas na tabela de um CSV
+	maxPreviewCSVRows = 1000
+)
+
+// readmeNames são os arquivos exibidos abaixo da listagem do diretório, em ordem de prioridade.
+var readmeNames = []string{"README.md", "readme.md", "Readme.md", "README.markdown"}
+
+// markdown não renderiza HTML bruto nem links 'javascript:', o que é suficiente
+// para exibir arquivos de terceiros com segurança.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+// Preview descreve a pré-visualização de um arquivo em 'preview.html'.
+type Preview struct {
+	// Kind é o tipo do visualizador: markdown, code, image, audio, video, pdf, csv, json ou none
+	Kind        string
+	Name        string
+	ContentType string
+	Size        int64
+	// RawURL é a URL do arquivo original, usada no visualizador e no link de download
+	RawURL string
+	// HTML é o conteúdo já renderizado (markdown, code e json)
+	HTML template.HTML
+	// Header e Rows são a tabela de um CSV
+	Header []string
+	Rows   [][]string
+	// Truncated indica que somente parte do arquivo foi exibida
+	Truncated bool
+	// Message explica por que não há pré-visualização (previewNone)
+	Message string
+}
+
+func wantsPreview(r *http.Request) bool {
+	_, ok := r.URL.Query()["preview"]
+	return ok
+}
+
+// previewKindByName escolhe o visualizador pela extensão do arquivo. Retorna
+// "" quando a extensão não é conhecida.
+func previewKindByName(name string) string {
+	switch ext := strings.ToLower(path.Ext(name)); ext {
+	case ".md", ".markdown":
+		return previewMarkdown
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg", ".bmp", ".ico", ".avif":
+		return previewImage
+	case ".mp3", ".wav", ".ogg", ".oga", ".flac", ".m4a", ".aac", ".opus":
+		return previewAudio
+	case ".mp4", ".webm", ".ogv", ".mov", ".m4v":
+		return previewVideo
+	case ".pdf":
+		return previewPDF
+	case ".csv", ".tsv":
+		return previewCSV
+	case ".json", ".geojson", ".webmanifest":
+		return previewJSON
+	}
+	if lexers.Match(name) != nil {
+		return previewCode
+	}
+	return ""
+}
+
+// previewKind completa previewKindByName com o Content-Type, assim arquivos
+// de texto sem extensão conhecida (ex: 'Makefile' ou 'LICENSE') também são exibidos.
+func previewKind(name string, ctype string) string {
+	if kind := previewKindByName(name); kind != "" {
+		return kind
+	}
+	mediaType := strings.SplitN(ctype, ";", 2)[0]
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return previewImage
+	case strings.HasPrefix(mediaType, "audio/"):
+		return previewAudio
+	case strings.HasPrefix(mediaType, "video/"):
+		return previewVideo
+	case mediaType == "application/pdf":
+		return previewPDF
+	case strings.HasPrefix(mediaType, "text/") || IsCompressible(mediaType):
+		return previewCode
+	}
+	return previewNone
+}
+
+// sendPreviewToClient envia a página 'preview.html' para o arquivo filepath.
+func (s *Server) sendPreviewToClient(w http.ResponseWriter, r *http.Request, filepath string) error {
+	fileinfo, err := s.stat(filepath)
+	if err != nil {
+		return err
+	}
+	if !fileinfo.Mode().IsRegular() {
+		return ErrFileIsNotRegular
+	}
+
+	f, err := s.open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctype, err := getContentType(filepath, f, make([]byte, 512))
+	if err != nil {
+		return fmt.Errorf("Get Content-Type error: %w", err)
+	}
+
+	name := path.Base(filepath)
+	p := &Preview{
+		Kind:        previewKind(name, ctype),
+		Name:        name,
+		ContentType: ctype,
+		Size:        fileinfo.Size(),
+		RawURL:      (&url.URL{Path: name}).String(),
+	}
+
+	switch p.Kind {
+	case previewMarkdown, previewCode, previewCSV, previewJSON:
+		if p.Size > maxPreviewBytes {
+			p.Kind = previewNone
+			p.Message = fmt.Sprintf("Arquivo grande demais para pré-visualização (máximo %s)", formatBytes(maxPreviewBytes))
+			break
+		}
+		content, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		if err := renderPreviewContent(p, content); err != nil {
+			// invalid CSV or JSON falls back to the source view
+			s.logger.Debugf("Preview %s as %s: %s", filepath, p.Kind, err)
+			p.Kind = previewCode
+			if err := renderPreviewContent(p, content); err != nil {
+				return err
+			}
+		}
+	case previewNone:
+		p.Message = "Pré-visualização não disponível para este tipo de arquivo"
+	}
+
+	data := s.newPageData(strings.TrimSuffix(path.Dir(r.URL.Path), "/") + "/")
+	data.Title = name
+	data.Preview = p
+
+	s.setCacheControl(w, filepath, "text/html; charset=utf-8")
+	return s.renderPage(w, r, http.StatusOK, templatePreview, data)
+}
+
+func renderPreviewContent(p *Preview, content []byte) error {
+	switch p.Kind {
+	case previewMarkdown:
+		html, err := renderMarkdown(content)
+		if err != nil {
+			return err
+		}
+		p.HTML = html
+	case previewCSV:
+		comma := ','
+		if strings.EqualFold(path.Ext(p.Name), ".tsv") {
+			comma = '\t'
+		}
+		header, rows, truncated, err := readCSVPreview(bytes.NewReader(content), comma)
+		if err != nil {
+			return err
+		}
+		p.Header, p.Rows, p.Truncated = header, rows, truncated
+	case previewJSON:
+		html, err := renderJSONTree(content)
+		if err != nil {
+			return err
+		}
+		p.HTML = html
+	default:
+		html, err := highlightSource(p.Name, content)
+		if err != nil {
+			return err
+		}
+		p.HTML = html
+	}
+	return nil
+}
+
+func renderMarkdown(content []byte) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert(content, &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// highlightSource gera o HTML do código fonte com números de linha e estilos inline.
+func highlightSource(name string, content []byte) (template.HTML, error) {
+	lexer := lexers.Match(name)
+	if lexer == nil {
+		lexer = lexers.Analyse(string(content))
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	it, err := lexer.Tokenise(nil, string(content))
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	formatter := chromahtml.New(chromahtml.WithLineNumbers(true), chromahtml.TabWidth(4))
+	if err := formatter.Format(&buf, styles.Get("github"), it); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// readCSVPreview lê até maxPreviewCSVRows linhas. A primeira linha é usada como cabeçalho.
+func readCSVPreview(r io.Reader, comma rune) ([]string, [][]string, bool, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, false, nil
+		}
+		return nil, nil, false, err
+	}
+
+	var rows [][]string
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return header, rows, false, nil
+		}
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if len(rows) == maxPreviewCSVRows {
+			return header, rows, true, nil
+		}
+		rows = append(rows, record)
+	}
+}
+
+// renderJSONTree gera uma árvore de elementos <details> a partir do JSON,
+// lendo os tokens em sequência para preservar a ordem original das chaves.
+func renderJSONTree(content []byte) (template.HTML, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	buf.WriteString(`<div class="json-tree">`)
+	if err := writeJSONValue(&buf, dec, ""); err != nil {
+		return "", err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return "", errors.New("invalid JSON: trailing data")
+	}
+	buf.WriteString(`</div>`)
+	return template.HTML(buf.String()), nil
+}
+
+func writeJSONValue(buf *bytes.Buffer, dec *json.Decoder, key string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	label := ""
+	if key != "" {
+		label = `<span class="json-key">` + template.HTMLEscapeString(key) + `</span>: `
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		buf.WriteString(`<div class="json-leaf">` + label)
+		writeJSONScalar(buf, tok)
+		buf.WriteString(`</div>`)
+		return nil
+	}
+
+	open, close := "{", "}"
+	if delim == '[' {
+		open, close = "[", "]"
+	}
+	buf.WriteString(`<details open><summary>` + label + open + `</summary>`)
+
+	for i := 0; dec.More(); i++ {
+		childKey := fmt.Sprint(i)
+		if delim == '{' {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			childKey = fmt.Sprint(keyTok)
+		}
+		if err := writeJSONValue(buf, dec, childKey); err != nil {
+			return err
+		}
+	}
+	// consume the closing delimiter
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	buf.WriteString(`</details><div class="json-leaf">` + close + `</div>`)
+	return nil
+}
+
+func writeJSONScalar(buf *bytes.Buffer, tok json.Token) {
+	switch v := tok.(type) {
+	case string:
+		var quoted bytes.Buffer
+		enc := json.NewEncoder(&quoted)
+		enc.SetEscapeHTML(false) // the string is escaped as HTML below
+		enc.Encode(v)
+		buf.WriteString(`<span class="json-string">` + template.HTMLEscapeString(strings.TrimSpace(quoted.String())) + `</span>`)
+	case json.Number:
+		buf.WriteString(`<span class="json-number">` + v.String() + `</span>`)
+	case bool:
+		buf.WriteString(`<span class="json-bool">` + fmt.Sprint(v) + `</span>`)
+	default:
+		buf.WriteString(`<span class="json-null">null</span>`)
+	}
+}
+
+// readmeHTML renderiza o primeiro README encontrado em dirpath. Retorna "" quando
+// o diretório não possui README ou ele é grande demais.
+func (s *Server) readmeHTML(dirpath string) (template.HTML, error) {
+	for _, name := range readmeNames {
+		if s.checkAccess(path.Join(dirpath, name)) != nil {
+			continue
+		}
+		fi, err := s.stat(path.Join(dirpath, name))
+		if err != nil || !fi.Mode().IsRegular() || fi.Size() > maxPreviewBytes {
+			continue
+		}
+		content, err := s.readFile(path.Join(dirpath, name))
+		if err != nil {
+			return "", err
+		}
+		return renderMarkdown(content)
+	}
+	return "", nil
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newPreviewTestDir(t *testing.T) string {
+	return writeTestFiles(t, map[string]string{
+		"README.md":   "# Hello\n\n<script>alert(1)</script>\n\n[x](javascript:alert(1))\n",
+		"main.go":     "package main\n\nfunc main() {}\n",
+		"data.csv":    "name,size\na.txt,10\nb.txt,20\n",
+		"data.json":   `{"zeta": 1, "alpha": ["<b>", true, null]}`,
+		"broken.json": `{"a":`,
+		"photo.png":   "\x89PNG\r\n\x1a\n",
+		"blob.bin":    "\x00\x01\x02\x03",
+	})
+}
+
+func TestPreviewHandler(t *testing.T) {
+	dir := newPreviewTestDir(t)
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true))
+
+	tests := []struct {
+		url      string
+		contains []string
+		excludes []string
+	}{
+		{"/README.md?preview", []string{`preview--markdown`, `<h1 id="hello">Hello</h1>`}, []string{"<script>alert", "javascript:"}},
+		{"/main.go?preview", []string{`preview--code`, "package", "func"}, nil},
+		{"/data.csv?preview", []string{`preview--csv`, "<th>name</th>", "<td>b.txt</td>"}, nil},
+		{"/data.json?preview", []string{`preview--json`, `json-key">zeta`, "&lt;b&gt;"}, []string{"<b>"}},
+		{"/broken.json?preview", []string{`preview--code`}, nil},
+		{"/photo.png?preview", []string{`<img src="photo.png"`}, nil},
+		{"/blob.bin?preview", []string{`preview--none`, `href="blob.bin" download`}, nil},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", tt.url, status, http.StatusOK)
+		}
+		body := rr.Body.String()
+		for _, want := range tt.contains {
+			if !strings.Contains(body, want) {
+				t.Fatalf("%s: body does not contain %q:\n%s", tt.url, want, body)
+			}
+		}
+		for _, unwanted := range tt.excludes {
+			if strings.Contains(body, unwanted) {
+				t.Fatalf("%s: body contains %q", tt.url, unwanted)
+			}
+		}
+	}
+
+	// the JSON tree keeps the original key order
+	req, _ := http.NewRequest(http.MethodGet, "/data.json?preview", nil)
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if body := rr.Body.String(); strings.Index(body, "zeta") > strings.Index(body, "alpha") {
+		t.Fatalf("JSON tree did not keep the key order")
+	}
+}
+
+func TestDirListReadme(t *testing.T) {
+	dir := newPreviewTestDir(t)
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true))
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `<h1 id="hello">Hello</h1>`) {
+		t.Fatalf("listing does not render the README")
+	}
+	if !strings.Contains(body, `href="main.go?preview"`) || !strings.Contains(body, `href="blob.bin"`) {
+		t.Fatalf("listing does not link previewable files to the preview page")
+	}
+}
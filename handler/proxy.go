@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ipNets é uma lista de redes, usada pelos proxies confiáveis e pelas listas de acesso.
+type ipNets []*net.IPNet
+
+// parseIPNet lê um CIDR ('10.0.0.0/8') ou um único IP.
+func parseIPNet(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", value)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", value)
+	}
+	return ipnet, nil
+}
+
+func (n ipNets) contains(ip net.IP) bool {
+	for _, ipnet := range n {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxies são as redes dos proxies reversos (ex: o router do Heroku)
+// cujos headers de encaminhamento são aceitos.
+type trustedProxies ipNets
+
+// parseTrustedProxy lê a rede de um proxy confiável.
+func parseTrustedProxy(value string) (*net.IPNet, error) {
+	ipnet, err := parseIPNet(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTrustedProxy, err)
+	}
+	return ipnet, nil
+}
+
+func (t trustedProxies) contains(ip net.IP) bool {
+	return ipNets(t).contains(ip)
+}
+
+type clientContextKey struct{}
+
+// remoteClient é o cliente real de uma requisição, que pode ter passado por
+// proxies reversos.
+type remoteClient struct {
+	ip     string
+	scheme string
+	host   string
+	// peer é o IP da conexão, o próprio cliente ou o último proxy
+	peer string
+}
+
+// forwardedHop é o cliente visto por um dos proxies: o IP e o esquema e o
+// host da requisição que o proxy recebeu.
+type forwardedHop struct {
+	ip     net.IP
+	scheme string
+	host   string
+}
+
+// resolve retorna o cliente real de r. Os headers de encaminhamento só são
+// considerados quando a conexão vem de um proxy confiável, e os saltos são
+// lidos da direita para a esquerda até o primeiro IP que não é de um proxy
+// confiável, já que os saltos à esquerda podem ter sido enviados pelo próprio
+// cliente.
+func (t trustedProxies) resolve(r *http.Request) remoteClient {
+	c := remoteClient{ip: peerIP(r), scheme: "http", host: r.Host}
+	c.peer = c.ip
+	if r.TLS != nil {
+		c.scheme = "https"
+	}
+	if ip := net.ParseIP(c.ip); ip == nil || !t.contains(ip) {
+		return c
+	}
+
+	hops := forwardedHops(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		// the hop was written by a trusted proxy, even when its IP is unknown
+		hop := hops[i]
+		if hop.scheme != "" {
+			c.scheme = hop.scheme
+		}
+		if hop.host != "" {
+			c.host = hop.host
+		}
+		if hop.ip == nil {
+			break
+		}
+		c.ip = hop.ip.String()
+		if !t.contains(hop.ip) {
+			break
+		}
+	}
+	return c
+}
+
+// forwardedHops lê os saltos do header Forwarded (RFC 7239) ou, na sua
+// ausência, do X-Forwarded-For ou X-Real-IP com o X-Forwarded-Proto e o
+// X-Forwarded-Host. Sem nenhum IP os headers de esquema e host valem para a
+// própria conexão.
+func forwardedHops(r *http.Request) []forwardedHop {
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		return parseForwarded(values)
+	}
+
+	ips := splitHeader(r.Header.Values("X-Forwarded-For"))
+	if len(ips) == 0 {
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			ips = []string{realIP}
+		} else {
+			ips = []string{peerIP(r)}
+		}
+	}
+	// proxies either append to the lists or overwrite them, so the values are
+	// matched by position only when every proxy appended
+	schemes := alignHeader(splitHeader(r.Header.Values("X-Forwarded-Proto")), len(ips))
+	hosts := alignHeader(splitHeader(r.Header.Values("X-Forwarded-Host")), len(ips))
+
+	hops := make([]forwardedHop, len(ips))
+	for i, ip := range ips {
+		hops[i] = forwardedHop{ip: parseHopIP(ip), scheme: validScheme(schemes[i]), host: validHost(hosts[i])}
+	}
+	return hops
+}
+
+// parseForwarded lê os elementos 'for=192.0.2.60;proto=https;host=example.com'
+// de um ou mais headers Forwarded.
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, element := range splitHeader(values) {
+		var hop forwardedHop
+		for _, pair := range strings.Split(element, ";") {
+			key, value, _ := cutString(strings.TrimSpace(pair), "=")
+			value = strings.Trim(value, `"`)
+			switch strings.ToLower(key) {
+			case "for":
+				hop.ip = parseHopIP(value)
+			case "proto":
+				hop.scheme = validScheme(value)
+			case "host":
+				hop.host = validHost(value)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// parseHopIP lê um IP com ou sem a porta ('192.0.2.60:47011', '[2001:db8::1]:4711').
+// Identificadores ofuscados e 'unknown' retornam nil.
+func parseHopIP(value string) net.IP {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	return net.ParseIP(strings.Trim(value, "[]"))
+}
+
+func validScheme(scheme string) string {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	if scheme != "http" && scheme != "https" {
+		return ""
+	}
+	return scheme
+}
+
+func validHost(host string) string {
+	host = strings.TrimSpace(host)
+	if strings.ContainsAny(host, " /\\@?#") {
+		return ""
+	}
+	return host
+}
+
+// splitHeader separa os valores de headers repetidos ou separados por vírgula.
+func splitHeader(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// alignHeader retorna n valores: os próprios values quando há um para cada
+// salto, senão o último valor repetido.
+func alignHeader(values []string, n int) []string {
+	if len(values) == n {
+		return values
+	}
+	aligned := make([]string, n)
+	if len(values) > 0 {
+		for i := range aligned {
+			aligned[i] = values[len(values)-1]
+		}
+	}
+	return aligned
+}
+
+// withClient guarda no contexto o cliente real resolvido pelos proxies confiáveis.
+func (s *Server) withClient(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(clientContextKey{}).(remoteClient); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), clientContextKey{}, s.trustedProxies.resolve(r)))
+}
+
+// requestClient retorna o cliente real de r, ou o da conexão quando r não
+// passou pelo Server.
+func requestClient(r *http.Request) remoteClient {
+	if c, ok := r.Context().Value(clientContextKey{}).(remoteClient); ok {
+		return c
+	}
+	return trustedProxies(nil).resolve(r)
+}
+
+// clientIP retorna o IP real do cliente da requisição, usado nos limites por
+// cliente e nas listas de acesso.
+func clientIP(r *http.Request) string {
+	return requestClient(r).ip
+}
+
+// peerIP retorna o IP da conexão de r.
+func peerIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// withForceHTTPS redireciona para https as requisições que o cliente fez por
+// http, de acordo com o esquema resolvido (ex: 'X-Forwarded-Proto').
+func (s *Server) withForceHTTPS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := requestClient(r)
+		if c.scheme == "https" || c.host == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+c.host+r.URL.RequestURI(), status)
+	})
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestTrustedProxiesResolve(t *testing.T) {
+	var proxies trustedProxies
+	for _, cidr := range []string{"10.0.0.0/8", "192.0.2.10", "2001:db8::/32"} {
+		ipnet, err := parseTrustedProxy(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxies = append(proxies, ipnet)
+	}
+	if _, err := parseTrustedProxy("10.0.0.0/33"); !errors.Is(err, ErrTrustedProxy) {
+		t.Fatalf("invalid CIDR returned %v", err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		headers    map[string][]string
+		want       remoteClient
+	}{
+		// untrusted peers can not choose their IP or scheme
+		{"203.0.113.7:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https"}},
+			remoteClient{ip: "203.0.113.7", scheme: "http", host: "example.com"}},
+		{"10.1.2.3:1234", nil, remoteClient{ip: "10.1.2.3", scheme: "http", host: "example.com"}},
+		{"10.1.2.3:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"files.example.com"}},
+			remoteClient{ip: "198.51.100.1", scheme: "https", host: "files.example.com"}},
+		// the entries sent by the client are left of the first untrusted one
+		{"10.1.2.3:1234", map[string][]string{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 192.0.2.10"}},
+			remoteClient{ip: "198.51.100.1", scheme: "http", host: "example.com"}},
+		{"10.1.2.3:1234", map[string][]string{"X-Forwarded-For": {"1.1.1.1", "198.51.100.1"}, "X-Forwarded-Proto": {"http", "https"}},
+			remoteClient{ip: "198.51.100.1", scheme: "https", host: "example.com"}},
+		{"10.1.2.3:1234", map[string][]string{"X-Forwarded-For": {"10.0.0.1, 10.0.0.2"}},
+			remoteClient{ip: "10.0.0.1", scheme: "http", host: "example.com"}},
+		{"10.1.2.3:1234", map[string][]string{"X-Forwarded-For": {"garbage, 198.51.100.1:5555"}},
+			remoteClient{ip: "198.51.100.1", scheme: "http", host: "example.com"}},
+		{"10.1.2.3:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1, garbage"}},
+			remoteClient{ip: "10.1.2.3", scheme: "http", host: "example.com"}},
+		{"[2001:db8::1]:1234", map[string][]string{"X-Forwarded-For": {"2001:DB8:1::5, 2001:db8::2"}},
+			remoteClient{ip: "2001:db8:1::5", scheme: "http", host: "example.com"}},
+		{"10.1.2.3:1234", map[string][]string{"X-Real-IP": {"198.51.100.1"}, "X-Forwarded-Proto": {"ftp"}, "X-Forwarded-Host": {"evil.com/path"}},
+			remoteClient{ip: "198.51.100.1", scheme: "http", host: "example.com"}},
+		{"10.1.2.3:1234", map[string][]string{"X-Forwarded-Proto": {"HTTPS"}},
+			remoteClient{ip: "10.1.2.3", scheme: "https", host: "example.com"}},
+		// Forwarded takes precedence over the X-Forwarded-* headers
+		{"10.1.2.3:1234", map[string][]string{
+			"Forwarded":       {`for=1.1.1.1, for="198.51.100.1:4711";proto=https;host=files.example.com`, `for="[2001:db8::2]";proto=http`},
+			"X-Forwarded-For": {"203.0.113.9"},
+		}, remoteClient{ip: "198.51.100.1", scheme: "https", host: "files.example.com"}},
+		{"10.1.2.3:1234", map[string][]string{"Forwarded": {"for=_hidden;proto=https"}},
+			remoteClient{ip: "10.1.2.3", scheme: "https", host: "example.com"}},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.RemoteAddr = test.remoteAddr
+		for name, values := range test.headers {
+			for _, v := range values {
+				r.Header.Add(name, v)
+			}
+		}
+		got := proxies.resolve(r)
+		got.peer = ""
+		if got != test.want {
+			t.Errorf("resolve(%s, %v) = %+v, want %+v", test.remoteAddr, test.headers, got, test.want)
+		}
+	}
+}
+
+func TestForceHTTPS(t *testing.T) {
+	s := NewServer(t.TempDir(), true, false, logrus.WithField("test", true),
+		WithTrustedProxies([]string{"10.0.0.0/8", "bad"}),
+		WithForceHTTPS(true),
+	)
+	defer s.Close()
+
+	tests := []struct {
+		method   string
+		proto    string
+		status   int
+		location string
+	}{
+		{http.MethodGet, "http", http.StatusMovedPermanently, "https://example.com/docs/?sort=size"},
+		{http.MethodPost, "http", http.StatusPermanentRedirect, "https://example.com/docs/?sort=size"},
+		{http.MethodGet, "https", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(test.method, "http://example.com/docs/?sort=size", nil)
+		r.RemoteAddr = "10.1.2.3:1234"
+		r.Header.Set("X-Forwarded-Proto", test.proto)
+		s.ServeHTTP(w, r)
+		if w.Code != test.status || w.Header().Get("Location") != test.location {
+			t.Errorf("%s over %s returned %d %q", test.method, test.proto, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteRange é o trecho do arquivo pedido no header 'Range'.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// requestedRange retorna o trecho pedido pelo header 'Range'. Somente um
+// intervalo é suportado: múltiplos intervalos, headers inválidos e um
+// 'If-Range' diferente da data de modificação recebem o arquivo inteiro
+// (ok false). Intervalos fora do arquivo retornam ErrRange.
+func requestedRange(r *http.Request, fi os.FileInfo) (br byteRange, ok bool, err error) {
+	spec := r.Header.Get("Range")
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" {
+		// there are no ETags, only the Last-Modified date validates a range
+		t, err := http.ParseTime(ifRange)
+		if err != nil || !fi.ModTime().Truncate(time.Second).Equal(t) {
+			return byteRange{}, false, nil
+		}
+	}
+
+	size := fi.Size()
+	first, last := strings.TrimSpace(spec[len("bytes="):]), ""
+	if i := strings.Index(first, "-"); i >= 0 {
+		first, last = strings.TrimSpace(first[:i]), strings.TrimSpace(first[i+1:])
+	} else {
+		return byteRange{}, false, nil
+	}
+
+	if first == "" {
+		// 'bytes=-500' are the last 500 bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return byteRange{}, false, nil
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, false, fmt.Errorf("%w: %s", ErrRange, spec)
+		}
+		if n > size {
+			n = size
+		}
+		return byteRange{start: size - n, length: n}, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return byteRange{}, false, fmt.Errorf("%w: %s", ErrRange, spec)
+	}
+	return byteRange{start: start, length: end - start + 1}, true, nil
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// rateLimitMaxClients limita os IPs guardados em memória, os menos recentes são descartados
+	rateLimitMaxClients = 10000
+	// rateLimitTTL é o tempo que um IP sem requisições e sem bloqueio fica em memória
+	rateLimitTTL = time.Hour
+	// rateLimitLogInterval é o intervalo mínimo entre os logs dos contadores
+	rateLimitLogInterval = time.Minute
+	// authLockoutMax limita o bloqueio, que dobra a cada novo bloqueio do IP
+	authLockoutMax = 24 * time.Hour
+)
+
+// rateLimiter limita as requisições de cada IP e bloqueia os IPs após
+// repetidas falhas de autenticação, por um tempo que dobra a cada bloqueio.
+type rateLimiter struct {
+	requests     int // por window, zero sem limite
+	window       time.Duration
+	authFailures int // zero sem bloqueio
+	lockout      time.Duration
+	logger       *logrus.Entry
+
+	mu      sync.Mutex
+	ll      *list.List // os IPs, do uso mais recente para o menos recente
+	clients map[string]*list.Element
+	stats   rateLimitStats
+	logged  rateLimitStats
+	lastLog time.Time
+}
+
+type rateLimitClient struct {
+	ip          string
+	bucket      *tokenBucket
+	failures    int
+	lockouts    int
+	lockedUntil time.Time
+	seen        time.Time
+}
+
+// rateLimitStats são os contadores registrados no log.
+type rateLimitStats struct {
+	allowed      int64
+	limited      int64
+	lockedOut    int64
+	authFailures int64
+	lockouts     int64
+	evicted      int64
+}
+
+func newRateLimiter(requests int, window time.Duration, authFailures int, lockout time.Duration, logger *logrus.Entry) *rateLimiter {
+	return &rateLimiter{
+		requests:     requests,
+		window:       window,
+		authFailures: authFailures,
+		lockout:      lockout,
+		logger:       logger,
+		ll:           list.New(),
+		clients:      make(map[string]*list.Element),
+		lastLog:      time.Now(),
+	}
+}
+
+// client retorna o estado do ip, criando-o se necessário. Deve ser chamado com mu.
+func (l *rateLimiter) client(ip string, now time.Time) *rateLimitClient {
+	// the least recently seen clients are at the back
+	for el := l.ll.Back(); el != nil; el = l.ll.Back() {
+		c := el.Value.(*rateLimitClient)
+		if now.Sub(c.seen) < rateLimitTTL || now.Before(c.lockedUntil) {
+			break
+		}
+		l.remove(el)
+	}
+
+	if el, ok := l.clients[ip]; ok {
+		l.ll.MoveToFront(el)
+		c := el.Value.(*rateLimitClient)
+		c.seen = now
+		return c
+	}
+
+	if l.ll.Len() >= rateLimitMaxClients {
+		l.remove(l.ll.Back())
+		l.stats.evicted++
+	}
+	c := &rateLimitClient{ip: ip, seen: now}
+	if l.requests > 0 {
+		c.bucket = newTokenBucket(float64(l.requests)/l.window.Seconds(), float64(l.requests))
+		c.bucket.last = now
+	}
+	l.clients[ip] = l.ll.PushFront(c)
+	return c
+}
+
+func (l *rateLimiter) remove(el *list.Element) {
+	l.ll.Remove(el)
+	delete(l.clients, el.Value.(*rateLimitClient).ip)
+}
+
+// allow indica se a requisição do ip pode ser atendida, senão retorna quanto
+// tempo esperar.
+func (l *rateLimiter) allow(ip string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.logStats(now)
+
+	c := l.client(ip, now)
+	if now.Before(c.lockedUntil) {
+		l.stats.lockedOut++
+		return c.lockedUntil.Sub(now), false
+	}
+	if c.bucket != nil {
+		if wait := c.bucket.take(1, now); wait > 0 {
+			l.stats.limited++
+			return wait, false
+		}
+	}
+	l.stats.allowed++
+	return 0, true
+}
+
+// lockedOut indica se o ip está bloqueado por falhas de autenticação.
+func (l *rateLimiter) lockedOut(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.clients[ip]
+	return ok && now.Before(el.Value.(*rateLimitClient).lockedUntil)
+}
+
+// authFailed conta uma falha de autenticação do ip e o bloqueia quando as
+// falhas chegam a authFailures.
+func (l *rateLimiter) authFailed(ip string, now time.Time) {
+	if l.authFailures <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stats.authFailures++
+	c := l.client(ip, now)
+	if c.failures++; c.failures < l.authFailures {
+		return
+	}
+	c.failures = 0
+	c.lockouts++
+	d := time.Duration(math.Min(float64(l.lockout)*math.Pow(2, float64(c.lockouts-1)), float64(authLockoutMax)))
+	c.lockedUntil = now.Add(d)
+	l.stats.lockouts++
+	l.logger.Warnf("Auth lockout: %s locked for %s after %d failures (lockout %d)", ip, d, l.authFailures, c.lockouts)
+}
+
+// authSucceeded zera as falhas e os bloqueios anteriores do ip.
+func (l *rateLimiter) authSucceeded(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.clients[ip]; ok {
+		c := el.Value.(*rateLimitClient)
+		c.failures, c.lockouts = 0, 0
+	}
+}
+
+// logStats registra os contadores quando mudaram desde o último log. Deve ser chamado com mu.
+func (l *rateLimiter) logStats(now time.Time) {
+	if now.Sub(l.lastLog) < rateLimitLogInterval || l.stats == l.logged {
+		return
+	}
+	l.lastLog, l.logged = now, l.stats
+	l.logger.Infof("Rate limit: %d clients, %d allowed, %d limited, %d locked out, %d auth failures, %d lockouts, %d evicted",
+		l.ll.Len(), l.stats.allowed, l.stats.limited, l.stats.lockedOut, l.stats.authFailures, l.stats.lockouts, l.stats.evicted)
+}
+
+// withRateLimit responde 429 com 'Retry-After' às requisições acima do limite
+// do IP e às dos IPs bloqueados.
+func (s *Server) withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait, ok := s.rateLimit.allow(clientIP(r), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			s.sendError(w, r, http.StatusTooManyRequests, ErrTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Second, 2, time.Minute, logrus.WithField("test", true))
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, ok := l.allow("192.0.2.1", now); !ok {
+			t.Fatalf("request %d was limited", i)
+		}
+	}
+	if wait, ok := l.allow("192.0.2.1", now); ok || wait != 500*time.Millisecond {
+		t.Fatalf("the third request returned %s, %v", wait, ok)
+	}
+	if _, ok := l.allow("192.0.2.2", now); !ok {
+		t.Fatal("another IP was limited")
+	}
+	if _, ok := l.allow("192.0.2.1", now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("the refilled request was limited")
+	}
+
+	// the lockout doubles on each new series of failures
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute} {
+		l.authFailed("192.0.2.3", now)
+		if l.lockedOut("192.0.2.3", now) {
+			t.Fatal("locked out after a single failure")
+		}
+		l.authFailed("192.0.2.3", now)
+		if wait, ok := l.allow("192.0.2.3", now); ok || wait != want {
+			t.Fatalf("locked out for %s, want %s", wait, want)
+		}
+		now = now.Add(want)
+	}
+	l.authSucceeded("192.0.2.3")
+	l.authFailed("192.0.2.3", now)
+	l.authFailed("192.0.2.3", now)
+	if wait, _ := l.allow("192.0.2.3", now); wait != time.Minute {
+		t.Fatalf("a login did not reset the lockouts, locked out for %s", wait)
+	}
+	if l.stats.lockouts != 3 || l.stats.authFailures != 6 || l.stats.lockedOut != 3 || l.stats.limited != 1 {
+		t.Fatalf("counters are %+v", l.stats)
+	}
+}
+
+func TestRateLimiterBounds(t *testing.T) {
+	l := newRateLimiter(10, time.Second, 0, 0, logrus.WithField("test", true))
+	now := time.Now()
+	for i := 0; i < rateLimitMaxClients+5; i++ {
+		l.allow(strconv.Itoa(i), now)
+	}
+	if len(l.clients) != rateLimitMaxClients || l.stats.evicted != 5 {
+		t.Fatalf("%d clients kept, %d evicted", len(l.clients), l.stats.evicted)
+	}
+	if _, ok := l.clients["0"]; ok {
+		t.Fatal("the least recent client was kept")
+	}
+
+	// idle clients expire after the TTL
+	l.allow("new", now.Add(rateLimitTTL))
+	if len(l.clients) != 1 {
+		t.Fatalf("%d clients kept after the TTL", len(l.clients))
+	}
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	s := NewServer(t.TempDir(), true, false, logrus.WithField("test", true),
+		WithBasicAuth([]string{"admin:secret"}),
+		WithRateLimit(0, 0, 2, time.Minute),
+	)
+	defer s.Close()
+
+	get := func(user, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// requests without credentials are not failures
+	for i := 0; i < 3; i++ {
+		if w := get("", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("anonymous request returned %d", w.Code)
+		}
+	}
+	get("admin", "wrong")
+	get("admin", "wrong")
+	w := get("admin", "secret")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("locked out request returned %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// resumableUploadTTL é o tempo que um upload incompleto aguarda pela próxima parte.
+	resumableUploadTTL = 24 * time.Hour
+	// resumableSweepInterval é o intervalo entre as remoções dos uploads expirados
+	resumableSweepInterval = time.Hour
+	// resumableMaxUploads e resumableMaxClientUploads limitam os uploads
+	// incompletos do Server e de cada IP, cada um ocupa um arquivo temporário
+	resumableMaxUploads       = 1024
+	resumableMaxClientUploads = 32
+	// defaultResumableMaxLength é o maior 'Upload-Length' aceito por padrão
+	defaultResumableMaxLength = 64 << 30
+)
+
+// resumableUpload é um upload enviado em partes, que continua de onde parou
+// após uma falha de rede. O conteúdo fica em um arquivo temporário até chegar
+// a length bytes, então é gravado com as mesmas regras do uploadHandler.
+type resumableUpload struct {
+	id string
+	// urlPath é a URL do arquivo pedida na criação (ex: '/docs/report.pdf')
+	urlPath string
+	dirPath string
+	name    string
+	// client é o IP que criou o upload
+	client string
+	length int64
+	offset int64
+	// tempPath guarda as partes recebidas, aberto somente durante cada requisição
+	tempPath string
+	updated  time.Time
+	// busy impede que duas requisições escrevam no mesmo upload
+	busy bool
+}
+
+func (u *resumableUpload) discard() {
+	os.Remove(u.tempPath)
+}
+
+// resumableUploads guarda os uploads em andamento de um Server. Os uploads
+// parados por ttl são descartados em segundo plano até o Close.
+type resumableUploads struct {
+	mu      sync.Mutex
+	uploads map[string]*resumableUpload
+	ttl     time.Duration
+	done    chan struct{}
+}
+
+func newResumableUploads(ttl time.Duration) *resumableUploads {
+	ru := &resumableUploads{
+		uploads: make(map[string]*resumableUpload),
+		ttl:     ttl,
+		done:    make(chan struct{}),
+	}
+	go ru.sweep(resumableSweepInterval)
+	return ru
+}
+
+func (ru *resumableUploads) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			ru.expire(now)
+		case <-ru.done:
+			return
+		}
+	}
+}
+
+// expire descarta os uploads parados por mais de ttl em now.
+func (ru *resumableUploads) expire(now time.Time) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	for id, u := range ru.uploads {
+		if !u.busy && now.Sub(u.updated) > ru.ttl {
+			u.discard()
+			delete(ru.uploads, id)
+		}
+	}
+}
+
+// add registra o upload, retorna ErrTooManyResumableUploads quando o Server
+// ou o cliente já tem o máximo de uploads incompletos.
+func (ru *resumableUploads) add(u *resumableUpload) error {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	if len(ru.uploads) >= resumableMaxUploads {
+		return ErrTooManyResumableUploads
+	}
+	n := 0
+	for _, old := range ru.uploads {
+		if old.client == u.client {
+			n++
+		}
+	}
+	if n >= resumableMaxClientUploads {
+		return fmt.Errorf("%w: %d uploads of %s", ErrTooManyResumableUploads, n, u.client)
+	}
+	ru.uploads[u.id] = u
+	return nil
+}
+
+// get retorna o upload id criado para urlPath.
+func (ru *resumableUploads) get(id string, urlPath string) (*resumableUpload, bool) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	u, ok := ru.uploads[id]
+	if !ok || u.urlPath != urlPath {
+		return nil, false
+	}
+	return u, true
+}
+
+// acquire reserva o upload para uma requisição, retorna ErrResumableUpload
+// quando ele não existe e ErrUploadOffset quando outra requisição está escrevendo.
+func (ru *resumableUploads) acquire(id string, urlPath string) (*resumableUpload, error) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	u, ok := ru.uploads[id]
+	if !ok || u.urlPath != urlPath {
+		return nil, ErrResumableUpload
+	}
+	if u.busy {
+		return nil, fmt.Errorf("%w: upload in progress", ErrUploadOffset)
+	}
+	u.busy = true
+	return u, nil
+}
+
+func (ru *resumableUploads) release(u *resumableUpload) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	u.busy = false
+	u.updated = time.Now()
+}
+
+// remove descarta o upload, completo ou cancelado.
+func (ru *resumableUploads) remove(u *resumableUpload) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	delete(ru.uploads, u.id)
+	u.discard()
+}
+
+func (ru *resumableUploads) Close() {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	select {
+	case <-ru.done:
+	default:
+		close(ru.done)
+	}
+	for id, u := range ru.uploads {
+		u.discard()
+		delete(ru.uploads, id)
+	}
+}
+
+// createResumableUpload inicia um upload em partes para a URL do arquivo
+// ('POST /docs/report.pdf?resumable' com o tamanho no header 'Upload-Length').
+// A resposta indica em 'Location' a URL das partes.
+func (s *Server) createResumableUpload(w http.ResponseWriter, r *http.Request, urlPath string) {
+	dirPath := s.localPath(path.Dir(urlPath))
+	fname := path.Base(urlPath)
+
+	if !validUploadFileName(fname) {
+		s.logger.Errorf("%s: %q", ErrUploadFileName, fname)
+		http.Error(w, fmt.Sprintf("%s: %q", ErrUploadFileName, fname), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkAccess(dirPath); err != nil {
+		s.logger.Errorf("Upload error: %s", err)
+		http.Error(w, os.ErrNotExist.Error(), http.StatusNotFound)
+		return
+	}
+	if fi, err := s.stat(dirPath); err != nil || !fi.IsDir() {
+		s.logger.Errorf("Upload error: %s is not a directory", dirPath)
+		http.Error(w, os.ErrNotExist.Error(), http.StatusNotFound)
+		return
+	}
+	if err := s.checkAccess(path.Join(dirPath, fname)); err != nil {
+		s.logger.Errorf("Upload error: %s", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, fmt.Sprintf("%s: invalid Upload-Length %q", ErrResumableUpload, r.Header.Get("Upload-Length")), http.StatusBadRequest)
+		return
+	}
+	if length > s.resumableMaxLength {
+		http.Error(w, fmt.Sprintf("%s: Upload-Length %d exceeds %d bytes", ErrResumableUpload, length, s.resumableMaxLength), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := ioutil.TempFile("", "gouploadserver-upload-")
+	if err != nil {
+		s.logger.Errorf("Upload error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	u := &resumableUpload{
+		id:       hex.EncodeToString(id),
+		urlPath:  urlPath,
+		dirPath:  dirPath,
+		name:     fname,
+		client:   clientIP(r),
+		length:   length,
+		tempPath: f.Name(),
+		updated:  time.Now(),
+	}
+	if err := s.resumable.add(u); err != nil {
+		u.discard()
+		s.logger.Errorf("Upload error: %s", err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(resumableSweepInterval/time.Second)))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	s.logger.Infof("Resumable upload %s: %s, %d bytes", u.id, urlPath, length)
+
+	w.Header().Set("Location", (&url.URL{Path: urlPath, RawQuery: "upload=" + u.id}).String())
+	w.Header().Set("Upload-Offset", "0")
+	w.Header().Set("Upload-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// resumableUploadStatus informa em 'Upload-Offset' quantos bytes do upload
+// foram recebidos ('GET /docs/report.pdf?upload=<id>').
+func (s *Server) resumableUploadStatus(w http.ResponseWriter, r *http.Request) {
+	u, ok := s.resumable.get(r.URL.Query().Get("upload"), r.URL.Path)
+	if !ok {
+		http.Error(w, ErrResumableUpload.Error(), http.StatusNotFound)
+		return
+	}
+	s.resumable.mu.Lock()
+	offset := u.offset
+	s.resumable.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.length, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resumableUploadHandler recebe a próxima parte de um upload
+// ('PATCH /docs/report.pdf?upload=<id>'), que começa no byte 'Upload-Offset'.
+// Quando o upload completa, o arquivo é gravado e a resposta 201 indica a
+// sua URL em 'Location'.
+func (s *Server) resumableUploadHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	u, err := s.resumable.acquire(r.URL.Query().Get("upload"), r.URL.Path)
+	if errors.Is(err, ErrUploadOffset) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer s.resumable.release(u)
+
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.length, 10))
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != u.offset {
+		// the client resends from the offset the server already has
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+		http.Error(w, fmt.Sprintf("%s: got %q, want %d", ErrUploadOffset, r.Header.Get("Upload-Offset"), u.offset), http.StatusConflict)
+		return
+	}
+
+	release, ok := s.acquireTransfer(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	n, err := s.writeResumablePart(r, u)
+	s.resumable.mu.Lock()
+	u.offset += n
+	s.resumable.mu.Unlock()
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	if err != nil {
+		// the bytes already received are kept, the client resumes from them
+		s.logger.Errorf("Resumable upload %s: %s", u.id, err)
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrUploadIdle) {
+			status = http.StatusRequestTimeout
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if extra, _ := r.Body.Read(make([]byte, 1)); extra > 0 {
+		http.Error(w, fmt.Sprintf("%s: body exceeds Upload-Length", ErrResumableUpload), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if u.offset < u.length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	f, err := os.Open(u.tempPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buf := make([]byte, 4096)
+	fileSent, err := s.readerToFile(f, u.dirPath, u.name, s.keepOriginalUploadFileName, buf, nil)
+	f.Close()
+	if err != nil {
+		s.logger.Errorf("Reader To File error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.resumable.remove(u)
+	s.logger.Infof("File sent: %s", fileSent)
+	s.notifyUpload(fileSent)
+
+	fileURL := path.Join(path.Dir(u.urlPath), path.Base(fileSent))
+	w.Header().Set("Location", (&url.URL{Path: fileURL}).String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// writeResumablePart grava o corpo de r no arquivo temporário do upload a
+// partir do offset atual e retorna quantos bytes foram gravados.
+func (s *Server) writeResumablePart(r *http.Request, u *resumableUpload) (int64, error) {
+	f, err := os.OpenFile(u.tempPath, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(u.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	src := requestSource(r, "resumable", u.length)
+	src.received = u.offset
+	tracked := s.uploads.start(src, u.urlPath)
+	body := s.throttle(src.ctx, io.LimitReader(r.Body, u.length-u.offset), directionUpload, src.client, src.user)
+	n, err := io.Copy(f, tracked.reader(body))
+	tracked.finish(err)
+	return n, err
+}
+
+// abortResumableUpload cancela o upload e descarta as partes recebidas.
+func (s *Server) abortResumableUpload(w http.ResponseWriter, r *http.Request) {
+	u, err := s.resumable.acquire(r.URL.Query().Get("upload"), r.URL.Path)
+	if errors.Is(err, ErrUploadOffset) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.resumable.remove(u)
+	s.logger.Infof("Resumable upload %s aborted", u.id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newResumableTestServer(t *testing.T) (*Server, string) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "docs", "guide.md"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(dir, true, false, logrus.WithField("test", true))
+	t.Cleanup(func() { s.Close() })
+	return s, dir
+}
+
+func serveTest(s http.Handler, method string, target string, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestResumableUpload(t *testing.T) {
+	s, dir := newResumableTestServer(t)
+
+	rr := serveTest(s, http.MethodPost, "/docs/report.txt?resumable", "", map[string]string{"Upload-Length": "11"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", rr.Code, rr.Body)
+	}
+	location := rr.Header().Get("Location")
+	if !strings.HasPrefix(location, "/docs/report.txt?upload=") {
+		t.Fatalf("create returned Location %q", location)
+	}
+
+	rr = serveTest(s, http.MethodPatch, location, "hello", map[string]string{"Upload-Offset": "0"})
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("first part returned %d, offset %q", rr.Code, rr.Header().Get("Upload-Offset"))
+	}
+	// a part sent again after a lost response is rejected with the current offset
+	rr = serveTest(s, http.MethodPatch, location, "hello", map[string]string{"Upload-Offset": "0"})
+	if rr.Code != http.StatusConflict || rr.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("repeated part returned %d, offset %q", rr.Code, rr.Header().Get("Upload-Offset"))
+	}
+	rr = serveTest(s, http.MethodGet, location, "", nil)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("status returned %d, offset %q", rr.Code, rr.Header().Get("Upload-Offset"))
+	}
+
+	rr = serveTest(s, http.MethodPatch, location, " world", map[string]string{"Upload-Offset": "5"})
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") != "/docs/report.txt" {
+		t.Fatalf("last part returned %d, Location %q", rr.Code, rr.Header().Get("Location"))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "docs", "report.txt"))
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("upload saved %q, %v", data, err)
+	}
+	if rr := serveTest(s, http.MethodGet, location, "", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("a completed upload must be removed, status returned %d", rr.Code)
+	}
+
+	rr = serveTest(s, http.MethodPost, "/docs/aborted.txt?resumable", "", map[string]string{"Upload-Length": "3"})
+	location = rr.Header().Get("Location")
+	if rr := serveTest(s, http.MethodDelete, location, "", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("abort returned %d", rr.Code)
+	}
+	if rr := serveTest(s, http.MethodPatch, location, "abc", map[string]string{"Upload-Offset": "0"}); rr.Code != http.StatusNotFound {
+		t.Fatalf("a part of an aborted upload returned %d", rr.Code)
+	}
+
+	if rr := serveTest(s, http.MethodPost, "/missing/file.txt?resumable", "", map[string]string{"Upload-Length": "3"}); rr.Code != http.StatusNotFound {
+		t.Fatalf("an upload to a missing directory returned %d", rr.Code)
+	}
+	if rr := serveTest(s, http.MethodPost, "/docs/file.txt?resumable", "", nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("an upload without Upload-Length returned %d", rr.Code)
+	}
+}
+
+func TestResumableUploadLimits(t *testing.T) {
+	s, _ := newResumableTestServer(t)
+	s.resumableMaxLength = 10
+
+	if rr := serveTest(s, http.MethodPost, "/docs/big.txt?resumable", "", map[string]string{"Upload-Length": "11"}); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("an upload over the maximum size returned %d", rr.Code)
+	}
+	for i := 0; i < resumableMaxClientUploads; i++ {
+		if rr := serveTest(s, http.MethodPost, "/docs/file.txt?resumable", "", map[string]string{"Upload-Length": "3"}); rr.Code != http.StatusCreated {
+			t.Fatalf("upload %d returned %d", i, rr.Code)
+		}
+	}
+	if rr := serveTest(s, http.MethodPost, "/docs/file.txt?resumable", "", map[string]string{"Upload-Length": "3"}); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("an upload over the client limit returned %d", rr.Code)
+	}
+
+	// abandoned uploads are discarded with their temporary files
+	var temps []string
+	for _, u := range s.resumable.uploads {
+		temps = append(temps, u.tempPath)
+	}
+	s.resumable.expire(time.Now().Add(resumableUploadTTL + time.Minute))
+	if n := len(s.resumable.uploads); n != 0 {
+		t.Fatalf("expire kept %d uploads", n)
+	}
+	for _, temp := range temps {
+		if _, err := os.Stat(temp); !os.IsNotExist(err) {
+			t.Fatalf("expire kept %s: %v", temp, err)
+		}
+	}
+	if rr := serveTest(s, http.MethodPost, "/docs/file.txt?resumable", "", map[string]string{"Upload-Length": "3"}); rr.Code != http.StatusCreated {
+		t.Fatalf("an upload after the expiration returned %d", rr.Code)
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	s, dir := newResumableTestServer(t)
+
+	if rr := serveTest(s, http.MethodDelete, "/docs/", "", nil); rr.Code != http.StatusConflict {
+		t.Fatalf("deleting a non-empty directory returned %d", rr.Code)
+	}
+	if rr := serveTest(s, http.MethodDelete, "/docs/guide.md", "", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete returned %d", rr.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs", "guide.md")); !os.IsNotExist(err) {
+		t.Fatalf("delete kept the file: %v", err)
+	}
+	if rr := serveTest(s, http.MethodDelete, "/docs/guide.md", "", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("deleting a missing file returned %d", rr.Code)
+	}
+	if rr := serveTest(s, http.MethodDelete, "/docs/", "", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("deleting an empty directory returned %d", rr.Code)
+	}
+	if rr := serveTest(s, http.MethodDelete, "/", "", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("deleting the root returned %d", rr.Code)
+	}
+
+	readOnly := NewServer(dir, false, false, logrus.WithField("test", true), WithUploads(false))
+	if rr := serveTest(readOnly, http.MethodDelete, "/docs/", "", nil); rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("delete with uploads disabled returned %d", rr.Code)
+	}
+}
+
+func TestFileHandlerRange(t *testing.T) {
+	s, _ := newResumableTestServer(t)
+
+	rr := serveTest(s, http.MethodGet, "/docs/guide.md", "", map[string]string{"Range": "bytes=2-5"})
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "2345" || rr.Header().Get("Content-Range") != "bytes 2-5/10" {
+		t.Fatalf("range returned %d %q, Content-Range %q", rr.Code, rr.Body, rr.Header().Get("Content-Range"))
+	}
+	if rr := serveTest(s, http.MethodGet, "/docs/guide.md", "", map[string]string{"Range": "bytes=-3"}); rr.Body.String() != "789" {
+		t.Fatalf("suffix range returned %q", rr.Body)
+	}
+	if rr := serveTest(s, http.MethodGet, "/docs/guide.md", "", map[string]string{"Range": "bytes=20-"}); rr.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("range after the end returned %d", rr.Code)
+	}
+
+	// a range of an older version of the file receives the whole file
+	header := map[string]string{"Range": "bytes=2-5", "If-Range": "Mon, 02 Jan 2006 15:04:05 GMT"}
+	if rr := serveTest(s, http.MethodGet, "/docs/guide.md", "", header); rr.Code != http.StatusOK || rr.Body.String() != "0123456789" {
+		t.Fatalf("If-Range mismatch returned %d %q", rr.Code, rr.Body)
+	}
+	header["If-Range"] = rr.Header().Get("Last-Modified")
+	if rr := serveTest(s, http.MethodGet, "/docs/guide.md", "", header); rr.Code != http.StatusPartialContent {
+		t.Fatalf("If-Range match returned %d", rr.Code)
+	}
+}
+
+func TestMkdirHandler(t *testing.T) {
+	s, dir := newResumableTestServer(t)
+
+	if rr := serveTest(s, "MKCOL", "/docs/new/", "", nil); rr.Code != http.StatusCreated {
+		t.Fatalf("MKCOL returned %d", rr.Code)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "docs", "new")); err != nil || !fi.IsDir() {
+		t.Fatalf("MKCOL did not create the directory: %v", err)
+	}
+	if rr := serveTest(s, "MKCOL", "/docs/new/", "", nil); rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("MKCOL of an existing directory returned %d", rr.Code)
+	}
+	if rr := serveTest(s, "MKCOL", "/missing/new/", "", nil); rr.Code != http.StatusConflict {
+		t.Fatalf("MKCOL without the parent returned %d", rr.Code)
+	}
+}
@@ -0,0 +1,371 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Arquivos de regras no formato do Netlify, lidos da raiz do staticDirPath.
+// Ex: https://docs.netlify.com/routing/redirects/ e https://docs.netlify.com/routing/headers/
+const (
+	redirectsFileName = "_redirects"
+	headersFileName   = "_headers"
+)
+
+// redirectRule é uma linha do arquivo '_redirects': 'from to [status][!]'
+type redirectRule struct {
+	from   string
+	to     string
+	status int
+	// force aplica a regra mesmo quando existe um arquivo no caminho requisitado
+	force bool
+}
+
+// headerRule é um bloco do arquivo '_headers': um padrão de caminho seguido
+// por linhas indentadas 'Name: value'.
+type headerRule struct {
+	pattern string
+	headers http.Header
+}
+
+// siteRules mantém as regras carregadas e as recarrega quando a data de
+// modificação dos arquivos muda.
+type siteRules struct {
+	storage storage.Storage
+	logger  *logrus.Entry
+
+	mu           sync.RWMutex
+	redirects    []redirectRule
+	headers      []headerRule
+	redirectsMod time.Time
+	headersMod   time.Time
+}
+
+func newSiteRules(st storage.Storage, logger *logrus.Entry) *siteRules {
+	rules := &siteRules{storage: st, logger: logger}
+	rules.reload()
+	return rules
+}
+
+// reload relê os arquivos que foram alterados desde a última leitura.
+func (sr *siteRules) reload() {
+	redirectsMod := sr.modTime(redirectsFileName)
+	headersMod := sr.modTime(headersFileName)
+
+	sr.mu.RLock()
+	unchanged := redirectsMod.Equal(sr.redirectsMod) && headersMod.Equal(sr.headersMod)
+	sr.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if !redirectsMod.Equal(sr.redirectsMod) {
+		sr.redirects = nil
+		if f, err := sr.storage.Open(redirectsFileName); err == nil {
+			sr.redirects = parseRedirects(f, sr.logger)
+			f.Close()
+			sr.logger.Infof("Loaded %d rules from %s", len(sr.redirects), redirectsFileName)
+		}
+		sr.redirectsMod = redirectsMod
+	}
+
+	if !headersMod.Equal(sr.headersMod) {
+		sr.headers = nil
+		if f, err := sr.storage.Open(headersFileName); err == nil {
+			sr.headers = parseHeaders(f, sr.logger)
+			f.Close()
+			sr.logger.Infof("Loaded %d rules from %s", len(sr.headers), headersFileName)
+		}
+		sr.headersMod = headersMod
+	}
+}
+
+func (sr *siteRules) modTime(name string) time.Time {
+	fi, err := sr.storage.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func parseRedirects(f io.Reader, logger *logrus.Entry) []redirectRule {
+	var rules []redirectRule
+	sc := bufio.NewScanner(f)
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			logger.Warnf("%s:%d: expected 'from to [status]', got %q", redirectsFileName, lineNum, line)
+			continue
+		}
+
+		rule := redirectRule{from: fields[0], to: fields[1], status: http.StatusMovedPermanently}
+		if len(fields) > 2 {
+			code := fields[2]
+			if strings.HasSuffix(code, "!") {
+				rule.force = true
+				code = strings.TrimSuffix(code, "!")
+			}
+			status, err := strconv.Atoi(code)
+			if err != nil {
+				logger.Warnf("%s:%d: invalid status %q", redirectsFileName, lineNum, fields[2])
+				continue
+			}
+			rule.status = status
+			if len(fields) > 3 {
+				logger.Warnf("%s:%d: conditions are not supported, ignoring %v", redirectsFileName, lineNum, fields[3:])
+			}
+		}
+
+		if rule.status != http.StatusOK && rule.status != http.StatusNotFound && (rule.status < 300 || rule.status > 399) {
+			logger.Warnf("%s:%d: unsupported status %d", redirectsFileName, lineNum, rule.status)
+			continue
+		}
+
+		if rule.status == http.StatusOK && !strings.HasPrefix(rule.to, "/") {
+			logger.Warnf("%s:%d: proxying to %s is not supported", redirectsFileName, lineNum, rule.to)
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func parseHeaders(f io.Reader, logger *logrus.Entry) []headerRule {
+	var rules []headerRule
+	var current *headerRule
+	sc := bufio.NewScanner(f)
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		raw := sc.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		indented := raw[0] == ' ' || raw[0] == '\t'
+		if !indented {
+			rules = append(rules, headerRule{pattern: line, headers: make(http.Header)})
+			current = &rules[len(rules)-1]
+			continue
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if current == nil || len(kv) != 2 {
+			logger.Warnf("%s:%d: expected an indented 'Name: value' after a path, got %q", headersFileName, lineNum, line)
+			continue
+		}
+		current.headers.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return rules
+}
+
+// matchRulePath compara um caminho com um padrão das regras. Segmentos ':name'
+// capturam um segmento do caminho e um '*' final captura o restante como 'splat'.
+func matchRulePath(pattern string, urlPath string) (map[string]string, bool) {
+	params := make(map[string]string)
+
+	pattern = strings.TrimSuffix(pattern, "/")
+	urlPath = strings.TrimSuffix(urlPath, "/")
+	if pattern == "" {
+		pattern = "/"
+	}
+	if urlPath == "" {
+		urlPath = "/"
+	}
+
+	pp := strings.Split(pattern, "/")
+	up := strings.Split(urlPath, "/")
+
+	for i, seg := range pp {
+		if seg == "*" && i == len(pp)-1 {
+			if i < len(up) {
+				params["splat"] = strings.Join(up[i:], "/")
+			} else {
+				params["splat"] = ""
+			}
+			return params, true
+		}
+
+		if i >= len(up) {
+			return nil, false
+		}
+
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			params[seg[1:]] = up[i]
+		case strings.HasSuffix(seg, "*") && i == len(pp)-1:
+			if !strings.HasPrefix(up[i], seg[:len(seg)-1]) {
+				return nil, false
+			}
+			params["splat"] = strings.Join(append([]string{up[i][len(seg)-1:]}, up[i+1:]...), "/")
+			return params, true
+		case seg != up[i]:
+			return nil, false
+		}
+	}
+
+	if len(pp) != len(up) {
+		return nil, false
+	}
+	return params, true
+}
+
+// expandRuleTarget substitui ':splat' e os placeholders capturados no destino.
+func expandRuleTarget(to string, params map[string]string) string {
+	segments := strings.Split(to, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") {
+			if v, ok := params[seg[1:]]; ok {
+				segments[i] = v
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// applyHeaders adiciona em w os headers de todos os blocos que casam com urlPath.
+func (sr *siteRules) applyHeaders(w http.ResponseWriter, urlPath string) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	for _, rule := range sr.headers {
+		if _, ok := matchRulePath(rule.pattern, urlPath); !ok {
+			continue
+		}
+		for name, values := range rule.headers {
+			for _, v := range values {
+				w.Header().Add(name, v)
+			}
+		}
+	}
+}
+
+// matchRedirect retorna a primeira regra que casa com urlPath e o destino expandido.
+// Regras sem '!' não se aplicam quando existe um arquivo no caminho (shadowing).
+func (sr *siteRules) matchRedirect(urlPath string, fileExists func(string) bool) (*redirectRule, string) {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	for i := range sr.redirects {
+		rule := &sr.redirects[i]
+		params, ok := matchRulePath(rule.from, urlPath)
+		if !ok {
+			continue
+		}
+		if !rule.force && fileExists(urlPath) {
+			continue
+		}
+		return rule, expandRuleTarget(rule.to, params)
+	}
+	return nil, ""
+}
+
+// statusOverrideWriter força o status da resposta, usado nas regras 404 que
+// respondem com uma página personalizada.
+type statusOverrideWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusOverrideWriter) WriteHeader(code int) {
+	if code == http.StatusOK {
+		code = sw.status
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// withRules envolve next aplicando as regras antes do roteamento.
+func (s *Server) withRules(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if w, ok := s.applyRules(w, r); ok {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// isRuleFile indica se urlPath é um dos arquivos de regras, que nunca são
+// servidos, com ou sem o mount path e a barra final.
+func (s *Server) isRuleFile(urlPath string) bool {
+	rel := s.relPath(urlPath)
+	return rel == "/"+redirectsFileName || rel == "/"+headersFileName
+}
+
+// applyRules aplica os arquivos '_headers' e '_redirects' antes do roteamento.
+// Retorna false quando a resposta já foi enviada (ex: redirect).
+func (s *Server) applyRules(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, bool) {
+	s.rules.reload()
+
+	urlPath := r.URL.Path
+	s.rules.applyHeaders(w, urlPath)
+
+	if s.isRuleFile(urlPath) {
+		http.NotFound(w, r)
+		return w, false
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return w, true
+	}
+
+	rule, target := s.rules.matchRedirect(urlPath, func(p string) bool {
+		fi, err := s.stat(s.localPath(p))
+		return err == nil && (fi.Mode().IsRegular() || fi.Mode().IsDir())
+	})
+	if rule == nil {
+		return w, true
+	}
+
+	s.logger.Tracef("Rule %s %s %d matched %s -> %s", rule.from, rule.to, rule.status, urlPath, target)
+
+	switch {
+	case rule.status >= 300 && rule.status <= 399:
+		if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, rule.status)
+		return w, false
+	case rule.status == http.StatusOK || rule.status == http.StatusNotFound:
+		// the target may carry its own query, which replaces the request's
+		parsed, err := url.Parse(target)
+		if err != nil || parsed.Host != "" {
+			http.Error(w, fmt.Sprintf("Unsupported rewrite target %q", target), http.StatusInternalServerError)
+			return w, false
+		}
+		if s.isRuleFile(parsed.Path) {
+			http.NotFound(w, r)
+			return w, false
+		}
+		u := *r.URL
+		u.Path = parsed.Path
+		u.RawPath = ""
+		if parsed.RawQuery != "" {
+			u.RawQuery = parsed.RawQuery
+		}
+		r.URL = &u
+		if rule.status == http.StatusNotFound {
+			return &statusOverrideWriter{w, http.StatusNotFound}, true
+		}
+		return w, true
+	}
+
+	http.Error(w, fmt.Sprintf("Unsupported rule status %d", rule.status), http.StatusInternalServerError)
+	return w, false
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMatchRulePath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		urlPath string
+		match   bool
+		params  map[string]string
+	}{
+		{"/home", "/home", true, map[string]string{}},
+		{"/home", "/home/", true, map[string]string{}},
+		{"/home", "/about", false, nil},
+		{"/news/*", "/news/2021/01/post", true, map[string]string{"splat": "2021/01/post"}},
+		{"/news/*", "/news", true, map[string]string{"splat": ""}},
+		{"/news/:year/:month", "/news/2021/01", true, map[string]string{"year": "2021", "month": "01"}},
+		{"/news/:year/:month", "/news/2021", false, nil},
+		{"/js/app.*", "/js/app.95ec3ca2.js", true, map[string]string{"splat": "95ec3ca2.js"}},
+		{"/*", "/", true, map[string]string{"splat": ""}},
+	}
+
+	for _, tt := range tests {
+		params, ok := matchRulePath(tt.pattern, tt.urlPath)
+		if ok != tt.match {
+			t.Fatalf("matchRulePath(%q, %q) = %v want %v", tt.pattern, tt.urlPath, ok, tt.match)
+		}
+		for k, v := range tt.params {
+			if params[k] != v {
+				t.Fatalf("matchRulePath(%q, %q) param %s = %q want %q", tt.pattern, tt.urlPath, k, params[k], v)
+			}
+		}
+	}
+}
+
+func newRulesTestDir(t *testing.T, redirects string, headers string) string {
+	return writeTestFiles(t, map[string]string{
+		redirectsFileName:  redirects,
+		headersFileName:    headers,
+		"index.html":       "<html><body>index</body></html>",
+		"404.html":         "<html><body>not found</body></html>",
+		"blog/2021/a.html": "<html><body>a</body></html>",
+		"api-v2/users":     "users-v2",
+		"api/users":        "users-v1",
+	})
+}
+
+func TestRuleFiles(t *testing.T) {
+	redirects := `
+# comment
+/home              /                  301
+/news/:year/*      /blog/:year/:splat 302
+/api/*             /api-v2/:splat     200!
+/shadowed/*        /index.html        200
+/*                 /404.html          404
+`
+	headers := `
+/*
+  X-Frame-Options: DENY
+/blog/*
+  Cache-Control: no-cache
+`
+	dir := newRulesTestDir(t, redirects, headers)
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithRuleFiles(true))
+
+	tests := []struct {
+		url      string
+		status   int
+		location string
+		body     string
+	}{
+		{"/home?x=1", http.StatusMovedPermanently, "/?x=1", ""},
+		{"/news/2021/a.html", http.StatusFound, "/blog/2021/a.html", ""},
+		{"/api/users", http.StatusOK, "", "users-v2"},
+		{"/shadowed/anything", http.StatusOK, "", "<html><body>index</body></html>"},
+		{"/missing.txt", http.StatusNotFound, "", "<html><body>not found</body></html>"},
+		{"/_redirects", http.StatusNotFound, "", ""},
+		{"/_redirects/", http.StatusNotFound, "", ""},
+		{"/_headers/", http.StatusNotFound, "", ""},
+		{"/blog/2021/a.html", http.StatusOK, "", "<html><body>a</body></html>"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tt.status {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", tt.url, status, tt.status)
+		}
+		if location := rr.Header().Get("Location"); location != tt.location {
+			t.Fatalf("%s: handler returned wrong header Location: got %v want %v", tt.url, location, tt.location)
+		}
+		if tt.body != "" && rr.Body.String() != tt.body {
+			t.Fatalf("%s: handler returned wrong body: got %v want %v", tt.url, rr.Body.String(), tt.body)
+		}
+		if frame := rr.Header().Get("X-Frame-Options"); frame != "DENY" {
+			t.Fatalf("%s: handler returned wrong header X-Frame-Options: got %v want %v", tt.url, frame, "DENY")
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/blog/2021/a.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if cache := rr.Header().Get("Cache-Control"); cache != "no-cache" {
+		t.Fatalf("handler returned wrong header Cache-Control: got %v want %v", cache, "no-cache")
+	}
+}
+
+func TestRuleFilesRewriteTarget(t *testing.T) {
+	redirects := `
+/find/*    /api-v2/users?q=find   200!
+/users     /api-v2/users          200!
+/config/*  /:splat                200!
+`
+	dir := newRulesTestDir(t, redirects, "")
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithRuleFiles(true))
+
+	tests := []struct {
+		url    string
+		path   string
+		query  string
+		status int
+	}{
+		// the query of the target replaces the request's, otherwise it is kept
+		{"/find/gopher?page=2", "/api-v2/users", "q=find", http.StatusOK},
+		{"/users?page=2", "/api-v2/users", "page=2", http.StatusOK},
+		// rewrites must not expose the rule files
+		{"/config/_redirects", "/config/_redirects", "", http.StatusNotFound},
+		{"/config/_headers", "/config/_headers", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		rr := httptest.NewRecorder()
+		_, ok := s.applyRules(rr, req)
+		if ok != (tt.status == http.StatusOK) || rr.Code != tt.status {
+			t.Fatalf("%s: applyRules returned %v with status %d", tt.url, ok, rr.Code)
+		}
+		if ok && (req.URL.Path != tt.path || req.URL.RawQuery != tt.query) {
+			t.Fatalf("%s: rewritten to %q with query %q", tt.url, req.URL.Path, req.URL.RawQuery)
+		}
+	}
+}
+
+func TestRuleFilesReload(t *testing.T) {
+	dir := newRulesTestDir(t, "/old /index.html 302\n", "")
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithRuleFiles(true))
+
+	// make sure the modification time changes even on coarse grained filesystems
+	redirectsPath := path.Join(dir, redirectsFileName)
+	if err := ioutil.WriteFile(redirectsPath, []byte("/new /index.html 302\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(redirectsPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/new", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusFound)
+	}
+}
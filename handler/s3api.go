@@ -0,0 +1,1282 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/sigv4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+	// s3MaxKeys é o máximo de chaves por página do ListObjects, como no S3
+	s3MaxKeys      = 1000
+	s3MaxPartCount = 10000
+	// s3MaxPartSize é o maior corpo de um UploadPart, como no S3
+	s3MaxPartSize = 5 << 30
+	// s3MaxUploads e s3MaxClientUploads limitam os uploads multipart abertos
+	// da API e de cada IP, as partes ocupam diretórios temporários
+	s3MaxUploads       = 1024
+	s3MaxClientUploads = 32
+	// s3UploadTTL é o tempo que um upload multipart sem novas partes é mantido
+	s3UploadTTL           = 24 * time.Hour
+	s3UploadSweepInterval = time.Hour
+	// s3MaxXMLBody limita os corpos XML do DeleteObjects e do CompleteMultipartUpload
+	s3MaxXMLBody = 4 << 20
+	s3TimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// s3Subresources são as operações do S3 identificadas pela query string que
+// não fazem parte do subconjunto implementado (ex: '?acl' e '?versioning').
+var s3Subresources = []string{"acl", "cors", "lifecycle", "policy", "tagging", "versioning", "versions", "website", "uploads"}
+
+var (
+	errS3BadDigest     = errors.New("Content-MD5 does not match the content")
+	errS3InvalidDigest = errors.New("Invalid Content-MD5")
+	errS3StopWalk      = errors.New("stop walk")
+	errS3TooLarge      = errors.New("Your proposed upload exceeds the maximum allowed size")
+)
+
+// S3API atende um subconjunto da API do S3 sobre o staticDirPath de um Server,
+// para ferramentas como o aws-cli, o rclone e os SDKs. Os buckets são os
+// diretórios do primeiro nível e as chaves são os caminhos dentro deles. Somente
+// o endereçamento por caminho ('http://host:port/bucket/key') é suportado.
+//
+// As operações seguem as políticas de acesso do Server (exclude, hidden e
+// symlinks) e as escritas exigem que os uploads estejam habilitados.
+type S3API struct {
+	s      *Server
+	logger *logrus.Entry
+	// keys mapeia a chave de acesso para o segredo, sem chaves o acesso é anônimo
+	keys map[string]string
+
+	mu      sync.Mutex
+	uploads map[string]*multipartUpload
+	done    chan struct{}
+}
+
+// multipartUpload guarda as partes de um upload em um diretório temporário até
+// o CompleteMultipartUpload.
+type multipartUpload struct {
+	bucket string
+	key    string
+	dir    string
+	// client é o IP que criou o upload
+	client string
+
+	mu    sync.Mutex
+	parts map[int]s3Part
+	// size é a soma dos tamanhos das partes
+	size    int64
+	writing int
+	updated time.Time
+}
+
+// s3Part é uma parte recebida de um upload multipart.
+type s3Part struct {
+	etag string
+	size int64
+}
+
+// NewS3API cria a API do S3 sobre os arquivos de s. Cada uma das keys tem o
+// formato 'ACCESS_KEY:SECRET_KEY' e as requisições devem ser assinadas com o
+// AWS Signature Version 4 por uma delas. Sem keys o acesso é anônimo, o que
+// não é permitido quando o Server exige autenticação.
+func NewS3API(s *Server, keys []string, logger *logrus.Entry) (*S3API, error) {
+	a := &S3API{
+		s:       s,
+		logger:  logger,
+		keys:    make(map[string]string),
+		uploads: make(map[string]*multipartUpload),
+		done:    make(chan struct{}),
+	}
+	for _, key := range keys {
+		i := strings.Index(key, ":")
+		if i <= 0 || i == len(key)-1 {
+			return nil, fmt.Errorf("%w: expected 'ACCESS_KEY:SECRET_KEY'", ErrS3Key)
+		}
+		a.keys[key[:i]] = key[i+1:]
+	}
+	if len(a.keys) == 0 && s.auth != nil {
+		return nil, fmt.Errorf("%w: the server requires authentication, configure an S3 access key", ErrS3Key)
+	}
+	go a.sweepUploads(s3UploadSweepInterval)
+	return a, nil
+}
+
+func (a *S3API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mw := NewLoggingInterceptorOnServer(http.HandlerFunc(a.serveS3), a.logger.WithField("server", "interceptor-on-server"))
+	mw.ServeHTTP(w, a.s.withClient(r))
+}
+
+// Close descarta as partes dos uploads multipart não concluídos.
+func (a *S3API) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-a.done:
+	default:
+		close(a.done)
+	}
+	for id, u := range a.uploads {
+		os.RemoveAll(u.dir)
+		delete(a.uploads, id)
+	}
+	return nil
+}
+
+func (a *S3API) serveS3(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Amz-Request-Id", newRequestID())
+	if !a.allowed(r, r.Method, r.URL.Path) {
+		a.sendError(w, r, http.StatusForbidden, "AccessDenied", "Access denied")
+		return
+	}
+
+	if a.s.rateLimit != nil {
+		// S3 clients retry SlowDown with backoff, like the 429 of the http server
+		if wait, ok := a.s.rateLimit.allow(clientIP(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			a.sendError(w, r, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate")
+			return
+		}
+	}
+
+	var sig *sigv4.Signature
+	if len(a.keys) > 0 {
+		var err error
+		sig, err = sigv4.Verify(r, a.secretKey, time.Now())
+		if a.s.rateLimit != nil {
+			// like the basic auth, only wrong credentials count as failures
+			if errors.Is(err, sigv4.ErrAccessKey) || errors.Is(err, sigv4.ErrSignature) {
+				a.s.rateLimit.authFailed(clientIP(r), time.Now())
+			} else if err == nil {
+				a.s.rateLimit.authSucceeded(clientIP(r))
+			}
+		}
+		if err != nil {
+			a.sendAuthError(w, r, err)
+			return
+		}
+	}
+
+	bucket, key := splitBucketKey(r.URL.Path)
+	query := r.URL.Query()
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			a.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+			return
+		}
+		a.listBuckets(w, r)
+		return
+	}
+	if !validBucket(bucket) || a.s.checkAccess(a.objectPath(bucket, "")) != nil {
+		a.sendError(w, r, http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid")
+		return
+	}
+	if key != "" && !validKey(key) {
+		a.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "The specified key is not valid")
+		return
+	}
+	if hasSubresource(query) && !(key != "" && (hasParam(query, "uploads") || hasParam(query, "uploadId"))) {
+		a.sendError(w, r, http.StatusNotImplemented, "NotImplemented", "This operation is not supported")
+		return
+	}
+
+	write := r.Method == http.MethodPut || r.Method == http.MethodPost || r.Method == http.MethodDelete
+	if write && (!a.s.uploadEnabled || a.s.spaMode) {
+		a.sendError(w, r, http.StatusForbidden, "AccessDenied", "Uploads are disabled")
+		return
+	}
+
+	if key == "" {
+		switch {
+		case r.Method == http.MethodHead:
+			a.headBucket(w, r, bucket)
+		case r.Method == http.MethodGet && hasParam(query, "location"):
+			a.getBucketLocation(w, r, bucket)
+		case r.Method == http.MethodGet:
+			a.listObjects(w, r, bucket, query)
+		case r.Method == http.MethodPut:
+			a.createBucket(w, r, bucket)
+		case r.Method == http.MethodDelete:
+			a.deleteBucket(w, r, bucket)
+		case r.Method == http.MethodPost && hasParam(query, "delete"):
+			a.deleteObjects(w, r, bucket, sig)
+		default:
+			a.sendError(w, r, http.StatusNotImplemented, "NotImplemented", "This operation is not supported")
+		}
+		return
+	}
+
+	if err := a.s.checkAccess(a.objectPath(bucket, key)); err != nil {
+		a.logger.Errorf("S3 error: %s", err)
+		a.sendError(w, r, http.StatusForbidden, "AccessDenied", "Access denied")
+		return
+	}
+	if !a.bucketExists(bucket) {
+		a.sendError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && !hasParam(query, "uploadId"), r.Method == http.MethodHead:
+		a.getObject(w, r, bucket, key)
+	case r.Method == http.MethodPut && hasParam(query, "uploadId"):
+		a.uploadPart(w, r, bucket, key, query, sig)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") == "":
+		a.putObject(w, r, bucket, key, sig)
+	case r.Method == http.MethodPost && hasParam(query, "uploads"):
+		a.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && hasParam(query, "uploadId"):
+		a.completeMultipartUpload(w, r, bucket, key, query.Get("uploadId"), sig)
+	case r.Method == http.MethodDelete && hasParam(query, "uploadId"):
+		a.abortMultipartUpload(w, r, bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodDelete:
+		a.deleteObject(w, r, bucket, key)
+	default:
+		a.sendError(w, r, http.StatusNotImplemented, "NotImplemented", "This operation is not supported")
+	}
+}
+
+// allowed aplica a lista de acesso do Server ao método e ao caminho do objeto,
+// que no S3 é o mesmo caminho servido por http ('/bucket/key').
+func (a *S3API) allowed(r *http.Request, method string, urlPath string) bool {
+	if a.s.accessList == nil {
+		return true
+	}
+	ip := clientIP(r)
+	rule, ok := a.s.accessList.check(ip, method, urlPath)
+	if !ok {
+		a.logger.Warnf("Access list: S3 %s %s from %s denied by %q", method, urlPath, ip, rule)
+	}
+	return ok
+}
+
+func (a *S3API) secretKey(accessKey string) (string, bool) {
+	secret, ok := a.keys[accessKey]
+	return secret, ok
+}
+
+// splitBucketKey separa o caminho '/bucket/key' da requisição.
+func splitBucketKey(urlPath string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(urlPath, "/"), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func validBucket(bucket string) bool {
+	return bucket != "." && bucket != ".." && !strings.ContainsAny(bucket, `/\`)
+}
+
+// validKey aceita somente chaves que correspondem a um caminho limpo dentro do
+// bucket. A '/' final indica um diretório (ex: 'fotos/').
+func validKey(key string) bool {
+	k := strings.TrimSuffix(key, "/")
+	return k != "" && path.Clean("/"+k) == "/"+k && !strings.Contains(k, `\`)
+}
+
+func hasSubresource(query url.Values) bool {
+	for _, name := range s3Subresources {
+		if hasParam(query, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasParam indica se o parâmetro está na query, mesmo sem valor (ex: '?uploads').
+func hasParam(query url.Values, name string) bool {
+	_, ok := query[name]
+	return ok
+}
+
+// objectPath retorna o caminho da chave dentro do staticDirPath.
+func (a *S3API) objectPath(bucket string, key string) string {
+	return path.Join(a.s.staticDirPath, bucket, key)
+}
+
+func (a *S3API) bucketExists(bucket string) bool {
+	fi, err := a.s.stat(a.objectPath(bucket, ""))
+	return err == nil && fi.IsDir()
+}
+
+// storageName retorna o nome da chave no Storage do Server.
+func (a *S3API) storageName(bucket string, key string) string {
+	name, _ := a.s.relToRoot(a.objectPath(bucket, key))
+	return name
+}
+
+// objectETag identifica a versão do arquivo pela data de modificação e pelo
+// tamanho. O '-' indica aos clientes que o ETag não é o md5 do conteúdo.
+func objectETag(fi os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// buckets
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+var s3DefaultOwner = s3Owner{ID: "gouploadserver", DisplayName: "gouploadserver"}
+
+func (a *S3API) listBuckets(w http.ResponseWriter, r *http.Request) {
+	type bucketEntry struct {
+		Name         string
+		CreationDate string
+	}
+	result := struct {
+		XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+		Xmlns   string        `xml:"xmlns,attr"`
+		Owner   s3Owner       `xml:"Owner"`
+		Buckets []bucketEntry `xml:"Buckets>Bucket"`
+	}{Xmlns: s3Namespace, Owner: s3DefaultOwner}
+
+	root := a.objectPath("", "")
+	dir, err := a.s.openDir(root)
+	if err != nil {
+		a.sendInternalError(w, r, err)
+		return
+	}
+	entries, err := dir.ReadDir(-1)
+	dir.Close()
+	if err != nil && err != io.EOF {
+		a.sendInternalError(w, r, err)
+		return
+	}
+	for _, e := range entries {
+		if !a.s.listable(root, e.Name(), e.Type()) {
+			continue
+		}
+		fi, err := a.s.stat(path.Join(root, e.Name()))
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		result.Buckets = append(result.Buckets, bucketEntry{e.Name(), fi.ModTime().UTC().Format(s3TimeFormat)})
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool { return result.Buckets[i].Name < result.Buckets[j].Name })
+	a.sendXML(w, http.StatusOK, result)
+}
+
+func (a *S3API) headBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !a.bucketExists(bucket) {
+		a.sendError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *S3API) getBucketLocation(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !a.bucketExists(bucket) {
+		a.sendError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	a.sendXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"LocationConstraint"`
+		Xmlns   string   `xml:"xmlns,attr"`
+	}{Xmlns: s3Namespace})
+}
+
+func (a *S3API) createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if a.bucketExists(bucket) {
+		a.sendError(w, r, http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket already exists")
+		return
+	}
+	if err := a.s.storage.MkdirAll(a.storageName(bucket, "")); err != nil {
+		a.sendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *S3API) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	dir, err := a.s.openDir(a.objectPath(bucket, ""))
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	entries, _ := dir.ReadDir(1)
+	dir.Close()
+	if len(entries) > 0 {
+		a.sendError(w, r, http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty")
+		return
+	}
+	if err := a.s.storage.Remove(a.storageName(bucket, "")); err != nil {
+		a.sendInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listagem
+
+// s3ListParams são os parâmetros do ListObjects e do ListObjectsV2.
+type s3ListParams struct {
+	prefix    string
+	delimiter string
+	// after é a última chave já retornada (start-after, marker ou continuation-token)
+	after   string
+	maxKeys int
+}
+
+type s3Object struct {
+	key  string
+	info os.FileInfo
+}
+
+type s3ListEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+func (a *S3API) listObjects(w http.ResponseWriter, r *http.Request, bucket string, query url.Values) {
+	get := query.Get
+	v2 := get("list-type") == "2"
+	p := s3ListParams{prefix: get("prefix"), delimiter: get("delimiter"), maxKeys: s3MaxKeys}
+	if v := get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			a.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys")
+			return
+		}
+		if n < p.maxKeys {
+			p.maxKeys = n
+		}
+	}
+	token := get("continuation-token")
+	switch {
+	case !v2:
+		p.after = get("marker")
+	case token != "":
+		after, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			a.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+			return
+		}
+		p.after = string(after)
+	default:
+		p.after = get("start-after")
+	}
+
+	if !a.bucketExists(bucket) {
+		a.sendError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	objects, prefixes, truncated, err := a.list(bucket, p)
+	if err != nil {
+		a.sendInternalError(w, r, err)
+		return
+	}
+
+	encode := func(s string) string { return s }
+	encodingType := get("encoding-type")
+	if encodingType == "url" {
+		encode = sigv4.EncodePath
+	}
+	last := ""
+	var contents []s3ListEntry
+	for _, o := range objects {
+		contents = append(contents, s3ListEntry{
+			Key:          encode(o.key),
+			LastModified: o.info.ModTime().UTC().Format(s3TimeFormat),
+			ETag:         objectETag(o.info),
+			Size:         o.info.Size(),
+			StorageClass: "STANDARD",
+		})
+		last = maxString(last, o.key)
+	}
+	var commonPrefixes []s3CommonPrefix
+	for _, prefix := range prefixes {
+		commonPrefixes = append(commonPrefixes, s3CommonPrefix{encode(prefix)})
+		last = maxString(last, prefix)
+	}
+
+	if v2 {
+		result := struct {
+			XMLName               xml.Name `xml:"ListBucketResult"`
+			Xmlns                 string   `xml:"xmlns,attr"`
+			Name                  string
+			Prefix                string
+			Delimiter             string `xml:",omitempty"`
+			MaxKeys               int
+			KeyCount              int
+			IsTruncated           bool
+			EncodingType          string `xml:",omitempty"`
+			ContinuationToken     string `xml:",omitempty"`
+			NextContinuationToken string `xml:",omitempty"`
+			StartAfter            string `xml:",omitempty"`
+			Contents              []s3ListEntry
+			CommonPrefixes        []s3CommonPrefix
+		}{
+			Xmlns: s3Namespace, Name: bucket, Prefix: encode(p.prefix), Delimiter: encode(p.delimiter),
+			MaxKeys: p.maxKeys, KeyCount: len(contents) + len(commonPrefixes), IsTruncated: truncated,
+			EncodingType: encodingType, ContinuationToken: token, StartAfter: encode(get("start-after")),
+			Contents: contents, CommonPrefixes: commonPrefixes,
+		}
+		if truncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+		a.sendXML(w, http.StatusOK, result)
+		return
+	}
+
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Xmlns          string   `xml:"xmlns,attr"`
+		Name           string
+		Prefix         string
+		Marker         string
+		NextMarker     string `xml:",omitempty"`
+		Delimiter      string `xml:",omitempty"`
+		MaxKeys        int
+		IsTruncated    bool
+		EncodingType   string `xml:",omitempty"`
+		Contents       []s3ListEntry
+		CommonPrefixes []s3CommonPrefix
+	}{
+		Xmlns: s3Namespace, Name: bucket, Prefix: encode(p.prefix), Marker: encode(p.after),
+		Delimiter: encode(p.delimiter), MaxKeys: p.maxKeys, IsTruncated: truncated,
+		EncodingType: encodingType, Contents: contents, CommonPrefixes: commonPrefixes,
+	}
+	if truncated {
+		result.NextMarker = encode(last)
+	}
+	a.sendXML(w, http.StatusOK, result)
+}
+
+func maxString(a string, b string) string {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+// list retorna até p.maxKeys objetos e prefixos comuns em ordem, como no S3.
+func (a *S3API) list(bucket string, p s3ListParams) ([]s3Object, []string, bool, error) {
+	var objects []s3Object
+	var prefixes []string
+	truncated := false
+	err := a.walk(bucket, "", p, func(key string, fi os.FileInfo) error {
+		if fi != nil && p.delimiter != "" {
+			if i := strings.Index(key[len(p.prefix):], p.delimiter); i >= 0 {
+				key, fi = key[:len(p.prefix)+i+len(p.delimiter)], nil
+				if key <= p.after || (len(prefixes) > 0 && prefixes[len(prefixes)-1] == key) {
+					return nil
+				}
+			}
+		}
+		if len(objects)+len(prefixes) == p.maxKeys {
+			truncated = true
+			return errS3StopWalk
+		}
+		if fi == nil {
+			prefixes = append(prefixes, key)
+		} else {
+			objects = append(objects, s3Object{key, fi})
+		}
+		return nil
+	})
+	if err != nil && err != errS3StopWalk {
+		return nil, nil, false, err
+	}
+	return objects, prefixes, truncated, nil
+}
+
+// walk percorre o diretório dirKey do bucket chamando fn com as chaves em ordem
+// lexicográfica, a mesma do S3. fi é nil para os diretórios agrupados pelo
+// delimitador '/'. Os diretórios fora do prefixo ou já listados (p.after) não
+// são lidos.
+func (a *S3API) walk(bucket string, dirKey string, p s3ListParams, fn func(key string, fi os.FileInfo) error) error {
+	dirPath := a.objectPath(bucket, dirKey)
+	dir, err := a.s.openDir(dirPath)
+	if err != nil {
+		return err
+	}
+	entries, err := dir.ReadDir(-1)
+	dir.Close()
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	// the key of a directory ends with '/', so sorting by key keeps the order
+	// of the keys inside it (ex: 'a.txt' < 'a/b.txt' < 'a0.txt')
+	var children []s3Object
+	for _, e := range entries {
+		if !a.s.listable(dirPath, e.Name(), e.Type()) {
+			continue
+		}
+		fi, err := e.Info()
+		if err == nil && e.Type()&os.ModeSymlink != 0 {
+			fi, err = a.s.stat(path.Join(dirPath, e.Name()))
+		}
+		if err != nil {
+			// removed while listing or a broken symlink
+			continue
+		}
+		key := dirKey + e.Name()
+		if fi.IsDir() {
+			key += "/"
+		} else if !fi.Mode().IsRegular() {
+			continue
+		}
+		children = append(children, s3Object{key, fi})
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].key < children[j].key })
+
+	for _, c := range children {
+		if !c.info.IsDir() {
+			if strings.HasPrefix(c.key, p.prefix) && c.key > p.after {
+				if err := fn(c.key, c.info); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !strings.HasPrefix(c.key, p.prefix) && !strings.HasPrefix(p.prefix, c.key) {
+			continue
+		}
+		if p.after >= c.key && !strings.HasPrefix(p.after, c.key) {
+			continue
+		}
+		if p.delimiter == "/" && strings.HasPrefix(c.key, p.prefix) {
+			// the whole directory is a single common prefix, even when empty
+			if c.key > p.after {
+				if err := fn(c.key, nil); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := a.walk(bucket, c.key, p, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objetos
+
+// getObject atende o GetObject e o HeadObject, com suporte a Range e aos
+// headers condicionais. Diretórios são vistos como objetos vazios 'key/'.
+func (a *S3API) getObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	objectPath := a.objectPath(bucket, key)
+	fi, err := a.s.stat(objectPath)
+	if err != nil || fi.IsDir() != strings.HasSuffix(key, "/") || !(fi.IsDir() || fi.Mode().IsRegular()) {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			a.logger.Errorf("S3 error: %s", err)
+		}
+		a.sendError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+
+	w.Header().Set("ETag", objectETag(fi))
+	if fi.IsDir() {
+		w.Header().Set("Content-Type", "application/x-directory")
+		http.ServeContent(w, r, key, fi.ModTime(), bytes.NewReader(nil))
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		release, ok := a.acquireTransfer(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	f, err := a.s.open(objectPath)
+	if err != nil {
+		a.sendInternalError(w, r, err)
+		return
+	}
+	defer f.Close()
+	// ServeContent seeks the file for the ranges and reads it through the limits
+	content := struct {
+		io.Reader
+		io.Seeker
+	}{a.s.throttleDownload(r, f), f}
+	http.ServeContent(w, r, path.Base(key), fi.ModTime(), content)
+}
+
+// putObject grava o objeto com o readerToFile, assim como os uploads do
+// navegador, criando os diretórios da chave. A chave 'key/' cria um diretório.
+func (a *S3API) putObject(w http.ResponseWriter, r *http.Request, bucket string, key string, sig *sigv4.Signature) {
+	body, sum, err := objectBody(r, sig)
+	if err != nil {
+		a.sendBodyError(w, r, err)
+		return
+	}
+
+	if strings.HasSuffix(key, "/") {
+		if _, err := io.Copy(ioutil.Discard, body); err != nil {
+			a.sendBodyError(w, r, err)
+			return
+		}
+		if err := a.s.storage.MkdirAll(a.storageName(bucket, key)); err != nil {
+			a.sendInternalError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum.Sum(nil))+`"`)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	release, ok := a.acquireTransfer(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+	fileSent, err := a.writeObject(bucket, key, body, requestSource(r, "s3", r.ContentLength))
+	if err != nil {
+		a.sendBodyError(w, r, err)
+		return
+	}
+	a.logger.Infof("File sent: %s", fileSent)
+	a.s.notifyUpload(fileSent)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum.Sum(nil))+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeObject cria os diretórios da chave e grava o conteúdo de body. src é
+// nil quando o conteúdo já foi recebido (ex: partes de um upload multipart).
+func (a *S3API) writeObject(bucket string, key string, body io.Reader, src *uploadSource) (string, error) {
+	objectPath := a.objectPath(bucket, key)
+	dirPath := path.Dir(objectPath)
+	if err := a.s.storage.MkdirAll(a.storageName(bucket, path.Dir(key))); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 32*1024)
+	return a.s.readerToFile(body, dirPath, path.Base(objectPath), true, buf, src)
+}
+
+// objectBody retorna o corpo verificado pela assinatura e pelo Content-MD5, e
+// o md5 calculado durante a leitura, usado como ETag.
+func objectBody(r *http.Request, sig *sigv4.Signature) (io.Reader, hash.Hash, error) {
+	sum := md5.New()
+	body := io.TeeReader(sigv4.Body(r, sig), sum)
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		want, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(want) != md5.Size {
+			return nil, nil, errS3InvalidDigest
+		}
+		body = &digestReader{r: body, sum: sum, want: want}
+	}
+	return body, sum, nil
+}
+
+// digestReader compara o md5 do corpo com o Content-MD5 no fim da leitura.
+type digestReader struct {
+	r    io.Reader
+	sum  hash.Hash
+	want []byte
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err == io.EOF && !bytes.Equal(d.sum.Sum(nil), d.want) {
+		return n, errS3BadDigest
+	}
+	return n, err
+}
+
+// deleteObject remove o objeto e os diretórios que ficaram vazios, já que no
+// S3 os prefixos deixam de existir junto com as chaves. Remover uma chave
+// inexistente não é um erro.
+func (a *S3API) deleteObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	if err := a.removeKey(bucket, key); err != nil {
+		a.sendInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *S3API) removeKey(bucket string, key string) error {
+	fi, err := a.s.stat(a.objectPath(bucket, key))
+	if errors.Is(err, os.ErrNotExist) || (err == nil && fi.IsDir() != strings.HasSuffix(key, "/")) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.s.storage.Remove(a.storageName(bucket, key)); err != nil {
+		if fi.IsDir() {
+			// a directory with files is still a prefix of other keys
+			return nil
+		}
+		return err
+	}
+	for dir := path.Dir(strings.TrimSuffix(key, "/")); dir != "."; dir = path.Dir(dir) {
+		if a.s.storage.Remove(a.storageName(bucket, dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (a *S3API) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string, sig *sigv4.Signature) {
+	var request struct {
+		Quiet   bool
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if !a.readXML(w, r, sig, &request) {
+		return
+	}
+	if !a.bucketExists(bucket) {
+		a.sendError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	type deleted struct {
+		Key string
+	}
+	type deleteError struct {
+		Key     string
+		Code    string
+		Message string
+	}
+	result := struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Deleted []deleted
+		Error   []deleteError
+	}{Xmlns: s3Namespace}
+
+	for _, o := range request.Objects {
+		switch {
+		case !validKey(o.Key):
+			result.Error = append(result.Error, deleteError{o.Key, "InvalidArgument", "The specified key is not valid"})
+		case a.s.checkAccess(a.objectPath(bucket, o.Key)) != nil, !a.allowed(r, http.MethodDelete, "/"+bucket+"/"+o.Key):
+			result.Error = append(result.Error, deleteError{o.Key, "AccessDenied", "Access denied"})
+		default:
+			if err := a.removeKey(bucket, o.Key); err != nil {
+				a.logger.Errorf("S3 error: %s", err)
+				result.Error = append(result.Error, deleteError{o.Key, "InternalError", "We encountered an internal error"})
+			} else if !request.Quiet {
+				result.Deleted = append(result.Deleted, deleted{o.Key})
+			}
+		}
+	}
+	a.sendXML(w, http.StatusOK, result)
+}
+
+// multipart
+
+func (a *S3API) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	if strings.HasSuffix(key, "/") {
+		a.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "The specified key is not valid")
+		return
+	}
+	dir, err := ioutil.TempDir("", "gouploadserver-multipart-*")
+	if err != nil {
+		a.sendInternalError(w, r, err)
+		return
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	u := &multipartUpload{bucket: bucket, key: key, dir: dir, client: clientIP(r), parts: make(map[int]s3Part), updated: time.Now()}
+	if err := a.addUpload(id, u); err != nil {
+		os.RemoveAll(dir)
+		a.logger.Errorf("S3 error: %s", err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(s3UploadSweepInterval/time.Second)))
+		a.sendError(w, r, http.StatusServiceUnavailable, "SlowDown", "Too many open multipart uploads")
+		return
+	}
+
+	a.sendXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadId: id})
+}
+
+// addUpload registra o upload id, retorna ErrTooManyResumableUploads quando a
+// API ou o cliente já tem o máximo de uploads abertos.
+func (a *S3API) addUpload(id string, u *multipartUpload) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.uploads) >= s3MaxUploads {
+		return ErrTooManyResumableUploads
+	}
+	n := 0
+	for _, old := range a.uploads {
+		if old.client == u.client {
+			n++
+		}
+	}
+	if n >= s3MaxClientUploads {
+		return fmt.Errorf("%w: %d multipart uploads of %s", ErrTooManyResumableUploads, n, u.client)
+	}
+	a.uploads[id] = u
+	return nil
+}
+
+func (a *S3API) sweepUploads(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			a.expireUploads(now)
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// expireUploads descarta os uploads sem novas partes há mais de s3UploadTTL em now.
+func (a *S3API) expireUploads(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, u := range a.uploads {
+		u.mu.Lock()
+		stale := u.writing == 0 && now.Sub(u.updated) > s3UploadTTL
+		u.mu.Unlock()
+		if stale {
+			os.RemoveAll(u.dir)
+			delete(a.uploads, id)
+		}
+	}
+}
+
+// upload retorna o upload multipart id da chave, ou envia NoSuchUpload.
+func (a *S3API) upload(w http.ResponseWriter, r *http.Request, bucket string, key string, id string) *multipartUpload {
+	a.mu.Lock()
+	u, ok := a.uploads[id]
+	a.mu.Unlock()
+	if !ok || u.bucket != bucket || u.key != key {
+		a.sendError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist")
+		return nil
+	}
+	return u
+}
+
+func (a *S3API) uploadPart(w http.ResponseWriter, r *http.Request, bucket string, key string, query url.Values, sig *sigv4.Signature) {
+	id := query.Get("uploadId")
+	number, _ := strconv.Atoi(query.Get("partNumber"))
+	if number < 1 || number > s3MaxPartCount {
+		a.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000")
+		return
+	}
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		a.sendError(w, r, http.StatusNotImplemented, "NotImplemented", "This operation is not supported")
+		return
+	}
+	u := a.upload(w, r, bucket, key, id)
+	if u == nil {
+		return
+	}
+	body, sum, err := objectBody(r, sig)
+	if err != nil {
+		a.sendBodyError(w, r, err)
+		return
+	}
+
+	release, ok := a.acquireTransfer(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	u.mu.Lock()
+	u.writing++
+	u.updated = time.Now()
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.writing--
+		u.updated = time.Now()
+		u.mu.Unlock()
+	}()
+
+	size, err := a.writePart(r, u, number, body)
+	if err != nil {
+		a.sendBodyError(w, r, err)
+		return
+	}
+
+	etag := hex.EncodeToString(sum.Sum(nil))
+	u.mu.Lock()
+	u.size += size - u.parts[number].size
+	u.parts[number] = s3Part{etag: etag, size: size}
+	u.mu.Unlock()
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// writePart grava a parte number de u com os limites de banda e o
+// acompanhamento dos uploads do Server, e retorna o seu tamanho. Uma parte
+// acima de s3MaxPartSize, ou que leva o upload acima do limite dos uploads
+// retomáveis, é descartada com errS3TooLarge.
+func (a *S3API) writePart(r *http.Request, u *multipartUpload, number int, body io.Reader) (size int64, err error) {
+	u.mu.Lock()
+	max := a.s.resumableMaxLength - (u.size - u.parts[number].size)
+	u.mu.Unlock()
+	if max > s3MaxPartSize {
+		max = s3MaxPartSize
+	}
+
+	src := requestSource(r, "s3", r.ContentLength)
+	tracked := a.s.uploads.start(src, path.Join("/", u.bucket, u.key))
+	defer func() { tracked.finish(err) }()
+	body = tracked.reader(a.s.throttle(src.ctx, body, directionUpload, src.client, src.user))
+
+	tmp, err := ioutil.TempFile(u.dir, "part-*")
+	if err != nil {
+		return 0, err
+	}
+	size, err = io.Copy(tmp, io.LimitReader(body, max+1))
+	if err == nil && size > max {
+		err = errS3TooLarge
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(u.dir, strconv.Itoa(number)))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return size, nil
+}
+
+// completeMultipartUpload junta as partes em ordem e grava o objeto com o
+// readerToFile. O ETag final segue o S3: o md5 dos md5 das partes e o número
+// de partes (ex: '"...-3"').
+func (a *S3API) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string, id string, sig *sigv4.Signature) {
+	u := a.upload(w, r, bucket, key, id)
+	if u == nil {
+		return
+	}
+	var request struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if !a.readXML(w, r, sig, &request) {
+		return
+	}
+	if len(request.Parts) == 0 {
+		a.sendError(w, r, http.StatusBadRequest, "MalformedXML", "The request must list the uploaded parts")
+		return
+	}
+
+	u.mu.Lock()
+	var readers []io.Reader
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	etags := md5.New()
+	var partErr string
+	for i, part := range request.Parts {
+		if i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+			partErr = "InvalidPartOrder"
+			break
+		}
+		p, ok := u.parts[part.PartNumber]
+		etag := p.etag
+		if !ok || strings.Trim(part.ETag, `"`) != etag {
+			partErr = "InvalidPart"
+			break
+		}
+		f, err := os.Open(filepath.Join(u.dir, strconv.Itoa(part.PartNumber)))
+		if err != nil {
+			partErr = "InvalidPart"
+			break
+		}
+		files = append(files, f)
+		readers = append(readers, f)
+		raw, _ := hex.DecodeString(etag)
+		etags.Write(raw)
+	}
+	u.mu.Unlock()
+	if partErr != "" {
+		a.sendError(w, r, http.StatusBadRequest, partErr, "One or more of the specified parts could not be found or are not in ascending order")
+		return
+	}
+
+	fileSent, err := a.writeObject(bucket, key, io.MultiReader(readers...), nil)
+	if err != nil {
+		a.sendInternalError(w, r, err)
+		return
+	}
+	a.logger.Infof("File sent: %s", fileSent)
+	a.s.notifyUpload(fileSent)
+	a.removeUpload(id)
+
+	a.sendXML(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{
+		Xmlns: s3Namespace, Location: "/" + bucket + "/" + key, Bucket: bucket, Key: key,
+		ETag: fmt.Sprintf(`"%x-%d"`, etags.Sum(nil), len(request.Parts)),
+	})
+}
+
+func (a *S3API) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string, id string) {
+	if a.upload(w, r, bucket, key, id) == nil {
+		return
+	}
+	a.removeUpload(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *S3API) removeUpload(id string) {
+	a.mu.Lock()
+	u, ok := a.uploads[id]
+	delete(a.uploads, id)
+	a.mu.Unlock()
+	if ok {
+		os.RemoveAll(u.dir)
+	}
+}
+
+// acquireTransfer reserva uma vaga de transferência do Server para o cliente
+// de r. Sem vaga responde SlowDown, que os clientes do S3 repetem com backoff.
+func (a *S3API) acquireTransfer(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	if a.s.transfers == nil {
+		return func() {}, true
+	}
+	release, err := a.s.transfers.acquire(r.Context(), clientIP(r))
+	if err != nil {
+		a.logger.Warnf("Transfer limit: %s from %s: %s", r.Method, clientIP(r), err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(transferRetryAfter/time.Second)))
+		a.sendError(w, r, http.StatusServiceUnavailable, "SlowDown", "Too many concurrent transfers")
+		return nil, false
+	}
+	return release, true
+}
+
+// respostas
+
+// readXML lê o corpo XML da requisição em v, enviando o erro quando falha.
+func (a *S3API) readXML(w http.ResponseWriter, r *http.Request, sig *sigv4.Signature, v interface{}) bool {
+	body, _, err := objectBody(r, sig)
+	if err != nil {
+		a.sendBodyError(w, r, err)
+		return false
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, s3MaxXMLBody))
+	if err != nil {
+		a.sendBodyError(w, r, err)
+		return false
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed")
+		return false
+	}
+	return true
+}
+
+func (a *S3API) sendXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		a.logger.Errorf("S3 response error: %s", err)
+	}
+}
+
+// sendError envia o erro no formato XML do S3.
+func (a *S3API) sendError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	a.sendXML(w, status, struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string
+		Message   string
+		Resource  string
+		RequestId string
+	}{Code: code, Message: message, Resource: r.URL.Path, RequestId: w.Header().Get("X-Amz-Request-Id")})
+}
+
+func (a *S3API) sendInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	a.logger.Errorf("S3 error: %s", err)
+	a.sendError(w, r, http.StatusInternalServerError, "InternalError", "We encountered an internal error, please try again")
+}
+
+func (a *S3API) sendAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	a.logger.Errorf("S3 auth error: %s", err)
+	switch {
+	case errors.Is(err, sigv4.ErrMissingAuth):
+		a.sendError(w, r, http.StatusForbidden, "AccessDenied", "Anonymous access is not allowed")
+	case errors.Is(err, sigv4.ErrAccessKey):
+		a.sendError(w, r, http.StatusForbidden, "InvalidAccessKeyId", "The access key does not exist")
+	case errors.Is(err, sigv4.ErrSignature):
+		a.sendError(w, r, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match")
+	case errors.Is(err, sigv4.ErrExpired):
+		a.sendError(w, r, http.StatusForbidden, "RequestTimeTooSkewed", "The request time is too far from the server time or the URL expired")
+	default:
+		a.sendError(w, r, http.StatusBadRequest, "AuthorizationHeaderMalformed", err.Error())
+	}
+}
+
+// sendBodyError envia os erros da leitura e da verificação do corpo.
+func (a *S3API) sendBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, sigv4.ErrPayload):
+		a.sendError(w, r, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The content does not match the signed payload")
+	case errors.Is(err, errS3BadDigest):
+		a.sendError(w, r, http.StatusBadRequest, "BadDigest", errS3BadDigest.Error())
+	case errors.Is(err, errS3InvalidDigest):
+		a.sendError(w, r, http.StatusBadRequest, "InvalidDigest", errS3InvalidDigest.Error())
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		a.sendError(w, r, http.StatusBadRequest, "IncompleteBody", "The request body is incomplete")
+	case errors.Is(err, errS3TooLarge):
+		a.sendError(w, r, http.StatusBadRequest, "EntityTooLarge", errS3TooLarge.Error())
+	case errors.Is(err, ErrUploadIdle):
+		a.sendError(w, r, http.StatusBadRequest, "RequestTimeout", "Your socket connection to the server was not read from or written to within the timeout period")
+	default:
+		a.sendInternalError(w, r, err)
+	}
+}
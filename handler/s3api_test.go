@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/sigv4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testS3AccessKey = "AKIDTEST"
+	testS3SecretKey = "secret/key"
+)
+
+// newTestS3API cria a API sobre um diretório temporário com o bucket 'photos'.
+func newTestS3API(t *testing.T, opts ...Option) (*S3API, string) {
+	dir := writeTestFiles(t, map[string]string{
+		"photos/a.txt":   "hello world",
+		"photos/a/b.txt": "b",
+		"photos/a0.txt":  "a0",
+		"photos/.secret": "hidden",
+		"other/x.txt":    "x",
+	})
+
+	opts = append([]Option{WithAccessPolicy(SymlinksFollowWithinRoot, HiddenDeny, nil)}, opts...)
+	s := NewServer(dir, false, false, logrus.WithField("test", true), opts...)
+	api, err := NewS3API(s, []string{testS3AccessKey + ":" + testS3SecretKey}, logrus.WithField("test", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { api.Close() })
+	return api, dir
+}
+
+// s3Do envia a requisição assinada com as credenciais de teste.
+func s3Do(api *S3API, method string, target string, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://localhost:9000"+target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		sum := sha256.Sum256([]byte(body))
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	secret := testS3SecretKey
+	if header["secret"] != "" {
+		secret = header["secret"]
+		req.Header.Del("secret")
+	}
+	cred := sigv4.Credentials{AccessKey: testS3AccessKey, SecretKey: secret}
+	sigv4.Sign(req, cred, "us-east-1", "s3", payloadHash, time.Now())
+
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, req)
+	return rr
+}
+
+// s3Keys retorna as chaves e os prefixos comuns da listagem, em ordem.
+func s3Keys(body string) []string {
+	var keys []string
+	for _, m := range regexp.MustCompile(`<(?:Key|Prefix)>([^<]+)</`).FindAllStringSubmatch(body, -1) {
+		keys = append(keys, m[1])
+	}
+	return keys
+}
+
+func TestS3APIObjects(t *testing.T) {
+	api, dir := newTestS3API(t)
+
+	rr := s3Do(api, http.MethodGet, "/", "", nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "<Name>photos</Name>") || !strings.Contains(rr.Body.String(), "<Name>other</Name>") {
+		t.Fatalf("ListBuckets returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = s3Do(api, http.MethodPut, "/photos/docs/guide.md", "# Guide", nil)
+	sum := md5.Sum([]byte("# Guide"))
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"`+hex.EncodeToString(sum[:])+`"` {
+		t.Fatalf("PutObject returned %d %q %s", rr.Code, rr.Header().Get("ETag"), rr.Body.String())
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "photos", "docs", "guide.md")); err != nil || string(data) != "# Guide" {
+		t.Fatalf("PutObject wrote %q, %v", data, err)
+	}
+
+	rr = s3Do(api, http.MethodGet, "/photos/a.txt", "", map[string]string{"Range": "bytes=0-4"})
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "hello" {
+		t.Fatalf("GetObject with Range returned %d %q", rr.Code, rr.Body.String())
+	}
+	rr = s3Do(api, http.MethodHead, "/photos/a.txt", "", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Length") != "11" || rr.Header().Get("ETag") == "" {
+		t.Fatalf("HeadObject returned %d %v", rr.Code, rr.Header())
+	}
+	if rr := s3Do(api, http.MethodGet, "/photos/missing.txt", "", nil); rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "<Code>NoSuchKey</Code>") {
+		t.Fatalf("GetObject of a missing key returned %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := s3Do(api, http.MethodGet, "/photos/.secret", "", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("GetObject of a hidden file returned %d", rr.Code)
+	}
+	if rr := s3Do(api, http.MethodGet, "/photos/../other/x.txt", "", nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("GetObject of '..' returned %d", rr.Code)
+	}
+	if rr := s3Do(api, http.MethodGet, "/missing/a.txt", "", nil); rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "NoSuchBucket") {
+		t.Fatalf("GetObject in a missing bucket returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	// keys in S3 order, the hidden file is not listed
+	rr = s3Do(api, http.MethodGet, "/photos?list-type=2", "", nil)
+	want := []string{"a.txt", "a/b.txt", "a0.txt", "docs/guide.md"}
+	if got := s3Keys(rr.Body.String()); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ListObjectsV2 returned %v, want %v", got, want)
+	}
+	rr = s3Do(api, http.MethodGet, "/photos?list-type=2&delimiter=%2F", "", nil)
+	want = []string{"a.txt", "a0.txt", "a/", "docs/"}
+	if got := s3Keys(rr.Body.String()); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ListObjectsV2 with delimiter returned %v, want %v", got, want)
+	}
+	rr = s3Do(api, http.MethodGet, "/photos?list-type=2&prefix=a%2F", "", nil)
+	want = []string{"a/", "a/b.txt"}
+	if got := s3Keys(rr.Body.String()); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ListObjectsV2 with prefix returned %v, want %v", got, want)
+	}
+
+	// pages of 3 keys
+	var listed []string
+	token := ""
+	for page := 0; page < 3; page++ {
+		target := "/photos?list-type=2&max-keys=3"
+		if token != "" {
+			target += "&continuation-token=" + token
+		}
+		body := s3Do(api, http.MethodGet, target, "", nil).Body.String()
+		listed = append(listed, s3Keys(body)...)
+		m := regexp.MustCompile(`<NextContinuationToken>([^<]+)</`).FindStringSubmatch(body)
+		if m == nil {
+			break
+		}
+		token = m[1]
+	}
+	want = []string{"a.txt", "a/b.txt", "a0.txt", "docs/guide.md"}
+	if fmt.Sprint(listed) != fmt.Sprint(want) {
+		t.Fatalf("paginated ListObjectsV2 returned %v, want %v", listed, want)
+	}
+
+	rr = s3Do(api, http.MethodGet, "/photos?marker=a.txt&max-keys=1", "", nil)
+	if got := s3Keys(rr.Body.String()); fmt.Sprint(got) != "[a/b.txt]" || !strings.Contains(rr.Body.String(), "<IsTruncated>true</IsTruncated>") {
+		t.Fatalf("ListObjects with marker returned %s", rr.Body.String())
+	}
+
+	// deleting the last key removes the empty directories
+	if rr := s3Do(api, http.MethodDelete, "/photos/docs/guide.md", "", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("DeleteObject returned %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photos", "docs")); !os.IsNotExist(err) {
+		t.Fatalf("DeleteObject kept the empty directory: %v", err)
+	}
+	if rr := s3Do(api, http.MethodDelete, "/photos/docs/guide.md", "", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("DeleteObject of a missing key returned %d", rr.Code)
+	}
+
+	rr = s3Do(api, http.MethodPost, "/photos?delete", "<Delete><Object><Key>a.txt</Key></Object><Object><Key>a0.txt</Key></Object></Delete>", nil)
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "<Deleted>") != 2 {
+		t.Fatalf("DeleteObjects returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := s3Do(api, http.MethodPut, "/new-bucket", "", nil); rr.Code != http.StatusOK {
+		t.Fatalf("CreateBucket returned %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := s3Do(api, http.MethodHead, "/new-bucket", "", nil); rr.Code != http.StatusOK {
+		t.Fatalf("HeadBucket returned %d", rr.Code)
+	}
+	if rr := s3Do(api, http.MethodDelete, "/photos", "", nil); rr.Code != http.StatusConflict {
+		t.Fatalf("DeleteBucket of a bucket with files returned %d", rr.Code)
+	}
+	if rr := s3Do(api, http.MethodDelete, "/new-bucket", "", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("DeleteBucket returned %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestS3APIAuth(t *testing.T) {
+	api, _ := newTestS3API(t)
+
+	rr := s3Do(api, http.MethodGet, "/photos/a.txt", "", map[string]string{"secret": "wrong"})
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "SignatureDoesNotMatch") {
+		t.Fatalf("wrong secret returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/photos/a.txt", nil))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "AccessDenied") {
+		t.Fatalf("anonymous request returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	// the body must match the signed payload hash
+	rr = s3Do(api, http.MethodPut, "/photos/b.txt", "changed", map[string]string{"X-Amz-Content-Sha256": sigv4.EmptyPayload})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "XAmzContentSHA256Mismatch") {
+		t.Fatalf("payload mismatch returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	s := NewServer("/tmp", false, false, logrus.WithField("test", true), WithBasicAuth([]string{"user:password"}))
+	if _, err := NewS3API(s, nil, logrus.WithField("test", true)); err == nil {
+		t.Fatal("anonymous S3 access to a server with authentication must fail")
+	}
+}
+
+func TestS3APIUploads(t *testing.T) {
+	api, dir := newTestS3API(t)
+
+	// aws-chunked body sent by the newer SDKs
+	rr := s3Do(api, http.MethodPut, "/photos/chunked.txt", "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", map[string]string{
+		"X-Amz-Content-Sha256": sigv4.StreamingUnsignedPayloadTrailer,
+		"Content-Encoding":     "aws-chunked",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("chunked PutObject returned %d %s", rr.Code, rr.Body.String())
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "photos", "chunked.txt")); string(data) != "hello world" {
+		t.Fatalf("chunked PutObject wrote %q", data)
+	}
+
+	wrong := md5.Sum([]byte("other"))
+	rr = s3Do(api, http.MethodPut, "/photos/digest.txt", "content", map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(wrong[:])})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "BadDigest") {
+		t.Fatalf("Content-MD5 mismatch returned %d %s", rr.Code, rr.Body.String())
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "photos", "digest*")); len(files) != 0 {
+		t.Fatalf("a rejected upload left %v", files)
+	}
+
+	rr = s3Do(api, http.MethodPost, "/photos/big/file.bin?uploads", "", nil)
+	m := regexp.MustCompile(`<UploadId>([^<]+)</`).FindStringSubmatch(rr.Body.String())
+	if rr.Code != http.StatusOK || m == nil {
+		t.Fatalf("CreateMultipartUpload returned %d %s", rr.Code, rr.Body.String())
+	}
+	id := m[1]
+
+	var etags []string
+	for i, part := range []string{"first ", "second"} {
+		rr = s3Do(api, http.MethodPut, fmt.Sprintf("/photos/big/file.bin?partNumber=%d&uploadId=%s", i+1, id), part, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("UploadPart returned %d %s", rr.Code, rr.Body.String())
+		}
+		etags = append(etags, rr.Header().Get("ETag"))
+	}
+
+	complete := func(order ...int) *httptest.ResponseRecorder {
+		body := "<CompleteMultipartUpload>"
+		for _, n := range order {
+			body += fmt.Sprintf("<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", n, etags[n-1])
+		}
+		return s3Do(api, http.MethodPost, "/photos/big/file.bin?uploadId="+id, body+"</CompleteMultipartUpload>", nil)
+	}
+	if rr := complete(2, 1); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "InvalidPartOrder") {
+		t.Fatalf("CompleteMultipartUpload out of order returned %d %s", rr.Code, rr.Body.String())
+	}
+	rr = complete(1, 2)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `-2&#34;</ETag>`) {
+		t.Fatalf("CompleteMultipartUpload returned %d %s", rr.Code, rr.Body.String())
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "photos", "big", "file.bin")); string(data) != "first second" {
+		t.Fatalf("CompleteMultipartUpload wrote %q", data)
+	}
+	if rr := complete(1, 2); rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "NoSuchUpload") {
+		t.Fatalf("a completed upload must be removed, got %d", rr.Code)
+	}
+
+	rr = s3Do(api, http.MethodPost, "/photos/aborted.bin?uploads", "", nil)
+	id = regexp.MustCompile(`<UploadId>([^<]+)</`).FindStringSubmatch(rr.Body.String())[1]
+	if rr := s3Do(api, http.MethodDelete, "/photos/aborted.bin?uploadId="+id, "", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("AbortMultipartUpload returned %d", rr.Code)
+	}
+}
+
+func TestS3APIMultipartLimits(t *testing.T) {
+	api, _ := newTestS3API(t, WithMaxResumableUploadSize(8))
+
+	create := func() *httptest.ResponseRecorder {
+		return s3Do(api, http.MethodPost, "/photos/big.bin?uploads", "", nil)
+	}
+	rr := create()
+	id := regexp.MustCompile(`<UploadId>([^<]+)</`).FindStringSubmatch(rr.Body.String())[1]
+	part := func(n int, body string) *httptest.ResponseRecorder {
+		return s3Do(api, http.MethodPut, fmt.Sprintf("/photos/big.bin?partNumber=%d&uploadId=%s", n, id), body, nil)
+	}
+	if rr := part(1, "first "); rr.Code != http.StatusOK {
+		t.Fatalf("UploadPart returned %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := part(2, "second"); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "EntityTooLarge") {
+		t.Fatalf("UploadPart over the upload size returned %d %s", rr.Code, rr.Body.String())
+	}
+	// a part sent again replaces the old size
+	if rr := part(1, "1st"); rr.Code != http.StatusOK {
+		t.Fatalf("UploadPart sent again returned %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := part(2, "2nd"); rr.Code != http.StatusOK {
+		t.Fatalf("UploadPart within the upload size returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	for i := 1; i < s3MaxClientUploads; i++ {
+		if rr := create(); rr.Code != http.StatusOK {
+			t.Fatalf("CreateMultipartUpload %d returned %d", i, rr.Code)
+		}
+	}
+	if rr := create(); rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "SlowDown") {
+		t.Fatalf("CreateMultipartUpload over the client limit returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	api.expireUploads(time.Now().Add(s3UploadTTL + time.Minute))
+	if rr := part(3, "3rd"); rr.Code != http.StatusNotFound {
+		t.Fatalf("UploadPart of an expired upload returned %d", rr.Code)
+	}
+	if rr := create(); rr.Code != http.StatusOK {
+		t.Fatalf("CreateMultipartUpload after the expiration returned %d", rr.Code)
+	}
+}
+
+func TestS3APIReadOnly(t *testing.T) {
+	api, _ := newTestS3API(t, WithUploads(false))
+	if rr := s3Do(api, http.MethodPut, "/photos/new.txt", "new", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("PutObject with uploads disabled returned %d", rr.Code)
+	}
+	if rr := s3Do(api, http.MethodGet, "/photos/a.txt", "", nil); rr.Code != http.StatusOK {
+		t.Fatalf("GetObject with uploads disabled returned %d", rr.Code)
+	}
+
+	// the SPA mode also disables the uploads
+	api, _ = newTestS3API(t)
+	api.s.spaMode = true
+	if rr := s3Do(api, http.MethodPut, "/photos/new.txt", "new", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("PutObject in SPA mode returned %d", rr.Code)
+	}
+}
+
+func TestS3APIAuthLockout(t *testing.T) {
+	api, _ := newTestS3API(t, WithRateLimit(0, 0, 2, time.Minute))
+
+	// requests without credentials are not failures
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/photos/a.txt", nil))
+	if rr := s3Do(api, http.MethodGet, "/photos/a.txt", "", nil); rr.Code != http.StatusOK {
+		t.Fatalf("GetObject returned %d", rr.Code)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := s3Do(api, http.MethodGet, "/photos/a.txt", "", map[string]string{"secret": "wrong"}); rr.Code != http.StatusForbidden {
+			t.Fatalf("wrong secret returned %d", rr.Code)
+		}
+	}
+	rr = s3Do(api, http.MethodGet, "/photos/a.txt", "", nil)
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "SlowDown") || rr.Header().Get("Retry-After") != "60" {
+		t.Fatalf("locked out client returned %d %q %s", rr.Code, rr.Header().Get("Retry-After"), rr.Body.String())
+	}
+}
+
+func TestS3APIAccessList(t *testing.T) {
+	// the test requests come from 192.0.2.1
+	api, _ := newTestS3API(t, WithAccessList([]string{"deny 192.0.2.0/24 write", "deny all * /other/**"}, ""))
+
+	rr := s3Do(api, http.MethodPut, "/photos/new.txt", "new", nil)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "AccessDenied") {
+		t.Fatalf("PutObject from a denied network returned %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := s3Do(api, http.MethodGet, "/photos/a.txt", "", nil); rr.Code != http.StatusOK {
+		t.Fatalf("GetObject returned %d", rr.Code)
+	}
+	if rr := s3Do(api, http.MethodGet, "/other/x.txt", "", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("GetObject of a denied path returned %d", rr.Code)
+	}
+	rr = s3Do(api, http.MethodPost, "/photos?delete", "<Delete><Object><Key>a.txt</Key></Object></Delete>", nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("DeleteObjects from a denied network returned %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,471 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	searchPath = internalPathPrefix + "search"
+
+	searchDefaultLimit    = 200
+	searchMaxLimit        = 5000
+	searchDefaultDepth    = 32
+	searchDefaultTimeout  = 10 * time.Second
+	searchMaxTimeout      = 60 * time.Second
+	searchMaxContentSize  = 10 << 20 // only grep files up to 10 MiB
+	searchMaxLineMatches  = 5
+	searchMaxLineLength   = 200
+	searchContentSniffLen = 512
+)
+
+// searchQuery são os parâmetros de '/_gouploadserver/search':
+// '?dir=/docs/&q=*.md&mode=glob|regex|substring&content=texto&depth=3&limit=100&timeout=5s'
+type searchQuery struct {
+	dir      string
+	name     func(string) bool
+	content  string
+	maxDepth int
+	limit    int
+	timeout  time.Duration
+}
+
+// searchResult é uma linha NDJSON da resposta.
+type searchResult struct {
+	Path    string        `json:"path"`
+	Name    string        `json:"name"`
+	IsDir   bool          `json:"isDir"`
+	Size    int64         `json:"size"`
+	ModTime time.Time     `json:"modTime"`
+	Matches []searchMatch `json:"matches,omitempty"`
+}
+
+type searchMatch struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// searchSummary é sempre a última linha da resposta.
+type searchSummary struct {
+	Done      bool   `json:"done"`
+	Count     int    `json:"count"`
+	Truncated bool   `json:"truncated"`
+	Reason    string `json:"reason,omitempty"`
+	Indexed   bool   `json:"indexed"`
+}
+
+func parseSearchQuery(q map[string][]string) (*searchQuery, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	sq := &searchQuery{
+		dir:      path.Clean("/" + get("dir")),
+		content:  get("content"),
+		maxDepth: searchDefaultDepth,
+		limit:    searchDefaultLimit,
+		timeout:  searchDefaultTimeout,
+	}
+
+	pattern := get("q")
+	switch mode := get("mode"); mode {
+	case "", "glob":
+		if !strings.ContainsAny(pattern, "*?[") {
+			// a plain word is a substring search, like the listing filter
+			p := strings.ToLower(pattern)
+			sq.name = func(name string) bool { return strings.Contains(strings.ToLower(name), p) }
+			break
+		}
+		p := strings.ToLower(pattern)
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSearchQuery, err)
+		}
+		sq.name = func(name string) bool {
+			ok, _ := path.Match(p, strings.ToLower(name))
+			return ok
+		}
+	case "substring":
+		p := strings.ToLower(pattern)
+		sq.name = func(name string) bool { return strings.Contains(strings.ToLower(name), p) }
+	case "regex":
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSearchQuery, err)
+		}
+		sq.name = re.MatchString
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %q", ErrSearchQuery, mode)
+	}
+
+	if pattern == "" && sq.content == "" {
+		return nil, fmt.Errorf("%w: q or content is required", ErrSearchQuery)
+	}
+
+	if v := get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: invalid depth %q", ErrSearchQuery, v)
+		}
+		sq.maxDepth = n
+	}
+	if v := get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%w: invalid limit %q", ErrSearchQuery, v)
+		}
+		if n > searchMaxLimit {
+			n = searchMaxLimit
+		}
+		sq.limit = n
+	}
+	if v := get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: invalid timeout %q", ErrSearchQuery, v)
+		}
+		if d > searchMaxTimeout {
+			d = searchMaxTimeout
+		}
+		sq.timeout = d
+	}
+
+	return sq, nil
+}
+
+// grepFile procura content, sem diferenciar maiúsculas, nas linhas de arquivos de
+// texto. Arquivos binários ou grandes demais são ignorados.
+func grepFile(name string, content string) ([]searchMatch, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(searchContentSniffLen)
+	if bytes.IndexByte(head, 0) >= 0 || !strings.HasPrefix(http.DetectContentType(head), "text/") {
+		return nil, nil
+	}
+
+	needle := strings.ToLower(content)
+	var matches []searchMatch
+	sc := bufio.NewScanner(br)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		if !strings.Contains(strings.ToLower(text), needle) {
+			continue
+		}
+		if len(text) > searchMaxLineLength {
+			text = text[:searchMaxLineLength]
+		}
+		matches = append(matches, searchMatch{line, text})
+		if len(matches) == searchMaxLineMatches {
+			break
+		}
+	}
+	return matches, nil
+}
+
+// searchEntry é um caminho candidato, vindo do disco ou do índice.
+type searchEntry struct {
+	rel  string // caminho relativo a raiz, separado por '/'
+	info os.FileInfo
+}
+
+// searchRun aplica os filtros e escreve os resultados em streaming.
+type searchRun struct {
+	ctx   context.Context
+	root  string
+	query *searchQuery
+	emit  func(searchResult) error
+	// visible é nil quando todos os caminhos podem aparecer nos resultados
+	visible func(rel string, mode os.FileMode) bool
+	count   int
+	summary searchSummary
+}
+
+// consider avalia um candidato. Retorna false quando a busca deve parar.
+func (sr *searchRun) consider(e searchEntry) bool {
+	if err := sr.ctx.Err(); err != nil {
+		sr.summary.Truncated = true
+		sr.summary.Reason = "timeout"
+		return false
+	}
+
+	name := path.Base(e.rel)
+	if sr.query.name != nil && !sr.query.name(name) {
+		return true
+	}
+
+	res := searchResult{
+		Path:    "/" + e.rel,
+		Name:    name,
+		IsDir:   e.info.IsDir(),
+		Size:    e.info.Size(),
+		ModTime: e.info.ModTime(),
+	}
+	if res.IsDir {
+		res.Path += "/"
+	}
+
+	if sr.query.content != "" {
+		if res.IsDir || !e.info.Mode().IsRegular() || e.info.Size() > searchMaxContentSize {
+			return true
+		}
+		matches, err := grepFile(filepath.Join(sr.root, filepath.FromSlash(e.rel)), sr.query.content)
+		if err != nil || len(matches) == 0 {
+			return true
+		}
+		res.Matches = matches
+	}
+
+	if err := sr.emit(res); err != nil {
+		// client went away
+		return false
+	}
+	sr.count++
+	if sr.count >= sr.query.limit {
+		sr.summary.Truncated = true
+		sr.summary.Reason = "limit"
+		return false
+	}
+	return true
+}
+
+var errStopSearch = errors.New("stop search")
+
+func (sr *searchRun) walk(base string) {
+	start := filepath.Join(sr.root, filepath.FromSlash(base))
+	filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == start {
+			return nil
+		}
+
+		rel, rerr := filepath.Rel(sr.root, p)
+		relStart, serr := filepath.Rel(start, p)
+		info := dirEntryInfo(d)
+		if rerr != nil || serr != nil || info == nil {
+			return nil
+		}
+		if sr.visible != nil && !sr.visible(filepath.ToSlash(rel), d.Type()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !sr.consider(searchEntry{filepath.ToSlash(rel), info}) {
+			return errStopSearch
+		}
+
+		// depth 0 are the entries of the start directory
+		if d.IsDir() && strings.Count(filepath.ToSlash(relStart), "/") >= sr.query.maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+func dirEntryInfo(d fs.DirEntry) os.FileInfo {
+	info, err := d.Info()
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
+// searchHandler percorre o staticDirPath a partir de 'dir' e envia os resultados
+// em NDJSON, uma linha por arquivo encontrado, à medida que são encontrados.
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	sq, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start := filepath.Join(s.staticDirPath, filepath.FromSlash(sq.dir))
+	if err := s.checkAccess(start); err != nil {
+		s.logger.Info(err)
+		http.Error(w, fmt.Sprintf("%s: %s", ErrFileIsNotDir, sq.dir), http.StatusNotFound)
+		return
+	}
+	if fi, err := os.Stat(start); err != nil || !fi.IsDir() {
+		http.Error(w, fmt.Sprintf("%s: %s", ErrFileIsNotDir, sq.dir), http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), sq.timeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	run := &searchRun{
+		ctx:   ctx,
+		root:  s.staticDirPath,
+		query: sq,
+		emit: func(res searchResult) error {
+			if err := enc.Encode(res); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		},
+	}
+
+	if !s.access.permissive() {
+		run.visible = func(rel string, mode os.FileMode) bool {
+			return s.listable(s.staticDirPath, rel, mode)
+		}
+	}
+
+	if s.searchIndex != nil && s.searchIndex.ready() {
+		run.summary.Indexed = true
+		s.searchIndex.search(run)
+	} else {
+		run.walk(sq.dir)
+	}
+
+	run.summary.Done = true
+	run.summary.Count = run.count
+	enc.Encode(run.summary)
+}
+
+// searchIndex é um índice em memória dos caminhos do staticDirPath, construído
+// na inicialização e atualizado pelos eventos de upload. As buscas pelo índice
+// evitam percorrer o disco; entradas removidas fora do servidor são descartadas
+// quando encontradas.
+type searchIndex struct {
+	root   string
+	logger *logrus.Entry
+
+	mu      sync.RWMutex
+	paths   map[string]struct{}
+	isReady bool
+}
+
+func newSearchIndex(root string, logger *logrus.Entry) *searchIndex {
+	idx := &searchIndex{root: root, logger: logger, paths: make(map[string]struct{})}
+	go idx.build()
+	return idx
+}
+
+func (idx *searchIndex) build() {
+	start := time.Now()
+	paths := make(map[string]struct{})
+	filepath.WalkDir(idx.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == idx.root {
+			return nil
+		}
+		if rel, err := filepath.Rel(idx.root, p); err == nil {
+			paths[filepath.ToSlash(rel)] = struct{}{}
+		}
+		return nil
+	})
+
+	idx.mu.Lock()
+	for p := range idx.paths {
+		// uploads received while building
+		paths[p] = struct{}{}
+	}
+	idx.paths = paths
+	idx.isReady = true
+	idx.mu.Unlock()
+
+	idx.logger.Infof("Search index built: %d entries in %s", len(paths), time.Since(start))
+}
+
+func (idx *searchIndex) ready() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.isReady
+}
+
+// add registra um arquivo, e seus diretórios pais, recebido pelo servidor.
+func (idx *searchIndex) add(fullPath string) {
+	rel, err := filepath.Rel(idx.root, fullPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		idx.paths[p] = struct{}{}
+	}
+}
+
+func (idx *searchIndex) remove(rel string) {
+	idx.mu.Lock()
+	delete(idx.paths, rel)
+	idx.mu.Unlock()
+}
+
+func (idx *searchIndex) search(run *searchRun) {
+	prefix := strings.TrimPrefix(run.query.dir, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	// snapshot the candidates so the lock is not held while grepping files
+	idx.mu.RLock()
+	var candidates []string
+	for p := range idx.paths {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		if strings.Count(p[len(prefix):], "/") > run.query.maxDepth {
+			continue
+		}
+		if run.query.name == nil || run.query.name(path.Base(p)) {
+			candidates = append(candidates, p)
+		}
+	}
+	idx.mu.RUnlock()
+
+	sort.Strings(candidates)
+	for _, p := range candidates {
+		if run.visible != nil {
+			fi, err := os.Lstat(filepath.Join(idx.root, filepath.FromSlash(p)))
+			if err != nil || !run.visible(p, fi.Mode()) {
+				continue
+			}
+		}
+		info, err := os.Stat(filepath.Join(idx.root, filepath.FromSlash(p)))
+		if err != nil {
+			idx.remove(p)
+			continue
+		}
+		if !run.consider(searchEntry{p, info}) {
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newSearchTestDir(t *testing.T) string {
+	return writeTestFiles(t, map[string]string{
+		"readme.md":             "# project\nTODO: write docs\n",
+		"docs/guide.md":         "guide\n",
+		"docs/api/reference.md": "todo: api reference\n",
+		"docs/api/deep/x.txt":   "deep\n",
+		"src/main.go":           "package main // todo\n",
+		"img/logo.png":          "\x89PNG\r\n\x1a\n\x00\x00todo",
+	})
+}
+
+func doSearch(t *testing.T, s *Server, query string) ([]searchResult, searchSummary) {
+	req, err := http.NewRequest(http.MethodGet, searchPath+"?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("%s: handler returned wrong status code: got %v want %v", query, status, http.StatusOK)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Fatalf("handler returned wrong header Content-Type: got %v want %v", contentType, "application/x-ndjson")
+	}
+
+	var results []searchResult
+	var summary searchSummary
+	sc := bufio.NewScanner(rr.Body)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if strings.Contains(string(line), `"done":true`) {
+			if err := json.Unmarshal(line, &summary); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		var res searchResult
+		if err := json.Unmarshal(line, &res); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+	}
+	return results, summary
+}
+
+func resultPaths(results []searchResult) string {
+	var paths []string
+	for _, res := range results {
+		paths = append(paths, res.Path)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, ",")
+}
+
+func TestSearchHandler(t *testing.T) {
+	dir := newSearchTestDir(t)
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithSearch(true, false))
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"q=*.md", "/docs/api/reference.md,/docs/guide.md,/readme.md"},
+		{"q=*.md&dir=/docs/", "/docs/api/reference.md,/docs/guide.md"},
+		{"q=*.md&depth=0", "/readme.md"},
+		{"q=API", "/docs/api/"},
+		{"q=^(guide|main)\\.&mode=regex", "/docs/guide.md,/src/main.go"},
+		{"content=todo", "/docs/api/reference.md,/readme.md,/src/main.go"},
+		{"q=*.md&content=todo", "/docs/api/reference.md,/readme.md"},
+	}
+
+	for _, tt := range tests {
+		results, summary := doSearch(t, s, strings.Replace(tt.query, "^", "%5E", -1))
+		if got := resultPaths(results); got != tt.want {
+			t.Fatalf("%s: got %v want %v", tt.query, got, tt.want)
+		}
+		if !summary.Done || summary.Count != len(results) {
+			t.Fatalf("%s: wrong summary %+v", tt.query, summary)
+		}
+	}
+
+	results, summary := doSearch(t, s, "q=*&limit=2")
+	if len(results) != 2 || !summary.Truncated || summary.Reason != "limit" {
+		t.Fatalf("limit: got %d results and summary %+v", len(results), summary)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, searchPath+"?q=[&mode=regex", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestSearchIndexUpdatedOnUpload(t *testing.T) {
+	dir := newSearchTestDir(t)
+
+	s := NewServer(dir, true, false, logrus.WithField("test", true), WithSearch(true, true))
+	for i := 0; i < 100 && !s.searchIndex.ready(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	results, summary := doSearch(t, s, "q=*.md")
+	if !summary.Indexed || len(results) != 3 {
+		t.Fatalf("index: got %d results and summary %+v", len(results), summary)
+	}
+
+	// files written directly to disk are not indexed, uploads are
+	if err := ioutil.WriteFile(path.Join(dir, "docs/direct.md"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.notifyUpload(path.Join(dir, "docs/uploaded.md"))
+	if err := ioutil.WriteFile(path.Join(dir, "docs/uploaded.md"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, _ = doSearch(t, s, "q=*.md&dir=/docs/")
+	if got := resultPaths(results); got != "/docs/api/reference.md,/docs/guide.md,/docs/uploaded.md" {
+		t.Fatalf("index: got %v", got)
+	}
+}
@@ -0,0 +1,641 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPServer expõe o staticDirPath de um Server por SFTP, com as mesmas
+// políticas de acesso do HTTP. Os usuários do WithBasicAuth entram com a senha
+// e as chaves do arquivo authorized_keys entram com qualquer usuário. Sem
+// nenhum dos dois o acesso anônimo deve ser pedido explicitamente.
+//
+// Os arquivos recebidos passam pelo readerToFile, assim seguem a mesma política
+// de nomes dos uploads do navegador (ex: 'foto-123.jpg') e avisam os mesmos
+// interessados (ex: índice de busca). Com os uploads desabilitados o acesso é
+// somente leitura.
+type SFTPServer struct {
+	s      *Server
+	logger *logrus.Entry
+	config *ssh.ServerConfig
+	// authorizedKeysFile é relido a cada login, como no sshd
+	authorizedKeysFile string
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// NewSFTPServer cria o servidor SFTP sobre os arquivos de s. A chave do host é
+// lida de hostKeyFile, que é criado com uma nova chave ed25519 quando não existe.
+// Sem usuários nem authorizedKeysFile retorna ErrSFTPNoAuth, a menos que
+// anonymous permita o acesso sem autenticação.
+func NewSFTPServer(s *Server, hostKeyFile string, authorizedKeysFile string, anonymous bool, logger *logrus.Entry) (*SFTPServer, error) {
+	f := &SFTPServer{
+		s:                  s,
+		logger:             logger,
+		authorizedKeysFile: authorizedKeysFile,
+		conns:              make(map[net.Conn]struct{}),
+	}
+
+	if s.auth == nil && authorizedKeysFile == "" && !anonymous {
+		return nil, ErrSFTPNoAuth
+	}
+	hostKey, err := loadHostKey(hostKeyFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	f.config = &ssh.ServerConfig{ServerVersion: "SSH-2.0-gouploadserver"}
+	f.config.AddHostKey(hostKey)
+
+	if s.auth != nil {
+		f.config.PasswordCallback = f.checkPassword
+	}
+	if authorizedKeysFile != "" {
+		if _, err := f.authorizedKeys(); err != nil {
+			return nil, err
+		}
+		f.config.PublicKeyCallback = f.checkPublicKey
+	}
+	if s.auth == nil && authorizedKeysFile == "" {
+		logger.Warn("SFTP without --auth or authorized keys, anyone can log in")
+		f.config.NoClientAuth = true
+	}
+	return f, nil
+}
+
+// loadHostKey lê a chave privada do host ou cria uma nova em file.
+func loadHostKey(file string, logger *logrus.Entry) (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrSFTPHostKey, file, err)
+		}
+		return signer, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSFTPHostKey, err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSFTPHostKey, err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSFTPHostKey, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSFTPHostKey, err)
+	}
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSFTPHostKey, err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSFTPHostKey, err)
+	}
+	logger.Infof("SFTP host key created: %s (%s)", file, ssh.FingerprintSHA256(signer.PublicKey()))
+	return signer, nil
+}
+
+func (f *SFTPServer) checkPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if f.s.rateLimit != nil && f.s.rateLimit.lockedOut(ip, time.Now()) {
+		f.logger.Warnf("SFTP login refused: %s is locked out", ip)
+		return nil, ErrUnauthorized
+	}
+	if !f.s.auth.check(conn.User(), string(password)) {
+		f.logger.Warnf("SFTP login failed: user %q from %s", conn.User(), conn.RemoteAddr())
+		if f.s.rateLimit != nil {
+			f.s.rateLimit.authFailed(ip, time.Now())
+		}
+		return nil, ErrUnauthorized
+	}
+	if f.s.rateLimit != nil {
+		f.s.rateLimit.authSucceeded(ip)
+	}
+	return nil, nil
+}
+
+func (f *SFTPServer) checkPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if f.s.rateLimit != nil && f.s.rateLimit.lockedOut(ip, time.Now()) {
+		f.logger.Warnf("SFTP login refused: %s is locked out", ip)
+		return nil, ErrUnauthorized
+	}
+	keys, err := f.authorizedKeys()
+	if err != nil {
+		f.logger.Error(err)
+		return nil, ErrUnauthorized
+	}
+	marshaled := key.Marshal()
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), marshaled) {
+			if f.s.rateLimit != nil {
+				f.s.rateLimit.authSucceeded(ip)
+			}
+			return nil, nil
+		}
+	}
+	f.logger.Warnf("SFTP login failed: unknown key %s for %q from %s", ssh.FingerprintSHA256(key), conn.User(), conn.RemoteAddr())
+	if f.s.rateLimit != nil {
+		f.s.rateLimit.authFailed(ip, time.Now())
+	}
+	return nil, ErrUnauthorized
+}
+
+// authorizedKeys lê as chaves públicas no formato do authorized_keys do OpenSSH.
+func (f *SFTPServer) authorizedKeys() ([]ssh.PublicKey, error) {
+	data, err := ioutil.ReadFile(f.authorizedKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSFTPAuthorizedKeys, err)
+	}
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrSFTPAuthorizedKeys, f.authorizedKeysFile, err)
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	return keys, nil
+}
+
+func (f *SFTPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return f.Serve(l)
+}
+
+// Serve atende as conexões de l até o Close.
+func (f *SFTPServer) Serve(l net.Listener) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		l.Close()
+		return ErrSFTPClosed
+	}
+	f.listener = l
+	f.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			f.mu.Lock()
+			closed := f.closed
+			f.mu.Unlock()
+			if closed {
+				return ErrSFTPClosed
+			}
+			return err
+		}
+		go f.serveConn(conn)
+	}
+}
+
+// Close encerra o listener e as conexões abertas.
+func (f *SFTPServer) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for conn := range f.conns {
+		conn.Close()
+	}
+	if f.listener != nil {
+		return f.listener.Close()
+	}
+	return nil
+}
+
+func (f *SFTPServer) serveConn(conn net.Conn) {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		conn.Close()
+		return
+	}
+	f.conns[conn] = struct{}{}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, f.config)
+	if err != nil {
+		f.logger.Debugf("SFTP handshake from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	defer sconn.Close()
+	ip, _, _ := net.SplitHostPort(sconn.RemoteAddr().String())
+	logger := f.logger.WithField("user", sconn.User()).WithField("remote", sconn.RemoteAddr().String())
+	logger.Info("SFTP login")
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			logger.Errorf("SFTP channel error: %s", err)
+			continue
+		}
+		go f.serveSession(channel, requests, conn, ip, sconn.User(), logger)
+	}
+	logger.Info("SFTP logout")
+}
+
+// serveSession atende somente o subsistema 'sftp', sem shell nem comandos.
+func (f *SFTPServer) serveSession(channel ssh.Channel, requests <-chan *ssh.Request, conn net.Conn, ip string, user string, logger *logrus.Entry) {
+	defer channel.Close()
+	for req := range requests {
+		// the payload is the subsystem name as an ssh string
+		if req.Type != "subsystem" || len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		fs := &sftpFS{s: f.s, logger: logger, ip: ip, user: user, conn: conn}
+		server := sftp.NewRequestServer(channel, sftp.Handlers{FileGet: fs, FilePut: fs, FileCmd: fs, FileList: fs})
+		if err := server.Serve(); err != nil && err != io.EOF {
+			logger.Errorf("SFTP error: %s", err)
+		}
+		server.Close()
+		return
+	}
+}
+
+// sftpFS atende as requisições SFTP de uma sessão sobre os arquivos do Server.
+type sftpFS struct {
+	s      *Server
+	logger *logrus.Entry
+	// ip é o endereço remoto da conexão, verificado na lista de acesso
+	ip   string
+	user string
+	// conn é interrompida quando um upload fica parado, como nos uploads http
+	conn net.Conn
+}
+
+// sftpMethods mapeia as operações SFTP para os métodos http das listas de acesso.
+var sftpMethods = map[string]string{
+	"Get":         http.MethodGet,
+	"List":        http.MethodGet,
+	"Stat":        http.MethodHead,
+	"Readlink":    http.MethodHead,
+	"Put":         http.MethodPut,
+	"Open":        http.MethodPut,
+	"Remove":      http.MethodDelete,
+	"Rmdir":       http.MethodDelete,
+	"Mkdir":       "MKCOL",
+	"Rename":      "MOVE",
+	"PosixRename": "MOVE",
+}
+
+// localPath resolve o caminho SFTP dentro do staticDirPath e aplica as
+// políticas de acesso e a lista de acesso do Server à operação sftpMethod.
+func (fs *sftpFS) localPath(p string, sftpMethod string) (string, error) {
+	urlPath := path.Clean("/" + p)
+	filePath := path.Join(fs.s.staticDirPath, urlPath)
+	if err := fs.s.checkAccess(filePath); err != nil {
+		fs.logger.Errorf("SFTP error: %s", err)
+		return "", os.ErrPermission
+	}
+	if fs.s.accessList != nil {
+		method, ok := sftpMethods[sftpMethod]
+		if !ok {
+			method = http.MethodPut
+		}
+		if rule, ok := fs.s.accessList.check(fs.ip, method, urlPath); !ok {
+			fs.logger.Warnf("Access list: SFTP %s %s from %s denied by %q", sftpMethod, urlPath, fs.ip, rule)
+			return "", os.ErrPermission
+		}
+	}
+	return filePath, nil
+}
+
+func (fs *sftpFS) storageName(filePath string) (string, error) {
+	name, err := fs.s.relToRoot(filePath)
+	if err != nil {
+		return "", os.ErrPermission
+	}
+	return name, nil
+}
+
+func (fs *sftpFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	filePath, err := fs.localPath(r.Filepath, r.Method)
+	if err != nil {
+		return nil, err
+	}
+	release, err := fs.acquireTransfer()
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.s.open(filePath)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		ra = &seekReaderAt{f: f}
+	}
+	ra = fs.s.throttleReaderAt(context.Background(), ra, directionDownload, fs.ip, fs.user)
+	return &sftpFile{ReaderAt: ra, f: f, release: release}, nil
+}
+
+// acquireTransfer ocupa uma vaga de transferência do cliente, como o
+// acquireTransfer das requisições http.
+func (fs *sftpFS) acquireTransfer() (func(), error) {
+	if fs.s.transfers == nil {
+		return func() {}, nil
+	}
+	release, err := fs.s.transfers.acquire(context.Background(), fs.ip)
+	if err != nil {
+		fs.logger.Warnf("Transfer limit: SFTP from %s: %s", fs.ip, err)
+		return nil, err
+	}
+	return release, nil
+}
+
+// sftpFile é um arquivo aberto para leitura, que ocupa uma vaga de
+// transferência até ser fechado.
+type sftpFile struct {
+	io.ReaderAt
+	f       io.Closer
+	release func()
+}
+
+func (f *sftpFile) Close() error {
+	defer f.release()
+	return f.f.Close()
+}
+
+// seekReaderAt implementa o io.ReaderAt sobre os arquivos dos demais
+// armazenamentos, que só permitem a leitura sequencial com Seek.
+type seekReaderAt struct {
+	mu sync.Mutex
+	f  storage.File
+}
+
+func (r *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.f, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (fs *sftpFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if !fs.s.uploadEnabled || fs.s.spaMode {
+		return nil, os.ErrPermission
+	}
+	filePath, err := fs.localPath(r.Filepath, r.Method)
+	if err != nil {
+		return nil, err
+	}
+	dirPath, fname := path.Dir(filePath), path.Base(filePath)
+	if fname == "/" || fname == "." {
+		return nil, os.ErrPermission
+	}
+	if fi, err := fs.s.stat(dirPath); err != nil || !fi.IsDir() {
+		return nil, os.ErrNotExist
+	}
+
+	release, err := fs.acquireTransfer()
+	if err != nil {
+		return nil, err
+	}
+	// the client may send the blocks out of order, so the upload is only
+	// written with readerToFile when the handle is closed
+	tmp, err := ioutil.TempFile("", "gouploadserver-sftp-*")
+	if err != nil {
+		release()
+		return nil, err
+	}
+	src := &uploadSource{protocol: "sftp", client: fs.ip, user: fs.user, size: -1, conn: fs.conn, ctx: context.Background()}
+	rel, _ := fs.s.relToRoot(filePath)
+	tracked := fs.s.uploads.start(src, fs.s.mountPath+path.Join("/", rel))
+	return &sftpUpload{fs: fs, tmp: tmp, dir: dirPath, fname: fname, src: src, tracked: tracked, release: release}, nil
+}
+
+// sftpUpload guarda os blocos recebidos em um arquivo temporário.
+type sftpUpload struct {
+	fs    *sftpFS
+	tmp   *os.File
+	dir   string
+	fname string
+	// src e tracked aplicam aos blocos os limites de banda e o acompanhamento
+	// dos uploads http, que leem o corpo da requisição
+	src     *uploadSource
+	tracked *trackedUpload
+	release func()
+
+	mu  sync.Mutex
+	err error
+}
+
+func (u *sftpUpload) WriteAt(p []byte, off int64) (int, error) {
+	body := u.fs.s.throttle(u.src.ctx, bytes.NewReader(p), directionUpload, u.src.client, u.src.user)
+	if _, err := io.Copy(ioutil.Discard, u.tracked.reader(body)); err != nil {
+		return 0, err
+	}
+	return u.tmp.WriteAt(p, off)
+}
+
+// TransferError é chamado pelo pkg/sftp quando a transferência é interrompida,
+// assim o Close descarta o arquivo incompleto.
+func (u *sftpUpload) TransferError(err error) {
+	u.mu.Lock()
+	u.err = err
+	u.mu.Unlock()
+}
+
+func (u *sftpUpload) Close() (err error) {
+	defer os.Remove(u.tmp.Name())
+	defer u.tmp.Close()
+	defer u.release()
+	defer func() { u.tracked.finish(err) }()
+
+	u.mu.Lock()
+	err = u.err
+	u.mu.Unlock()
+	if err != nil {
+		u.fs.logger.Errorf("SFTP upload of %s interrupted: %s", u.fname, err)
+		return err
+	}
+
+	if _, err := u.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// the blocks were already tracked and throttled as they arrived
+	buf := make([]byte, 32*1024)
+	fileSent, err := u.fs.s.readerToFile(u.tmp, u.dir, u.fname, u.fs.s.keepOriginalUploadFileName, buf, nil)
+	if err != nil {
+		u.fs.logger.Errorf("Reader To File error: %s", err)
+		return err
+	}
+	u.fs.logger.Infof("File sent: %s", fileSent)
+	u.fs.s.notifyUpload(fileSent)
+	return nil
+}
+
+func (fs *sftpFS) Filecmd(r *sftp.Request) error {
+	if r.Method == "Setstat" {
+		// permissions and times are not kept, like in the HTTP uploads
+		return nil
+	}
+	if !fs.s.uploadEnabled || fs.s.spaMode {
+		return os.ErrPermission
+	}
+	filePath, err := fs.localPath(r.Filepath, r.Method)
+	if err != nil {
+		return err
+	}
+	name, err := fs.storageName(filePath)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return os.ErrPermission
+	}
+
+	switch r.Method {
+	case "Rename", "PosixRename":
+		targetPath, err := fs.localPath(r.Target, r.Method)
+		if err != nil {
+			return err
+		}
+		target, err := fs.storageName(targetPath)
+		if err != nil {
+			return err
+		}
+		if fi, err := fs.s.storage.Stat(name); err != nil {
+			return err
+		} else if fi.IsDir() {
+			// object storages can not rename directories
+			return sftp.ErrSSHFxOpUnsupported
+		}
+		if r.Method == "Rename" {
+			// SSH_FXP_RENAME does not replace existing files
+			if _, err := fs.s.storage.Stat(target); err == nil {
+				return os.ErrExist
+			}
+		}
+		return fs.s.storage.Rename(name, target)
+	case "Remove":
+		if fi, err := fs.s.storage.Stat(name); err != nil {
+			return err
+		} else if fi.IsDir() {
+			return ErrFileIsNotRegular
+		}
+		return fs.s.storage.Remove(name)
+	case "Rmdir":
+		if fi, err := fs.s.storage.Stat(name); err != nil {
+			return err
+		} else if !fi.IsDir() {
+			return ErrFileIsNotDir
+		}
+		return fs.s.storage.Remove(name)
+	case "Mkdir":
+		if _, err := fs.s.storage.Stat(name); err == nil {
+			return os.ErrExist
+		}
+		return fs.s.storage.MkdirAll(name)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (fs *sftpFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	filePath, err := fs.localPath(r.Filepath, r.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case "List":
+		dir, err := fs.s.openDir(filePath)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := dir.ReadDir(-1)
+		dir.Close()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		var list sftpList
+		for _, e := range entries {
+			if !fs.s.listable(filePath, e.Name(), e.Type()) {
+				continue
+			}
+			fi, err := e.Info()
+			if err == nil && e.Type()&os.ModeSymlink != 0 {
+				fi, err = fs.s.stat(path.Join(filePath, e.Name()))
+			}
+			if err != nil {
+				// removed while listing or a broken symlink
+				continue
+			}
+			list = append(list, fi)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+		return list, nil
+	case "Stat":
+		fi, err := fs.s.stat(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpList{fi}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// sftpList é uma listagem já lida do diretório.
+type sftpList []os.FileInfo
+
+func (l sftpList) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
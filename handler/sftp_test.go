@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSFTP inicia o servidor SFTP sobre um diretório temporário.
+func newTestSFTP(t *testing.T, authorizedKeysFile string, opts ...Option) (*SFTPServer, string, string) {
+	dir := writeTestFiles(t, map[string]string{
+		"root/hello.txt":     "hello world",
+		"root/docs/guide.md": "# Guide",
+		"root/.env":          "SECRET=1",
+	})
+	root := filepath.Join(dir, "root")
+
+	opts = append([]Option{WithBasicAuth([]string{"user:password"}), WithAccessPolicy(SymlinksFollowWithinRoot, HiddenDeny, nil)}, opts...)
+	s := NewServer(root, false, false, logrus.WithField("test", true), opts...)
+	srv, err := NewSFTPServer(s, filepath.Join(dir, "state", "host_key"), authorizedKeysFile, false, logrus.WithField("test", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return srv, l.Addr().String(), root
+}
+
+func dialSFTP(t *testing.T, addr string, auth ssh.AuthMethod) (*sftp.Client, error) {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "user",
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.Cleanup(func() { client.Close(); conn.Close() })
+	return client, nil
+}
+
+func TestSFTP(t *testing.T) {
+	var uploads []string
+	srv, addr, root := newTestSFTP(t, "", func(s *Server) {
+		s.uploadListeners = append(s.uploadListeners, func(fullPath string) { uploads = append(uploads, fullPath) })
+	})
+
+	if _, err := dialSFTP(t, addr, ssh.Password("wrong")); err == nil {
+		t.Fatal("login with a wrong password must fail")
+	}
+	client, err := dialSFTP(t, addr, ssh.Password("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := client.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "docs,hello.txt" {
+		t.Fatalf("ReadDir listed %v, hidden files must be omitted", names)
+	}
+
+	f, err := client.Open("/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+	if _, err := client.Open("/.env"); err == nil {
+		t.Fatal("a denied hidden file must not be opened")
+	}
+	if _, err := client.Open("/../../etc/passwd"); err == nil {
+		t.Fatal("paths outside the root must not be opened")
+	}
+
+	// uploads follow the naming policy of the HTTP uploads
+	w, err := client.Create("/docs/report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("%PDF"))
+	// the upload is tracked like the HTTP uploads while the file is open
+	if active := srv.s.uploads.list(); len(active) != 1 || active[0].Protocol != "sftp" || active[0].User != "user" || active[0].Target != "/docs/report.pdf" || active[0].Received != 4 {
+		t.Fatalf("active uploads %+v", active)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(root, "docs", "report-*.pdf"))
+	if len(matches) != 1 {
+		t.Fatalf("upload created %v", matches)
+	}
+	if len(uploads) != 1 || uploads[0] != filepath.ToSlash(matches[0]) {
+		t.Fatalf("upload listeners received %v", uploads)
+	}
+	if _, err := client.Create("/missing/file.txt"); err == nil {
+		t.Fatal("an upload to a missing directory must fail")
+	}
+
+	if err := client.Mkdir("/new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Rename("/hello.txt", "/new/hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Remove("/new/hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveDirectory("/new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "new")); !os.IsNotExist(err) {
+		t.Fatalf("RemoveDirectory kept the directory: %v", err)
+	}
+}
+
+func TestSFTPAuthorizedKeys(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	sshPub, _ := ssh.NewPublicKey(pub)
+	signer, _ := ssh.NewSignerFromKey(key)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherKey)
+
+	keysFile := filepath.Join(t.TempDir(), "authorized_keys")
+	ioutil.WriteFile(keysFile, append([]byte("# partner\n"), ssh.MarshalAuthorizedKey(sshPub)...), 0600)
+	_, addr, _ := newTestSFTP(t, keysFile, WithUploads(false))
+
+	if _, err := dialSFTP(t, addr, ssh.PublicKeys(otherSigner)); err == nil {
+		t.Fatal("login with an unknown key must fail")
+	}
+	client, err := dialSFTP(t, addr, ssh.PublicKeys(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Stat("/docs/guide.md"); err != nil {
+		t.Fatal(err)
+	}
+	// uploads disabled make the server read only
+	if _, err := client.Create("/new.txt"); err == nil {
+		t.Fatal("an upload with uploads disabled must fail")
+	}
+	if err := client.Remove("/hello.txt"); err == nil {
+		t.Fatal("a remove with uploads disabled must fail")
+	}
+}
+
+func TestSFTPAuthorizedKeysLockout(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	sshPub, _ := ssh.NewPublicKey(pub)
+	signer, _ := ssh.NewSignerFromKey(key)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherKey)
+
+	keysFile := filepath.Join(t.TempDir(), "authorized_keys")
+	ioutil.WriteFile(keysFile, ssh.MarshalAuthorizedKey(sshPub), 0600)
+	_, addr, _ := newTestSFTP(t, keysFile, WithRateLimit(0, time.Minute, 2, time.Hour))
+
+	for i := 0; i < 2; i++ {
+		if _, err := dialSFTP(t, addr, ssh.PublicKeys(otherSigner)); err == nil {
+			t.Fatal("login with an unknown key must fail")
+		}
+	}
+	if _, err := dialSFTP(t, addr, ssh.PublicKeys(signer)); err == nil {
+		t.Fatal("a locked out IP must not log in even with a known key")
+	}
+}
+
+func TestSFTPTransferLimits(t *testing.T) {
+	_, addr, root := newTestSFTP(t, "", WithTransferLimit(1, 0), WithBandwidthLimits([]string{"download/user=2000"}))
+	ioutil.WriteFile(filepath.Join(root, "big.bin"), []byte(strings.Repeat("x", 3000)), 0644)
+	client, err := dialSFTP(t, addr, ssh.Password("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an open file holds the only slot of the client
+	f, err := client.Open("/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Open("/hello.txt"); err == nil {
+		t.Fatal("a second transfer over the limit must fail")
+	}
+	if _, err := client.Create("/new.txt"); err == nil {
+		t.Fatal("an upload over the limit must fail")
+	}
+
+	// the 1000 bytes over the burst take half a second
+	start := time.Now()
+	data, err := ioutil.ReadAll(f)
+	if elapsed := time.Since(start); err != nil || len(data) != 3000 || elapsed < 400*time.Millisecond {
+		t.Fatalf("read %d bytes in %s: %v", len(data), elapsed, err)
+	}
+	f.Close()
+	f, err = client.Open("/hello.txt")
+	if err != nil {
+		t.Fatalf("the slot must be released on close: %v", err)
+	}
+	f.Close()
+}
+
+func TestSFTPNoAuth(t *testing.T) {
+	s := NewServer(t.TempDir(), false, false, logrus.WithField("test", true))
+	hostKey := filepath.Join(t.TempDir(), "host_key")
+	if _, err := NewSFTPServer(s, hostKey, "", false, logrus.WithField("test", true)); err != ErrSFTPNoAuth {
+		t.Fatalf("NewSFTPServer without auth returned %v", err)
+	}
+	srv, err := NewSFTPServer(s, hostKey, "", true, logrus.WithField("test", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+}
+
+func TestSFTPHostKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "host_key")
+	first, err := loadHostKey(file, logrus.WithField("test", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := loadHostKey(file, logrus.WithField("test", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ssh.FingerprintSHA256(first.PublicKey()) != ssh.FingerprintSHA256(second.PublicKey()) {
+		t.Fatal("the host key must be kept between restarts")
+	}
+}
+
+func TestSFTPAccessList(t *testing.T) {
+	_, addr, root := newTestSFTP(t, "", WithAccessList([]string{"deny 127.0.0.0/8 write", "deny all * /docs/**"}, ""))
+	client, err := dialSFTP(t, addr, ssh.Password("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := client.Open("/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := client.Open("/docs/guide.md"); err == nil {
+		t.Fatal("a denied path must not be opened")
+	}
+	if _, err := client.ReadDir("/docs"); err == nil {
+		t.Fatal("a denied directory must not be listed")
+	}
+	if _, err := client.Create("/new.txt"); err == nil {
+		t.Fatal("an upload from a denied network must fail")
+	}
+	if err := client.Remove("/hello.txt"); err == nil {
+		t.Fatal("a remove from a denied network must fail")
+	}
+	if _, err := os.Stat(filepath.Join(root, "hello.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSFTPSPAMode(t *testing.T) {
+	srv, addr, _ := newTestSFTP(t, "")
+	srv.s.spaMode = true
+	client, err := dialSFTP(t, addr, ssh.Password("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Create("/new.txt"); err == nil {
+		t.Fatal("an upload in SPA mode must fail")
+	}
+	if err := client.Mkdir("/new"); err == nil {
+		t.Fatal("a mkdir in SPA mode must fail")
+	}
+}
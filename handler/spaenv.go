@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// spaEnv injeta variáveis de ambiente no 'index.html' de uma SPA em tempo de execução.
+// Assim o mesmo build (ex: 'dist') pode ser publicado em ambientes que diferem
+// apenas em configurações como URLs de API, sem precisar de um novo build.
+type spaEnv struct {
+	// prefixes é a whitelist de prefixos das variáveis de ambiente expostas (ex: VUE_APP_)
+	prefixes []string
+	// file é um arquivo JSON opcional com valores adicionais
+	file string
+	// placeholders habilita a substituição de '%%NAME%%' e '%%json:NAME%%' no 'index.html'
+	placeholders bool
+}
+
+var spaEnvPlaceholderRegexp = regexp.MustCompile(`%%(json:)?([A-Za-z_][A-Za-z0-9_]*)%%`)
+
+func (e *spaEnv) enabled() bool {
+	return len(e.prefixes) > 0 || e.file != "" || e.placeholders
+}
+
+// values monta o objeto window.__ENV__. O arquivo JSON é lido a cada chamada para
+// que alterações sejam aplicadas sem reiniciar o servidor, e as variáveis de
+// ambiente têm precedência sobre o arquivo.
+func (e *spaEnv) values() (map[string]interface{}, error) {
+	env := make(map[string]interface{})
+
+	if e.file != "" {
+		data, err := ioutil.ReadFile(e.file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSpaEnvFile, err)
+		}
+		if err := json.Unmarshal(data, &env); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSpaEnvFile, err)
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		pair := strings.SplitN(kv, "=", 2)
+		for _, prefix := range e.prefixes {
+			if prefix != "" && strings.HasPrefix(pair[0], prefix) {
+				env[pair[0]] = pair[1]
+				break
+			}
+		}
+	}
+
+	return env, nil
+}
+
+// inject adiciona o script com window.__ENV__ antes do fechamento do </head> e,
+// se habilitado, substitui os placeholders pelos valores whitelisted. '%%NAME%%'
+// é escapado para o texto e os atributos do HTML, e não é seguro dentro de
+// <script>, onde '%%json:NAME%%' deve ser usado: o valor em JSON, uma string
+// entre aspas, sem '<', '>' e '&'. Placeholders desconhecidos são mantidos intactos.
+func (e *spaEnv) inject(page []byte) ([]byte, error) {
+	env, err := e.values()
+	if err != nil {
+		return nil, err
+	}
+
+	if e.placeholders {
+		page = spaEnvPlaceholderRegexp.ReplaceAllFunc(page, func(m []byte) []byte {
+			sub := spaEnvPlaceholderRegexp.FindSubmatch(m)
+			v, ok := env[string(sub[2])]
+			if !ok {
+				return m
+			}
+			if len(sub[1]) > 0 {
+				b, _ := json.Marshal(v)
+				return b
+			}
+			if s, ok := v.(string); ok {
+				return []byte(html.EscapeString(s))
+			}
+			b, _ := json.Marshal(v)
+			return []byte(html.EscapeString(string(b)))
+		})
+	}
+
+	// json.Marshal escapes '<', '>' and '&', so the object can not close the script tag
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	script := []byte("<script>window.__ENV__ = " + string(data) + ";</script>")
+
+	i := bytes.Index(bytes.ToLower(page), []byte("</head>"))
+	if i < 0 {
+		return append(script, page...), nil
+	}
+
+	out := make([]byte, 0, len(page)+len(script))
+	out = append(out, page[:i]...)
+	out = append(out, script...)
+	out = append(out, page[i:]...)
+	return out, nil
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSpaEnvInjection(t *testing.T) {
+	os.Setenv("VUE_APP_API_URL", "https://api.example.com")
+	os.Setenv("SECRET_TOKEN", "do-not-leak")
+	defer os.Unsetenv("VUE_APP_API_URL")
+	defer os.Unsetenv("SECRET_TOKEN")
+
+	req, err := http.NewRequest(http.MethodGet, "/random-url-12345", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	s := NewServer("../test/spa/dist/", false, true, logrus.WithField("test", true),
+		WithSpaEnv([]string{"VUE_APP_"}, "", false))
+
+	s.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	body := rr.Body.String()
+	want := `<script>window.__ENV__ = {"VUE_APP_API_URL":"https://api.example.com"};</script></head>`
+	if !strings.Contains(body, want) {
+		t.Fatalf("handler did not inject window.__ENV__: got %v", body)
+	}
+
+	if strings.Contains(body, "do-not-leak") {
+		t.Fatalf("handler leaked a non whitelisted env var")
+	}
+}
+
+func TestSpaEnvFileAndPlaceholders(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"index.html": `<html><head><title>%%APP_TITLE%%</title></head><body>%%UNKNOWN%%<script>var api = %%json:API%%;</script></body></html>`,
+		"env.json":   `{"APP_TITLE": "Staging <b>", "API": "x\"</script><script>alert(1)//"}`,
+	})
+	envFile := path.Join(dir, "env.json")
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	s := NewServer(dir, false, true, logrus.WithField("test", true), WithSpaEnv(nil, envFile, true))
+
+	s.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "<title>Staging &lt;b&gt;</title>") {
+		t.Fatalf("handler did not replace the placeholder: got %v", body)
+	}
+	// inside scripts the value is a JSON string that can not close the tag
+	if !strings.Contains(body, `<script>var api = "x\"\u003c/script\u003e\u003cscript\u003ealert(1)//";</script>`) {
+		t.Fatalf("handler did not replace the JSON placeholder: got %v", body)
+	}
+	if !strings.Contains(body, "%%UNKNOWN%%") {
+		t.Fatalf("handler replaced an unknown placeholder: got %v", body)
+	}
+	if !strings.Contains(body, `"APP_TITLE":"Staging \u003cb\u003e"`) {
+		t.Fatalf("handler did not inject the env file values: got %v", body)
+	}
+}
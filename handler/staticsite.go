@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// findIndexFile retorna o primeiro arquivo de indexFiles existente em dirPath,
+// ou "" quando o diretório deve ser listado.
+func (s *Server) findIndexFile(dirPath string) string {
+	for _, name := range s.indexFiles {
+		indexPath := path.Join(dirPath, name)
+		if s.checkAccess(indexPath) != nil {
+			continue
+		}
+		if fi, err := s.stat(indexPath); err == nil && fi.Mode().IsRegular() {
+			return indexPath
+		}
+	}
+	return ""
+}
+
+// findCleanURLFile resolve URLs sem extensão para o arquivo '.html'
+// correspondente (ex: '/about' para 'about.html'). Retorna "" quando não existe.
+func (s *Server) findCleanURLFile(fileUrlPath string) string {
+	if !s.cleanURLs || strings.HasSuffix(fileUrlPath, "/") || path.Ext(fileUrlPath) != "" {
+		return ""
+	}
+	htmlPath := s.localPath(fileUrlPath + ".html")
+	if s.checkAccess(htmlPath) != nil {
+		return ""
+	}
+	if fi, err := s.stat(htmlPath); err == nil && fi.Mode().IsRegular() {
+		return htmlPath
+	}
+	return ""
+}
+
+// sendNotFound responde com a página 404 personalizada do site, quando existir
+// e puder ser servida, e com o erro padrão nos demais casos (ex: clientes que
+// pedem JSON).
+func (s *Server) sendNotFound(w http.ResponseWriter, r *http.Request, err error) {
+	if s.notFoundPage != "" && !acceptsJSON(r) {
+		notFoundPath := path.Join(s.staticDirPath, ".", s.notFoundPage)
+		if accessErr := s.checkAccess(notFoundPath); accessErr != nil {
+			s.logger.Info(accessErr)
+		} else if fi, statErr := s.stat(notFoundPath); statErr == nil && fi.Mode().IsRegular() {
+			sendErr := s.sendFileToClient(&statusOverrideWriter{w, http.StatusNotFound}, r, notFoundPath)
+			if sendErr == nil {
+				return
+			}
+			s.logger.Errorf("Send not found page: %s", sendErr)
+		}
+	}
+	s.sendError(w, r, http.StatusNotFound, err)
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStaticSite(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"index.html":      "<h1>home</h1>",
+		"about.html":      "<h1>about</h1>",
+		"404.html":        "<h1>custom not found</h1>",
+		"docs/index.htm":  "<h1>docs</h1>",
+		"files/readme.md": "# files",
+	})
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true),
+		WithIndexFiles([]string{"index.html", "index.htm"}),
+		WithCleanURLs(true),
+		WithNotFoundPage("404.html"),
+	)
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/", http.StatusOK, "<h1>home</h1>"},
+		{"/docs/", http.StatusOK, "<h1>docs</h1>"},
+		{"/about", http.StatusOK, "<h1>about</h1>"},
+		{"/about.html", http.StatusOK, "<h1>about</h1>"},
+		{"/missing", http.StatusNotFound, "<h1>custom not found</h1>"},
+		{"/missing.css", http.StatusNotFound, "<h1>custom not found</h1>"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tt.status {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", tt.url, status, tt.status)
+		}
+		if body := rr.Body.String(); body != tt.body {
+			t.Fatalf("%s: handler returned wrong body: got %v want %v", tt.url, body, tt.body)
+		}
+	}
+
+	// directories without an index file are still listed
+	req, err := http.NewRequest(http.MethodGet, "/files/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "readme.md") {
+		t.Fatalf("handler did not list the directory without index file")
+	}
+}
+
+func TestNotFoundPageFallback(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"404.html": "<h1>custom not found</h1>",
+	})
+
+	// JSON clients receive the standard error
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithNotFoundPage("404.html"))
+	rr := serveTest(s, http.MethodGet, "/missing", "", map[string]string{"Accept": "application/json"})
+	if rr.Code != http.StatusNotFound || strings.Contains(rr.Body.String(), "custom not found") {
+		t.Fatalf("JSON client received %d %q", rr.Code, rr.Body)
+	}
+
+	// an excluded page is never sent
+	s = NewServer(dir, false, false, logrus.WithField("test", true), WithNotFoundPage("404.html"),
+		WithAccessPolicy(SymlinksFollowWithinRoot, HiddenShow, []string{"404.html"}))
+	rr = serveTest(s, http.MethodGet, "/missing", "", nil)
+	if rr.Code != http.StatusNotFound || strings.Contains(rr.Body.String(), "custom not found") {
+		t.Fatalf("excluded not found page returned %d %q", rr.Code, rr.Body)
+	}
+}
+
+func TestStaticSiteDisabledByDefault(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s := NewServer("../test/plain-html", false, false, logrus.WithField("test", true))
+	s.ServeHTTP(rr, req)
+
+	if body := rr.Body.String(); !strings.Contains(body, "script.js") {
+		t.Fatalf("handler did not list the directory")
+	}
+}
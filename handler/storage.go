@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
+)
+
+// Os handlers continuam trabalhando com caminhos dentro do staticDirPath, como
+// no disco local. Os helpers abaixo convertem esses caminhos para os nomes
+// relativos à raiz usados pelo Storage.
+
+func (s *Server) stat(filePath string) (os.FileInfo, error) {
+	name, err := s.relToRoot(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.Stat(name)
+}
+
+func (s *Server) open(filePath string) (storage.File, error) {
+	name, err := s.relToRoot(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.Open(name)
+}
+
+func (s *Server) openDir(dirPath string) (storage.Dir, error) {
+	name, err := s.relToRoot(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.OpenDir(name)
+}
+
+func (s *Server) readFile(filePath string) ([]byte, error) {
+	f, err := s.open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// localStorage indica se os arquivos estão no disco, requisito dos recursos
+// que observam ou percorrem o sistema de arquivos (ex: busca e live reload).
+func (s *Server) localStorage() bool {
+	_, ok := s.storage.(*storage.Local)
+	return ok
+}
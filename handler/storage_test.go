@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	st := storage.NewMemory()
+	for name, content := range map[string]string{
+		"index.txt":         "hello from memory",
+		"docs/readme.md":    "# Docs",
+		"docs/data.json":    `{"ok": true}`,
+		"_redirects":        "/old /index.txt 301",
+		"img/empty/.keep":   "",
+		"docs/big/file.bin": "\x00\x01\x02",
+	} {
+		w, err := st.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the path only names the files, nothing is read from the disk
+	s := NewServer("/nonexistent", false, false, logrus.WithField("test", true),
+		WithStorage(st), WithRuleFiles(true), WithSearch(true, false))
+	defer s.Close()
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get("/index.txt"); rr.Code != http.StatusOK || rr.Body.String() != "hello from memory" {
+		t.Fatalf("GET /index.txt returned %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := get("/docs/data.json?preview"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "json-bool") {
+		t.Fatalf("preview returned %d", rr.Code)
+	}
+	if rr := get("/old"); rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/index.txt" {
+		t.Fatalf("redirect rule returned %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := get("/missing.txt"); rr.Code != http.StatusNotFound {
+		t.Fatalf("GET /missing.txt returned %d, want 404", rr.Code)
+	}
+	if rr := get(searchPath + "?q=readme"); rr.Code != http.StatusNotFound {
+		t.Fatalf("search should be disabled for memory storage, got %d", rr.Code)
+	}
+
+	rr := get("/docs/")
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /docs/ returned %d", rr.Code)
+	}
+	for _, want := range []string{`href="readme.md`, `href="data.json`, `href="big/"`, ">Docs</h1>"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("listing does not contain %s:\n%s", want, body)
+		}
+	}
+
+	upload := func(url string, fname string, content string) *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		mw := multipart.NewWriter(&b)
+		fw, err := mw.CreateFormFile("file", fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+		mw.Close()
+
+		req, err := http.NewRequest(http.MethodPost, url, &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := upload("/docs/", "notes.txt", "uploaded"); rr.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rr.Code, rr.Body.String())
+	}
+	d, err := st.OpenDir("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := d.ReadDir(-1)
+	d.Close()
+	var uploaded string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "notes-") && strings.HasSuffix(e.Name(), ".txt") {
+			uploaded = e.Name()
+		}
+	}
+	if uploaded == "" {
+		t.Fatal("uploaded file not found in the storage")
+	}
+	f, err := st.Open("docs/" + uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(content) != "uploaded" {
+		t.Fatalf("uploaded file contains %q", content)
+	}
+
+	// storages with implicit directories must not create the posted path
+	if rr := upload("/nowhere/", "notes.txt", "lost"); rr.Code != http.StatusNotFound {
+		t.Fatalf("upload to a missing directory returned %d, want 404", rr.Code)
+	}
+	if _, err := st.Stat("nowhere"); err == nil {
+		t.Fatal("upload created a missing directory")
+	}
+}
+
+func TestFSStorage(t *testing.T) {
+	built := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	st := storage.NewFS(fstest.MapFS{
+		"index.html":            {Data: []byte("<h1>app</h1>")},
+		"js/app.5f4616df.js":    {Data: []byte("console.log('app')")},
+		"js/app.5f4616df.js.br": {Data: []byte("br")},
+		"css/style.css":         {Data: []byte("body{}")},
+	}, built)
+	s := NewServer("/", false, true, logrus.WithField("test", true),
+		WithStorage(st), WithUploads(false), WithCompression(true, false, 0, 0), WithCachePolicy(true, nil))
+	defer s.Close()
+
+	rr := serveTest(s, http.MethodGet, "/js/app.5f4616df.js", "", map[string]string{"Accept-Encoding": "br"})
+	if rr.Code != http.StatusOK || rr.Body.String() != "br" || rr.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("precompressed returned %d %q, encoding %q", rr.Code, rr.Body, rr.Header().Get("Content-Encoding"))
+	}
+	if !strings.Contains(rr.Header().Get("Cache-Control"), "immutable") {
+		t.Fatalf("hashed asset sent Cache-Control %q", rr.Header().Get("Cache-Control"))
+	}
+	// embed.FS has no modification times, the files have the build date
+	if rr := serveTest(s, http.MethodGet, "/css/style.css", "", nil); rr.Body.String() != "body{}" || rr.Header().Get("Last-Modified") != built.Format(http.TimeFormat) {
+		t.Fatalf("GET returned %q, Last-Modified %q", rr.Body, rr.Header().Get("Last-Modified"))
+	}
+	if rr := serveTest(s, http.MethodGet, "/about/team", "", nil); rr.Code != http.StatusOK || rr.Body.String() != "<h1>app</h1>" {
+		t.Fatalf("SPA fallback returned %d %q", rr.Code, rr.Body)
+	}
+	if rr := serveTest(s, http.MethodPost, "/", "", nil); rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("upload to a read-only storage returned %d", rr.Code)
+	}
+}
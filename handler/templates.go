@@ -0,0 +1,365 @@
+package handler
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// defaultTemplatesFS contém os templates e assets padrão do navegador de arquivos.
+// Um diretório informado em WithTemplates pode sobrescrever qualquer um desses
+// arquivos mantendo o mesmo nome (ex: 'list.html' ou 'assets/style.css').
+//
+//go:embed templates
+var defaultTemplatesFS embed.FS
+
+const (
+	templateLayout    = "layout.html"
+	templateList      = "list.html"
+	templateError     = "error.html"
+	templateUpload    = "upload.html"
+	templatePreview   = "preview.html"
+	templateForbidden = "forbidden.html"
+
+	assetsPath = internalPathPrefix + "assets/"
+)
+
+// templateNames são parseados juntos, assim as páginas podem usar os blocos
+// definidos em 'layout.html' ("head", "header", "breadcrumbs" e "footer").
+var templateNames = []string{templateLayout, templateList, templateError, templateUpload, templatePreview, templateForbidden}
+
+// PageData é o modelo de dados disponível em todos os templates.
+type PageData struct {
+	// Title é o título da página
+	Title string
+	// Path é o caminho da URL do diretório exibido (ex: '/docs/')
+	Path string
+	// AssetsPath é o prefixo da URL dos assets (ex: '{{ .AssetsPath }}style.css')
+	AssetsPath string
+	// Breadcrumbs contém um item para cada diretório de Path, a partir da raiz
+	Breadcrumbs []Breadcrumb
+	// Entries contém os arquivos da página atual do diretório, somente em 'list.html'
+	Entries []Entry
+	// Listing contém a ordenação, filtro e paginação, somente em 'list.html'
+	Listing ListingInfo
+	// Readme é o 'README.md' do diretório renderizado, somente em 'list.html'
+	Readme template.HTML
+	// Preview descreve o arquivo exibido, somente em 'preview.html'
+	Preview *Preview
+	// Uploads contém os nomes dos arquivos recebidos, somente em 'upload.html'
+	Uploads []string
+	// Error descreve o erro, somente em 'error.html'
+	Error *ErrorInfo
+	// Forbidden descreve a requisição recusada pela lista de acesso, somente em 'forbidden.html'
+	Forbidden *ForbiddenInfo
+	// Server contém a configuração do servidor
+	Server ServerInfo
+	// User é o usuário autenticado, vazio quando não há autenticação
+	User string
+}
+
+// Breadcrumb é um link para um dos diretórios do caminho atual.
+type Breadcrumb struct {
+	Name string
+	URL  string
+}
+
+// Entry é um arquivo ou diretório da listagem.
+type Entry struct {
+	Name string
+	URL  string
+	// PreviewURL abre o arquivo em 'preview.html', vazio quando não há visualizador
+	PreviewURL string
+	// ThumbURL é a URL da miniatura, vazia quando o arquivo não é uma imagem
+	// ou as miniaturas estão desabilitadas
+	ThumbURL string
+	IsDir    bool
+	Size     int64
+	ModTime  time.Time
+	Mode     os.FileMode
+}
+
+// ErrorInfo descreve uma resposta de erro.
+type ErrorInfo struct {
+	Status     int
+	StatusText string
+	Message    string
+}
+
+// ForbiddenInfo descreve uma requisição recusada pela lista de acesso.
+type ForbiddenInfo struct {
+	ClientIP string
+	Method   string
+}
+
+// ServerInfo expõe aos templates as opções do servidor.
+type ServerInfo struct {
+	UploadEnabled              bool
+	SPAMode                    bool
+	LiveReload                 bool
+	KeepOriginalUploadFileName bool
+	// Thumbnails indica que as miniaturas e a visualização em grade estão disponíveis
+	Thumbnails bool
+	// SearchURL é a URL da busca recursiva, vazia quando a busca está desabilitada
+	SearchURL string
+}
+
+// templateSet carrega os templates, priorizando os arquivos de dir sobre os padrões
+// embutidos. Em produção os templates são parseados uma única vez; com reload
+// habilitado (modo dev) eles são relidos a cada requisição.
+type templateSet struct {
+	dir    string
+	reload bool
+
+	mu     sync.Mutex
+	cached *template.Template
+}
+
+func (ts *templateSet) readFile(name string) ([]byte, error) {
+	if ts.dir != "" {
+		data, err := os.ReadFile(filepath.Join(ts.dir, filepath.FromSlash(name)))
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return defaultTemplatesFS.ReadFile(path.Join("templates", name))
+}
+
+func (ts *templateSet) parse() (*template.Template, error) {
+	t := template.New("").Funcs(template.FuncMap{
+		"formatBytes": formatBytes,
+		"formatTime":  formatTime,
+		"ext":         path.Ext,
+	})
+
+	for _, name := range templateNames {
+		content, err := ts.readFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s", ErrCreateTemplate, err)
+		}
+		if _, err := t.New(name).Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("%w %s", ErrCreateTemplate, err)
+		}
+	}
+
+	return t, nil
+}
+
+func (ts *templateSet) load() (*template.Template, error) {
+	if ts.reload {
+		return ts.parse()
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.cached == nil {
+		t, err := ts.parse()
+		if err != nil {
+			return nil, err
+		}
+		ts.cached = t
+	}
+	return ts.cached, nil
+}
+
+func (ts *templateSet) execute(w io.Writer, name string, data *PageData) error {
+	t, err := ts.load()
+	if err != nil {
+		return err
+	}
+
+	err = t.ExecuteTemplate(w, name, data)
+	if err != nil {
+		return fmt.Errorf("%w %s", ErrExecuteTemplate, err)
+	}
+	return nil
+}
+
+// openAsset abre um asset do diretório de templates ou, se não existir, o padrão embutido.
+func (ts *templateSet) openAsset(name string) (fs.File, error) {
+	name = path.Join("assets", path.Clean("/"+name))
+	if ts.dir != "" {
+		f, err := os.Open(filepath.Join(ts.dir, filepath.FromSlash(name)))
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return defaultTemplatesFS.Open(path.Join("templates", name))
+}
+
+// assetsHandler serve os arquivos estáticos usados pelos templates.
+func (s *Server) assetsHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	name := p.ByName("name")
+	f, err := s.templates.openAsset(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			s.sendError(w, r, http.StatusNotFound, err)
+		} else {
+			s.sendError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		s.sendError(w, r, http.StatusNotFound, fs.ErrNotExist)
+		return
+	}
+
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		s.sendError(w, r, http.StatusInternalServerError, ErrFileIsNotRegular)
+		return
+	}
+
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("Cache-Control", cacheControlNoCache)
+	http.ServeContent(w, r, name, fi.ModTime(), rs)
+}
+
+// newPageData monta os dados comuns a todas as páginas para o caminho urlPath.
+func (s *Server) newPageData(urlPath string) *PageData {
+	data := &PageData{
+		Title:       "GO Upload Server",
+		Path:        urlPath,
+		AssetsPath:  assetsPath,
+		Breadcrumbs: breadcrumbs(urlPath),
+		Server: ServerInfo{
+			UploadEnabled:              !s.spaMode && s.uploadEnabled,
+			SPAMode:                    s.spaMode,
+			LiveReload:                 s.liveReload != nil,
+			KeepOriginalUploadFileName: s.keepOriginalUploadFileName,
+			Thumbnails:                 s.thumbnails != nil,
+		},
+	}
+	if s.searchEnabled && !s.spaMode {
+		data.Server.SearchURL = searchPath
+	}
+	return data
+}
+
+// renderPage executa o template name e envia a página com o status informado.
+func (s *Server) renderPage(w http.ResponseWriter, r *http.Request, status int, name string, data *PageData) error {
+	data.User = requestUser(r)
+
+	var html bytes.Buffer
+	if err := s.templates.execute(&html, name, data); err != nil {
+		return err
+	}
+
+	body := html.Bytes()
+	if s.liveReload != nil && name == templateList {
+		body = injectLiveReloadScript(body, "dir")
+	} else if s.liveReload != nil && name == templatePreview {
+		body = injectLiveReloadScript(body, "")
+	}
+
+	return s.writeBody(w, r, status, "text/html; charset=utf-8", body)
+}
+
+// sendError responde com a página 'error.html' para navegadores e com texto
+// simples para os demais clientes (ex: curl ou axios).
+func (s *Server) sendError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if !acceptsHTML(r) {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// headers from a partially built response must not leak into the error page
+	w.Header().Del("Content-Encoding")
+	w.Header().Del("Content-Length")
+
+	data := s.newPageData(r.URL.Path)
+	data.Error = &ErrorInfo{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    err.Error(),
+	}
+
+	if rerr := s.renderPage(w, r, status, templateError, data); rerr != nil {
+		s.logger.Errorf("Render error page: %s", rerr)
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// sendForbidden responde 403 com a página 'forbidden.html' para navegadores e
+// com texto simples para os demais clientes.
+func (s *Server) sendForbidden(w http.ResponseWriter, r *http.Request, ip string) {
+	err := fmt.Errorf("%w: %s is not allowed to %s %s", ErrForbidden, ip, r.Method, r.URL.Path)
+	if !acceptsHTML(r) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	data := s.newPageData(r.URL.Path)
+	data.Forbidden = &ForbiddenInfo{ClientIP: ip, Method: r.Method}
+	if rerr := s.renderPage(w, r, http.StatusForbidden, templateForbidden, data); rerr != nil {
+		s.logger.Errorf("Render forbidden page: %s", rerr)
+		http.Error(w, err.Error(), http.StatusForbidden)
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.Format("2006-01-02 15:04")
+}
+
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func breadcrumbs(urlPath string) []Breadcrumb {
+	crumbs := []Breadcrumb{{Name: "/", URL: "/"}}
+	current := "/"
+	for _, name := range strings.Split(strings.Trim(urlPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		current += name + "/"
+		crumbs = append(crumbs, Breadcrumb{Name: name, URL: (&url.URL{Path: current}).String()})
+	}
+	return crumbs
+}
+
+func newEntry(fi os.FileInfo) Entry {
+	// url.URL escapes the name and prefixes './' to names like 'a:b'
+	u := (&url.URL{Path: fi.Name()}).String()
+	if fi.IsDir() {
+		u += "/"
+	}
+	e := Entry{
+		Name:    fi.Name(),
+		URL:     u,
+		IsDir:   fi.IsDir(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Mode:    fi.Mode(),
+	}
+	if !fi.IsDir() && previewKindByName(fi.Name()) != "" {
+		e.PreviewURL = u + "?preview"
+	}
+	return e
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestBreadcrumbs(t *testing.T) {
+	crumbs := breadcrumbs("/docs/my dir/")
+	want := []Breadcrumb{{"/", "/"}, {"docs", "/docs/"}, {"my dir", "/docs/my%20dir/"}}
+	if len(crumbs) != len(want) {
+		t.Fatalf("breadcrumbs returned wrong length: got %v want %v", crumbs, want)
+	}
+	for i := range want {
+		if crumbs[i] != want[i] {
+			t.Fatalf("breadcrumbs returned wrong item %d: got %v want %v", i, crumbs[i], want[i])
+		}
+	}
+}
+
+func TestCustomTemplateDir(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		templateList: `{{ define "list.html" }}{{ range .Entries }}[{{ .Name }}]{{ end }}{{ end }}`,
+	})
+
+	// reload = true, so changes to the template are picked up on the next request
+	s := NewServer("../test/plain-html", false, false, logrus.WithField("test", true), WithTemplates(dir, true))
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	if body := rr.Body.String(); body != "[index.html][script.js][style.css]" {
+		t.Fatalf("handler returned wrong body: got %v want %v", body, "[index.html][script.js][style.css]")
+	}
+
+	list := `{{ define "list.html" }}{{ len .Entries }} files in {{ .Path }}{{ end }}`
+	if err := ioutil.WriteFile(path.Join(dir, templateList), []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	if body := rr.Body.String(); body != "3 files in /" {
+		t.Fatalf("handler returned wrong body: got %v want %v", body, "3 files in /")
+	}
+}
+
+func TestErrorPage(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/not-found.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	rr := httptest.NewRecorder()
+	s := NewServer("../test/plain-html", false, false, logrus.WithField("test", true))
+
+	s.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+		t.Fatalf("handler returned wrong header Content-Type: got %v want %v", contentType, "text/html; charset=utf-8")
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "404 Not Found") {
+		t.Fatalf("handler returned wrong body: got %v", body)
+	}
+}
+
+func TestAssetsHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, assetsPath+"style.css", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	s := NewServer("../test/plain-html", false, false, logrus.WithField("test", true))
+
+	s.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if contentType := rr.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/css") {
+		t.Fatalf("handler returned wrong header Content-Type: got %v want %v", contentType, "text/css")
+	}
+
+	req, err = http.NewRequest(http.MethodGet, assetsPath+"../list.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	directionDownload = "download"
+	directionUpload   = "upload"
+
+	scopeGlobal = "global"
+	scopeIP     = "ip"
+	scopeUser   = "user"
+
+	// bucketIdleTTL é o tempo sem uso para descartar os buckets de um IP ou usuário
+	bucketIdleTTL = time.Minute
+	// transferRetryAfter é o Retry-After das respostas 429 por excesso de transferências
+	transferRetryAfter = 5 * time.Second
+)
+
+// tokenBucket limita a taxa em bytes por segundo. As retiradas podem deixar o
+// saldo negativo, assim leituras concorrentes reservam a sua vez em ordem e
+// cada uma espera somente pela própria reserva.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// reserve retira n tokens e retorna quanto tempo esperar até que eles existam.
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// take retira n tokens somente quando eles existem, senão retorna quanto
+// tempo esperar até que existam.
+func (b *tokenBucket) take(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return 0
+	}
+	return time.Duration((float64(n) - b.tokens) / b.rate * float64(time.Second))
+}
+
+// idle indica se o bucket está cheio e sem uso desde before.
+func (b *tokenBucket) idle(before time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last.Before(before) && b.tokens+before.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// bandwidthRule é um limite 'direction/scope=rate' (ex: 'download/ip=2MiB').
+type bandwidthRule struct {
+	direction string
+	scope     string
+	rate      int64
+}
+
+func parseBandwidthRule(rule string) (bandwidthRule, error) {
+	key, value, ok := cutString(rule, "=")
+	if !ok {
+		return bandwidthRule{}, fmt.Errorf("%w: %q, expected 'direction[/scope]=rate'", ErrBandwidthLimit, rule)
+	}
+	direction, scope, hasScope := cutString(strings.TrimSpace(key), "/")
+	if !hasScope {
+		scope = scopeGlobal
+	}
+	if direction != directionDownload && direction != directionUpload {
+		return bandwidthRule{}, fmt.Errorf("%w: %q, the direction must be download or upload", ErrBandwidthLimit, rule)
+	}
+	if scope != scopeGlobal && scope != scopeIP && scope != scopeUser {
+		return bandwidthRule{}, fmt.Errorf("%w: %q, the scope must be global, ip or user", ErrBandwidthLimit, rule)
+	}
+	rate, err := parseRate(value)
+	if err != nil {
+		return bandwidthRule{}, fmt.Errorf("%w: %q: %s", ErrBandwidthLimit, rule, err)
+	}
+	return bandwidthRule{direction, scope, rate}, nil
+}
+
+// parseRate lê uma taxa em bytes por segundo, como '500KB', '10MiB' ou '1G/s'.
+func parseRate(value string) (int64, error) {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "/s")
+	// the longer suffixes first, 'kib' before 'b'
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+		{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9},
+		{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+		{"b", 1},
+	}
+	size := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToLower(value), u.suffix) {
+			value, size = strings.TrimSpace(value[:len(value)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n*size < 1 || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return int64(n * size), nil
+}
+
+func cutString(s string, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// bandwidthLimiter guarda os buckets globais e os de cada IP e usuário, por direção.
+type bandwidthLimiter struct {
+	rates map[string]int64 // 'direction/scope'
+
+	mu      sync.Mutex
+	global  map[string]*tokenBucket            // direction
+	buckets map[string]map[string]*tokenBucket // 'direction/scope' -> key
+	swept   time.Time
+}
+
+func newBandwidthLimiter(rules []bandwidthRule) *bandwidthLimiter {
+	l := &bandwidthLimiter{
+		rates:   make(map[string]int64),
+		global:  make(map[string]*tokenBucket),
+		buckets: make(map[string]map[string]*tokenBucket),
+		swept:   time.Now(),
+	}
+	for _, rule := range rules {
+		if rule.scope == scopeGlobal {
+			l.global[rule.direction] = newTokenBucket(float64(rule.rate), float64(rule.rate))
+			continue
+		}
+		key := rule.direction + "/" + rule.scope
+		l.rates[key] = rule.rate
+		l.buckets[key] = make(map[string]*tokenBucket)
+	}
+	return l
+}
+
+// bucketsFor retorna os buckets que limitam uma transferência do cliente.
+func (l *bandwidthLimiter) bucketsFor(direction string, client string, user string) []*tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) > bucketIdleTTL {
+		l.sweep(now.Add(-bucketIdleTTL))
+		l.swept = now
+	}
+
+	var list []*tokenBucket
+	if b := l.global[direction]; b != nil {
+		list = append(list, b)
+	}
+	for scope, key := range map[string]string{scopeIP: client, scopeUser: user} {
+		name := direction + "/" + scope
+		rate, ok := l.rates[name]
+		if !ok || key == "" {
+			continue
+		}
+		b := l.buckets[name][key]
+		if b == nil {
+			b = newTokenBucket(float64(rate), float64(rate))
+			l.buckets[name][key] = b
+		}
+		list = append(list, b)
+	}
+	return list
+}
+
+// sweep descarta os buckets cheios e sem uso, que seriam recriados iguais.
+func (l *bandwidthLimiter) sweep(before time.Time) {
+	for _, buckets := range l.buckets {
+		for key, b := range buckets {
+			if b.idle(before) {
+				delete(buckets, key)
+			}
+		}
+	}
+}
+
+// throttledReader espera pelos tokens de todos os buckets a cada leitura.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*tokenBucket
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+	if werr := waitBuckets(t.ctx, t.buckets, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// throttledReaderAt é o throttledReader das leituras com offset do SFTP.
+type throttledReaderAt struct {
+	ctx     context.Context
+	r       io.ReaderAt
+	buckets []*tokenBucket
+}
+
+func (t *throttledReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := t.r.ReadAt(p, off)
+	if n <= 0 {
+		return n, err
+	}
+	if werr := waitBuckets(t.ctx, t.buckets, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// waitBuckets reserva n tokens de cada bucket e espera pela maior reserva.
+func waitBuckets(ctx context.Context, buckets []*tokenBucket, n int) error {
+	now := time.Now()
+	var wait time.Duration
+	for _, b := range buckets {
+		if d := b.reserve(n, now); d > wait {
+			wait = d
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttle limita a leitura de r conforme os limites de banda do Server. Os
+// limites valem para os downloads e uploads do http, os uploads retomáveis, a
+// API do S3 e o SFTP. As miniaturas e os arquivos dos templates, gerados ou
+// internos, não passam pelos limites.
+func (s *Server) throttle(ctx context.Context, r io.Reader, direction string, client string, user string) io.Reader {
+	if s.bandwidth == nil {
+		return r
+	}
+	buckets := s.bandwidth.bucketsFor(direction, client, user)
+	if len(buckets) == 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, buckets: buckets}
+}
+
+// throttleReaderAt limita as leituras de r como o throttle.
+func (s *Server) throttleReaderAt(ctx context.Context, r io.ReaderAt, direction string, client string, user string) io.ReaderAt {
+	if s.bandwidth == nil {
+		return r
+	}
+	buckets := s.bandwidth.bucketsFor(direction, client, user)
+	if len(buckets) == 0 {
+		return r
+	}
+	return &throttledReaderAt{ctx: ctx, r: r, buckets: buckets}
+}
+
+// throttleDownload limita o envio de um arquivo para o cliente de r.
+func (s *Server) throttleDownload(r *http.Request, body io.Reader) io.Reader {
+	return s.throttle(r.Context(), body, directionDownload, clientIP(r), requestUser(r))
+}
+
+// transferSlots limita as transferências simultâneas de cada cliente. Quando o
+// limite é atingido a requisição espera na fila por até queueTimeout. No SFTP
+// cada arquivo aberto para leitura ou escrita ocupa uma vaga até ser fechado.
+type transferSlots struct {
+	max          int
+	queueTimeout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*clientSlots
+}
+
+type clientSlots struct {
+	slots chan struct{}
+	// refs conta as transferências e as esperas, a entrada é removida em zero
+	refs int
+}
+
+func newTransferSlots(max int, queueTimeout time.Duration) *transferSlots {
+	return &transferSlots{max: max, queueTimeout: queueTimeout, clients: make(map[string]*clientSlots)}
+}
+
+// acquire ocupa uma vaga do cliente e retorna a função que a libera, ou
+// ErrTooManyTransfers quando não há vaga dentro do tempo da fila.
+func (t *transferSlots) acquire(ctx context.Context, client string) (func(), error) {
+	t.mu.Lock()
+	c := t.clients[client]
+	if c == nil {
+		c = &clientSlots{slots: make(chan struct{}, t.max)}
+		t.clients[client] = c
+	}
+	c.refs++
+	t.mu.Unlock()
+
+	release := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if c.refs--; c.refs == 0 {
+			delete(t.clients, client)
+		}
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+		return func() { <-c.slots; release() }, nil
+	default:
+	}
+	if t.queueTimeout <= 0 {
+		release()
+		return nil, ErrTooManyTransfers
+	}
+
+	timer := time.NewTimer(t.queueTimeout)
+	defer timer.Stop()
+	select {
+	case c.slots <- struct{}{}:
+		return func() { <-c.slots; release() }, nil
+	case <-timer.C:
+		release()
+		return nil, ErrTooManyTransfers
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// acquireTransfer reserva uma vaga de transferência para o cliente de r. Sem
+// vaga responde 429 com Retry-After e retorna ok false.
+func (s *Server) acquireTransfer(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	if s.transfers == nil {
+		return func() {}, true
+	}
+	release, err := s.transfers.acquire(r.Context(), clientIP(r))
+	if err != nil {
+		s.logger.Warnf("Transfer limit: %s from %s: %s", r.Method, clientIP(r), err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(transferRetryAfter/time.Second)))
+		s.sendError(w, r, http.StatusTooManyRequests, err)
+		return nil, false
+	}
+	return release, true
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseBandwidthRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		want bandwidthRule
+	}{
+		{"download=10MiB", bandwidthRule{directionDownload, scopeGlobal, 10 << 20}},
+		{"upload/ip=500KB", bandwidthRule{directionUpload, scopeIP, 500000}},
+		{"download/user=1.5k/s", bandwidthRule{directionDownload, scopeUser, 1536}},
+		{"upload/global=2048", bandwidthRule{directionUpload, scopeGlobal, 2048}},
+		{"download = 1 GB", bandwidthRule{directionDownload, scopeGlobal, 1e9}},
+	}
+	for _, test := range tests {
+		got, err := parseBandwidthRule(test.rule)
+		if err != nil || got != test.want {
+			t.Errorf("parseBandwidthRule(%q) = %+v, %v, want %+v", test.rule, got, err, test.want)
+		}
+	}
+
+	for _, rule := range []string{"download", "delete=1MB", "download/host=1MB", "upload=fast", "upload=0", "upload=0.5B"} {
+		if _, err := parseBandwidthRule(rule); !errors.Is(err, ErrBandwidthLimit) {
+			t.Errorf("parseBandwidthRule(%q) returned %v", rule, err)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(1000, 1000)
+	now := b.last
+	if d := b.reserve(1000, now); d != 0 {
+		t.Fatalf("the burst waited %s", d)
+	}
+	// the next reservations queue after the previous ones
+	if d := b.reserve(500, now); d != 500*time.Millisecond {
+		t.Fatalf("first reservation waits %s", d)
+	}
+	if d := b.reserve(500, now); d != time.Second {
+		t.Fatalf("second reservation waits %s", d)
+	}
+	if b.idle(now.Add(time.Second)) || !b.idle(now.Add(3*time.Second)) {
+		t.Fatal("the bucket is idle only after refilling")
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	dir := t.TempDir()
+	data := strings.Repeat("x", 3000)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.bin"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(dir, true, false, logrus.WithField("test", true), WithBandwidthLimits([]string{"download/ip=2000", "upload=invalid"}))
+	defer s.Close()
+	if s.bandwidth == nil || len(s.bandwidth.rates) != 1 {
+		t.Fatalf("limits parsed as %+v", s.bandwidth)
+	}
+
+	// the file is read at once, the 1000 bytes over the burst take half a second
+	start := time.Now()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file.bin", nil))
+	if elapsed := time.Since(start); w.Body.String() != data || elapsed < 400*time.Millisecond {
+		t.Fatalf("sent %d bytes in %s", w.Body.Len(), elapsed)
+	}
+
+	// another client has its own full bucket, the shared one would wait 1.5s
+	start = time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/file.bin", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("a new client waited %s", elapsed)
+	}
+}
+
+func TestTransferLimit(t *testing.T) {
+	slots := newTransferSlots(1, 0)
+	release, err := slots.acquire(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := slots.acquire(context.Background(), "192.0.2.1"); !errors.Is(err, ErrTooManyTransfers) {
+		t.Fatalf("second transfer returned %v", err)
+	}
+	if other, err := slots.acquire(context.Background(), "192.0.2.2"); err != nil {
+		t.Fatalf("another client returned %v", err)
+	} else {
+		other()
+	}
+	release()
+	if len(slots.clients) != 0 {
+		t.Fatalf("released clients are kept: %v", slots.clients)
+	}
+
+	// a queued transfer starts when the slot is released
+	queued := newTransferSlots(1, time.Second)
+	release, _ = queued.acquire(context.Background(), "192.0.2.1")
+	time.AfterFunc(50*time.Millisecond, release)
+	if next, err := queued.acquire(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatalf("queued transfer returned %v", err)
+	} else {
+		next()
+	}
+}
+
+func TestTransferLimitResponse(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.bin"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(dir, true, false, logrus.WithField("test", true), WithTransferLimit(1, 0))
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// an upload that has not finished holds the only slot
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Post(ts.URL+"/", "multipart/form-data; boundary=x", pr)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	pw.Write([]byte("--x\r\n"))
+
+	resp := waitStatus(t, ts.URL+"/file.bin", http.StatusTooManyRequests)
+	if resp.Header.Get("Retry-After") != "5" {
+		t.Fatalf("the download over the limit returned Retry-After %q", resp.Header.Get("Retry-After"))
+	}
+
+	// the slot is released when the upload handler returns
+	pw.CloseWithError(io.ErrUnexpectedEOF)
+	<-done
+	waitStatus(t, ts.URL+"/file.bin", http.StatusOK)
+}
+
+func waitStatus(t *testing.T, url string, status int) *http.Response {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == status {
+			return resp
+		}
+	}
+	t.Fatalf("GET %s never returned %d", url, status)
+	return nil
+}
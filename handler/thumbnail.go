@@ -0,0 +1,417 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif" // registers the GIF decoder used by image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
+	"golang.org/x/image/draw"
+)
+
+const (
+	defaultThumbSize = 256
+	minThumbSize     = 16
+	maxThumbSize     = 1024
+
+	// maxThumbSourcePixels limita a resolução das imagens decodificadas. Uma imagem
+	// de 40 megapixels ocupa cerca de 160 MiB em memória depois de decodificada.
+	maxThumbSourcePixels = 40 << 20
+
+	thumbJPEGQuality = 80
+
+	// thumbCacheMaxBytes limita o cache, as miniaturas usadas há mais tempo são removidas
+	thumbCacheMaxBytes = 256 << 20
+)
+
+// thumbContentTypes são as extensões das miniaturas no cache e os seus Content-Types.
+var thumbContentTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+}
+
+// thumbnailer gera miniaturas de imagens JPEG, PNG e GIF e as guarda em dir.
+// A quantidade de imagens decodificadas ao mesmo tempo é limitada pelo tamanho
+// do pool de workers, mantendo o uso de memória previsível.
+type thumbnailer struct {
+	dir     string
+	workers chan struct{}
+	// maxBytes limita o tamanho total das miniaturas em dir
+	maxBytes int64
+
+	pruneMu sync.Mutex
+}
+
+func newThumbnailer(dir string, workers int) *thumbnailer {
+	if workers < 1 {
+		workers = 1
+	}
+	return &thumbnailer{dir: dir, workers: make(chan struct{}, workers), maxBytes: thumbCacheMaxBytes}
+}
+
+func wantsThumbnail(r *http.Request) bool {
+	_, ok := r.URL.Query()["thumb"]
+	return ok
+}
+
+// isThumbnailable indica, pela extensão, se o arquivo pode ter uma miniatura.
+func isThumbnailable(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+func parseThumbSize(value string) (int, error) {
+	if value == "" {
+		return defaultThumbSize, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < minThumbSize || size > maxThumbSize {
+		return 0, fmt.Errorf("%w: size must be between %d and %d, got %q", ErrThumbnail, minThumbSize, maxThumbSize, value)
+	}
+	return size, nil
+}
+
+// cachePath identifica a miniatura pelo caminho, mtime e tamanho do arquivo
+// original, assim uma alteração no arquivo gera uma nova miniatura. A extensão
+// do formato da miniatura é acrescentada ao caminho.
+func (t *thumbnailer) cachePath(filepath string, fileinfo os.FileInfo, size int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d", filepath, fileinfo.ModTime().UnixNano(), fileinfo.Size(), size)
+	return path.Join(t.dir, hex.EncodeToString(h.Sum(nil)))
+}
+
+// lookup retorna a miniatura do cache e o seu Content-Type, marcando-a como
+// usada agora para que seja a última removida pelo prune.
+func (t *thumbnailer) lookup(cachePath string) (string, string, bool) {
+	for ext, ctype := range thumbContentTypes {
+		if _, err := os.Stat(cachePath + ext); err == nil {
+			now := time.Now()
+			os.Chtimes(cachePath+ext, now, now)
+			return cachePath + ext, ctype, true
+		}
+	}
+	return "", "", false
+}
+
+// thumbnail retorna o caminho da miniatura no cache e o seu Content-Type,
+// gerando-a quando necessário. open abre o arquivo original somente quando a
+// miniatura não está no cache.
+func (t *thumbnailer) thumbnail(filepath string, fileinfo os.FileInfo, size int, open func() (storage.File, error)) (string, string, error) {
+	cachePath := t.cachePath(filepath, fileinfo, size)
+	if cached, ctype, ok := t.lookup(cachePath); ok {
+		return cached, ctype, nil
+	}
+
+	t.workers <- struct{}{}
+	defer func() { <-t.workers }()
+
+	// another request may have generated it while this one was waiting
+	if cached, ctype, ok := t.lookup(cachePath); ok {
+		return cached, ctype, nil
+	}
+
+	f, err := open()
+	if err != nil {
+		return "", "", err
+	}
+	data, ext, err := generateThumbnail(f, size)
+	f.Close()
+	if err != nil {
+		return "", "", err
+	}
+	cached, ctype := cachePath+ext, thumbContentTypes[ext]
+
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return "", "", err
+	}
+	// write to a temp file and rename, so readers never see a partial thumbnail
+	tmp, err := ioutil.TempFile(t.dir, "tmp-*")
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	t.prune(cached)
+	return cached, ctype, nil
+}
+
+// prune remove as miniaturas usadas há mais tempo até o cache caber em
+// maxBytes, mantendo keep, a miniatura que acabou de ser gerada.
+func (t *thumbnailer) prune(keep string) {
+	t.pruneMu.Lock()
+	defer t.pruneMu.Unlock()
+
+	infos, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		return
+	}
+	var total int64
+	var thumbs []os.FileInfo
+	for _, fi := range infos {
+		// the temp files belong to the thumbnails being written
+		if fi.Mode().IsRegular() && !strings.HasPrefix(fi.Name(), "tmp-") {
+			total += fi.Size()
+			thumbs = append(thumbs, fi)
+		}
+	}
+	sort.Slice(thumbs, func(i, j int) bool { return thumbs[i].ModTime().Before(thumbs[j].ModTime()) })
+	for _, fi := range thumbs {
+		if total <= t.maxBytes {
+			return
+		}
+		if p := path.Join(t.dir, fi.Name()); p != keep && os.Remove(p) == nil {
+			total -= fi.Size()
+		}
+	}
+}
+
+// generateThumbnail decodifica a imagem, reduz para caber em size x size
+// pixels e aplica a orientação EXIF. JPEGs são codificados como JPEG e as demais
+// imagens como PNG, preservando a transparência. Retorna a miniatura e a
+// extensão do seu formato, decidido pelo conteúdo e não pela extensão do arquivo.
+func generateThumbnail(f io.ReadSeeker, size int) ([]byte, string, error) {
+	// the header is checked before decoding, so a small file declaring a huge
+	// image never allocates its pixels
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrThumbnail, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxThumbSourcePixels {
+		return nil, "", fmt.Errorf("%w: image too large (%dx%d)", ErrThumbnail, config.Width, config.Height)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	src, format, err := image.Decode(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrThumbnail, err)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, "", err
+		}
+		orientation = readExifOrientation(f)
+	}
+
+	dst := applyOrientation(resizeToFit(src, size), orientation)
+
+	var buf bytes.Buffer
+	ext := ".png"
+	if format == "jpeg" {
+		ext = ".jpg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbJPEGQuality})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), ext, nil
+}
+
+// resizeToFit reduz a imagem mantendo a proporção. Imagens menores que size não são ampliadas.
+func resizeToFit(src image.Image, size int) *image.NRGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max1(h*size/w)
+		} else {
+			w, h = max1(w*size/h), size
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// applyOrientation transforma a imagem de acordo com a tag EXIF Orientation (1 a 8).
+func applyOrientation(src *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// orientations 5 to 8 swap the width and the height
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 counterclockwise
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// readExifOrientation procura a tag Orientation (0x0112) no segmento APP1 do
+// JPEG. Retorna 1 (sem transformação) quando a tag não existe ou é inválida.
+func readExifOrientation(r io.Reader) int {
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+		// start of scan or end of image: the metadata segments are over
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return 1
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return 1
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 1
+		}
+
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseExifOrientation(segment[6:])
+		}
+	}
+}
+
+func parseExifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	// the offset is compared as uint32, converted to int it may wrap to a
+	// negative value on 32-bit platforms
+	offset := order.Uint32(tiff[4:8])
+	if offset > uint32(len(tiff)-2) {
+		return 1
+	}
+	ifd := int(offset)
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// sendThumbnailToClient envia a miniatura de filepath no tamanho pedido em '?thumb=256'.
+func (s *Server) sendThumbnailToClient(w http.ResponseWriter, r *http.Request, filepath string) error {
+	fileinfo, err := s.stat(filepath)
+	if err != nil {
+		return err
+	}
+	if !fileinfo.Mode().IsRegular() {
+		return ErrFileIsNotRegular
+	}
+	if !isThumbnailable(filepath) {
+		return fmt.Errorf("%w: unsupported image format", ErrThumbnail)
+	}
+
+	size, err := parseThumbSize(r.URL.Query().Get("thumb"))
+	if err != nil {
+		return err
+	}
+
+	cached, ctype, err := s.thumbnails.thumbnail(filepath, fileinfo, size, func() (storage.File, error) {
+		return s.open(filepath)
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(cached)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Cache-Control", cacheControlNoCache)
+	// the modification time of the original lets browsers revalidate with If-Modified-Since
+	http.ServeContent(w, r, "", fileinfo.ModTime(), f)
+	return nil
+}
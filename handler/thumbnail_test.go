@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// exifJPEG insere um segmento APP1 com a tag Orientation logo após o SOI do JPEG.
+func exifJPEG(jpg []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(42))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(jpg[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(jpg[2:])
+	return out.Bytes()
+}
+
+func newThumbnailTestDir(t *testing.T) string {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 200; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	var jpg, pngBuf, gifBuf bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gifBuf, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+	// a tiny GIF declaring a 65535x65535 screen
+	huge := gifBuf.Bytes()
+	binary.LittleEndian.PutUint16(huge[6:], 0xFFFF)
+	binary.LittleEndian.PutUint16(huge[8:], 0xFFFF)
+
+	return writeTestFiles(t, map[string]string{
+		"photo.jpg":   jpg.String(),
+		"rotated.jpg": string(exifJPEG(jpg.Bytes(), 6)),
+		"image.png":   pngBuf.String(),
+		"png.jpg":     pngBuf.String(),
+		"huge.gif":    string(huge),
+		"fake.png":    "not an image",
+	})
+}
+
+func TestReadExifOrientation(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewRGBA(image.Rect(0, 0, 2, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if o := readExifOrientation(bytes.NewReader(jpg.Bytes())); o != 1 {
+		t.Fatalf("readExifOrientation without EXIF: got %v want 1", o)
+	}
+	for _, want := range []int{3, 6, 8} {
+		if o := readExifOrientation(bytes.NewReader(exifJPEG(jpg.Bytes(), uint16(want)))); o != want {
+			t.Fatalf("readExifOrientation: got %v want %v", o, want)
+		}
+	}
+
+	// IFD offsets past the data, including the ones that wrap as int32
+	for _, offset := range []string{"\x00\x00\x00\x07", "\x80\x00\x00\x00", "\xff\xff\xff\xfe"} {
+		if o := parseExifOrientation([]byte("MM\x00\x2a" + offset + "\x00")); o != 1 {
+			t.Fatalf("parseExifOrientation with offset %q: got %v want 1", offset, o)
+		}
+	}
+}
+
+func TestThumbnailHandler(t *testing.T) {
+	dir := newThumbnailTestDir(t)
+	cacheDir := path.Join(dir, ".cache")
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithThumbnails(true, cacheDir, 2))
+
+	tests := []struct {
+		url           string
+		ctype         string
+		width, height int
+	}{
+		{"/photo.jpg?thumb=100", "image/jpeg", 100, 50},
+		{"/rotated.jpg?thumb=100", "image/jpeg", 50, 100},
+		{"/image.png?thumb", "image/png", 256, 128},
+		{"/image.png?thumb=1000", "image/png", 400, 200},
+		// the format comes from the content, not from the extension
+		{"/png.jpg?thumb=100", "image/png", 100, 50},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", tt.url, status, http.StatusOK)
+		}
+		if ctype := rr.Header().Get("Content-Type"); ctype != tt.ctype {
+			t.Fatalf("%s: handler returned wrong Content-Type: got %v want %v", tt.url, ctype, tt.ctype)
+		}
+		config, _, err := image.DecodeConfig(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != tt.width || config.Height != tt.height {
+			t.Fatalf("%s: wrong thumbnail size: got %dx%d want %dx%d", tt.url, config.Width, config.Height, tt.width, tt.height)
+		}
+	}
+
+	cached, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != len(tests) {
+		t.Fatalf("thumbnail cache has %d files, want %d", len(cached), len(tests))
+	}
+
+	for _, url := range []string{"/fake.png?thumb", "/huge.gif?thumb", "/photo.jpg?thumb=5000", "/photo.jpg?thumb=abc"} {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", url, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestThumbnailCacheLimit(t *testing.T) {
+	dir := newThumbnailTestDir(t)
+	cacheDir := path.Join(dir, ".cache")
+
+	s := NewServer(dir, false, false, logrus.WithField("test", true), WithThumbnails(true, cacheDir, 2))
+	// every new thumbnail replaces the previous ones
+	s.thumbnails.maxBytes = 1
+
+	for _, url := range []string{"/photo.jpg?thumb=100", "/image.png?thumb=100", "/image.png?thumb=50"} {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", url, rr.Code, http.StatusOK)
+		}
+		cached, err := ioutil.ReadDir(cacheDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cached) != 1 {
+			t.Fatalf("%s: thumbnail cache has %d files, want 1", url, len(cached))
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	uploadsPath = internalPathPrefix + "uploads"
+	// uploadSampleInterval é o intervalo das amostras de taxa e dos eventos SSE
+	uploadSampleInterval  = time.Second
+	uploadSubscriberBuf   = 8
+	uploadRateSmoothing   = 0.3
+	uploadStatusCompleted = "completed"
+	uploadStatusFailed    = "failed"
+	uploadStatusAborted   = "aborted"
+)
+
+// connContextKey guarda a conexão da requisição, usada para interromper a
+// leitura de um upload parado.
+type connContextKey struct{}
+
+// ConnContext guarda a conexão no contexto das requisições. Deve ser usada no
+// http.Server.ConnContext para que o tempo limite de uploads parados consiga
+// interromper uma leitura bloqueada.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// uploadSource identifica quem envia um upload.
+type uploadSource struct {
+	protocol string
+	client   string
+	user     string
+	// size é o tamanho esperado em bytes, -1 quando desconhecido
+	size int64
+	// received são os bytes recebidos antes desta requisição (ex: partes anteriores)
+	received int64
+	conn     net.Conn
+	// ctx interrompe a espera pelos limites de banda
+	ctx context.Context
+}
+
+// requestSource retorna o uploadSource de uma requisição HTTP.
+func requestSource(r *http.Request, protocol string, size int64) *uploadSource {
+	src := &uploadSource{protocol: protocol, client: clientIP(r), user: requestUser(r), size: size, ctx: r.Context()}
+	src.conn, _ = r.Context().Value(connContextKey{}).(net.Conn)
+	return src
+}
+
+// uploadInfo é o estado de um upload enviado pelo endpoint de acompanhamento.
+type uploadInfo struct {
+	ID       string    `json:"id"`
+	Protocol string    `json:"protocol"`
+	Client   string    `json:"client"`
+	User     string    `json:"user,omitempty"`
+	Target   string    `json:"target"`
+	Size     int64     `json:"size"`
+	Received int64     `json:"received"`
+	Rate     float64   `json:"rate"`
+	ETA      *float64  `json:"eta,omitempty"`
+	Started  time.Time `json:"started"`
+	Idle     float64   `json:"idle"`
+	Status   string    `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// uploadEvent é enviado aos inscritos: a lista dos uploads ativos a cada
+// amostra ou o resultado de um upload terminado.
+type uploadEvent struct {
+	name string
+	data interface{}
+}
+
+// uploadTracker acompanha os uploads em andamento: bytes recebidos, taxa, ETA,
+// cliente e destino. Uploads sem receber dados por idleTimeout são abortados.
+type uploadTracker struct {
+	idleTimeout time.Duration
+	interval    time.Duration
+	logger      *logrus.Entry
+
+	mu          sync.Mutex
+	nextID      uint64
+	active      map[string]*trackedUpload
+	subscribers map[chan uploadEvent]struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// trackedUpload é um upload em andamento.
+type trackedUpload struct {
+	tracker *uploadTracker
+	id      string
+	src     uploadSource
+	target  string
+	started time.Time
+
+	received int64 // atomic
+	lastRead int64 // atomic, UnixNano
+	aborted  int32 // atomic
+
+	// rate e sampled são usados somente pelo sample, com o tracker.mu
+	rate        float64
+	sampled     int64
+	sampledTime time.Time
+}
+
+func newUploadTracker(idleTimeout time.Duration, logger *logrus.Entry) *uploadTracker {
+	t := &uploadTracker{
+		idleTimeout: idleTimeout,
+		interval:    uploadSampleInterval,
+		logger:      logger,
+		active:      make(map[string]*trackedUpload),
+		subscribers: make(map[chan uploadEvent]struct{}),
+		done:        make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+// Close interrompe as amostras e desconecta os inscritos. Pode ser chamado
+// mais de uma vez, os pontos de montagem compartilham o tracker da raiz.
+func (t *uploadTracker) Close() {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.wg.Wait()
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for c := range t.subscribers {
+			close(c)
+			delete(t.subscribers, c)
+		}
+	})
+}
+
+// start registra um upload para target, a URL do arquivo.
+func (t *uploadTracker) start(src *uploadSource, target string) *trackedUpload {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	u := &trackedUpload{
+		tracker:     t,
+		id:          strconv.FormatUint(t.nextID, 10),
+		src:         *src,
+		target:      target,
+		started:     now,
+		received:    src.received,
+		lastRead:    now.UnixNano(),
+		sampled:     src.received,
+		sampledTime: now,
+	}
+	t.active[u.id] = u
+	return u
+}
+
+// reader conta os bytes lidos de r. Depois do tempo limite as leituras
+// retornam ErrUploadIdle.
+func (u *trackedUpload) reader(r io.Reader) io.Reader {
+	return &trackedReader{r: r, u: u}
+}
+
+// finish remove o upload dos ativos e avisa os inscritos do resultado.
+func (u *trackedUpload) finish(err error) {
+	t := u.tracker
+	t.mu.Lock()
+	delete(t.active, u.id)
+	info := u.info(time.Now())
+	t.mu.Unlock()
+
+	elapsed := time.Since(u.started)
+	switch {
+	case atomic.LoadInt32(&u.aborted) != 0:
+		info.Status = uploadStatusAborted
+		info.Error = ErrUploadIdle.Error()
+	case err != nil:
+		info.Status = uploadStatusFailed
+		info.Error = err.Error()
+	default:
+		info.Status = uploadStatusCompleted
+		info.Rate = float64(info.Received-u.src.received) / elapsed.Seconds()
+		t.logger.Infof("Upload of %s from %s: %s in %s (%s/s)", u.target, u.src.client,
+			formatBytes(info.Received), elapsed.Round(time.Millisecond), formatBytes(int64(info.Rate)))
+	}
+	info.ETA = nil
+	t.publish(uploadEvent{"finished", info})
+}
+
+func (u *trackedUpload) info(now time.Time) uploadInfo {
+	info := uploadInfo{
+		ID:       u.id,
+		Protocol: u.src.protocol,
+		Client:   u.src.client,
+		User:     u.src.user,
+		Target:   u.target,
+		Size:     u.src.size,
+		Received: atomic.LoadInt64(&u.received),
+		Rate:     u.rate,
+		Started:  u.started,
+		Idle:     now.Sub(time.Unix(0, atomic.LoadInt64(&u.lastRead))).Seconds(),
+	}
+	if info.Size >= info.Received && u.rate > 0 {
+		eta := float64(info.Size-info.Received) / u.rate
+		info.ETA = &eta
+	}
+	return info
+}
+
+// countingReader conta os bytes lidos do corpo de uma requisição.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type trackedReader struct {
+	r io.Reader
+	u *trackedUpload
+}
+
+func (r *trackedReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&r.u.aborted) != 0 {
+		return 0, ErrUploadIdle
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&r.u.received, int64(n))
+		atomic.StoreInt64(&r.u.lastRead, time.Now().UnixNano())
+	}
+	if err != nil && atomic.LoadInt32(&r.u.aborted) != 0 {
+		// the read deadline interrupted the connection
+		return n, ErrUploadIdle
+	}
+	return n, err
+}
+
+func (t *uploadTracker) run() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	// the list is sent once more after the last upload ends
+	wasActive := false
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-ticker.C:
+			list := t.sample(now)
+			if len(list) > 0 || wasActive {
+				t.publish(uploadEvent{"uploads", list})
+			}
+			wasActive = len(list) > 0
+		}
+	}
+}
+
+// sample atualiza as taxas, aborta os uploads parados e retorna a lista dos ativos.
+func (t *uploadTracker) sample(now time.Time) []uploadInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]uploadInfo, 0, len(t.active))
+	for _, u := range t.active {
+		received := atomic.LoadInt64(&u.received)
+		if elapsed := now.Sub(u.sampledTime).Seconds(); elapsed > 0 {
+			// exponential moving average, a single slow second does not zero the ETA
+			current := float64(received-u.sampled) / elapsed
+			if u.rate == 0 {
+				u.rate = current
+			} else {
+				u.rate = uploadRateSmoothing*current + (1-uploadRateSmoothing)*u.rate
+			}
+			u.sampled, u.sampledTime = received, now
+		}
+
+		idle := now.Sub(time.Unix(0, atomic.LoadInt64(&u.lastRead)))
+		if t.idleTimeout > 0 && idle > t.idleTimeout && atomic.CompareAndSwapInt32(&u.aborted, 0, 1) {
+			t.logger.Warnf("Upload of %s from %s stalled for %s at %s, aborting", u.target, u.src.client,
+				idle.Round(time.Second), formatBytes(received))
+			if u.src.conn != nil {
+				// unblocks the read waiting for the client
+				u.src.conn.SetReadDeadline(now)
+			}
+		}
+		list = append(list, u.info(now))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
+
+// list retorna os uploads ativos sem atualizar as taxas.
+func (t *uploadTracker) list() []uploadInfo {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]uploadInfo, 0, len(t.active))
+	for _, u := range t.active {
+		list = append(list, u.info(now))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
+
+func (t *uploadTracker) subscribe() chan uploadEvent {
+	c := make(chan uploadEvent, uploadSubscriberBuf)
+	t.mu.Lock()
+	t.subscribers[c] = struct{}{}
+	t.mu.Unlock()
+	return c
+}
+
+func (t *uploadTracker) unsubscribe(c chan uploadEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.subscribers[c]; ok {
+		delete(t.subscribers, c)
+		close(c)
+	}
+}
+
+func (t *uploadTracker) publish(evt uploadEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.subscribers {
+		select {
+		case c <- evt:
+		default:
+			// slow client, drop the event
+		}
+	}
+}
+
+// uploadsHandler lista os uploads em andamento em JSON. Com 'Accept:
+// text/event-stream' mantém uma conexão SSE com um evento "uploads" com a lista
+// a cada segundo e um evento "finished" para cada upload terminado.
+func (s *Server) uploadsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if r.Header.Get("Accept") != "text/event-stream" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(struct {
+			Uploads []uploadInfo `json:"uploads"`
+		}{s.visibleUploads(s.uploads.list())})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	c := s.uploads.subscribe()
+	defer s.uploads.unsubscribe(c)
+
+	writeUploadEvent(w, uploadEvent{"uploads", s.visibleUploads(s.uploads.list())})
+	flusher.Flush()
+	keepAlive := time.NewTicker(liveReloadKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case evt, ok := <-c:
+			if !ok {
+				return
+			}
+			switch data := evt.data.(type) {
+			case []uploadInfo:
+				evt.data = s.visibleUploads(data)
+			case uploadInfo:
+				if !s.uploadVisible(data.Target) {
+					continue
+				}
+			}
+			writeUploadEvent(w, evt)
+		}
+		flusher.Flush()
+	}
+}
+
+// visibleUploads retorna os uploads de list cujo destino pode ser listado.
+func (s *Server) visibleUploads(list []uploadInfo) []uploadInfo {
+	visible := make([]uploadInfo, 0, len(list))
+	for _, info := range list {
+		if s.uploadVisible(info.Target) {
+			visible = append(visible, info)
+		}
+	}
+	return visible
+}
+
+// uploadVisible indica se o destino target de um upload está neste Server e
+// aparece nas listagens. O acompanhamento é compartilhado com os pontos de
+// montagem, cada um vê somente os uploads do seu prefixo.
+func (s *Server) uploadVisible(target string) bool {
+	if s.mountPath != "" && !strings.HasPrefix(target, s.mountPath+"/") {
+		return false
+	}
+	filePath := s.localPath(target)
+	if s.checkAccess(filePath) != nil {
+		return false
+	}
+	return s.listable(path.Dir(filePath), path.Base(filePath), 0)
+}
+
+func writeUploadEvent(w io.Writer, evt uploadEvent) {
+	data, _ := json.Marshal(evt.data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.name, data)
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestUploadProgress(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer(dir, true, false, logrus.WithField("test", true), WithUploadIdleTimeout(500*time.Millisecond), WithUploadProgress(true))
+	defer s.Close()
+	ts := httptest.NewUnstartedServer(s)
+	ts.Config.ConnContext = ConnContext
+	ts.Start()
+	defer ts.Close()
+
+	// the events of the SSE stream
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+uploadsPath, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	finished := make(chan uploadInfo, 4)
+	go func() {
+		sc := bufio.NewScanner(stream.Body)
+		event := ""
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: ") && event == "finished":
+				var info uploadInfo
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &info)
+				finished <- info
+			}
+		}
+	}()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "fast.txt")
+	fw.Write([]byte("hello"))
+	mw.Close()
+	resp, err := http.Post(ts.URL+"/", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if info := waitFinished(t, finished); info.Status != uploadStatusCompleted || info.Received != 5 || info.Client != "127.0.0.1" || !strings.HasPrefix(info.Target, "/fast") {
+		t.Fatalf("completed upload reported %+v", info)
+	}
+
+	// an upload that stops sending is listed until the idle timeout aborts it
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	mw = multipart.NewWriter(pw)
+	result := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(ts.URL+"/", mw.FormDataContentType(), pr)
+		if err != nil {
+			result <- 0
+			return
+		}
+		resp.Body.Close()
+		result <- resp.StatusCode
+	}()
+	fw, _ = mw.CreateFormFile("file", "slow.txt")
+	fw.Write(bytes.Repeat([]byte("x"), 64*1024))
+
+	var listed []uploadInfo
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		resp, err := http.Get(ts.URL + uploadsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list struct {
+			Uploads []uploadInfo `json:"uploads"`
+		}
+		json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if len(list.Uploads) == 1 && list.Uploads[0].Received > 0 {
+			listed = list.Uploads
+			break
+		}
+	}
+	if len(listed) != 1 || listed[0].Protocol != "http" || listed[0].Status != "" {
+		t.Fatalf("the active upload was listed as %+v", listed)
+	}
+
+	select {
+	case code := <-result:
+		if code != http.StatusRequestTimeout {
+			t.Fatalf("the stalled upload returned %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stalled upload was not aborted")
+	}
+	if info := waitFinished(t, finished); info.Status != uploadStatusAborted || info.Error != ErrUploadIdle.Error() {
+		t.Fatalf("aborted upload reported %+v", info)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), "slow") {
+			t.Fatalf("the aborted upload left %s", filepath.Join(dir, fi.Name()))
+		}
+	}
+}
+
+func waitFinished(t *testing.T, finished <-chan uploadInfo) uploadInfo {
+	select {
+	case info := <-finished:
+		return info
+	case <-time.After(5 * time.Second):
+		t.Fatal("no finished event")
+	}
+	return uploadInfo{}
+}
+
+func TestUploadTrackerSample(t *testing.T) {
+	tracker := newUploadTracker(0, logrus.WithField("test", true))
+	defer tracker.Close()
+
+	u := tracker.start(&uploadSource{protocol: "resumable", client: "192.0.2.1", size: 3000, received: 1000}, "/docs/big.iso")
+	io.Copy(ioutil.Discard, u.reader(strings.NewReader(strings.Repeat("x", 1000))))
+
+	list := tracker.sample(u.started.Add(time.Second))
+	if len(list) != 1 || list[0].Received != 2000 || list[0].Rate != 1000 || list[0].ETA == nil || *list[0].ETA != 1 {
+		t.Fatalf("sample returned %+v", list)
+	}
+	// a second without data lowers the rate gradually
+	list = tracker.sample(u.started.Add(2 * time.Second))
+	if list[0].Rate != 700 {
+		t.Fatalf("rate after an idle second is %v", list[0].Rate)
+	}
+
+	u.finish(nil)
+	if list := tracker.list(); len(list) != 0 {
+		t.Fatalf("finished upload is still listed: %+v", list)
+	}
+}
+
+func TestUploadsHandlerVisibility(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer(dir, true, false, logrus.WithField("test", true), WithUploadProgress(true), WithAccessPolicy(SymlinksFollowWithinRoot, HiddenHide, []string{"*.key"}))
+	defer s.Close()
+
+	for _, target := range []string{"/docs/report.pdf", "/.secret/notes.txt", "/server.key"} {
+		u := s.uploads.start(&uploadSource{protocol: "http", client: "192.0.2.1", size: -1}, target)
+		defer u.finish(nil)
+	}
+	rr := serveTest(s, http.MethodGet, uploadsPath, "", nil)
+	var body struct {
+		Uploads []uploadInfo `json:"uploads"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Uploads) != 1 || body.Uploads[0].Target != "/docs/report.pdf" {
+		t.Fatalf("uploads returned %+v", body.Uploads)
+	}
+
+	for _, s := range []*Server{
+		NewServer(dir, true, false, logrus.WithField("test", true)),
+		NewServer(dir, true, false, logrus.WithField("test", true), WithUploadProgress(true), WithUploads(false)),
+		NewServer(dir, true, true, logrus.WithField("test", true), WithUploadProgress(true)),
+	} {
+		if rr := serveTest(s, http.MethodGet, uploadsPath, "", nil); rr.Code != http.StatusNotFound {
+			t.Fatalf("uploads endpoint returned %d, want 404", rr.Code)
+		}
+		s.Close()
+	}
+}
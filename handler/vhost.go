@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// VirtualHosts escolhe o site pelo header Host da requisição, permitindo
+// servir vários sites, cada um com sua raiz e opções, em uma única porta.
+// Requisições que não correspondem a nenhum padrão usam o site padrão.
+type VirtualHosts struct {
+	defaultSite Handler
+	exact       map[string]Handler
+	wildcards   []virtualHost
+	servers     []Handler
+}
+
+type virtualHost struct {
+	// suffix é o padrão sem o '*' inicial (ex: '.example.com')
+	suffix string
+	server Handler
+}
+
+func NewVirtualHosts(defaultSite Handler) *VirtualHosts {
+	return &VirtualHosts{
+		defaultSite: defaultSite,
+		exact:       make(map[string]Handler),
+		servers:     []Handler{defaultSite},
+	}
+}
+
+// Add registra s para os hosts que correspondem a pattern. O padrão pode ser
+// um host exato ('example.com') ou um wildcard ('*.example.com'), que
+// corresponde a qualquer subdomínio. Padrões exatos têm prioridade e, entre os
+// wildcards, o mais específico é usado.
+func (vh *VirtualHosts) Add(pattern string, s Handler) error {
+	pattern = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), ".")
+	switch {
+	case pattern == "" || pattern == "*":
+		return fmt.Errorf("%w: %q, use the default site for any host", ErrVirtualHost, pattern)
+	case strings.HasPrefix(pattern, "*."):
+		suffix := pattern[1:]
+		if strings.Contains(suffix, "*") {
+			return fmt.Errorf("%w: %q, only a leading '*.' is supported", ErrVirtualHost, pattern)
+		}
+		for _, wc := range vh.wildcards {
+			if wc.suffix == suffix {
+				return fmt.Errorf("%w: duplicate host %q", ErrVirtualHost, pattern)
+			}
+		}
+		vh.wildcards = append(vh.wildcards, virtualHost{suffix, s})
+		// longest suffixes first, so '*.a.example.com' wins over '*.example.com'
+		sort.SliceStable(vh.wildcards, func(i, j int) bool {
+			return len(vh.wildcards[i].suffix) > len(vh.wildcards[j].suffix)
+		})
+	case strings.Contains(pattern, "*"):
+		return fmt.Errorf("%w: %q, only a leading '*.' is supported", ErrVirtualHost, pattern)
+	default:
+		if _, ok := vh.exact[pattern]; ok {
+			return fmt.Errorf("%w: duplicate host %q", ErrVirtualHost, pattern)
+		}
+		vh.exact[pattern] = s
+	}
+
+	for _, registered := range vh.servers {
+		if registered == s {
+			return nil
+		}
+	}
+	vh.servers = append(vh.servers, s)
+	return nil
+}
+
+// Lookup retorna o site responsável pelo host (com ou sem porta).
+func (vh *VirtualHosts) Lookup(host string) Handler {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	if s, ok := vh.exact[host]; ok {
+		return s
+	}
+	for _, wc := range vh.wildcards {
+		if strings.HasSuffix(host, wc.suffix) {
+			return wc.server
+		}
+	}
+	return vh.defaultSite
+}
+
+func (vh *VirtualHosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vh.Lookup(r.Host).ServeHTTP(w, r)
+}
+
+// Close libera os recursos de todos os sites.
+func (vh *VirtualHosts) Close() error {
+	for _, s := range vh.servers {
+		s.Close()
+	}
+	return nil
+}
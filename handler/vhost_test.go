@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestVirtualHostsLookup(t *testing.T) {
+	logger := logrus.WithField("test", true)
+	defaultSite := NewServer(".", false, false, logger)
+	app := NewServer(".", false, true, logger)
+	docs := NewServer(".", false, false, logger)
+	api := NewServer(".", false, false, logger)
+
+	vh := NewVirtualHosts(defaultSite)
+	for pattern, s := range map[string]*Server{
+		"app.example.com":     app,
+		"*.example.com":       docs,
+		"*.api.example.com":   api,
+		"Static.Example.COM.": app,
+	} {
+		if err := vh.Add(pattern, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		host string
+		want *Server
+	}{
+		{"app.example.com", app},
+		{"APP.example.com:8000", app},
+		{"static.example.com", app},
+		{"docs.example.com", docs},
+		{"a.b.example.com", docs},
+		{"v1.api.example.com", api},
+		{"example.com", defaultSite},
+		{"other.org", defaultSite},
+		{"", defaultSite},
+	}
+	for _, tt := range tests {
+		if got := vh.Lookup(tt.host); got != tt.want {
+			t.Fatalf("%q: wrong site", tt.host)
+		}
+	}
+
+	for _, pattern := range []string{"", "*", "app.example.com", "*.example.com", "a.*.com", "*.*.com"} {
+		if err := vh.Add(pattern, docs); !errors.Is(err, ErrVirtualHost) {
+			t.Fatalf("%q: expected ErrVirtualHost, got %v", pattern, err)
+		}
+	}
+}
+
+func TestVirtualHostsServeHTTP(t *testing.T) {
+	logger := logrus.WithField("test", true)
+	var roots []string
+	for _, name := range []string{"default", "site"} {
+		roots = append(roots, writeTestFiles(t, map[string]string{"name.txt": name}))
+	}
+
+	vh := NewVirtualHosts(NewServer(roots[0], false, false, logger))
+	site := NewServer(roots[1], false, false, logger, WithUploads(false), WithBasicAuth([]string{"admin:secret"}))
+	if err := vh.Add("site.example.com", site); err != nil {
+		t.Fatal(err)
+	}
+	defer vh.Close()
+
+	tests := []struct {
+		method string
+		host   string
+		auth   bool
+		status int
+		body   string
+	}{
+		{http.MethodGet, "other.example.com", false, http.StatusOK, "default"},
+		{http.MethodGet, "site.example.com", false, http.StatusUnauthorized, ""},
+		{http.MethodGet, "site.example.com", true, http.StatusOK, "site"},
+		{http.MethodPost, "site.example.com", true, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "/name.txt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = tt.host
+		if tt.auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		rr := httptest.NewRecorder()
+		vh.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tt.status {
+			t.Fatalf("%s %s: handler returned wrong status code: got %v want %v", tt.method, tt.host, status, tt.status)
+		}
+		if tt.body != "" && rr.Body.String() != tt.body {
+			t.Fatalf("%s %s: handler returned wrong body: got %v want %v", tt.method, tt.host, rr.Body.String(), tt.body)
+		}
+	}
+}
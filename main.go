@@ -9,15 +9,22 @@ package main
 */
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/guilhermerodrigues680/gouploadserver/app"
+	"github.com/guilhermerodrigues680/gouploadserver/cli"
+	"github.com/guilhermerodrigues680/gouploadserver/handler"
+	"github.com/guilhermerodrigues680/gouploadserver/pack"
+	"github.com/guilhermerodrigues680/gouploadserver/storage"
 
 	"github.com/sirupsen/logrus"
 )
@@ -30,14 +37,110 @@ var devFlag = flag.Bool("dev", false, "Use development settings")
 var keepOriginalUploadFileNameFlag = flag.Bool("keep-upload-filename", false, "Keep original upload file name: Use 'filename.ext' instead of 'filename<-random>.ext'")
 var showVersionFlag = flag.Bool("version", false, "Show version number and quit")
 var spaFlag = flag.Bool("spa", false, "Return to all files not found /index.html")
+var liveReloadFlag = flag.Bool("live-reload", false, "Reload browsers when files in [path] change")
+var ruleFilesFlag = flag.Bool("rule-files", false, "Apply Netlify-style _redirects and _headers files from the root of [path]")
+var precompressedFlag = flag.Bool("precompressed", false, "Serve sibling .br or .gz files when the client accepts the encoding")
+var compressFlag = flag.Bool("compress", false, "Compress compressible responses on the fly with brotli or gzip")
+var compressMinSizeFlag = flag.Int64("compress-min-size", 1024, "Minimum response size in bytes for on the fly compression")
+var compressCacheSizeFlag = flag.Int64("compress-cache-size", 32, "Size in MiB of the in-memory cache of compressed files (0 disables)")
+var spaEnvPrefixFlag = flag.String("spa-env-prefix", "", "Comma separated env var prefixes injected as window.__ENV__ in the SPA index.html (ex: VUE_APP_)")
+var spaEnvFileFlag = flag.String("spa-env-file", "", "JSON file with values injected as window.__ENV__ in the SPA index.html")
+var spaEnvPlaceholdersFlag = flag.Bool("spa-env-placeholders", false, "Replace %%NAME%% (HTML text) and %%json:NAME%% (scripts) placeholders in the SPA index.html with window.__ENV__ values")
+var templateDirFlag = flag.String("template-dir", "", "Directory with templates overriding the file browser pages (list.html, error.html, upload.html, layout.html, assets/)")
+var listPageSizeFlag = flag.Int("list-page-size", 1000, "Directory listing entries per page (0 shows all)")
+var searchFlag = flag.Bool("search", false, "Enable the recursive file name and content search")
+var searchIndexFlag = flag.Bool("search-index", false, "Keep an in-memory index of [path] to speed up searches")
+var indexFilesFlag = flag.String("index-files", "", "Comma separated index files served instead of the directory listing (ex: index.html,index.htm)")
+var cleanURLsFlag = flag.Bool("clean-urls", false, "Serve /about with about.html when /about does not exist")
+var notFoundPageFlag = flag.String("not-found-page", "", "File in [path] sent with 404 responses when it exists, ex: 404.html (empty disables)")
+var thumbnailsFlag = flag.Bool("thumbnails", false, "Generate JPEG, PNG and GIF thumbnails (?thumb=256) and enable the gallery view")
+var thumbnailWorkersFlag = flag.Int("thumbnail-workers", runtime.NumCPU(), "Maximum number of images decoded at the same time")
+var stateDirFlag = flag.String("state-dir", "", "Directory for server state like the thumbnail cache (defaults to the user cache dir)")
+var cachePolicyFlag = flag.Bool("cache-policy", true, "Send Cache-Control headers: immutable for hashed assets and no-cache for HTML")
+var uploadFlag = flag.Bool("upload", true, "Allow file uploads in the file browser")
+var uploadIdleTimeoutFlag = flag.Duration("upload-idle-timeout", 5*time.Minute, "Abort uploads that receive no data for this long (0 disables)")
+var uploadProgressFlag = flag.Bool("upload-progress", false, "List the uploads in progress at /_gouploadserver/uploads (needs --upload, not in SPA mode)")
+var maxResumableUploadSizeFlag = flag.Int64("max-resumable-upload-size", 64<<30, "Maximum size in bytes of a resumable upload (Upload-Length)")
+var maxTransfersFlag = flag.Int("max-transfers-per-client", 0, "Maximum simultaneous downloads and uploads of each client IP (0 disables)")
+var transferQueueTimeoutFlag = flag.Duration("transfer-queue-timeout", 0, "How long a transfer over --max-transfers-per-client waits for a slot before the 429 response")
+var aclFileFlag = flag.String("acl-file", "", "Access list file with one 'allow|deny cidrs [methods] [path]' rule per line, reloaded when changed")
+var forceHTTPSFlag = flag.Bool("force-https", false, "Redirect http requests to https, behind a proxy the scheme comes from X-Forwarded-Proto of a --trusted-proxy")
+var rateLimitFlag = flag.Int("rate-limit", 0, "Maximum requests of each client IP per --rate-limit-window (0 disables)")
+var rateLimitWindowFlag = flag.Duration("rate-limit-window", time.Minute, "Window of --rate-limit")
+var authLockoutFailuresFlag = flag.Int("auth-lockout-failures", 5, "Lock out a client IP after this many failed logins (0 disables)")
+var authLockoutFlag = flag.Duration("auth-lockout", time.Minute, "First lockout duration, doubled on each new lockout of the same IP")
+var symlinksFlag = flag.String("symlinks", handler.SymlinksFollowWithinRoot, "Symlink policy: follow, follow-within-root or deny")
+var hiddenFlag = flag.String("hidden", handler.HiddenShow, "Hidden files (dotfiles) policy: show, hide from listings or deny")
+var vhostsFlag = flag.String("vhosts", "", "JSON file mapping Host patterns to sites with their own root and options")
+var s3PortFlag = flag.Int("s3-port", 0, "Port of the S3-compatible API over [path], buckets are its top-level directories (0 disables)")
+var sftpPortFlag = flag.Int("sftp-port", 0, "Port of the SFTP server over [path], users are the --auth credentials (0 disables)")
+var sftpHostKeyFlag = flag.String("sftp-host-key", "", "SFTP host private key, created when missing (defaults to sftp_host_key in --state-dir)")
+var sftpAuthorizedKeysFlag = flag.String("sftp-authorized-keys", "", "OpenSSH authorized_keys file with public keys allowed to log in over SFTP")
+var sftpAnonymousFlag = flag.Bool("sftp-anonymous", false, "Allow SFTP logins without password or key when there is no --auth or --sftp-authorized-keys")
+var cacheRuleFlag listFlag
+var authFlag listFlag
+var mountFlag listFlag
+var excludeFlag listFlag
+var s3KeyFlag listFlag
+var bandwidthLimitFlag listFlag
+var trustedProxyFlag listFlag
+var aclFlag listFlag
 var pathArg string
 
+func init() {
+	flag.Var(&cacheRuleFlag, "cache-rule", "Cache-Control rule 'pattern=value', pattern is a glob or a regex starting with '~' (repeatable)")
+	flag.Var(&authFlag, "auth", "Require HTTP Basic Authentication with the credential 'user:password' (repeatable)")
+	flag.Var(&excludeFlag, "exclude", "Glob of paths never served, listed or accepted as upload target, ex: '.git/**' or '*.key' (repeatable)")
+	flag.Var(&s3KeyFlag, "s3-key", "Access key 'ACCESS_KEY:SECRET_KEY' accepted by the S3-compatible API, without keys access is anonymous (repeatable)")
+	flag.Var(&bandwidthLimitFlag, "bandwidth-limit", "Bandwidth limit 'download|upload[/global|ip|user]=rate', ex: 'download/ip=2MiB' (repeatable)")
+	flag.Var(&trustedProxyFlag, "trusted-proxy", "CIDR or IP of a reverse proxy whose Forwarded and X-Forwarded-* headers are trusted, ex: '10.0.0.0/8' (repeatable)")
+	flag.Var(&aclFlag, "acl", "Access list rule 'allow|deny cidrs [methods] [path]', ex: 'allow 10.0.0.0/8 write /uploads/**', checked before --acl-file (repeatable)")
+	flag.Var(&mountFlag, "mount", "Serve a directory under an URL prefix '/prefix=/path[:ro|rw|spa]' (repeatable)")
+}
+
+// listFlag é uma flag que pode ser informada várias vezes.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func main() {
+	// the subcommands are clients of a running server
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		code := cli.Run(ctx, os.Args[1], os.Args[2:], os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
+	}
+
+	// a packed binary serves the embedded directory, its config sets the flag defaults
+	var packed *storage.FS
+	if pack.Embedded() {
+		site, cfg, err := pack.Load()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		setPackDefaults(cfg)
+		packed = storage.NewFS(site, cfg.BuiltAt)
+	}
+
 	// usage: flag -h or --help
 	flag.Usage = func() {
 		fmt.Fprintln(flag.CommandLine.Output(), "")
-		fmt.Fprintln(flag.CommandLine.Output(), "Usage: gouploadserver [options] [path]")
-		fmt.Fprintln(flag.CommandLine.Output(), "[path] defaults to ./")
+		if packed != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), "Usage: gouploadserver [options] (serves the embedded directory, read-only)")
+		} else {
+			fmt.Fprintln(flag.CommandLine.Output(), "Usage: gouploadserver [options] [path]")
+		}
+		fmt.Fprintf(flag.CommandLine.Output(), "       gouploadserver <%s> [options] ... (see gouploadserver <command> --help)\n", strings.Join(cli.Commands(), "|"))
+		fmt.Fprintln(flag.CommandLine.Output(), "[path] defaults to ./, it may also be a storage URI: memory: or s3://bucket/prefix?endpoint=URL&region=REGION&path-style=true")
 		fmt.Fprintln(flag.CommandLine.Output(), "Options are:")
 		flag.VisitAll(func(f *flag.Flag) {
 			fmt.Fprintf(flag.CommandLine.Output(), "  --%-24v %v (default %v)\n", f.Name, f.Usage, f.DefValue)
@@ -48,14 +151,6 @@ func main() {
 		fmt.Fprintln(flag.CommandLine.Output(), "Powered By: guilhermerodrigues680")
 	}
 
-	//FIXME
-	for _, e := range os.Environ() {
-		// pair := strings.SplitN(e, "=", 2)
-		// fmt.Println(pair[0])
-		fmt.Println(e)
-	}
-	//FIXME
-
 	// parses the command-line flags
 	flag.Parse()
 
@@ -112,12 +207,118 @@ func main() {
 		port = *portEnv
 	}
 
-	err := app.Run(wd, port, *keepOriginalUploadFileNameFlag, *spaFlag, logger.WithField("app", "run"))
+	stateDir := *stateDirFlag
+	if stateDir == "" {
+		stateDir = defaultStateDir()
+	}
+
+	sftpHostKey := *sftpHostKeyFlag
+	if sftpHostKey == "" {
+		sftpHostKey = filepath.Join(stateDir, "sftp_host_key")
+	}
+
+	mounts, err := app.ParseMounts(mountFlag)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	var sites []app.Site
+	if *vhostsFlag != "" {
+		sites, err = app.LoadSites(*vhostsFlag)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	listeners := app.Listeners{
+		S3:   app.S3Config{Port: *s3PortFlag, Keys: s3KeyFlag},
+		SFTP: app.SFTPConfig{Port: *sftpPortFlag, HostKeyFile: sftpHostKey, AuthorizedKeysFile: *sftpAuthorizedKeysFlag, Anonymous: *sftpAnonymousFlag},
+	}
+
+	opts := []handler.Option{
+		handler.WithUploads(*uploadFlag),
+		handler.WithUploadIdleTimeout(*uploadIdleTimeoutFlag),
+		handler.WithMaxResumableUploadSize(*maxResumableUploadSizeFlag),
+		handler.WithUploadProgress(*uploadProgressFlag),
+		handler.WithBandwidthLimits(bandwidthLimitFlag),
+		handler.WithTransferLimit(*maxTransfersFlag, *transferQueueTimeoutFlag),
+		handler.WithTrustedProxies(trustedProxyFlag),
+		handler.WithForceHTTPS(*forceHTTPSFlag),
+		handler.WithAccessList(aclFlag, *aclFileFlag),
+		handler.WithRateLimit(*rateLimitFlag, *rateLimitWindowFlag, *authLockoutFailuresFlag, *authLockoutFlag),
+		handler.WithBasicAuth(authFlag),
+		handler.WithAccessPolicy(*symlinksFlag, *hiddenFlag, excludeFlag),
+		handler.WithLiveReload(*liveReloadFlag),
+		handler.WithRuleFiles(*ruleFilesFlag),
+		handler.WithCompression(*precompressedFlag, *compressFlag, *compressMinSizeFlag, *compressCacheSizeFlag*1024*1024),
+		handler.WithCachePolicy(*cachePolicyFlag, cacheRuleFlag),
+		handler.WithListPageSize(*listPageSizeFlag),
+		handler.WithSearch(*searchFlag, *searchIndexFlag),
+		handler.WithIndexFiles(splitList(*indexFilesFlag)),
+		handler.WithCleanURLs(*cleanURLsFlag),
+		handler.WithNotFoundPage(*notFoundPageFlag),
+		handler.WithThumbnails(*thumbnailsFlag, filepath.Join(stateDir, "thumbnails"), *thumbnailWorkersFlag),
+		handler.WithTemplates(*templateDirFlag, *devFlag),
+		handler.WithSpaEnv(splitList(*spaEnvPrefixFlag), *spaEnvFileFlag, *spaEnvPlaceholdersFlag),
+	}
+	if packed != nil {
+		// the options are shared by the mounts and sites, which have their own roots
+		if len(mounts) > 0 || len(sites) > 0 {
+			logger.Fatal("--mount and --vhosts are not supported when serving the embedded directory")
+		}
+		wd = "/"
+		opts = append(opts, handler.WithUploads(false), handler.WithStorage(packed))
+		logger.Info("Serving the embedded directory")
+	}
+
+	err = app.RunWithSites(wd, port, *keepOriginalUploadFileNameFlag, *spaFlag, mounts, sites, listeners, logger.WithField("app", "run"), opts...)
 	if err != nil {
 		logger.Fatal(err)
 	}
 }
 
+// defaultStateDir usa o diretório de cache do usuário (ex: '~/.cache/gouploadserver')
+// e, se não estiver disponível, o diretório temporário.
+func defaultStateDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "gouploadserver")
+}
+
+// setPackDefaults troca os valores padrão das flags pelos da configuração do
+// diretório embutido, que ainda podem ser alterados na linha de comando.
+func setPackDefaults(cfg pack.Config) {
+	defaults := map[string]string{
+		"spa":           strconv.FormatBool(cfg.SPA),
+		"precompressed": strconv.FormatBool(cfg.Precompressed),
+		"cache-policy":  strconv.FormatBool(cfg.CachePolicy),
+		"rule-files":    strconv.FormatBool(cfg.RuleFiles),
+		"index-files":   strings.Join(cfg.IndexFiles, ","),
+		"clean-urls":    strconv.FormatBool(cfg.CleanURLs),
+		"upload":        "false",
+	}
+	for name, value := range defaults {
+		flag.Set(name, value)
+		flag.Lookup(name).DefValue = value
+	}
+	for _, rule := range cfg.CacheRules {
+		flag.Set("cache-rule", rule)
+	}
+}
+
+// splitList separa uma flag com valores separados por virgula, ignorando itens vazios.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func getLogger(development bool) *logrus.Logger {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.TextFormatter{
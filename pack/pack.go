@@ -0,0 +1,75 @@
+// Package pack guarda o diretório embutido no executável com embed.FS.
+//
+// O cmd/go-pre-compile copia o diretório para pack/site e gera o arquivo com a
+// diretiva //go:embed, compilado somente com a build tag 'pack':
+//
+//	go run ./cmd/go-pre-compile --spa test/spa/dist
+//	go build -tags pack -o bin/site ./main.go
+//	go run ./cmd/go-post-compile
+//
+// O cmd/pack executa os três passos e remove a cópia mesmo quando a
+// compilação falha:
+//
+//	go run ./cmd/pack test/spa/dist --spa -o bin/site
+package pack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+const (
+	// SiteDir é o diretório, dentro do pack, com a cópia do conteúdo embutido
+	SiteDir = "site"
+	// ConfigFile guarda a Config gerada pelo go-pre-compile
+	ConfigFile = "pack.json"
+	// GeneratedFile declara as variáveis embutidas com a build tag 'pack'
+	GeneratedFile = "zz_embed.go"
+)
+
+var ErrNotPacked = errors.New("No embedded content, build with the 'pack' tag after go-pre-compile")
+
+// Config são os valores padrão das flags do servidor para o conteúdo
+// embutido. As flags da linha de comando continuam tendo precedência.
+type Config struct {
+	SPA           bool     `json:"spa,omitempty"`
+	Precompressed bool     `json:"precompressed,omitempty"`
+	CachePolicy   bool     `json:"cachePolicy"`
+	CacheRules    []string `json:"cacheRules,omitempty"`
+	RuleFiles     bool     `json:"ruleFiles,omitempty"`
+	IndexFiles    []string `json:"indexFiles,omitempty"`
+	CleanURLs     bool     `json:"cleanURLs,omitempty"`
+	// BuiltAt é a data de modificação de todos os arquivos, o embed.FS não guarda
+	// as datas. É a data do arquivo mais recente do diretório embutido
+	BuiltAt time.Time `json:"builtAt"`
+}
+
+// content e config são preenchidos pelo GeneratedFile.
+var (
+	content fs.FS
+	config  []byte
+)
+
+// Embedded indica se o executável foi compilado com um diretório embutido.
+func Embedded() bool {
+	return content != nil
+}
+
+// Load retorna o conteúdo embutido, com a raiz no diretório copiado, e a Config.
+func Load() (fs.FS, Config, error) {
+	var cfg Config
+	if !Embedded() {
+		return nil, cfg, ErrNotPacked
+	}
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return nil, cfg, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	site, err := fs.Sub(content, SiteDir)
+	if err != nil {
+		return nil, cfg, err
+	}
+	return site, cfg, nil
+}
@@ -0,0 +1,203 @@
+package sigv4
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxChunkSize limita o tamanho declarado de um chunk do 'aws-chunked'
+const maxChunkSize = 1 << 30
+
+// Body retorna o corpo da requisição decodificado e verificado de acordo com o
+// X-Amz-Content-Sha256: o sha256 do corpo, UNSIGNED-PAYLOAD ou 'aws-chunked'.
+// Sem sig (requisições anônimas) os chunks são somente decodificados. Os erros
+// de verificação são retornados pelo Read, no máximo no fim do corpo, assim quem
+// grava o corpo deve descartar o resultado quando o Read falhar.
+func Body(req *http.Request, sig *Signature) io.Reader {
+	switch payloadHash := req.Header.Get("X-Amz-Content-Sha256"); payloadHash {
+	case StreamingPayload, StreamingPayloadTrailer:
+		c := &chunkedReader{r: bufio.NewReader(req.Body), sig: sig}
+		if sig != nil {
+			c.prev = sig.value
+		}
+		return c
+	case StreamingUnsignedPayloadTrailer:
+		return &chunkedReader{r: bufio.NewReader(req.Body)}
+	case "", UnsignedPayload:
+		return req.Body
+	default:
+		return &hashReader{r: req.Body, h: sha256.New(), want: strings.ToLower(payloadHash)}
+	}
+}
+
+// hashReader compara o sha256 do corpo com o valor assinado no fim da leitura.
+type hashReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want string
+}
+
+func (hr *hashReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(hr.h.Sum(nil)) != hr.want {
+		return n, ErrPayload
+	}
+	return n, err
+}
+
+// chunkedReader decodifica o 'aws-chunked': '<tamanho hex>[;chunk-signature=<sig>]\r\n<dados>\r\n',
+// terminado por um chunk vazio e, opcionalmente, por trailers (ex: 'x-amz-checksum-crc32').
+// Com sig, a assinatura de cada chunk é verificada, encadeada a partir da assinatura da requisição.
+type chunkedReader struct {
+	r   *bufio.Reader
+	sig *Signature
+
+	prev      string
+	chunkSig  string
+	chunkHash hash.Hash
+	remaining int64
+	inChunk   bool
+	err       error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	for c.remaining == 0 {
+		if c.inChunk {
+			if err := c.endChunk(); err != nil {
+				c.err = err
+				return 0, err
+			}
+		}
+		size, err := c.startChunk()
+		if err != nil {
+			c.err = err
+			return 0, err
+		}
+		if size == 0 {
+			// the final chunk is signed like the others, then the trailers follow
+			if err := c.verifyChunk(); err != nil {
+				c.err = err
+				return 0, err
+			}
+			c.err = c.skipTrailers()
+			if c.err == nil {
+				c.err = io.EOF
+			}
+			return 0, c.err
+		}
+		c.remaining, c.inChunk = size, true
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.chunkHash != nil {
+		c.chunkHash.Write(p[:n])
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// startChunk lê o cabeçalho do chunk e retorna o tamanho dos dados.
+func (c *chunkedReader) startChunk() (int64, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return 0, err
+	}
+	sizeHex, ext := line, ""
+	if i := strings.Index(line, ";"); i >= 0 {
+		sizeHex, ext = line[:i], line[i+1:]
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return 0, fmt.Errorf("%w: invalid chunk size %q", ErrPayload, sizeHex)
+	}
+
+	c.chunkSig = ""
+	if strings.HasPrefix(ext, "chunk-signature=") {
+		c.chunkSig = strings.TrimPrefix(ext, "chunk-signature=")
+	}
+	c.chunkHash = nil
+	if c.sig != nil {
+		if c.chunkSig == "" {
+			return 0, fmt.Errorf("%w: missing chunk signature", ErrPayload)
+		}
+		c.chunkHash = sha256.New()
+	}
+	return size, nil
+}
+
+// endChunk lê o '\r\n' depois dos dados e verifica a assinatura do chunk.
+func (c *chunkedReader) endChunk() error {
+	var crlf [2]byte
+	if _, err := io.ReadFull(c.r, crlf[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if crlf != [2]byte{'\r', '\n'} {
+		return fmt.Errorf("%w: malformed chunk", ErrPayload)
+	}
+	c.inChunk = false
+	return c.verifyChunk()
+}
+
+func (c *chunkedReader) verifyChunk() error {
+	if c.sig == nil {
+		return nil
+	}
+	// the final chunk is empty, its hash is the sha256 of nothing
+	chunkHash := hex.EncodeToString(c.chunkHash.Sum(nil))
+	toSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + c.sig.amzDate + "\n" + c.sig.scope + "\n" +
+		c.prev + "\n" + EmptyPayload + "\n" + chunkHash
+	expected := hex.EncodeToString(hmacSHA256(c.sig.key, toSign))
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSig)) {
+		return fmt.Errorf("%w: chunk signature does not match", ErrPayload)
+	}
+	c.prev = c.chunkSig
+	return nil
+}
+
+// skipTrailers descarta os trailers depois do último chunk até a linha vazia.
+func (c *chunkedReader) skipTrailers() error {
+	for {
+		line, err := c.readLine()
+		if err == io.ErrUnexpectedEOF {
+			// some clients end the body right after the last chunk
+			return nil
+		}
+		if err != nil || line == "" {
+			return err
+		}
+	}
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("%w: chunk header too long", ErrPayload)
+	}
+	if err != nil {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(bytes.TrimRight(line, "\r\n")), nil
+}
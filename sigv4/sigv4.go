@@ -0,0 +1,265 @@
+// Package sigv4 implementa o AWS Signature Version 4 usado pelo S3: a
+// assinatura das requisições enviadas e a verificação das recebidas, incluindo
+// os corpos enviados em 'aws-chunked'.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingAuth = errors.New("Missing authentication")
+	ErrMalformed   = errors.New("Malformed authorization")
+	ErrAccessKey   = errors.New("Unknown access key")
+	ErrSignature   = errors.New("Signature does not match")
+	ErrExpired     = errors.New("Request expired or not yet valid")
+	ErrPayload     = errors.New("Payload does not match the signed content")
+)
+
+const (
+	Algorithm = "AWS4-HMAC-SHA256"
+	// EmptyPayload é o sha256 de um corpo vazio
+	EmptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	// Corpos 'aws-chunked', com a assinatura de cada chunk e, opcionalmente, trailers
+	StreamingPayload                = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingPayloadTrailer         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	StreamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	TimeFormat = "20060102T150405Z"
+	// MaxSkew é a diferença máxima aceita entre o relógio do cliente e o do servidor
+	MaxSkew = 15 * time.Minute
+	// maxPresignedExpires é a validade máxima de uma URL pré-assinada (7 dias)
+	maxPresignedExpires = 7 * 24 * 60 * 60
+)
+
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// Sign assina a requisição com o header Authorization. São assinados o Host
+// e todos os headers já presentes na requisição.
+func Sign(req *http.Request, cred Credentials, region string, service string, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if cred.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", cred.SessionToken)
+	}
+
+	names := []string{"host"}
+	for k := range req.Header {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+
+	date := amzDate[:8]
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonical := canonicalRequest(req, req.URL.Query(), names, payloadHash)
+	signature := hex.EncodeToString(hmacSHA256(signingKey(cred.SecretKey, date, region, service), stringToSign(amzDate, scope, canonical)))
+
+	req.Header.Set("Authorization", Algorithm+" Credential="+cred.AccessKey+"/"+scope+
+		", SignedHeaders="+strings.Join(names, ";")+", Signature="+signature)
+}
+
+// Signature é a assinatura verificada de uma requisição, usada por Body para
+// verificar as assinaturas dos chunks do corpo.
+type Signature struct {
+	AccessKey string
+	amzDate   string
+	scope     string
+	key       []byte
+	value     string
+}
+
+// Verify verifica a assinatura feita no header Authorization ou na query string
+// (URL pré-assinada). secretKey retorna o segredo da chave de acesso.
+func Verify(req *http.Request, secretKey func(accessKey string) (string, bool), now time.Time) (*Signature, error) {
+	query := req.URL.Query()
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	presigned := false
+
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, Algorithm+" ") {
+			return nil, fmt.Errorf("%w: unsupported algorithm", ErrMalformed)
+		}
+		for _, field := range strings.Split(auth[len(Algorithm)+1:], ",") {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "Credential":
+				credential = kv[1]
+			case "SignedHeaders":
+				signedHeaders = kv[1]
+			case "Signature":
+				signature = kv[1]
+			}
+		}
+		amzDate = req.Header.Get("X-Amz-Date")
+		payloadHash = req.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return nil, fmt.Errorf("%w: missing X-Amz-Content-Sha256", ErrMalformed)
+		}
+	} else if algorithm := query.Get("X-Amz-Algorithm"); algorithm != "" {
+		if algorithm != Algorithm {
+			return nil, fmt.Errorf("%w: unsupported algorithm", ErrMalformed)
+		}
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature = query.Get("X-Amz-Signature")
+		amzDate = query.Get("X-Amz-Date")
+		payloadHash = UnsignedPayload
+		presigned = true
+		query.Del("X-Amz-Signature")
+	} else {
+		return nil, ErrMissingAuth
+	}
+
+	// Credential=<access key>/<date>/<region>/<service>/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || signature == "" {
+		return nil, fmt.Errorf("%w: invalid credential %q", ErrMalformed, credential)
+	}
+	accessKey, date, region, service := parts[0], parts[1], parts[2], parts[3]
+
+	t, err := time.Parse(TimeFormat, amzDate)
+	if err != nil || amzDate[:8] != date {
+		return nil, fmt.Errorf("%w: invalid date %q", ErrMalformed, amzDate)
+	}
+	if presigned {
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || expires > maxPresignedExpires {
+			return nil, fmt.Errorf("%w: invalid X-Amz-Expires", ErrMalformed)
+		}
+		if now.Before(t.Add(-MaxSkew)) || now.After(t.Add(time.Duration(expires)*time.Second)) {
+			return nil, ErrExpired
+		}
+	} else if now.Sub(t) > MaxSkew || t.Sub(now) > MaxSkew {
+		return nil, ErrExpired
+	}
+
+	names := strings.Split(signedHeaders, ";")
+	hasHost := false
+	for _, name := range names {
+		hasHost = hasHost || name == "host"
+	}
+	if !hasHost {
+		return nil, fmt.Errorf("%w: the host header must be signed", ErrMalformed)
+	}
+
+	secret, ok := secretKey(accessKey)
+	if !ok {
+		return nil, ErrAccessKey
+	}
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	key := signingKey(secret, date, region, service)
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign(amzDate, scope, canonicalRequest(req, query, names, payloadHash))))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, ErrSignature
+	}
+	return &Signature{AccessKey: accessKey, amzDate: amzDate, scope: scope, key: key, value: signature}, nil
+}
+
+// canonicalRequest monta a requisição canônica com os headers names, que devem estar ordenados.
+func canonicalRequest(req *http.Request, query url.Values, names []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range names {
+		var value string
+		switch name {
+		case "host":
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		case "content-length":
+			// the server moves Content-Length out of the headers
+			value = req.Header.Get("Content-Length")
+			if value == "" && req.ContentLength >= 0 {
+				value = strconv.FormatInt(req.ContentLength, 10)
+			}
+		default:
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		EncodePath(req.URL.Path),
+		EncodeQuery(query),
+		headers.String(),
+		strings.Join(names, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func stringToSign(amzDate string, scope string, canonicalRequest string) string {
+	return Algorithm + "\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+}
+
+func signingKey(secret string, date string, region string, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// EncodeQuery ordena e codifica a query string como exigido pela assinatura.
+func EncodeQuery(query url.Values) string {
+	var pairs []string
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// EncodePath codifica o caminho como exigido pela assinatura, mantendo as '/'.
+func EncodePath(p string) string {
+	return uriEncode(p, false)
+}
+
+// uriEncode codifica todos os bytes exceto os caracteres não reservados
+// (A-Z a-z 0-9 - _ . ~) e, quando encodeSlash é false, o '/'.
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
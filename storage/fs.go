@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var ErrReadOnly = errors.New("Read-only storage")
+
+// FS serve somente para leitura os arquivos de um fs.FS, como o embed.FS de um
+// executável gerado pelo pack. As gravações retornam ErrReadOnly.
+type FS struct {
+	fsys fs.FS
+	// modTime substitui as datas zeradas, o embed.FS não guarda as datas dos arquivos
+	modTime time.Time
+}
+
+func NewFS(fsys fs.FS, modTime time.Time) *FS {
+	return &FS{fsys: fsys, modTime: modTime}
+}
+
+func (s *FS) Stat(name string) (os.FileInfo, error) {
+	name = cleanName(name)
+	if name == "" {
+		name = "."
+	}
+	fi, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.info(fi), nil
+}
+
+func (s *FS) info(fi fs.FileInfo) os.FileInfo {
+	if !fi.ModTime().IsZero() {
+		return fi
+	}
+	name := fi.Name()
+	if name == "." {
+		name = "/"
+	}
+	return &fileInfo{name: name, size: fi.Size(), modTime: s.modTime, dir: fi.IsDir()}
+}
+
+func (s *FS) Open(name string) (File, error) {
+	name = cleanName(name)
+	if name == "" {
+		name = "."
+	}
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("open %s: is a directory", name)
+	}
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return &fsFile{ReadSeeker: rs, closer: f, info: s.info(fi)}, nil
+	}
+	// the files of the embed.FS support Seek, the others are read into memory
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &memoryReader{bytes.NewReader(data), &fileInfo{name: fi.Name(), size: int64(len(data)), modTime: s.info(fi).ModTime()}}, nil
+}
+
+func (s *FS) Create(name string) (io.WriteCloser, error) {
+	return nil, &os.PathError{Op: "create", Path: name, Err: ErrReadOnly}
+}
+
+func (s *FS) CreateExclusive(name string) (io.WriteCloser, error) {
+	return nil, &os.PathError{Op: "create", Path: name, Err: ErrReadOnly}
+}
+
+func (s *FS) Rename(oldname string, newname string) error {
+	return &os.PathError{Op: "rename", Path: oldname, Err: ErrReadOnly}
+}
+
+func (s *FS) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
+
+func (s *FS) MkdirAll(name string) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: ErrReadOnly}
+}
+
+func (s *FS) OpenDir(name string) (Dir, error) {
+	name = cleanName(name)
+	if name == "" {
+		name = "."
+	}
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	dir := &sliceDir{entries: make([]os.DirEntry, 0, len(entries))}
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		info := s.info(fi)
+		dir.entries = append(dir.entries, dirEntry{&fileInfo{name: info.Name(), size: info.Size(), modTime: info.ModTime(), dir: info.IsDir()}})
+	}
+	return dir, nil
+}
+
+// fsFile é um arquivo aberto do fs.FS com a data de modificação do FS.
+type fsFile struct {
+	io.ReadSeeker
+	closer io.Closer
+	info   os.FileInfo
+}
+
+func (f *fsFile) Stat() (os.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Close() error               { return f.closer.Close() }
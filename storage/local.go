@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local armazena os arquivos em um diretório do disco.
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// Root é o diretório local dos arquivos.
+func (l *Local) Root() string {
+	return l.root
+}
+
+// Path retorna o caminho local do arquivo name.
+func (l *Local) Path(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(cleanName(name)))
+}
+
+func (l *Local) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(l.Path(name))
+}
+
+func (l *Local) Open(name string) (File, error) {
+	f, err := os.Open(l.Path(name))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (l *Local) Create(name string) (io.WriteCloser, error) {
+	return os.Create(l.Path(name))
+}
+
+func (l *Local) CreateExclusive(name string) (io.WriteCloser, error) {
+	return os.OpenFile(l.Path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+}
+
+func (l *Local) Rename(oldname string, newname string) error {
+	return os.Rename(l.Path(oldname), l.Path(newname))
+}
+
+func (l *Local) Remove(name string) error {
+	return os.Remove(l.Path(name))
+}
+
+func (l *Local) MkdirAll(name string) error {
+	return os.MkdirAll(l.Path(name), 0755)
+}
+
+func (l *Local) OpenDir(name string) (Dir, error) {
+	f, err := os.Open(l.Path(name))
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s: not a directory", l.Path(name))
+	}
+	return f, nil
+}
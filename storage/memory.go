@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory armazena os arquivos em memória, útil em testes e para servidores
+// temporários. Os diretórios são criados implicitamente pelos arquivos.
+type Memory struct {
+	mu    sync.RWMutex
+	files map[string]*memoryFile
+	// dirs guarda a data de modificação dos diretórios, a raiz é ""
+	dirs map[string]time.Time
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		files: make(map[string]*memoryFile),
+		dirs:  map[string]time.Time{"": time.Now()},
+	}
+}
+
+func (m *Memory) Stat(name string) (os.FileInfo, error) {
+	name = cleanName(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.stat(name)
+}
+
+func (m *Memory) stat(name string) (*fileInfo, error) {
+	if f, ok := m.files[name]; ok {
+		return &fileInfo{name: path.Base(name), size: int64(len(f.data)), modTime: f.modTime}, nil
+	}
+	if modTime, ok := m.dirs[name]; ok {
+		return &fileInfo{name: path.Base("/" + name), modTime: modTime, dir: true}, nil
+	}
+	return nil, notExist("stat", name)
+}
+
+func (m *Memory) Open(name string) (File, error) {
+	name = cleanName(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	info, err := m.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.dir {
+		return nil, fmt.Errorf("open %s: is a directory", name)
+	}
+	// the data is never modified in place, Create replaces the slice
+	return &memoryReader{bytes.NewReader(m.files[name].data), info}, nil
+}
+
+func (m *Memory) Create(name string) (io.WriteCloser, error) {
+	name = cleanName(name)
+	m.mu.RLock()
+	_, isDir := m.dirs[name]
+	m.mu.RUnlock()
+	if isDir {
+		return nil, fmt.Errorf("create %s: is a directory", name)
+	}
+	return &memoryWriter{m: m, name: name}, nil
+}
+
+func (m *Memory) CreateExclusive(name string) (io.WriteCloser, error) {
+	name = cleanName(name)
+	m.mu.RLock()
+	_, err := m.stat(name)
+	m.mu.RUnlock()
+	if err == nil {
+		return nil, exist("create", name)
+	}
+	return &memoryWriter{m: m, name: name, exclusive: true}, nil
+}
+
+func (m *Memory) Rename(oldname string, newname string) error {
+	oldname, newname = cleanName(oldname), cleanName(newname)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[oldname]
+	if !ok {
+		return notExist("rename", oldname)
+	}
+	if _, isDir := m.dirs[newname]; isDir || m.parentIsFile(newname) {
+		return fmt.Errorf("rename %s: invalid destination %s", oldname, newname)
+	}
+	delete(m.files, oldname)
+	m.put(newname, f)
+	return nil
+}
+
+func (m *Memory) Remove(name string) error {
+	name = cleanName(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if _, ok := m.dirs[name]; !ok || name == "" {
+		return notExist("remove", name)
+	}
+	prefix := name + "/"
+	for p := range m.files {
+		if strings.HasPrefix(p, prefix) {
+			return fmt.Errorf("remove %s: directory not empty", name)
+		}
+	}
+	for p := range m.dirs {
+		if strings.HasPrefix(p, prefix) {
+			return fmt.Errorf("remove %s: directory not empty", name)
+		}
+	}
+	delete(m.dirs, name)
+	return nil
+}
+
+func (m *Memory) MkdirAll(name string) error {
+	name = cleanName(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, isFile := m.files[name]; isFile || m.parentIsFile(name) {
+		return fmt.Errorf("mkdir %s: not a directory", name)
+	}
+	now := time.Now()
+	for dir := name; dir != ""; dir = parentDir(dir) {
+		if _, ok := m.dirs[dir]; ok {
+			break
+		}
+		m.dirs[dir] = now
+	}
+	return nil
+}
+
+func (m *Memory) OpenDir(name string) (Dir, error) {
+	name = cleanName(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.dirs[name]; !ok {
+		if _, isFile := m.files[name]; isFile {
+			return nil, fmt.Errorf("open %s: not a directory", name)
+		}
+		return nil, notExist("open", name)
+	}
+
+	d := &sliceDir{}
+	for p := range m.files {
+		if parentDir(p) == name {
+			info, _ := m.stat(p)
+			d.entries = append(d.entries, dirEntry{info})
+		}
+	}
+	for p := range m.dirs {
+		if p != "" && parentDir(p) == name {
+			info, _ := m.stat(p)
+			d.entries = append(d.entries, dirEntry{info})
+		}
+	}
+	return d, nil
+}
+
+// put grava o arquivo e cria os diretórios do caminho. Deve ser chamado com o lock.
+func (m *Memory) put(name string, f *memoryFile) {
+	m.files[name] = f
+	for dir := parentDir(name); ; dir = parentDir(dir) {
+		m.dirs[dir] = f.modTime
+		if dir == "" {
+			break
+		}
+	}
+}
+
+// parentIsFile verifica se algum diretório do caminho já é um arquivo.
+func (m *Memory) parentIsFile(name string) bool {
+	for dir := parentDir(name); dir != ""; dir = parentDir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parentDir retorna o diretório do nome limpo, "" para a raiz.
+func parentDir(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+type memoryReader struct {
+	*bytes.Reader
+	info *fileInfo
+}
+
+func (r *memoryReader) Stat() (os.FileInfo, error) { return r.info, nil }
+func (r *memoryReader) Close() error               { return nil }
+
+// memoryWriter grava o conteúdo no Memory somente no Close.
+type memoryWriter struct {
+	bytes.Buffer
+	m      *Memory
+	name   string
+	closed bool
+	// exclusive falha no Close quando outro arquivo foi criado com o mesmo nome
+	exclusive bool
+}
+
+func (w *memoryWriter) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	if _, isDir := w.m.dirs[w.name]; isDir || w.m.parentIsFile(w.name) {
+		return fmt.Errorf("create %s: invalid path", w.name)
+	}
+	if _, ok := w.m.files[w.name]; ok && w.exclusive {
+		return exist("create", w.name)
+	}
+	w.m.put(w.name, &memoryFile{data: w.Bytes(), modTime: time.Now()})
+	return nil
+}
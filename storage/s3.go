@@ -0,0 +1,558 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/sigv4"
+)
+
+var ErrS3 = errors.New("S3 error")
+
+const s3ListPageSize = 1000
+
+// S3Config configura o acesso a um bucket compatível com o S3 (AWS, MinIO, ...).
+type S3Config struct {
+	// Endpoint é a URL do serviço (ex: 'https://s3.us-east-1.amazonaws.com' ou 'http://localhost:9000')
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix é o prefixo das chaves usado como raiz (ex: 'sites/docs')
+	Prefix string
+	// PathStyle usa URLs '/bucket/key' ao invés de 'bucket.endpoint/key', como no MinIO
+	PathStyle bool
+
+	// sem AccessKey as requisições são anônimas
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	Client *http.Client
+}
+
+// S3 armazena os arquivos em um bucket compatível com o S3. Os diretórios são
+// os prefixos das chaves separados por '/'.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("%w: missing bucket", ErrStorageURL)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("%w: invalid endpoint %q", ErrStorageURL, cfg.Endpoint)
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3{cfg: cfg, endpoint: endpoint, client: client}, nil
+}
+
+// newS3FromURL lê 's3://bucket/prefix?endpoint=...&region=...&path-style=true'.
+func newS3FromURL(uri string) (*S3, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrStorageURL, err)
+	}
+	q := u.Query()
+	cfg := S3Config{
+		Endpoint:     q.Get("endpoint"),
+		Region:       q.Get("region"),
+		Bucket:       u.Host,
+		Prefix:       u.Path,
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if v := q.Get("path-style"); v != "" {
+		if cfg.PathStyle, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("%w: invalid path-style %q", ErrStorageURL, v)
+		}
+	}
+	return NewS3(cfg)
+}
+
+// key retorna a chave do objeto name, "" é a raiz sem prefixo.
+func (s *S3) key(name string) string {
+	name = cleanName(name)
+	switch {
+	case s.cfg.Prefix == "":
+		return name
+	case name == "":
+		return s.cfg.Prefix
+	}
+	return s.cfg.Prefix + "/" + name
+}
+
+// dirPrefix retorna o prefixo das chaves dentro do diretório key.
+func dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func (s *S3) isRoot(key string) bool {
+	return key == s.cfg.Prefix
+}
+
+func (s *S3) Stat(name string) (os.FileInfo, error) {
+	key := s.key(name)
+	if s.isRoot(key) {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+
+	info, err := s.head(key)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return info, err
+	}
+
+	// directories only exist as prefixes of other keys
+	page, err := s.list(dirPrefix(key), "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Contents) == 0 && len(page.CommonPrefixes) == 0 {
+		return nil, notExist("stat", name)
+	}
+	return &fileInfo{name: path.Base(key), dir: true}, nil
+}
+
+func (s *S3) head(key string) (*fileInfo, error) {
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &fileInfo{name: path.Base(key), size: resp.ContentLength, modTime: modTime}, nil
+}
+
+func (s *S3) Open(name string) (File, error) {
+	key := s.key(name)
+	if s.isRoot(key) {
+		return nil, fmt.Errorf("open %s: is a directory", name)
+	}
+	info, err := s.head(key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3File{s3: s, key: key, info: info}, nil
+}
+
+func (s *S3) Create(name string) (io.WriteCloser, error) {
+	key := s.key(name)
+	if s.isRoot(key) {
+		return nil, fmt.Errorf("create %s: is a directory", name)
+	}
+	// PutObject needs the content length, so the upload is buffered on disk
+	tmp, err := ioutil.TempFile("", "gouploadserver-s3-*")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{s3: s, key: key, tmp: tmp}, nil
+}
+
+// CreateExclusive envia o objeto com 'If-None-Match: *', a escrita condicional
+// do S3, que falha no Close se outro objeto foi criado com a mesma chave.
+func (s *S3) CreateExclusive(name string) (io.WriteCloser, error) {
+	if _, err := s.head(s.key(name)); err == nil {
+		return nil, exist("create", name)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	w, err := s.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	w.(*s3Writer).exclusive = true
+	return w, nil
+}
+
+func (s *S3) Rename(oldname string, newname string) error {
+	oldkey, newkey := s.key(oldname), s.key(newname)
+	if _, err := s.head(oldkey); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", sigv4.EncodePath("/"+s.cfg.Bucket+"/"+oldkey))
+	resp, err := s.do(http.MethodPut, newkey, nil, header, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// CopyObject may fail after sending the 200 status, the error is in the body
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := parseS3Error(body); err != nil {
+		return fmt.Errorf("rename %s: %w", oldname, err)
+	}
+
+	return s.removeKey(oldkey)
+}
+
+func (s *S3) Remove(name string) error {
+	key := s.key(name)
+	fi, err := s.Stat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		// a directory only exists while it has keys, the marker created by
+		// MkdirAll is the only key of an empty directory
+		page, err := s.list(dirPrefix(key), "", 2)
+		if err != nil {
+			return err
+		}
+		if len(page.CommonPrefixes) > 0 || len(page.Contents) != 1 || page.Contents[0].Key != dirPrefix(key) {
+			return fmt.Errorf("remove %s: directory not empty", name)
+		}
+		return s.removeKey(dirPrefix(key))
+	}
+	return s.removeKey(key)
+}
+
+// MkdirAll grava o marcador de diretório vazio 'key/', o mesmo criado pelo
+// console da AWS. Os diretórios do caminho são implícitos.
+func (s *S3) MkdirAll(name string) error {
+	key := s.key(name)
+	if s.isRoot(key) {
+		return nil
+	}
+	fi, err := s.Stat(name)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("mkdir %s: not a directory", name)
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// keys may not continue a file key, like the directories on disk
+	for dir := parentDir(cleanName(name)); dir != ""; dir = parentDir(dir) {
+		if _, err := s.head(s.key(dir)); err == nil {
+			return fmt.Errorf("mkdir %s: not a directory", name)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	resp, err := s.do(http.MethodPut, dirPrefix(key), nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) removeKey(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil, -1)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) OpenDir(name string) (Dir, error) {
+	fi, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("open %s: not a directory", name)
+	}
+	return &s3Dir{s3: s, prefix: dirPrefix(s.key(name))}, nil
+}
+
+// listBucketResult é a resposta do ListObjectsV2.
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+func (s *S3) list(prefix string, token string, maxKeys int) (*listBucketResult, error) {
+	q := url.Values{}
+	q.Set("list-type", "2")
+	q.Set("delimiter", "/")
+	q.Set("prefix", prefix)
+	q.Set("max-keys", strconv.Itoa(maxKeys))
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+
+	resp, err := s.do(http.MethodGet, "", q, nil, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: list %s: %s", ErrS3, prefix, err)
+	}
+	return &result, nil
+}
+
+// s3Error é o corpo XML das respostas de erro.
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+func parseS3Error(body []byte) error {
+	var e s3Error
+	if xml.Unmarshal(body, &e) != nil || e.Code == "" {
+		return nil
+	}
+	return fmt.Errorf("%w: %s: %s", ErrS3, e.Code, e.Message)
+}
+
+// objectURL monta a URL do objeto key, ou do bucket quando key é "".
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	p := "/" + key
+	if s.cfg.PathStyle {
+		p = "/" + s.cfg.Bucket + p
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = p
+	u.RawPath = sigv4.EncodePath(p)
+	u.RawQuery = sigv4.EncodeQuery(query)
+	return &u
+}
+
+// do envia a requisição assinada. Respostas 404 retornam os.ErrNotExist e as
+// demais respostas de erro retornam ErrS3. size -1 indica uma requisição sem corpo.
+func (s *S3) do(method string, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	payloadHash := sigv4.EmptyPayload
+	if size >= 0 {
+		req.ContentLength = size
+		if size > 0 {
+			payloadHash = sigv4.UnsignedPayload
+		}
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrS3, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, notExist(strings.ToLower(method), key)
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed && header.Get("If-None-Match") == "*" {
+		return nil, exist(strings.ToLower(method), key)
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := parseS3Error(data); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %s %s: %s", ErrS3, method, key, resp.Status)
+}
+
+// sign assina a requisição com o AWS Signature Version 4. Sem AccessKey a
+// requisição é enviada anônima.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	if s.cfg.AccessKey == "" {
+		return
+	}
+	cred := sigv4.Credentials{AccessKey: s.cfg.AccessKey, SecretKey: s.cfg.SecretKey, SessionToken: s.cfg.SessionToken}
+	sigv4.Sign(req, cred, s.cfg.Region, "s3", payloadHash, now)
+}
+
+// s3File lê o objeto sob demanda com requisições Range a partir da posição atual.
+type s3File struct {
+	s3     *S3
+	key    string
+	info   *fileInfo
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *s3File) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		var header http.Header
+		if f.offset > 0 {
+			header = http.Header{}
+			header.Set("Range", "bytes="+strconv.FormatInt(f.offset, 10)+"-")
+		}
+		resp, err := f.s3.do(http.MethodGet, f.key, nil, header, nil, -1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = resp.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF && f.offset < f.info.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek %s: negative position", f.key)
+	}
+	if offset != f.offset {
+		f.Close()
+		f.offset = offset
+	}
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+// s3Writer guarda o conteúdo em um arquivo temporário e envia o objeto no Close.
+type s3Writer struct {
+	s3        *S3
+	key       string
+	tmp       *os.File
+	exclusive bool
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.tmp.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	defer os.Remove(w.tmp.Name())
+	defer w.tmp.Close()
+
+	size, err := w.tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := w.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if size > 0 {
+		body = w.tmp
+	}
+	var header http.Header
+	if w.exclusive {
+		header = http.Header{"If-None-Match": {"*"}}
+	}
+	resp, err := w.s3.do(http.MethodPut, w.key, nil, header, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3Dir lista o diretório com ListObjectsV2, uma página por vez.
+type s3Dir struct {
+	sliceDir
+	s3     *S3
+	prefix string
+	token  string
+	done   bool
+}
+
+func (d *s3Dir) fetch() error {
+	page, err := d.s3.list(d.prefix, d.token, s3ListPageSize)
+	if err != nil {
+		return err
+	}
+	for _, p := range page.CommonPrefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, d.prefix), "/")
+		if name != "" {
+			d.entries = append(d.entries, dirEntry{&fileInfo{name: name, dir: true}})
+		}
+	}
+	for _, c := range page.Contents {
+		name := strings.TrimPrefix(c.Key, d.prefix)
+		// skip the markers some tools create for empty directories
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		d.entries = append(d.entries, dirEntry{&fileInfo{name: name, size: c.Size, modTime: c.LastModified}})
+	}
+	d.token = page.NextContinuationToken
+	d.done = !page.IsTruncated || d.token == ""
+	return nil
+}
+
+func (d *s3Dir) ReadDir(n int) ([]os.DirEntry, error) {
+	for !d.done && (n <= 0 || len(d.entries) < n) {
+		if err := d.fetch(); err != nil {
+			return nil, err
+		}
+	}
+	return d.sliceDir.ReadDir(n)
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guilhermerodrigues680/gouploadserver/sigv4"
+)
+
+// fakeS3 implementa o mínimo da API do S3 usado pelo storage, com path-style,
+// e verifica a assinatura de cada requisição.
+type fakeS3 struct {
+	accessKey string
+	secretKey string
+	bucket    string
+	mu        sync.Mutex
+	objects   map[string]fakeObject
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func (f *fakeS3) verify(r *http.Request) bool {
+	_, err := sigv4.Verify(r, func(accessKey string) (string, bool) {
+		return f.secretKey, accessKey == f.accessKey
+	}, time.Now())
+	return err == nil
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.verify(r) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>invalid signature</Message></Error>")
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if p != f.bucket && !strings.HasPrefix(p, f.bucket+"/") {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchBucket</Code><Message>no such bucket</Message></Error>")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(p, f.bucket), "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key == "" && r.Method == http.MethodGet {
+		f.list(w, r.URL.Query())
+		return
+	}
+
+	obj, found := f.objects[key]
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data := obj.data
+		status := http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" {
+			start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if err != nil || start >= len(data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			data, status = data[start:], http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		if found && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, "<Error><Code>PreconditionFailed</Code><Message>the key exists</Message></Error>")
+			return
+		}
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			src, _ = url.PathUnescape(src)
+			srcObj, ok := f.objects[strings.TrimPrefix(src, "/"+f.bucket+"/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>no such key</Message></Error>")
+				return
+			}
+			data = srcObj.data
+		}
+		f.objects[key] = fakeObject{data, time.Now()}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	prefix, token := q.Get("prefix"), q.Get("continuation-token")
+	maxKeys, _ := strconv.Atoi(q.Get("max-keys"))
+
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+		CommonPrefixes        []struct{ Prefix string }
+	}
+
+	seen := map[string]bool{}
+	count := 0
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) || k <= token {
+			continue
+		}
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		rest := k[len(prefix):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			cp := prefix + rest[:i+1]
+			if !seen[cp] {
+				seen[cp] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, struct{ Prefix string }{cp})
+				count++
+				result.NextContinuationToken = cp + "\xff"
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, content{k, len(f.objects[k].data), f.objects[k].modTime.UTC().Format(time.RFC3339)})
+		count++
+		result.NextContinuationToken = k
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newFakeS3(t *testing.T, prefix string) (*S3, func()) {
+	fake := &fakeS3{bucket: "files", objects: make(map[string]fakeObject)}
+	srv := httptest.NewServer(fake)
+
+	cfg := S3Config{
+		Endpoint:  srv.URL,
+		Region:    "us-east-1",
+		Bucket:    "files",
+		Prefix:    prefix,
+		PathStyle: true,
+		AccessKey: "minioadmin",
+		SecretKey: "minioadmin",
+	}
+	s, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.accessKey, fake.secretKey = cfg.AccessKey, cfg.SecretKey
+	return s, srv.Close
+}
+
+func TestS3(t *testing.T) {
+	for _, prefix := range []string{"", "sites/docs"} {
+		s, closeServer := newFakeS3(t, prefix)
+		testStorage(t, s)
+		closeServer()
+	}
+}
+
+func TestS3ListPages(t *testing.T) {
+	s, closeServer := newFakeS3(t, "")
+	defer closeServer()
+
+	var want []string
+	for i := 0; i < s3ListPageSize+5; i++ {
+		name := fmt.Sprintf("file %04d (copy).txt", i)
+		writeFile(t, s, "many/"+name, "x")
+		want = append(want, name)
+	}
+	writeFile(t, s, "many/sub/inner.txt", "x")
+	want = append(want, "sub/")
+	sort.Strings(want)
+
+	if got := listNames(t, s, "many", 100); !equalStrings(got, want) {
+		t.Fatalf("OpenDir listed %d entries, want %d", len(got), len(want))
+	}
+}
+
+func TestS3SignatureMismatch(t *testing.T) {
+	s, closeServer := newFakeS3(t, "")
+	defer closeServer()
+
+	s.cfg.SecretKey = "wrong"
+	d, err := s.OpenDir("")
+	if err == nil {
+		_, err = d.ReadDir(-1)
+		d.Close()
+	}
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("expected SignatureDoesNotMatch, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Package storage abstrai o armazenamento dos arquivos servidos, permitindo
+// usar o disco local, a memória ou um bucket compatível com o S3.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrStorageURL = errors.New("Invalid storage URL")
+
+// Storage é o armazenamento dos arquivos servidos. Os nomes são caminhos
+// relativos à raiz separados por '/', no mesmo formato do io/fs ('.' é a raiz).
+// Os erros de arquivos inexistentes satisfazem errors.Is(err, os.ErrNotExist).
+type Storage interface {
+	Stat(name string) (os.FileInfo, error)
+	// Open abre um arquivo regular para leitura, com suporte a Seek
+	Open(name string) (File, error)
+	// Create cria ou trunca o arquivo, o conteúdo fica visível após o Close.
+	// No disco os diretórios do caminho precisam existir, nos demais
+	// armazenamentos os diretórios são implícitos.
+	Create(name string) (io.WriteCloser, error)
+	// CreateExclusive cria o arquivo como o Create, mas falha com os.ErrExist
+	// quando ele já existe, no Create ou, nos armazenamentos que só gravam no
+	// Close, no Close
+	CreateExclusive(name string) (io.WriteCloser, error)
+	// Rename move o arquivo, substituindo o destino quando ele existe
+	Rename(oldname string, newname string) error
+	// Remove remove o arquivo ou o diretório vazio
+	Remove(name string) error
+	// MkdirAll cria o diretório e os diretórios do caminho que não existem
+	MkdirAll(name string) error
+	// OpenDir abre um diretório para listar as entradas em lotes
+	OpenDir(name string) (Dir, error)
+}
+
+// File é um arquivo aberto para leitura.
+type File interface {
+	io.ReadSeekCloser
+	Stat() (os.FileInfo, error)
+}
+
+// Dir lista as entradas de um diretório em lotes, como os.File.ReadDir(n):
+// retorna no máximo n entradas e io.EOF quando não há mais entradas.
+type Dir interface {
+	ReadDir(n int) ([]os.DirEntry, error)
+	Close() error
+}
+
+// New cria o Storage descrito por uri:
+//   - '/srv/files' ou 'file:///srv/files': diretório local
+//   - 'memory:': em memória, o conteúdo é perdido quando o processo termina
+//   - 's3://bucket/prefix?endpoint=http://localhost:9000&region=us-east-1&path-style=true':
+//     bucket compatível com o S3, com as credenciais das variáveis de ambiente
+//     AWS_ACCESS_KEY_ID e AWS_SECRET_ACCESS_KEY
+func New(uri string) (Storage, error) {
+	switch {
+	case uri == "memory:":
+		return NewMemory(), nil
+	case strings.HasPrefix(uri, "s3://"):
+		return newS3FromURL(uri)
+	case strings.HasPrefix(uri, "file://"):
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrStorageURL, err)
+		}
+		return NewLocal(u.Path), nil
+	case IsURI(uri):
+		return nil, fmt.Errorf("%w: unsupported scheme in %q", ErrStorageURL, uri)
+	}
+	return NewLocal(uri), nil
+}
+
+// IsURI indica se value é a URI de um Storage e não um caminho do disco.
+func IsURI(value string) bool {
+	return value == "memory:" || strings.Contains(value, "://")
+}
+
+// cleanName normaliza o nome para um caminho relativo sem '..', assim nenhum
+// Storage permite sair da raiz. A raiz é ".".
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func notExist(op string, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+func exist(op string, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+}
+
+// fileInfo é o os.FileInfo dos armazenamentos que não estão no disco.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// dirEntry é o os.DirEntry de um fileInfo.
+type dirEntry struct {
+	info *fileInfo
+}
+
+func (e dirEntry) Name() string               { return e.info.name }
+func (e dirEntry) IsDir() bool                { return e.info.dir }
+func (e dirEntry) Type() os.FileMode          { return e.info.Mode().Type() }
+func (e dirEntry) Info() (os.FileInfo, error) { return e.info, nil }
+
+// sliceDir é um Dir com as entradas já carregadas em memória.
+type sliceDir struct {
+	entries []os.DirEntry
+}
+
+func (d *sliceDir) ReadDir(n int) ([]os.DirEntry, error) {
+	if len(d.entries) == 0 {
+		if n <= 0 {
+			return nil, nil
+		}
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(d.entries) {
+		n = len(d.entries)
+	}
+	batch := d.entries[:n]
+	d.entries = d.entries[n:]
+	return batch, nil
+}
+
+func (d *sliceDir) Close() error { return nil }
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func writeFile(t *testing.T, st Storage, name string, content string) {
+	w, err := st.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, st Storage, name string) string {
+	f, err := st.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func listNames(t *testing.T, st Storage, name string, batch int) []string {
+	d, err := st.OpenDir(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var names []string
+	for {
+		entries, err := d.ReadDir(batch)
+		for _, e := range entries {
+			n := e.Name()
+			if e.IsDir() {
+				n += "/"
+			}
+			names = append(names, n)
+		}
+		if err == io.EOF || (err == nil && batch <= 0) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// testStorage verifica o comportamento comum a todas as implementações.
+// Os diretórios 'docs' e 'docs/img' já devem existir.
+func testStorage(t *testing.T, st Storage) {
+	writeFile(t, st, "hello.txt", "hello world")
+	writeFile(t, st, "docs/guide.md", "# guide")
+	writeFile(t, st, "docs/img/logo.png", "png")
+
+	fi, err := st.Stat("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.IsDir() || fi.Size() != 11 || fi.Name() != "hello.txt" || !fi.Mode().IsRegular() {
+		t.Fatalf("Stat returned name %q size %d dir %v", fi.Name(), fi.Size(), fi.IsDir())
+	}
+	for _, name := range []string{".", "/", "docs", "/docs/img/"} {
+		if fi, err := st.Stat(name); err != nil || !fi.IsDir() {
+			t.Fatalf("Stat(%q) is not a directory: %v", name, err)
+		}
+	}
+	if _, err := st.Stat("missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat of a missing file returned %v", err)
+	}
+
+	f, err := st.Open("/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil || string(rest) != "world" {
+		t.Fatalf("Read after Seek returned %q, %v", rest, err)
+	}
+	if _, err := f.Seek(-11, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(f, head); err != nil || string(head) != "hello" {
+		t.Fatalf("Read after SeekEnd returned %q, %v", head, err)
+	}
+	f.Close()
+
+	// names are confined to the root
+	if got := readFile(t, st, "../../hello.txt"); got != "hello world" {
+		t.Fatalf("Open of '../../hello.txt' returned %q", got)
+	}
+
+	want := []string{"docs/", "hello.txt"}
+	if got := listNames(t, st, ".", 1); !equalStrings(got, want) {
+		t.Fatalf("OpenDir('.') listed %v, want %v", got, want)
+	}
+	want = []string{"guide.md", "img/"}
+	if got := listNames(t, st, "docs", 0); !equalStrings(got, want) {
+		t.Fatalf("OpenDir('docs') listed %v, want %v", got, want)
+	}
+	if _, err := st.OpenDir("missing"); err == nil {
+		t.Fatalf("OpenDir of a missing directory did not fail")
+	}
+
+	if _, err := st.CreateExclusive("docs/guide.md"); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("CreateExclusive of an existing file returned %v", err)
+	}
+	w, err := st.CreateExclusive("docs/new.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the storages that only write on Close fail when the file was created
+	// meanwhile, the disk reserves the name on CreateExclusive
+	writeFile(t, st, "docs/new.md", "first")
+	io.WriteString(w, "second")
+	err = w.Close()
+	if got := readFile(t, st, "docs/new.md"); !(errors.Is(err, os.ErrExist) && got == "first") && !(err == nil && got == "second") {
+		t.Fatalf("Close of CreateExclusive returned %v and kept %q", err, got)
+	}
+	if err := st.Remove("docs/new.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, st, "hello.txt", "replaced")
+	if got := readFile(t, st, "hello.txt"); got != "replaced" {
+		t.Fatalf("Create did not truncate the file: %q", got)
+	}
+
+	if err := st.Rename("hello.txt", "docs/hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Stat("hello.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Rename kept the old file: %v", err)
+	}
+	if got := readFile(t, st, "docs/hello.txt"); got != "replaced" {
+		t.Fatalf("Rename lost the content: %q", got)
+	}
+	if err := st.Rename("missing.txt", "other.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Rename of a missing file returned %v", err)
+	}
+
+	if err := st.Remove("docs/hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Stat("docs/hello.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Remove kept the file: %v", err)
+	}
+	if err := st.Remove("missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Remove of a missing file returned %v", err)
+	}
+	if err := st.Remove("docs"); err == nil {
+		t.Fatalf("Remove of a directory with files did not fail")
+	}
+
+	if err := st.MkdirAll("new/dir"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"new", "new/dir"} {
+		if fi, err := st.Stat(name); err != nil || !fi.IsDir() {
+			t.Fatalf("MkdirAll did not create %q: %v", name, err)
+		}
+	}
+	if err := st.MkdirAll("new/dir"); err != nil {
+		t.Fatalf("MkdirAll of an existing directory returned %v", err)
+	}
+	if got := listNames(t, st, "new/dir", 0); len(got) != 0 {
+		t.Fatalf("OpenDir('new/dir') listed %v, want nothing", got)
+	}
+	if err := st.Remove("new/dir"); err != nil {
+		t.Fatalf("Remove of an empty directory returned %v", err)
+	}
+	if err := st.MkdirAll("docs/guide.md/dir"); err == nil {
+		t.Fatalf("MkdirAll under a file did not fail")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(dir+"/docs/img", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	testStorage(t, NewLocal(dir))
+}
+
+func TestMemory(t *testing.T) {
+	testStorage(t, NewMemory())
+}
+
+func TestFS(t *testing.T) {
+	built := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	st := NewFS(fstest.MapFS{
+		"hello.txt":         {Data: []byte("hello world")},
+		"docs/guide.md":     {Data: []byte("# guide")},
+		"docs/img/logo.png": {Data: []byte("png")},
+	}, built)
+
+	fi, err := st.Stat("/hello.txt")
+	if err != nil || fi.Size() != 11 || !fi.ModTime().Equal(built) || !fi.Mode().IsRegular() {
+		t.Fatalf("Stat returned %v, %v", fi, err)
+	}
+	if fi, err := st.Stat("/"); err != nil || !fi.IsDir() {
+		t.Fatalf("Stat of the root returned %v", err)
+	}
+	if _, err := st.Stat("missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat of a missing file returned %v", err)
+	}
+
+	f, err := st.Open("../hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if rest, err := ioutil.ReadAll(f); err != nil || string(rest) != "world" {
+		t.Fatalf("Read after Seek returned %q, %v", rest, err)
+	}
+	if fi, _ := f.Stat(); !fi.ModTime().Equal(built) {
+		t.Fatalf("the open file has the date %v", fi.ModTime())
+	}
+	f.Close()
+	if _, err := st.Open("docs"); err == nil {
+		t.Fatal("Open of a directory did not fail")
+	}
+
+	if got := listNames(t, st, "docs", 1); !equalStrings(got, []string{"guide.md", "img/"}) {
+		t.Fatalf("OpenDir('docs') listed %v", got)
+	}
+
+	if _, err := st.Create("new.txt"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Create returned %v", err)
+	}
+	if err := st.Remove("hello.txt"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Remove returned %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"./files", "*storage.Local"},
+		{"file:///srv/files", "*storage.Local"},
+		{"memory:", "*storage.Memory"},
+		{"s3://bucket/prefix?endpoint=http://localhost:9000&path-style=true", "*storage.S3"},
+	}
+	for _, tt := range tests {
+		st, err := New(tt.uri)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.uri, err)
+		}
+		if got := typeName(st); got != tt.want {
+			t.Fatalf("%s: got %s want %s", tt.uri, got, tt.want)
+		}
+	}
+
+	for _, uri := range []string{"ftp://host/dir", "s3:///prefix", "s3://bucket/?path-style=maybe"} {
+		if _, err := New(uri); !errors.Is(err, ErrStorageURL) {
+			t.Fatalf("%s: expected ErrStorageURL, got %v", uri, err)
+		}
+	}
+}
+
+func typeName(st Storage) string {
+	switch st.(type) {
+	case *Local:
+		return "*storage.Local"
+	case *Memory:
+		return "*storage.Memory"
+	case *S3:
+		return "*storage.S3"
+	}
+	return "unknown"
+}